@@ -0,0 +1,141 @@
+// Package leapsecond reads leap second tables in the format of the
+// leap-seconds.list file published by the IERS and distributed with tzdata.
+package leapsecond
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Seconds from NTP epoch (1900) to Unix epoch (1970)
+const ntpEpochOffset = 2208988800
+
+type entry struct {
+	start  time.Time
+	offset int
+}
+
+// Table holds the offsets of TAI from UTC in seconds and the time until which
+// they are known.
+type Table struct {
+	entries []entry
+	expires time.Time
+}
+
+func parseNTPSeconds(s string) (time.Time, error) {
+	x, err := strconv.ParseUint(s, 10, 63)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(int64(x)-ntpEpochOffset, 0).UTC(), nil
+}
+
+// Load reads the leap second table from file name. Lines starting with '#'
+// are comments except for the expiration time ("#@"), the time of the last
+// update ("#$") and the SHA-1 hash of the data ("#h"), which is verified if
+// present. Each other line consists of a time in seconds since the NTP epoch
+// and the offset of TAI from UTC from that time on, optionally followed by a
+// comment.
+func Load(name string) (*Table, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+
+	var t Table
+	var updated, expires string
+	var hash []string
+	var data strings.Builder
+	s := bufio.NewScanner(f)
+	for n := 1; s.Scan(); n++ {
+		line := s.Text()
+		switch {
+		case strings.HasPrefix(line, "#@"):
+			expires = strings.TrimSpace(line[2:])
+			t.expires, err = parseNTPSeconds(expires)
+			if err != nil {
+				return nil, fmt.Errorf("leap second file %s, line %d: invalid expiration time: %w", name, n, err)
+			}
+			continue
+		case strings.HasPrefix(line, "#$"):
+			updated = strings.TrimSpace(line[2:])
+			continue
+		case strings.HasPrefix(line, "#h"):
+			hash = strings.Fields(line[2:])
+			continue
+		}
+		line, _, _ = strings.Cut(line, "#")
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("leap second file %s, line %d: unexpected number of fields", name, n)
+		}
+		start, err := parseNTPSeconds(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("leap second file %s, line %d: invalid time: %w", name, n, err)
+		}
+		offset, err := strconv.Atoi(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("leap second file %s, line %d: invalid offset: %w", name, n, err)
+		}
+		if len(t.entries) != 0 && !start.After(t.entries[len(t.entries)-1].start) {
+			return nil, fmt.Errorf("leap second file %s, line %d: entries out of order", name, n)
+		}
+		t.entries = append(t.entries, entry{start: start, offset: offset})
+		data.WriteString(fields[0])
+		data.WriteString(fields[1])
+	}
+	err = s.Err()
+	if err != nil {
+		return nil, err
+	}
+	if len(t.entries) == 0 {
+		return nil, fmt.Errorf("leap second file %s: no entries", name)
+	}
+	if t.expires.IsZero() {
+		return nil, fmt.Errorf("leap second file %s: no expiration time", name)
+	}
+	if hash != nil {
+		// The hash covers the digits of the update and expiration times and
+		// of all entries, see the comments in leap-seconds.list.
+		sum := sha1.Sum([]byte(updated + expires + data.String()))
+		if len(hash) != len(sum)/4 {
+			return nil, fmt.Errorf("leap second file %s: invalid hash", name)
+		}
+		for i, h := range hash {
+			x, err := strconv.ParseUint(h, 16, 32)
+			if err != nil || uint32(x) != binary.BigEndian.Uint32(sum[4*i:]) {
+				return nil, fmt.Errorf("leap second file %s: hash mismatch", name)
+			}
+		}
+	}
+	return &t, nil
+}
+
+// Expires returns the time until which the offsets of t are known.
+func (t *Table) Expires() time.Time {
+	return t.expires
+}
+
+// Offset returns the offset of TAI from UTC in seconds at UTC time at and
+// whether it is known, i.e., at is neither before the first entry of t nor
+// after its expiration time.
+func (t *Table) Offset(at time.Time) (int, bool) {
+	if at.Before(t.entries[0].start) || !at.Before(t.expires) {
+		return 0, false
+	}
+	i := len(t.entries) - 1
+	for at.Before(t.entries[i].start) {
+		i--
+	}
+	return t.entries[i].offset, true
+}
@@ -0,0 +1,71 @@
+package leapsecond_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"example.com/scion-time/base/leapsecond"
+)
+
+func TestLoad(t *testing.T) {
+	// testdata/leap-seconds.list is the file distributed with tzdata 2025b.
+	tbl, err := leapsecond.Load(filepath.Join("testdata", "leap-seconds.list"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := time.Date(2026, time.June, 28, 0, 0, 0, 0, time.UTC); !tbl.Expires().Equal(want) {
+		t.Errorf("Expires() = %v; want %v", tbl.Expires(), want)
+	}
+	tests := []struct {
+		at     time.Time
+		offset int
+		ok     bool
+	}{
+		{time.Date(1971, time.December, 31, 23, 59, 59, 0, time.UTC), 0, false},
+		{time.Date(1972, time.January, 1, 0, 0, 0, 0, time.UTC), 10, true},
+		{time.Date(1972, time.June, 30, 23, 59, 59, 0, time.UTC), 10, true},
+		{time.Date(1972, time.July, 1, 0, 0, 0, 0, time.UTC), 11, true},
+		{time.Date(2016, time.December, 31, 23, 59, 59, 0, time.UTC), 36, true},
+		{time.Date(2017, time.January, 1, 0, 0, 0, 0, time.UTC), 37, true},
+		{time.Date(2026, time.June, 27, 23, 59, 59, 0, time.UTC), 37, true},
+		{time.Date(2026, time.June, 28, 0, 0, 0, 0, time.UTC), 0, false},
+	}
+	for _, tt := range tests {
+		offset, ok := tbl.Offset(tt.at)
+		if offset != tt.offset || ok != tt.ok {
+			t.Errorf("Offset(%v) = %d, %t; want %d, %t", tt.at, offset, ok, tt.offset, tt.ok)
+		}
+	}
+}
+
+func TestLoadInvalid(t *testing.T) {
+	b, err := os.ReadFile(filepath.Join("testdata", "leap-seconds.list"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	valid := string(b)
+	tests := []struct {
+		name string
+		data string
+	}{
+		{"hash mismatch", strings.Replace(valid, "3692217600      37", "3692217600      38", 1)},
+		{"no expiration time", strings.Replace(valid, "#@", "# ", 1)},
+		{"invalid entry", strings.Replace(valid, "3692217600      37", "3692217600      37 1", 1)},
+		{"no entries", "#@\t3991593600\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			name := filepath.Join(t.TempDir(), "leap-seconds.list")
+			err := os.WriteFile(name, []byte(tt.data), 0o644)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := leapsecond.Load(name); err == nil {
+				t.Error("Load() accepted invalid file")
+			}
+		})
+	}
+}
@@ -1,6 +1,68 @@
 package metrics
 
 const (
+	BroadcastIPClientAuthFailuresH      = "The total number of NTP broadcast packets received via IP that failed authentication"
+	BroadcastIPClientAuthFailuresN      = "timeservice_broadcast_ip_client_auth_failures"
+	BroadcastIPClientPktsAcceptedH      = "The total number of NTP broadcast packets accepted via IP"
+	BroadcastIPClientPktsAcceptedN      = "timeservice_broadcast_ip_client_pkts_accepted"
+	BroadcastIPClientPktsAuthenticatedH = "The total number of NTP broadcast packets authenticated via IP"
+	BroadcastIPClientPktsAuthenticatedN = "timeservice_broadcast_ip_client_pkts_authenticated"
+	BroadcastIPClientPktsReceivedH      = "The total number of NTP broadcast packets received via IP"
+	BroadcastIPClientPktsReceivedN      = "timeservice_broadcast_ip_client_pkts_received"
+
+	BroadcastIPServerPktsSentH            = "The total number of NTP broadcast packets sent via IP"
+	BroadcastIPServerPktsSentN            = "timeservice_broadcast_ip_server_pkts_sent"
+	BroadcastIPServerPktsSentInterleavedH = "The total number of NTP broadcast packets sent via IP in interleaved mode"
+	BroadcastIPServerPktsSentInterleavedN = "timeservice_broadcast_ip_server_pkts_sent_interleaved"
+
+	CSPTPIPClientAuthFailuresH      = "The total number of CSPTP packets received via IP that failed authentication"
+	CSPTPIPClientAuthFailuresN      = "timeservice_csptp_ip_client_auth_failures"
+	CSPTPIPClientPktsAuthenticatedH = "The total number of CSPTP packets authenticated via IP"
+	CSPTPIPClientPktsAuthenticatedN = "timeservice_csptp_ip_client_pkts_authenticated"
+	CSPTPIPClientPktsReceivedH      = "The total number of CSPTP packets received via IP"
+	CSPTPIPClientPktsReceivedN      = "timeservice_csptp_ip_client_pkts_received"
+	CSPTPIPClientReqsSentH          = "The total number of CSPTP requests sent via IP"
+	CSPTPIPClientReqsSentN          = "timeservice_csptp_ip_client_reqs_sent"
+	CSPTPIPClientRespsAcceptedH     = "The total number of CSPTP responses accepted via IP"
+	CSPTPIPClientRespsAcceptedN     = "timeservice_csptp_ip_client_resps_accepted"
+
+	CSPTPIPServerAuthFailuresH      = "The total number of CSPTP requests received via IP that failed authentication"
+	CSPTPIPServerAuthFailuresN      = "timeservice_csptp_ip_server_auth_failures"
+	CSPTPIPServerPktsAuthenticatedH = "The total number of CSPTP packets authenticated via IP"
+	CSPTPIPServerPktsAuthenticatedN = "timeservice_csptp_ip_server_pkts_authenticated"
+	CSPTPIPServerPktsReceivedH      = "The total number of packets received by the CSPTP server via IP"
+	CSPTPIPServerPktsReceivedN      = "timeservice_csptp_ip_server_pkts_received"
+	CSPTPIPServerReqsAcceptedH      = "The total number of CSPTP requests accepted via IP"
+	CSPTPIPServerReqsAcceptedN      = "timeservice_csptp_ip_server_reqs_accepted"
+	CSPTPIPServerReqsDeniedH        = "The total number of CSPTP requests denied via IP by access control"
+	CSPTPIPServerReqsDeniedN        = "timeservice_csptp_ip_server_reqs_denied"
+	CSPTPIPServerReqsServedH        = "The total number of CSPTP requests served via IP"
+	CSPTPIPServerReqsServedN        = "timeservice_csptp_ip_server_reqs_served"
+
+	CSPTPSCIONClientPktsAuthenticatedH = "The total number of CSPTP packets authenticated via SCION"
+	CSPTPSCIONClientPktsAuthenticatedN = "timeservice_csptp_scion_client_pkts_authenticated"
+	CSPTPSCIONClientPktsReceivedH      = "The total number of CSPTP packets received via SCION"
+	CSPTPSCIONClientPktsReceivedN      = "timeservice_csptp_scion_client_pkts_received"
+	CSPTPSCIONClientReqsSentH          = "The total number of CSPTP requests sent via SCION"
+	CSPTPSCIONClientReqsSentN          = "timeservice_csptp_scion_client_reqs_sent"
+	CSPTPSCIONClientRespsAcceptedH     = "The total number of CSPTP responses accepted via SCION"
+	CSPTPSCIONClientRespsAcceptedN     = "timeservice_csptp_scion_client_resps_accepted"
+
+	CSPTPSCIONServerAuthFailuresH      = "The total number of CSPTP requests received via SCION that failed authentication"
+	CSPTPSCIONServerAuthFailuresN      = "timeservice_csptp_scion_server_auth_failures"
+	CSPTPSCIONServerPktsAuthenticatedH = "The total number of CSPTP packets authenticated via SCION"
+	CSPTPSCIONServerPktsAuthenticatedN = "timeservice_csptp_scion_server_pkts_authenticated"
+	CSPTPSCIONServerPktsForwardedH     = "The total number of packets forwarded by the CSPTP server via SCION"
+	CSPTPSCIONServerPktsForwardedN     = "timeservice_csptp_scion_server_pkts_forwarded"
+	CSPTPSCIONServerPktsReceivedH      = "The total number of packets received by the CSPTP server via SCION"
+	CSPTPSCIONServerPktsReceivedN      = "timeservice_csptp_scion_server_pkts_received"
+	CSPTPSCIONServerReqsAcceptedH      = "The total number of CSPTP requests accepted via SCION"
+	CSPTPSCIONServerReqsAcceptedN      = "timeservice_csptp_scion_server_reqs_accepted"
+	CSPTPSCIONServerReqsDeniedH        = "The total number of CSPTP requests denied via SCION by access control"
+	CSPTPSCIONServerReqsDeniedN        = "timeservice_csptp_scion_server_reqs_denied"
+	CSPTPSCIONServerReqsServedH        = "The total number of CSPTP requests served via SCION"
+	CSPTPSCIONServerReqsServedN        = "timeservice_csptp_scion_server_reqs_served"
+
 	DRKeyCacheKeysInsertedH = "The total number of DRKeys inserted into cache"
 	DRKeyCacheKeysInsertedN = "timeservice_drkey_cache_keys_inserted"
 	DRKeyCacheKeysExpiredH  = "The total number of DRKeys expired in the cache"
@@ -8,6 +70,8 @@ const (
 	DRKeyCacheKeysReplacedH = "The total number of DRKeys replaced in the cache"
 	DRKeyCacheKeysReplacedN = "timeservice_drkey_cache_keys_replaced"
 
+	IPClientKoDsReceivedH             = "The total number of valid Kiss-o'-Death packets received via IP"
+	IPClientKoDsReceivedN             = "timeservice_ip_client_kods_received"
 	IPClientPktsAuthenticatedH        = "The total number of packets authenticated via IP"
 	IPClientPktsAuthenticatedN        = "timeservice_ip_client_pkts_authenticated"
 	IPClientPktsReceivedH             = "The total number of packets received via IP"
@@ -21,13 +85,63 @@ const (
 	IPClientRespsAcceptedInterleavedH = "The total number of responses accepted via IP in interleaved mode"
 	IPClientRespsAcceptedInterleavedN = "timeservice_ip_client_resps_accepted_interleaved"
 
-	IPServerPktsReceivedH = "The total number of packets received via IP"
-	IPServerPktsReceivedN = "timeservice_ip_server_pkts_received"
-	IPServerReqsAcceptedH = "The total number of requests accepted via IP"
-	IPServerReqsAcceptedN = "timeservice_ip_server_reqs_accepted"
-	IPServerReqsServedH   = "The total number of requests served via IP"
-	IPServerReqsServedN   = "timeservice_ip_server_reqs_served"
+	IPServerPktsReceivedH    = "The total number of packets received via IP"
+	IPServerPktsReceivedN    = "timeservice_ip_server_pkts_received"
+	IPServerReqsAcceptedH    = "The total number of requests accepted via IP"
+	IPServerReqsAcceptedN    = "timeservice_ip_server_reqs_accepted"
+	IPServerReqsDeniedH      = "The total number of requests denied via IP by access control"
+	IPServerReqsDeniedN      = "timeservice_ip_server_reqs_denied"
+	IPServerReqsDroppedH     = "The total number of requests dropped via IP due to rate limiting or response size"
+	IPServerReqsDroppedN     = "timeservice_ip_server_reqs_dropped"
+	IPServerReqsRateLimitedH = "The total number of requests exceeding the rate limit via IP"
+	IPServerReqsRateLimitedN = "timeservice_ip_server_reqs_rate_limited"
+	IPServerReqsServedH      = "The total number of requests served via IP"
+	IPServerReqsServedN      = "timeservice_ip_server_reqs_served"
+
+	NTSKEFetcherCookiesH           = "The number of NTS cookies stored by NTS-KE clients"
+	NTSKEFetcherCookiesN           = "timeservice_ntske_fetcher_cookies"
+	NTSKEFetcherKeyExchangeErrorsH = "The total number of failed NTS key exchanges"
+	NTSKEFetcherKeyExchangeErrorsN = "timeservice_ntske_fetcher_key_exchange_errors"
+	NTSKEFetcherKeyExchangesH      = "The total number of successful NTS key exchanges"
+	NTSKEFetcherKeyExchangesN      = "timeservice_ntske_fetcher_key_exchanges"
+
+	NTSKEServerConnsRateLimitedH    = "The total number of NTS-KE connections exceeding the per-client rate limit"
+	NTSKEServerConnsRateLimitedN    = "timeservice_ntske_server_conns_rate_limited"
+	NTSKEServerConnsRejectedH       = "The total number of NTS-KE connections rejected due to the concurrency limit"
+	NTSKEServerConnsRejectedN       = "timeservice_ntske_server_conns_rejected"
+	NTSKEServerHandshakeDurationH   = "The duration of successful NTS-KE TLS handshakes in seconds"
+	NTSKEServerHandshakeDurationN   = "timeservice_ntske_server_handshake_duration_seconds"
+	NTSKEServerHandshakeErrorsH     = "The total number of failed NTS-KE TLS handshakes"
+	NTSKEServerHandshakeErrorsN     = "timeservice_ntske_server_handshake_errors"
+	NTSKEServerHandshakesH          = "The total number of successful NTS-KE TLS handshakes"
+	NTSKEServerHandshakesN          = "timeservice_ntske_server_handshakes"
+	NTSKEServerKeyExchangeDurationH = "The duration of successful NTS key exchanges in seconds"
+	NTSKEServerKeyExchangeDurationN = "timeservice_ntske_server_key_exchange_duration_seconds"
+	NTSKEServerKeyExchangeErrorsH   = "The total number of failed NTS key exchanges served"
+	NTSKEServerKeyExchangeErrorsN   = "timeservice_ntske_server_key_exchange_errors"
+	NTSKEServerKeyExchangesH        = "The total number of successful NTS key exchanges served"
+	NTSKEServerKeyExchangesN        = "timeservice_ntske_server_key_exchanges"
+
+	PTPIPClientGrantsReceivedH = "The total number of PTP unicast transmission grants received via IP"
+	PTPIPClientGrantsReceivedN = "timeservice_ptp_ip_client_grants_received"
+	PTPIPClientPktsReceivedH   = "The total number of PTP packets received via IP"
+	PTPIPClientPktsReceivedN   = "timeservice_ptp_ip_client_pkts_received"
+	PTPIPClientReqsSentH       = "The total number of PTP Delay_Req messages sent via IP"
+	PTPIPClientReqsSentN       = "timeservice_ptp_ip_client_reqs_sent"
+	PTPIPClientRespsAcceptedH  = "The total number of PTP Delay_Resp messages accepted via IP"
+	PTPIPClientRespsAcceptedN  = "timeservice_ptp_ip_client_resps_accepted"
+
+	PTPIPServerGrantsDeniedH = "The total number of PTP unicast transmission requests denied via IP"
+	PTPIPServerGrantsDeniedN = "timeservice_ptp_ip_server_grants_denied"
+	PTPIPServerGrantsIssuedH = "The total number of PTP unicast transmission grants issued via IP"
+	PTPIPServerGrantsIssuedN = "timeservice_ptp_ip_server_grants_issued"
+	PTPIPServerPktsReceivedH = "The total number of packets received by the PTP server via IP"
+	PTPIPServerPktsReceivedN = "timeservice_ptp_ip_server_pkts_received"
+	PTPIPServerReqsServedH   = "The total number of PTP Delay_Req messages served via IP"
+	PTPIPServerReqsServedN   = "timeservice_ptp_ip_server_reqs_served"
 
+	SCIONClientKoDsReceivedH             = "The total number of valid Kiss-o'-Death packets received via SCION"
+	SCIONClientKoDsReceivedN             = "timeservice_scion_client_kods_received"
 	SCIONClientPktsAuthenticatedH        = "The total number of packets authenticated via SCION"
 	SCIONClientPktsAuthenticatedN        = "timeservice_scion_client_pkts_authenticated"
 	SCIONClientPktsReceivedH             = "The total number of packets received via SCION"
@@ -49,6 +163,12 @@ const (
 	SCIONServerPktsReceivedN      = "timeservice_scion_server_pkts_received"
 	SCIONServerReqsAcceptedH      = "The total number of requests accepted via SCION"
 	SCIONServerReqsAcceptedN      = "timeservice_scion_server_reqs_accepted"
+	SCIONServerReqsDeniedH        = "The total number of requests denied via SCION by access control"
+	SCIONServerReqsDeniedN        = "timeservice_scion_server_reqs_denied"
+	SCIONServerReqsDroppedH       = "The total number of requests dropped via SCION due to rate limiting or response size"
+	SCIONServerReqsDroppedN       = "timeservice_scion_server_reqs_dropped"
+	SCIONServerReqsRateLimitedH   = "The total number of requests exceeding the rate limit via SCION"
+	SCIONServerReqsRateLimitedN   = "timeservice_scion_server_reqs_rate_limited"
 	SCIONServerReqsServedH        = "The total number of requests served via SCION"
 	SCIONServerReqsServedN        = "timeservice_scion_server_reqs_served"
 
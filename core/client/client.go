@@ -30,13 +30,21 @@ var (
 	errNoPath             = errors.New("failed to measure clock offset: no path")
 	errUnexpectedAddrType = errors.New("unexpected address type")
 
-	ipMetrics    atomic.Pointer[ipClientMetrics]
-	scionMetrics atomic.Pointer[scionClientMetrics]
+	ipMetrics          atomic.Pointer[ipClientMetrics]
+	scionMetrics       atomic.Pointer[scionClientMetrics]
+	csptpIPMetrics     atomic.Pointer[csptpIPClientMetrics]
+	csptpSCIONMetrics  atomic.Pointer[csptpSCIONClientMetrics]
+	ptpIPMetrics       atomic.Pointer[ptpIPClientMetrics]
+	broadcastIPMetrics atomic.Pointer[broadcastIPClientMetrics]
 )
 
 func init() {
 	ipMetrics.Store(newIPClientMetrics())
 	scionMetrics.Store(newSCIONClientMetrics())
+	csptpIPMetrics.Store(newCSPTPIPClientMetrics())
+	csptpSCIONMetrics.Store(newCSPTPSCIONClientMetrics())
+	ptpIPMetrics.Store(newPTPIPClientMetrics())
+	broadcastIPMetrics.Store(newBroadcastIPClientMetrics())
 }
 
 func MeasureClockOffsetIP(ctx context.Context, log *slog.Logger,
@@ -71,6 +79,7 @@ func MeasureClockOffsetIP(ctx context.Context, log *slog.Logger,
 			)
 		}
 	}
+	ntpc.stats.measured(err == nil)
 	return
 }
 
@@ -192,6 +201,7 @@ func MeasureClockOffsetSCION(ctx context.Context, log *slog.Logger,
 					)
 				}
 			}
+			ntpc.stats.measured(err == nil)
 			msc <- measurements.Measurement{
 				Timestamp: ts,
 				Offset:    off,
@@ -204,8 +214,92 @@ func MeasureClockOffsetSCION(ctx context.Context, log *slog.Logger,
 	return m.Timestamp, m.Offset, m.Error
 }
 
+func MeasureClockOffsetCSPTPSCION(ctx context.Context, log *slog.Logger,
+	cs []*CSPTPClientSCION, localAddr, remoteAddr udp.UDPAddr, ps []snet.Path) (
+	time.Time, time.Duration, error) {
+	mtrcs := csptpSCIONMetrics.Load()
+
+	// Clients keep their paths as long as they are available s.t. their
+	// filters are only reset on a path change.
+	sps := make([]snet.Path, len(cs))
+	nsps := 0
+	for i, c := range cs {
+		if c.path != "" {
+			for j := range len(ps) {
+				if p := ps[j]; snet.Fingerprint(p).String() == c.path {
+					ps[j] = ps[len(ps)-1]
+					ps = ps[:len(ps)-1]
+					sps[i] = p
+					nsps++
+					break
+				}
+			}
+		}
+		if sps[i] == nil {
+			c.path = ""
+			if c.Filter != nil {
+				c.Filter.Reset()
+			}
+		}
+	}
+	n, err := crypto.Sample(ctx, len(sps)-nsps, len(ps), func(dst, src int) {
+		ps[dst] = ps[src]
+	})
+	if err != nil {
+		return time.Time{}, 0, err
+	}
+	if nsps+n == 0 {
+		return time.Time{}, 0, errNoPath
+	}
+	for i, j := 0, 0; j != n; j++ {
+		for sps[i] != nil {
+			i++
+		}
+		sps[i] = ps[j]
+		nsps++
+	}
+
+	ms := make([]measurements.Measurement, nsps)
+	msc := make(chan measurements.Measurement)
+	for i := range len(cs) {
+		if sps[i] == nil {
+			continue
+		}
+		go func(ctx context.Context, log *slog.Logger, mtrcs *csptpSCIONClientMetrics,
+			c *CSPTPClientSCION, localAddr, remoteAddr udp.UDPAddr, p snet.Path) {
+			log.LogAttrs(ctx, slog.LevelDebug, "measuring clock offset",
+				slog.Any("to", remoteAddr),
+				slog.Any("via", snet.Fingerprint(p).String()),
+				slog.Any("path", p),
+			)
+			ts, off, err := c.measureClockOffsetSCION(ctx, mtrcs, localAddr, remoteAddr, p)
+			if err == nil {
+				c.path = snet.Fingerprint(p).String()
+			} else {
+				c.path = ""
+				log.LogAttrs(ctx, slog.LevelInfo, "failed to measure clock offset",
+					slog.Any("to", remoteAddr),
+					slog.Any("via", snet.Fingerprint(p).String()),
+					slog.Any("error", err),
+				)
+			}
+			msc <- measurements.Measurement{
+				Timestamp: ts,
+				Offset:    off,
+				Error:     err,
+			}
+		}(ctx, log, mtrcs, cs[i], localAddr, remoteAddr, sps[i])
+	}
+	collectMeasurements(ctx, ms, msc)
+	m := measurements.FaultTolerantMidpoint(ms)
+	return m.Timestamp, m.Offset, m.Error
+}
+
+// MeasureClockOffsets measures the offsets to the given reference clocks and
+// returns the number of successful measurements. The results of failed
+// measurements are replaced by zero offsets.
 func (c *ReferenceClockClient) MeasureClockOffsets(ctx context.Context,
-	refclks []ReferenceClock, ms []measurements.Measurement) {
+	refclks []ReferenceClock, ms []measurements.Measurement) int {
 	if len(ms) != len(refclks) {
 		panic("number of result offsets must be equal to the number of reference clocks")
 	}
@@ -231,5 +325,5 @@ func (c *ReferenceClockClient) MeasureClockOffsets(ctx context.Context,
 			}
 		}(ctx, refclk)
 	}
-	collectMeasurements(ctx, ms, msc)
+	return collectMeasurements(ctx, ms, msc)
 }
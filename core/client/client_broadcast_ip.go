@@ -0,0 +1,326 @@
+package client
+
+// See RFC 5905, Section 8 and RFC 9769, Section 4, broadcast and interleaved
+// broadcast mode
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"example.com/scion-time/base/metrics"
+
+	"example.com/scion-time/core/timebase"
+
+	"example.com/scion-time/net/ntp"
+	"example.com/scion-time/net/udp"
+)
+
+// broadcastCalibrationInterval is the time after which the delay from the
+// broadcast server is calibrated again.
+const broadcastCalibrationInterval = 1 * time.Hour
+
+var (
+	errNoBroadcastPacket = errors.New("failed to measure clock offset: no broadcast packet")
+	errNoBroadcastKey    = errors.New("failed to measure clock offset: no key to authenticate broadcast packets")
+)
+
+// BroadcastClientIP is an NTP broadcast client. It calibrates the delay from
+// the broadcast server in a unicast exchange of Unicast with the server and
+// then listens passively for packets the server sends in broadcast mode to
+// ListenAddr, joining the multicast group if ListenAddr is a multicast
+// address. Packets of other sources are ignored. The client listens until it
+// is closed.
+type BroadcastClientIP struct {
+	Log        *slog.Logger
+	ListenAddr netip.AddrPort
+	Unicast    *IPClient
+	Auth       struct {
+		// SymmetricKey, if its ID is not 0, is required to authenticate
+		// broadcast packets by a MAC.
+		SymmetricKey ntp.SymmetricKey
+		// Unauthenticated must be set to accept broadcast packets without a
+		// SymmetricKey. Anyone able to send packets to ListenAddr can then
+		// shift the clock of the client.
+		Unauthenticated bool
+	}
+
+	mu         sync.Mutex
+	started    bool
+	conn       *net.UDPConn
+	notify     chan struct{}
+	server     netip.Addr
+	delay      time.Duration
+	calibrated time.Time
+	// prev holds the transmit timestamp as sent in and the receive timestamp
+	// of the previous packet for interleaved broadcast mode.
+	prev struct {
+		txt ntp.Time64
+		rxt time.Time
+		ok  bool
+	}
+	// sample is the latest measurement from a broadcast packet.
+	sample struct {
+		t1, t2      time.Time
+		interleaved bool
+		used        bool
+		ok          bool
+	}
+}
+
+type broadcastIPClientMetrics struct {
+	pktsReceived      prometheus.Counter
+	pktsAuthenticated prometheus.Counter
+	pktsAccepted      prometheus.Counter
+	authFailures      prometheus.Counter
+}
+
+func newBroadcastIPClientMetrics() *broadcastIPClientMetrics {
+	return &broadcastIPClientMetrics{
+		pktsReceived: promauto.NewCounter(prometheus.CounterOpts{
+			Name: metrics.BroadcastIPClientPktsReceivedN,
+			Help: metrics.BroadcastIPClientPktsReceivedH,
+		}),
+		pktsAuthenticated: promauto.NewCounter(prometheus.CounterOpts{
+			Name: metrics.BroadcastIPClientPktsAuthenticatedN,
+			Help: metrics.BroadcastIPClientPktsAuthenticatedH,
+		}),
+		pktsAccepted: promauto.NewCounter(prometheus.CounterOpts{
+			Name: metrics.BroadcastIPClientPktsAcceptedN,
+			Help: metrics.BroadcastIPClientPktsAcceptedH,
+		}),
+		authFailures: promauto.NewCounter(prometheus.CounterOpts{
+			Name: metrics.BroadcastIPClientAuthFailuresN,
+			Help: metrics.BroadcastIPClientAuthFailuresH,
+		}),
+	}
+}
+
+// start opens the socket on ListenAddr and starts reading packets from it.
+// c.mu must be held.
+func (c *BroadcastClientIP) start(ctx context.Context) error {
+	var conn *net.UDPConn
+	if c.ListenAddr.Addr().IsMulticast() {
+		var err error
+		conn, err = net.ListenMulticastUDP("udp", nil /* default interface */, net.UDPAddrFromAddrPort(c.ListenAddr))
+		if err != nil {
+			return err
+		}
+	} else {
+		lc := net.ListenConfig{
+			Control: udp.SetsockoptReuseAddrPort,
+		}
+		pconn, err := lc.ListenPacket(ctx, "udp", c.ListenAddr.String())
+		if err != nil {
+			return err
+		}
+		conn = pconn.(*net.UDPConn)
+	}
+	err := udp.EnableTimestamping(conn, c.ListenAddr.Addr().Zone())
+	if err != nil {
+		c.Log.LogAttrs(ctx, slog.LevelError, "failed to enable timestamping", slog.Any("error", err))
+	}
+
+	c.started = true
+	c.conn = conn
+	c.notify = make(chan struct{}, 1)
+
+	go c.run(broadcastIPMetrics.Load(), conn)
+	return nil
+}
+
+// Close closes the client's socket.
+func (c *BroadcastClientIP) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.started {
+		return nil
+	}
+	c.started = false
+	return c.conn.Close()
+}
+
+// Status returns the current source state of the unicast client of c.
+func (c *BroadcastClientIP) Status() Status {
+	return c.Unicast.Status()
+}
+
+func (c *BroadcastClientIP) wakeUp() {
+	select {
+	case c.notify <- struct{}{}:
+	default:
+	}
+}
+
+// calibrate measures the clock offset to the server at remoteAddr in a
+// unicast exchange and derives the delay from the server from its round trip
+// delay.
+func (c *BroadcastClientIP) calibrate(ctx context.Context, localAddr, remoteAddr *net.UDPAddr) (
+	time.Time, time.Duration, error) {
+	ts, off, err := MeasureClockOffsetIP(ctx, c.Log, c.Unicast, localAddr, remoteAddr)
+	if err != nil {
+		return time.Time{}, 0, err
+	}
+	server, ok := netip.AddrFromSlice(remoteAddr.IP)
+	if !ok {
+		panic(errUnexpectedAddrType)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if server.Unmap() != c.server {
+		c.server = server.Unmap()
+		c.prev.ok = false
+		c.sample.ok = false
+	}
+	c.delay = c.Unicast.rtd / 2
+	c.calibrated = timebase.Now()
+	c.Log.LogAttrs(ctx, slog.LevelDebug, "calibrated broadcast delay",
+		slog.String("server", c.server.String()),
+		slog.Duration("delay", c.delay),
+	)
+	return ts, off, nil
+}
+
+// MeasureClockOffset measures the clock offset to the broadcast server at
+// remoteAddr. Once the delay from the server has been calibrated, it waits for
+// a broadcast packet of the server not used in a previous measurement.
+func (c *BroadcastClientIP) MeasureClockOffset(ctx context.Context, localAddr, remoteAddr *net.UDPAddr) (
+	timestamp time.Time, offset time.Duration, err error) {
+	if c.Auth.SymmetricKey.ID == 0 && !c.Auth.Unauthenticated {
+		return time.Time{}, 0, errNoBroadcastKey
+	}
+
+	c.mu.Lock()
+	if !c.started {
+		err = c.start(ctx)
+	}
+	calibrate := c.calibrated.IsZero() || timebase.Now().Sub(c.calibrated) > broadcastCalibrationInterval
+	c.mu.Unlock()
+	if err != nil {
+		return time.Time{}, 0, err
+	}
+	if calibrate {
+		return c.calibrate(ctx, localAddr, remoteAddr)
+	}
+
+	for {
+		var ok bool
+		c.mu.Lock()
+		if c.sample.ok && !c.sample.used {
+			c.sample.used = true
+			timestamp = c.sample.t2
+			offset = c.sample.t1.Sub(c.sample.t2) + c.delay
+			ok = true
+		}
+		c.mu.Unlock()
+		if ok {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return time.Time{}, 0, errNoBroadcastPacket
+		case <-c.notify:
+		}
+	}
+	return timestamp, offset, nil
+}
+
+// run reads packets from conn until it is closed.
+func (c *BroadcastClientIP) run(mtrcs *broadcastIPClientMetrics, conn *net.UDPConn) {
+	ctx := context.Background()
+	buf := make([]byte, ntp.PacketLen+ntp.MaxMACLen)
+	oob := make([]byte, udp.TimestampLen())
+	for {
+		buf = buf[:cap(buf)]
+		oob = oob[:cap(oob)]
+		n, oobn, flags, srcAddr, err := conn.ReadMsgUDPAddrPort(buf, oob)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			c.Log.LogAttrs(ctx, slog.LevelInfo, "failed to read packet", slog.Any("error", err))
+			continue
+		}
+		if flags != 0 {
+			c.Log.LogAttrs(ctx, slog.LevelInfo, "failed to read packet", slog.Int("flags", flags))
+			continue
+		}
+		rxt, err := udp.TimestampFromOOBData(oob[:oobn])
+		if err != nil {
+			rxt = timebase.Now()
+			c.Log.LogAttrs(ctx, slog.LevelError, "failed to read packet rx timestamp", slog.Any("error", err))
+		}
+		buf = buf[:n]
+		mtrcs.pktsReceived.Inc()
+
+		var pkt ntp.Packet
+		err = ntp.DecodePacket(&pkt, buf)
+		if err != nil {
+			c.Log.LogAttrs(ctx, slog.LevelInfo, "failed to decode packet payload", slog.Any("error", err))
+			continue
+		}
+
+		c.mu.Lock()
+		if srcAddr.Addr().Unmap() == c.server {
+			c.handlePacket(ctx, mtrcs, &pkt, buf, rxt)
+		}
+		c.mu.Unlock()
+	}
+}
+
+// handlePacket processes the broadcast packet pkt in b received from the
+// server at rxt. c.mu must be held.
+func (c *BroadcastClientIP) handlePacket(ctx context.Context, mtrcs *broadcastIPClientMetrics,
+	pkt *ntp.Packet, b []byte, rxt time.Time) {
+	err := ntp.ValidateBroadcastMetadata(pkt)
+	if err != nil {
+		c.Log.LogAttrs(ctx, slog.LevelInfo, "failed to validate packet payload", slog.Any("error", err))
+		return
+	}
+	authenticated := false
+	if c.Auth.SymmetricKey.ID != 0 {
+		err = ntp.VerifyMAC(b, c.Auth.SymmetricKey)
+		if err != nil {
+			mtrcs.authFailures.Inc()
+			c.Log.LogAttrs(ctx, slog.LevelInfo, "failed to verify MAC", slog.Any("error", err))
+			return
+		}
+		authenticated = true
+		mtrcs.pktsAuthenticated.Inc()
+	}
+	if c.prev.ok && !c.prev.txt.Before(pkt.TransmitTime) {
+		// replayed or reordered packet
+		c.Log.LogAttrs(ctx, slog.LevelInfo, "received packet with unexpected transmit timestamp")
+		return
+	}
+
+	interleaved := c.prev.ok && pkt.OriginTime != (ntp.Time64{}) && pkt.ReceiveTime == c.prev.txt
+	if interleaved {
+		c.sample.t1 = ntp.TimeFromTime64(pkt.OriginTime, c.prev.rxt)
+		c.sample.t2 = c.prev.rxt
+	} else {
+		c.sample.t1 = ntp.TimeFromTime64(pkt.TransmitTime, rxt)
+		c.sample.t2 = rxt
+	}
+	c.sample.interleaved = interleaved
+	c.sample.used, c.sample.ok = false, true
+	c.prev.txt, c.prev.rxt, c.prev.ok = pkt.TransmitTime, rxt, true
+	mtrcs.pktsAccepted.Inc()
+
+	c.Log.LogAttrs(ctx, slog.LevelDebug, "received broadcast packet",
+		slog.Time("at", rxt),
+		slog.String("from", c.server.String()),
+		slog.Bool("auth", authenticated),
+		slog.Bool("interleaved", interleaved),
+		slog.Any("data", ntp.PacketLogValuer{Pkt: pkt}),
+	)
+	c.wakeUp()
+}
@@ -7,19 +7,102 @@ import (
 	"net/netip"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"example.com/scion-time/base/metrics"
+
+	"example.com/scion-time/core/measurements"
 	"example.com/scion-time/core/timebase"
+
 	"example.com/scion-time/net/csptp"
+	"example.com/scion-time/net/ntske"
 	"example.com/scion-time/net/udp"
 )
 
+// csptpMaxKeyFailures is the number of consecutive failed measurements after
+// which a key obtained from an NTS-KE server is renewed.
+const csptpMaxKeyFailures = 3
+
 type CSPTPClientIP struct {
-	Log        *slog.Logger
-	DSCP       uint8
-	sequenceID uint16
+	Log  *slog.Logger
+	DSCP uint8
+	// Auth configures AUTHENTICATION TLVs on requests and responses. If Key is
+	// nil, the key is obtained from an NTS-KE server with NTSKEFetcher, which
+	// must request CSPTP keys.
+	Auth struct {
+		Enabled      bool
+		KeyID        uint32
+		Key          []byte
+		NTSKEFetcher ntske.Fetcher
+	}
+	Filter      measurements.Filter
+	sequenceID  uint16
+	keyFailures int
+}
+
+type csptpIPClientMetrics struct {
+	reqsSent          prometheus.Counter
+	pktsReceived      prometheus.Counter
+	pktsAuthenticated prometheus.Counter
+	authFailures      prometheus.Counter
+	respsAccepted     prometheus.Counter
+}
+
+func newCSPTPIPClientMetrics() *csptpIPClientMetrics {
+	return &csptpIPClientMetrics{
+		reqsSent: promauto.NewCounter(prometheus.CounterOpts{
+			Name: metrics.CSPTPIPClientReqsSentN,
+			Help: metrics.CSPTPIPClientReqsSentH,
+		}),
+		pktsReceived: promauto.NewCounter(prometheus.CounterOpts{
+			Name: metrics.CSPTPIPClientPktsReceivedN,
+			Help: metrics.CSPTPIPClientPktsReceivedH,
+		}),
+		pktsAuthenticated: promauto.NewCounter(prometheus.CounterOpts{
+			Name: metrics.CSPTPIPClientPktsAuthenticatedN,
+			Help: metrics.CSPTPIPClientPktsAuthenticatedH,
+		}),
+		authFailures: promauto.NewCounter(prometheus.CounterOpts{
+			Name: metrics.CSPTPIPClientAuthFailuresN,
+			Help: metrics.CSPTPIPClientAuthFailuresH,
+		}),
+		respsAccepted: promauto.NewCounter(prometheus.CounterOpts{
+			Name: metrics.CSPTPIPClientRespsAcceptedN,
+			Help: metrics.CSPTPIPClientRespsAcceptedH,
+		}),
+	}
 }
 
 func (c *CSPTPClientIP) MeasureClockOffset(ctx context.Context, localAddr, remoteAddr netip.Addr) (
 	timestamp time.Time, offset time.Duration, err error) {
+	mtrcs := csptpIPMetrics.Load()
+
+	var authKeyID uint32
+	var authKey []byte
+	if c.Auth.Enabled {
+		authKeyID, authKey = c.Auth.KeyID, c.Auth.Key
+		if authKey == nil {
+			authKeyID, authKey, err = c.Auth.NTSKEFetcher.FetchCSPTPKey(ctx)
+			if err != nil {
+				return time.Time{}, 0, err
+			}
+			// Servers only keep issued keys in memory. Renew the key if the
+			// server does not accept it anymore, e.g., after a restart.
+			defer func() {
+				if err == nil {
+					c.keyFailures = 0
+					return
+				}
+				c.keyFailures++
+				if c.keyFailures == csptpMaxKeyFailures {
+					c.keyFailures = 0
+					c.Auth.NTSKEFetcher.Reset()
+				}
+			}()
+		}
+	}
+
 	var lc net.ListenConfig
 	pconn, err := lc.ListenPacket(ctx, "udp", netip.AddrPortFrom(localAddr, 0).String())
 	if err != nil {
@@ -70,8 +153,15 @@ func (c *CSPTPClientIP) MeasureClockOffset(ctx context.Context, localAddr, remot
 		Timestamp:          csptp.Timestamp{},
 	}
 
+	if authKey != nil {
+		msg.MessageLength += csptp.EncodedAuthenticationTLVLength
+	}
+
 	buf = buf[:msg.MessageLength]
-	csptp.EncodeMessage(buf, &msg)
+	csptp.EncodeMessage(buf[:csptp.MinMessageLength], &msg)
+	if authKey != nil {
+		encodeCSPTPAuthenticationTLV(buf, authKeyID, authKey)
+	}
 
 	n, err = conn.WriteToUDPAddrPort(buf, netip.AddrPortFrom(remoteAddr, csptp.EventPortIP))
 	if err != nil {
@@ -121,10 +211,16 @@ func (c *CSPTPClientIP) MeasureClockOffset(ctx context.Context, localAddr, remot
 	}
 	msg.MessageLength += uint16(csptp.EncodedRequestTLVLength(&reqtlv))
 	reqtlv.Length = uint16(csptp.EncodedRequestTLVLength(&reqtlv))
+	if authKey != nil {
+		msg.MessageLength += csptp.EncodedAuthenticationTLVLength
+	}
 
 	buf = buf[:msg.MessageLength]
 	csptp.EncodeMessage(buf[:csptp.MinMessageLength], &msg)
 	csptp.EncodeRequestTLV(buf[csptp.MinMessageLength:], &reqtlv)
+	if authKey != nil {
+		encodeCSPTPAuthenticationTLV(buf, authKeyID, authKey)
+	}
 
 	n, err = conn.WriteToUDPAddrPort(buf, netip.AddrPortFrom(remoteAddr, csptp.GeneralPortIP))
 	if err != nil {
@@ -139,6 +235,7 @@ func (c *CSPTPClientIP) MeasureClockOffset(ctx context.Context, localAddr, remot
 		c.Log.LogAttrs(ctx, slog.LevelError, "failed to read packet tx timestamp", slog.Any("error", err))
 	}
 	_ = cTxTime1
+	mtrcs.reqsSent.Inc()
 
 	oob := make([]byte, udp.TimestampLen())
 	var oobn, flags int
@@ -168,6 +265,7 @@ func (c *CSPTPClientIP) MeasureClockOffset(ctx context.Context, localAddr, remot
 			}
 			return time.Time{}, 0, err
 		}
+		mtrcs.pktsReceived.Inc()
 		oob = oob[:oobn]
 		rxt, err := udp.TimestampFromOOBData(oob)
 		if err != nil {
@@ -215,7 +313,7 @@ func (c *CSPTPClientIP) MeasureClockOffset(ctx context.Context, localAddr, remot
 				return time.Time{}, 0, err
 			}
 
-			if len(buf)-csptp.MinMessageLength != 0 {
+			if len(buf)-csptp.MinMessageLength != authTLVLen(authKey) {
 				err = errUnexpectedPacket
 				if numRetries != maxNumRetries && deadlineIsSet && timebase.Now().Before(deadline) {
 					c.Log.LogAttrs(ctx, slog.LevelInfo, "received unexpected message")
@@ -224,6 +322,19 @@ func (c *CSPTPClientIP) MeasureClockOffset(ctx context.Context, localAddr, remot
 				return time.Time{}, 0, err
 			}
 
+			if authKey != nil {
+				err = verifyCSPTPAuthenticationTLV(buf, authKeyID, authKey)
+				if err != nil {
+					mtrcs.authFailures.Inc()
+					if numRetries != maxNumRetries && deadlineIsSet && timebase.Now().Before(deadline) {
+						c.Log.LogAttrs(ctx, slog.LevelInfo, "failed to authenticate packet", slog.Any("error", err))
+						continue
+					}
+					return time.Time{}, 0, err
+				}
+				mtrcs.pktsAuthenticated.Inc()
+			}
+
 			cRxTime0 = rxt
 			respmsg0, respmsg0Ok = msg, true
 		} else if msg.SdoIDMessageType == csptp.MessageTypeFollowUp {
@@ -261,7 +372,7 @@ func (c *CSPTPClientIP) MeasureClockOffset(ctx context.Context, localAddr, remot
 				return time.Time{}, 0, err
 			}
 
-			if len(buf)-csptp.MinMessageLength != csptp.EncodedResponseTLVLength(&resptlv) {
+			if len(buf)-csptp.MinMessageLength != csptp.EncodedResponseTLVLength(&resptlv)+authTLVLen(authKey) {
 				err = errUnexpectedPacket
 				if numRetries != maxNumRetries && deadlineIsSet && timebase.Now().Before(deadline) {
 					c.Log.LogAttrs(ctx, slog.LevelInfo, "received unexpected message")
@@ -270,6 +381,19 @@ func (c *CSPTPClientIP) MeasureClockOffset(ctx context.Context, localAddr, remot
 				return time.Time{}, 0, err
 			}
 
+			if authKey != nil {
+				err = verifyCSPTPAuthenticationTLV(buf, authKeyID, authKey)
+				if err != nil {
+					mtrcs.authFailures.Inc()
+					if numRetries != maxNumRetries && deadlineIsSet && timebase.Now().Before(deadline) {
+						c.Log.LogAttrs(ctx, slog.LevelInfo, "failed to authenticate packet", slog.Any("error", err))
+						continue
+					}
+					return time.Time{}, 0, err
+				}
+				mtrcs.pktsAuthenticated.Inc()
+			}
+
 			cRxTime1 = rxt
 			respmsg1, respmsg1Ok = msg, true
 		} else {
@@ -289,6 +413,7 @@ func (c *CSPTPClientIP) MeasureClockOffset(ctx context.Context, localAddr, remot
 	c.Log.LogAttrs(ctx, slog.LevelDebug, "received response",
 		slog.Time("at", cRxTime1),
 		slog.String("from", remoteAddr.String()),
+		slog.Bool("auth", authKey != nil),
 		slog.Any("respmsg0", &respmsg0),
 		slog.Any("respmsg1", &respmsg1),
 		slog.Any("resptlv", &resptlv),
@@ -320,9 +445,46 @@ func (c *CSPTPClientIP) MeasureClockOffset(ctx context.Context, localAddr, remot
 		slog.Duration("mean path delay", meanPathDelay),
 	)
 
+	mtrcs.respsAccepted.Inc()
+
 	timestamp = cRxTime0
-	offset = clockOffset
+	if c.Filter == nil {
+		offset = clockOffset
+	} else {
+		// Correction fields are applied to the timestamps they refer to s.t.
+		// filters can evaluate the exchange like an NTP exchange.
+		offset = c.Filter.Do(t0, t1.Add(-t1Corr), t2, t3.Add(-t3Corr))
+	}
 
 	c.sequenceID++
 	return
 }
+
+func authTLVLen(key []byte) int {
+	if key == nil {
+		return 0
+	}
+	return csptp.EncodedAuthenticationTLVLength
+}
+
+// encodeCSPTPAuthenticationTLV encodes an AUTHENTICATION TLV for key keyID at
+// the end of message b and computes its integrity check value.
+func encodeCSPTPAuthenticationTLV(b []byte, keyID uint32, key []byte) {
+	tlv := csptp.NewAuthenticationTLV(keyID)
+	csptp.EncodeAuthenticationTLV(b[len(b)-csptp.EncodedAuthenticationTLVLength:], &tlv)
+	csptp.ComputeICV(b, key)
+}
+
+// verifyCSPTPAuthenticationTLV verifies the AUTHENTICATION TLV for key keyID
+// at the end of message b.
+func verifyCSPTPAuthenticationTLV(b []byte, keyID uint32, key []byte) error {
+	var tlv csptp.AuthenticationTLV
+	err := csptp.DecodeAuthenticationTLV(&tlv, b[len(b)-csptp.EncodedAuthenticationTLVLength:])
+	if err != nil {
+		return err
+	}
+	if tlv.KeyID != keyID || !csptp.ValidICV(b, key) {
+		return errInvalidPacketAuthenticator
+	}
+	return nil
+}
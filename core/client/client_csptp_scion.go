@@ -0,0 +1,623 @@
+package client
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/binary"
+	"log/slog"
+	"net"
+	"net/netip"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/scionproto/scion/pkg/addr"
+	"github.com/scionproto/scion/pkg/drkey"
+	"github.com/scionproto/scion/pkg/slayers"
+	"github.com/scionproto/scion/pkg/snet"
+	"github.com/scionproto/scion/pkg/spao"
+
+	"example.com/scion-time/base/metrics"
+
+	"example.com/scion-time/core/measurements"
+	"example.com/scion-time/core/timebase"
+
+	"example.com/scion-time/net/csptp"
+	"example.com/scion-time/net/scion"
+	"example.com/scion-time/net/udp"
+)
+
+type CSPTPClientSCION struct {
+	Log  *slog.Logger
+	DSCP uint8
+	Auth struct {
+		Enabled      bool
+		DRKeyFetcher *scion.Fetcher
+		opt          *slayers.EndToEndOption
+		buf          []byte
+		mac          []byte
+	}
+	Filter     measurements.Filter
+	sequenceID uint16
+	clockID    uint64
+	path       string
+}
+
+type csptpSCIONClientMetrics struct {
+	reqsSent          prometheus.Counter
+	pktsReceived      prometheus.Counter
+	pktsAuthenticated prometheus.Counter
+	respsAccepted     prometheus.Counter
+}
+
+func newCSPTPSCIONClientMetrics() *csptpSCIONClientMetrics {
+	return &csptpSCIONClientMetrics{
+		reqsSent: promauto.NewCounter(prometheus.CounterOpts{
+			Name: metrics.CSPTPSCIONClientReqsSentN,
+			Help: metrics.CSPTPSCIONClientReqsSentH,
+		}),
+		pktsReceived: promauto.NewCounter(prometheus.CounterOpts{
+			Name: metrics.CSPTPSCIONClientPktsReceivedN,
+			Help: metrics.CSPTPSCIONClientPktsReceivedH,
+		}),
+		pktsAuthenticated: promauto.NewCounter(prometheus.CounterOpts{
+			Name: metrics.CSPTPSCIONClientPktsAuthenticatedN,
+			Help: metrics.CSPTPSCIONClientPktsAuthenticatedH,
+		}),
+		respsAccepted: promauto.NewCounter(prometheus.CounterOpts{
+			Name: metrics.CSPTPSCIONClientRespsAcceptedN,
+			Help: metrics.CSPTPSCIONClientRespsAcceptedH,
+		}),
+	}
+}
+
+func (c *CSPTPClientSCION) measureClockOffsetSCION(ctx context.Context, mtrcs *csptpSCIONClientMetrics,
+	localAddr, remoteAddr udp.UDPAddr, path snet.Path) (
+	timestamp time.Time, offset time.Duration, err error) {
+	if c.Auth.Enabled && c.Auth.opt == nil {
+		c.Auth.opt = &slayers.EndToEndOption{}
+		c.Auth.opt.OptData = make([]byte, scion.PacketAuthOptDataLen)
+		c.Auth.buf = make([]byte, spao.MACBufferSize)
+		c.Auth.mac = make([]byte, scion.PacketAuthMACLen)
+	}
+	if c.clockID == 0 {
+		// Servers match Sync and Follow Up requests by source port identity
+		// and sequence ID. A random clock ID keeps the sequences of clients
+		// on the same host apart.
+		var b [8]byte
+		_, err = rand.Read(b[:])
+		if err != nil {
+			return time.Time{}, 0, err
+		}
+		c.clockID = binary.BigEndian.Uint64(b[:]) | 1
+	}
+
+	laddr, ok := netip.AddrFromSlice(localAddr.Host.IP)
+	if !ok {
+		panic(errUnexpectedAddrType)
+	}
+	var lc net.ListenConfig
+	pconn, err := lc.ListenPacket(ctx, "udp", netip.AddrPortFrom(laddr, 0).String())
+	if err != nil {
+		return time.Time{}, 0, err
+	}
+	conn := pconn.(*net.UDPConn)
+	defer func() { _ = conn.Close() }()
+	deadline, deadlineIsSet := ctx.Deadline()
+	if deadlineIsSet {
+		err = conn.SetDeadline(deadline)
+		if err != nil {
+			return time.Time{}, 0, err
+		}
+	}
+	err = udp.EnableTimestamping(conn, localAddr.Host.Zone)
+	if err != nil {
+		c.Log.LogAttrs(ctx, slog.LevelError, "failed to enable timestamping", slog.Any("error", err))
+	}
+	err = udp.SetDSCP(conn, c.DSCP)
+	if err != nil {
+		c.Log.LogAttrs(ctx, slog.LevelInfo, "failed to set DSCP", slog.Any("error", err))
+	}
+
+	localPort := conn.LocalAddr().(*net.UDPAddr).Port
+
+	// remoteAddr.Host is shared by the clients measuring concurrently.
+	ip4 := remoteAddr.Host.IP.To4()
+	if ip4 != nil {
+		remoteAddr.Host = &net.UDPAddr{IP: ip4, Port: remoteAddr.Host.Port, Zone: remoteAddr.Host.Zone}
+	}
+
+	nextHop := path.UnderlayNextHop().AddrPort()
+	nextHopAddr := nextHop.Addr()
+	if nextHopAddr.Is4In6() {
+		nextHop = netip.AddrPortFrom(
+			netip.AddrFrom4(nextHopAddr.As4()),
+			nextHop.Port())
+	}
+
+	reference := remoteAddr.IA.String() + "," + remoteAddr.Host.IP.String()
+
+	var scionLayer slayers.SCION
+	scionLayer.TrafficClass = c.DSCP << 2
+	scionLayer.SrcIA = localAddr.IA
+	srcAddrIP, ok := netip.AddrFromSlice(localAddr.Host.IP)
+	if !ok {
+		panic(errUnexpectedAddrType)
+	}
+	err = scionLayer.SetSrcAddr(addr.HostIP(srcAddrIP.Unmap()))
+	if err != nil {
+		panic(err)
+	}
+	scionLayer.DstIA = remoteAddr.IA
+	dstAddrIP, ok := netip.AddrFromSlice(remoteAddr.Host.IP)
+	if !ok {
+		panic(errUnexpectedAddrType)
+	}
+	err = scionLayer.SetDstAddr(addr.HostIP(dstAddrIP.Unmap()))
+	if err != nil {
+		panic(err)
+	}
+	err = path.Dataplane().SetPath(&scionLayer)
+	if err != nil {
+		panic(err)
+	}
+
+	var udpLayer slayers.UDP
+	udpLayer.SrcPort = uint16(localPort)
+	udpLayer.SetNetworkLayerForChecksum(&scionLayer)
+
+	var authKey []byte
+	if c.Auth.Enabled {
+		hostHostKey, err := c.Auth.DRKeyFetcher.FetchHostHostKey(ctx, drkey.HostHostMeta{
+			ProtoId:  scion.DRKeyProtocolTS,
+			Validity: timebase.Now(),
+			SrcIA:    remoteAddr.IA,
+			DstIA:    localAddr.IA,
+			SrcHost:  remoteAddr.Host.IP.String(),
+			DstHost:  localAddr.Host.IP.String(),
+		})
+		if err != nil {
+			c.Log.LogAttrs(ctx, slog.LevelInfo, "failed to fetch DRKey level 3: host-host key", slog.Any("error", err))
+		} else {
+			authKey = hostHostKey.Key[:]
+		}
+	}
+
+	buffer := gopacket.NewSerializeBuffer()
+	options := gopacket.SerializeOptions{
+		ComputeChecksums: true,
+		FixLengths:       true,
+	}
+
+	var txid uint32
+	writeMsg := func(dstPort uint16, b []byte) (time.Time, error) {
+		err := buffer.Clear()
+		if err != nil {
+			panic(err)
+		}
+
+		payload := gopacket.Payload(b)
+		err = payload.SerializeTo(buffer, options)
+		if err != nil {
+			panic(err)
+		}
+		buffer.PushLayer(payload.LayerType())
+
+		udpLayer.DstPort = dstPort
+		err = udpLayer.SerializeTo(buffer, options)
+		if err != nil {
+			panic(err)
+		}
+		buffer.PushLayer(udpLayer.LayerType())
+
+		scionLayer.NextHdr = slayers.L4UDP
+		if authKey != nil {
+			scion.PreparePacketAuthOpt(c.Auth.opt, scion.PacketAuthSPIClient, scion.PacketAuthAlgorithm)
+			_, err = spao.ComputeAuthCMAC(
+				spao.MACInput{
+					Key:        authKey,
+					Header:     slayers.PacketAuthOption{EndToEndOption: c.Auth.opt},
+					ScionLayer: &scionLayer,
+					PldType:    scionLayer.NextHdr,
+					Pld:        buffer.Bytes(),
+				},
+				c.Auth.buf,
+				scion.PacketAuthOptMAC(c.Auth.opt),
+			)
+			if err != nil {
+				panic(err)
+			}
+
+			e2eExtn := slayers.EndToEndExtn{}
+			e2eExtn.NextHdr = scionLayer.NextHdr
+			e2eExtn.Options = []*slayers.EndToEndOption{c.Auth.opt}
+
+			err = e2eExtn.SerializeTo(buffer, options)
+			if err != nil {
+				panic(err)
+			}
+			buffer.PushLayer(e2eExtn.LayerType())
+
+			scionLayer.NextHdr = slayers.End2EndClass
+		}
+
+		err = scionLayer.SerializeTo(buffer, options)
+		if err != nil {
+			panic(err)
+		}
+		buffer.PushLayer(scionLayer.LayerType())
+
+		n, err := conn.WriteToUDPAddrPort(buffer.Bytes(), nextHop)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if n != len(buffer.Bytes()) {
+			return time.Time{}, errWrite
+		}
+		txt, id, err := udp.ReadTXTimestamp(conn)
+		if err != nil || id != txid {
+			txt = timebase.Now()
+			c.Log.LogAttrs(ctx, slog.LevelError, "failed to read packet tx timestamp", slog.Any("error", err))
+		}
+		txid++
+		return txt, nil
+	}
+
+	var cTxTime0, cRxTime0, cRxTime1 time.Time
+
+	buf := make([]byte, scion.MTU)
+
+	var msg csptp.Message
+	var reqtlv csptp.RequestTLV
+
+	msg = csptp.Message{
+		SdoIDMessageType:    csptp.MessageTypeSync,
+		PTPVersion:          csptp.PTPVersion,
+		MessageLength:       csptp.MinMessageLength,
+		DomainNumber:        csptp.DomainNumber,
+		MinorSdoID:          csptp.MinorSdoID,
+		FlagField:           csptp.FlagTwoStep | csptp.FlagUnicast,
+		CorrectionField:     0,
+		MessageTypeSpecific: 0,
+		SourcePortIdentity: csptp.PortID{
+			ClockID: c.clockID,
+			Port:    1,
+		},
+		SequenceID:         c.sequenceID,
+		ControlField:       csptp.ControlSync,
+		LogMessageInterval: 0,
+		Timestamp:          csptp.Timestamp{},
+	}
+
+	buf = buf[:msg.MessageLength]
+	csptp.EncodeMessage(buf, &msg)
+
+	cTxTime0, err = writeMsg(csptp.EventPortSCION, buf)
+	if err != nil {
+		return time.Time{}, 0, err
+	}
+
+	buf = buf[:cap(buf)]
+
+	msg = csptp.Message{
+		SdoIDMessageType:    csptp.MessageTypeFollowUp,
+		PTPVersion:          csptp.PTPVersion,
+		MessageLength:       csptp.MinMessageLength,
+		DomainNumber:        csptp.DomainNumber,
+		MinorSdoID:          csptp.MinorSdoID,
+		FlagField:           csptp.FlagUnicast,
+		CorrectionField:     0,
+		MessageTypeSpecific: 0,
+		SourcePortIdentity: csptp.PortID{
+			ClockID: c.clockID,
+			Port:    1,
+		},
+		SequenceID:         c.sequenceID,
+		ControlField:       csptp.ControlFollowUp,
+		LogMessageInterval: 0,
+		Timestamp:          csptp.Timestamp{},
+	}
+	reqtlv = csptp.RequestTLV{
+		Type:   csptp.TLVTypeOrganizationExtension,
+		Length: 0,
+		OrganizationID: [3]uint8{
+			csptp.OrganizationIDMeinberg0,
+			csptp.OrganizationIDMeinberg1,
+			csptp.OrganizationIDMeinberg2},
+		OrganizationSubType: [3]uint8{
+			csptp.OrganizationSubTypeRequest0,
+			csptp.OrganizationSubTypeRequest1,
+			csptp.OrganizationSubTypeRequest2},
+		FlagField: csptp.TLVFlagServerStateDS,
+	}
+	msg.MessageLength += uint16(csptp.EncodedRequestTLVLength(&reqtlv))
+	reqtlv.Length = uint16(csptp.EncodedRequestTLVLength(&reqtlv))
+
+	buf = buf[:msg.MessageLength]
+	csptp.EncodeMessage(buf[:csptp.MinMessageLength], &msg)
+	csptp.EncodeRequestTLV(buf[csptp.MinMessageLength:], &reqtlv)
+
+	_, err = writeMsg(csptp.GeneralPortSCION, buf)
+	if err != nil {
+		return time.Time{}, 0, err
+	}
+	mtrcs.reqsSent.Inc()
+
+	var (
+		hbhLayer  slayers.HopByHopExtnSkipper
+		e2eLayer  slayers.EndToEndExtn
+		scmpLayer slayers.SCMP
+	)
+	parser := gopacket.NewDecodingLayerParser(
+		slayers.LayerTypeSCION, &scionLayer, &hbhLayer, &e2eLayer, &udpLayer, &scmpLayer,
+	)
+	parser.IgnoreUnsupported = true
+	decoded := make([]gopacket.LayerType, 4)
+
+	oob := make([]byte, udp.TimestampLen())
+
+	var respmsg0, respmsg1 csptp.Message
+	var resptlv csptp.ResponseTLV
+	var respmsg0Ok, respmsg1Ok bool
+	var respmsg0Auth, respmsg1Auth bool
+
+	const maxNumRetries = 3
+	for numRetries := 0; ; numRetries++ {
+		buf = buf[:cap(buf)]
+		oob = oob[:cap(oob)]
+		n, oobn, flags, _, err := conn.ReadMsgUDPAddrPort(buf, oob)
+		if err != nil {
+			if numRetries != maxNumRetries && deadlineIsSet && timebase.Now().Before(deadline) {
+				c.Log.LogAttrs(ctx, slog.LevelInfo, "failed to read packet", slog.Any("error", err))
+				continue
+			}
+			return time.Time{}, 0, err
+		}
+		if flags != 0 {
+			err = errUnexpectedPacketFlags
+			if numRetries != maxNumRetries && deadlineIsSet && timebase.Now().Before(deadline) {
+				c.Log.LogAttrs(ctx, slog.LevelInfo, "failed to read packet", slog.Int("flags", flags))
+				continue
+			}
+			return time.Time{}, 0, err
+		}
+		mtrcs.pktsReceived.Inc()
+		oob = oob[:oobn]
+		rxt, err := udp.TimestampFromOOBData(oob)
+		if err != nil {
+			rxt = timebase.Now()
+			c.Log.LogAttrs(ctx, slog.LevelError, "failed to read packet rx timestamp", slog.Any("error", err))
+		}
+		buf = buf[:n]
+
+		err = parser.DecodeLayers(buf, &decoded)
+		if err != nil {
+			if numRetries != maxNumRetries && deadlineIsSet && timebase.Now().Before(deadline) {
+				c.Log.LogAttrs(ctx, slog.LevelInfo, "failed to decode packet", slog.Any("error", err))
+				continue
+			}
+			return time.Time{}, 0, err
+		}
+		validType := len(decoded) >= 2 && decoded[len(decoded)-1] == slayers.LayerTypeSCIONUDP &&
+			len(buf) >= int(udpLayer.Length)
+		if !validType {
+			err = errUnexpectedPacket
+			if numRetries != maxNumRetries && deadlineIsSet && timebase.Now().Before(deadline) {
+				c.Log.LogAttrs(ctx, slog.LevelInfo, "failed to decode packet", slog.String("cause", "unexpected type or structure"))
+				continue
+			}
+			return time.Time{}, 0, err
+		}
+		validSrc := scionLayer.SrcIA == remoteAddr.IA &&
+			compareIPs(scionLayer.RawSrcAddr, remoteAddr.Host.IP) == 0
+		validDst := scionLayer.DstIA == localAddr.IA &&
+			compareIPs(scionLayer.RawDstAddr, localAddr.Host.IP) == 0 &&
+			int(udpLayer.DstPort) == localPort
+		if !validSrc || !validDst {
+			err = errUnexpectedPacketSource
+			if numRetries != maxNumRetries && deadlineIsSet && timebase.Now().Before(deadline) {
+				if !validSrc {
+					c.Log.LogAttrs(ctx, slog.LevelInfo, "received packet from unexpected source")
+				}
+				if !validDst {
+					c.Log.LogAttrs(ctx, slog.LevelInfo, "received packet to unexpected destination")
+				}
+				continue
+			}
+			return time.Time{}, 0, err
+		}
+
+		authenticated := false
+		if len(decoded) >= 3 &&
+			decoded[len(decoded)-2] == slayers.LayerTypeEndToEndExtn {
+			tsOpt, err := e2eLayer.FindOption(scion.OptTypeTimestamp)
+			if err == nil {
+				rxt0, err := udp.TimestampFromOOBData(tsOpt.OptData)
+				if err == nil {
+					rxt = rxt0
+				}
+			}
+			if authKey != nil {
+				authOpt, err := e2eLayer.FindOption(slayers.OptTypeAuthenticator)
+				if err == nil {
+					spi, algo := scion.PacketAuthOptMetadata(authOpt)
+					if spi == scion.PacketAuthSPIServer && algo == scion.PacketAuthAlgorithm {
+						_, err = spao.ComputeAuthCMAC(
+							spao.MACInput{
+								Key:        authKey,
+								Header:     slayers.PacketAuthOption{EndToEndOption: authOpt},
+								ScionLayer: &scionLayer,
+								PldType:    slayers.L4UDP,
+								Pld:        buf[len(buf)-int(udpLayer.Length):],
+							},
+							c.Auth.buf,
+							c.Auth.mac,
+						)
+						if err != nil {
+							panic(err)
+						}
+						authenticated = subtle.ConstantTimeCompare(scion.PacketAuthOptMAC(authOpt), c.Auth.mac) != 0
+						if !authenticated {
+							err = errInvalidPacketAuthenticator
+							if numRetries != maxNumRetries && deadlineIsSet && timebase.Now().Before(deadline) {
+								c.Log.LogAttrs(ctx, slog.LevelInfo, "failed to authenticate packet", slog.Any("error", err))
+								continue
+							}
+							return time.Time{}, 0, err
+						}
+						mtrcs.pktsAuthenticated.Inc()
+					}
+				}
+			}
+		}
+
+		payload := udpLayer.Payload
+		err = csptp.DecodeMessage(&msg, payload)
+		if err != nil {
+			if numRetries != maxNumRetries && deadlineIsSet && timebase.Now().Before(deadline) {
+				c.Log.LogAttrs(ctx, slog.LevelInfo, "failed to decode packet payload", slog.Any("error", err))
+				continue
+			}
+			return time.Time{}, 0, err
+		}
+
+		if len(payload) != int(msg.MessageLength) || msg.SequenceID != c.sequenceID {
+			err = errUnexpectedPacket
+			if numRetries != maxNumRetries && deadlineIsSet && timebase.Now().Before(deadline) {
+				c.Log.LogAttrs(ctx, slog.LevelInfo, "received unexpected message")
+				continue
+			}
+			return time.Time{}, 0, err
+		}
+
+		if msg.SdoIDMessageType == csptp.MessageTypeSync {
+			respmsg0Ok = false
+
+			if udpLayer.SrcPort != csptp.EventPortSCION {
+				err = errUnexpectedPacketSource
+				if numRetries != maxNumRetries && deadlineIsSet && timebase.Now().Before(deadline) {
+					c.Log.LogAttrs(ctx, slog.LevelInfo, "failed to read packet: unexpected source")
+					continue
+				}
+				return time.Time{}, 0, err
+			}
+
+			if len(payload)-csptp.MinMessageLength != 0 {
+				err = errUnexpectedPacket
+				if numRetries != maxNumRetries && deadlineIsSet && timebase.Now().Before(deadline) {
+					c.Log.LogAttrs(ctx, slog.LevelInfo, "received unexpected message")
+					continue
+				}
+				return time.Time{}, 0, err
+			}
+
+			cRxTime0 = rxt
+			respmsg0, respmsg0Ok, respmsg0Auth = msg, true, authenticated
+		} else if msg.SdoIDMessageType == csptp.MessageTypeFollowUp {
+			respmsg1Ok = false
+
+			if udpLayer.SrcPort != csptp.GeneralPortSCION {
+				err = errUnexpectedPacketSource
+				if numRetries != maxNumRetries && deadlineIsSet && timebase.Now().Before(deadline) {
+					c.Log.LogAttrs(ctx, slog.LevelInfo, "failed to read packet: unexpected source")
+					continue
+				}
+				return time.Time{}, 0, err
+			}
+
+			err = csptp.DecodeResponseTLV(&resptlv, payload[csptp.MinMessageLength:])
+			if err != nil {
+				if numRetries != maxNumRetries && deadlineIsSet && timebase.Now().Before(deadline) {
+					c.Log.LogAttrs(ctx, slog.LevelInfo, "failed to decode packet payload", slog.Any("error", err))
+					continue
+				}
+				return time.Time{}, 0, err
+			}
+			if resptlv.Type != csptp.TLVTypeOrganizationExtension ||
+				resptlv.OrganizationID[0] != csptp.OrganizationIDMeinberg0 ||
+				resptlv.OrganizationID[1] != csptp.OrganizationIDMeinberg1 ||
+				resptlv.OrganizationID[2] != csptp.OrganizationIDMeinberg2 ||
+				resptlv.OrganizationSubType[0] != csptp.OrganizationSubTypeResponse0 ||
+				resptlv.OrganizationSubType[1] != csptp.OrganizationSubTypeResponse1 ||
+				resptlv.OrganizationSubType[2] != csptp.OrganizationSubTypeResponse2 ||
+				len(payload)-csptp.MinMessageLength != csptp.EncodedResponseTLVLength(&resptlv) {
+				err = errUnexpectedPacket
+				if numRetries != maxNumRetries && deadlineIsSet && timebase.Now().Before(deadline) {
+					c.Log.LogAttrs(ctx, slog.LevelInfo, "received unexpected message")
+					continue
+				}
+				return time.Time{}, 0, err
+			}
+
+			cRxTime1 = rxt
+			respmsg1, respmsg1Ok, respmsg1Auth = msg, true, authenticated
+		} else {
+			err = errUnexpectedPacket
+			if numRetries != maxNumRetries && deadlineIsSet && timebase.Now().Before(deadline) {
+				c.Log.LogAttrs(ctx, slog.LevelInfo, "received unexpected message")
+				continue
+			}
+			return time.Time{}, 0, err
+		}
+
+		if respmsg0Ok && respmsg1Ok {
+			break
+		}
+	}
+
+	authenticated := respmsg0Auth && respmsg1Auth
+
+	c.Log.LogAttrs(ctx, slog.LevelDebug, "received response",
+		slog.Time("at", cRxTime1),
+		slog.String("from", reference),
+		slog.String("via", snet.Fingerprint(path).String()),
+		slog.Bool("auth", authenticated),
+		slog.Any("respmsg0", &respmsg0),
+		slog.Any("respmsg1", &respmsg1),
+		slog.Any("resptlv", &resptlv),
+	)
+
+	t0 := cTxTime0
+	t1 := csptp.TimeFromTimestamp(resptlv.RequestIngressTimestamp)
+	t1Corr := csptp.DurationFromTimeInterval(resptlv.RequestCorrectionField)
+	t2 := csptp.TimeFromTimestamp(respmsg1.Timestamp)
+	t3 := cRxTime0
+	t3Corr := csptp.DurationFromTimeInterval(respmsg0.CorrectionField) +
+		csptp.DurationFromTimeInterval(respmsg1.CorrectionField)
+	var utcCorr time.Duration
+	if respmsg1.FlagField&csptp.FlagCurrentUTCOffsetValid == csptp.FlagCurrentUTCOffsetValid {
+		utcCorr = time.Duration(int64(resptlv.UTCOffset) * time.Second.Nanoseconds())
+	}
+
+	c2sDelay := csptp.C2SDelay(t0, t1, t1Corr, utcCorr)
+	s2cDelay := csptp.S2CDelay(t2, t3, t3Corr, utcCorr)
+	clockOffset := csptp.ClockOffset(t0, t1, t2, t3, t1Corr, t3Corr)
+	meanPathDelay := csptp.MeanPathDelay(t0, t1, t2, t3, t1Corr, t3Corr)
+
+	c.Log.LogAttrs(ctx, slog.LevelDebug, "evaluated response",
+		slog.Time("at", cRxTime1),
+		slog.String("from", reference),
+		slog.String("via", snet.Fingerprint(path).String()),
+		slog.Duration("C2S delay", c2sDelay),
+		slog.Duration("S2C delay", s2cDelay),
+		slog.Duration("clock offset", clockOffset),
+		slog.Duration("mean path delay", meanPathDelay),
+	)
+
+	mtrcs.respsAccepted.Inc()
+
+	timestamp = cRxTime0
+	if c.Filter == nil {
+		offset = clockOffset
+	} else {
+		// Correction fields are applied to the timestamps they refer to s.t.
+		// filters can evaluate the exchange like an NTP exchange.
+		offset = c.Filter.Do(t0, t1.Add(-t1Corr), t2, t3.Add(-t3Corr))
+	}
+
+	c.sequenceID++
+	return timestamp, offset, nil
+}
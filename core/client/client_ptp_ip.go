@@ -0,0 +1,695 @@
+package client
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"log/slog"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"example.com/scion-time/base/metrics"
+
+	"example.com/scion-time/core/measurements"
+	"example.com/scion-time/core/timebase"
+
+	"example.com/scion-time/net/csptp"
+	"example.com/scion-time/net/ptp"
+	"example.com/scion-time/net/udp"
+)
+
+const (
+	// Message intervals and duration requested in unicast negotiation.
+	ptpLogAnnounceInterval  = 0
+	ptpLogSyncInterval      = -2
+	ptpLogDelayRespInterval = -2
+	ptpGrantDuration        = 60 // seconds
+
+	// ptpRequestInterval is the minimum time between requests for the same
+	// grant, e.g., after a request has been denied or lost.
+	ptpRequestInterval = 1 * time.Second
+
+	// ptpAnnounceReceiptTimeout is the number of announce intervals after
+	// which a server without new Announce messages is no longer selectable.
+	ptpAnnounceReceiptTimeout = 3
+
+	// ptpPollInterval is the interval at which grants are checked while
+	// waiting for messages.
+	ptpPollInterval = 250 * time.Millisecond
+
+	// ptpSyncReceiptTimeout is the time after which the Sync grant of the
+	// selected server is requested again if no Sync message has been
+	// received. Servers limit the number of Sync messages sent without
+	// Delay_Req messages from the client, e.g., to servers not selected before.
+	ptpSyncReceiptTimeout = 2 * time.Second
+)
+
+// Grants are recorded per message type requested by the client.
+const (
+	ptpGrantAnnounce = iota
+	ptpGrantSync
+	ptpGrantDelayResp
+	ptpNumGrants
+)
+
+var ptpGrantMessageTypes = [ptpNumGrants]struct {
+	msgType     uint8
+	logInterval int8
+}{
+	{ptp.MessageTypeAnnounce, ptpLogAnnounceInterval},
+	{csptp.MessageTypeSync, ptpLogSyncInterval},
+	{ptp.MessageTypeDelayResp, ptpLogDelayRespInterval},
+}
+
+var errNoPTPServer = errors.New("failed to measure clock offset: no selectable PTP server")
+
+// PTPClientIP is a PTP unicast client, see IEEE 1588-2019, 16.1. It negotiates
+// the transmission of Announce, Sync and Delay_Resp messages with its
+// servers, selects the best of them with the dataset comparison of the best
+// master clock algorithm and measures the clock offset to the selected server
+// with Delay_Req messages. The client listens on the PTP event and general
+// ports of its local address until it is closed.
+type PTPClientIP struct {
+	Log    *slog.Logger
+	DSCP   uint8
+	Filter measurements.Filter
+	// Port, if not 0, replaces the PTP event port of client and servers and
+	// Port+1 the PTP general port, e.g., for tests.
+	Port int
+
+	mu       sync.Mutex
+	started  bool
+	portID   csptp.PortID
+	event    *net.UDPConn
+	eventW   *net.UDPConn
+	general  *net.UDPConn
+	txid     uint32
+	servers  map[netip.Addr]*ptpServerState
+	selected netip.Addr
+	notify   chan struct{}
+
+	signalingSeq uint16
+	delayReqSeq  uint16
+	delayReq     struct {
+		addr    netip.Addr
+		seq     uint16
+		pending bool
+	}
+	delayResp struct {
+		msg csptp.Message
+		ok  bool
+	}
+}
+
+type ptpServerState struct {
+	grants [ptpNumGrants]struct {
+		expiry    time.Time
+		requested time.Time
+	}
+
+	announce         ptp.Announce
+	announceFlags    uint16
+	announceTime     time.Time
+	announceInterval time.Duration
+	syncTime         time.Time
+
+	// sync and followUp hold the messages of a two-step Sync until the other
+	// message of the same sequence arrives. Both are read from different
+	// sockets and may be processed in either order.
+	sync struct {
+		msg    csptp.Message
+		rxTime time.Time
+		ok     bool
+	}
+	followUp struct {
+		msg csptp.Message
+		ok  bool
+	}
+	// sample is the latest complete Sync measurement.
+	sample struct {
+		t2   time.Time // preciseOriginTimestamp
+		t3   time.Time // Sync reception
+		corr time.Duration
+		used bool
+		ok   bool
+	}
+}
+
+type ptpIPClientMetrics struct {
+	pktsReceived   prometheus.Counter
+	grantsReceived prometheus.Counter
+	reqsSent       prometheus.Counter
+	respsAccepted  prometheus.Counter
+}
+
+func newPTPIPClientMetrics() *ptpIPClientMetrics {
+	return &ptpIPClientMetrics{
+		pktsReceived: promauto.NewCounter(prometheus.CounterOpts{
+			Name: metrics.PTPIPClientPktsReceivedN,
+			Help: metrics.PTPIPClientPktsReceivedH,
+		}),
+		grantsReceived: promauto.NewCounter(prometheus.CounterOpts{
+			Name: metrics.PTPIPClientGrantsReceivedN,
+			Help: metrics.PTPIPClientGrantsReceivedH,
+		}),
+		reqsSent: promauto.NewCounter(prometheus.CounterOpts{
+			Name: metrics.PTPIPClientReqsSentN,
+			Help: metrics.PTPIPClientReqsSentH,
+		}),
+		respsAccepted: promauto.NewCounter(prometheus.CounterOpts{
+			Name: metrics.PTPIPClientRespsAcceptedN,
+			Help: metrics.PTPIPClientRespsAcceptedH,
+		}),
+	}
+}
+
+func (c *PTPClientIP) newMessage(msgType, control uint8, length, sequenceID uint16) csptp.Message {
+	return csptp.Message{
+		SdoIDMessageType:    msgType,
+		PTPVersion:          csptp.PTPVersion,
+		MessageLength:       length,
+		DomainNumber:        csptp.DomainNumber,
+		MinorSdoID:          csptp.MinorSdoID,
+		FlagField:           csptp.FlagUnicast,
+		CorrectionField:     0,
+		MessageTypeSpecific: 0,
+		SourcePortIdentity:  c.portID,
+		SequenceID:          sequenceID,
+		ControlField:        control,
+		LogMessageInterval:  csptp.LogMessageInterval,
+		Timestamp:           csptp.Timestamp{},
+	}
+}
+
+func (c *PTPClientIP) eventPort() uint16 {
+	if c.Port != 0 {
+		return uint16(c.Port)
+	}
+	return csptp.EventPortIP
+}
+
+func (c *PTPClientIP) generalPort() uint16 {
+	if c.Port != 0 {
+		return uint16(c.Port + 1)
+	}
+	return csptp.GeneralPortIP
+}
+
+// start opens the event and general sockets on localAddr and starts reading
+// messages from them. c.mu must be held.
+func (c *PTPClientIP) start(ctx context.Context, localAddr netip.Addr) error {
+	var b [8]byte
+	_, err := rand.Read(b[:])
+	if err != nil {
+		return err
+	}
+	c.portID = csptp.PortID{
+		ClockID: binary.BigEndian.Uint64(b[:]) &^ 1, // never all ones
+		Port:    1,
+	}
+
+	var lc net.ListenConfig
+	pconn, err := lc.ListenPacket(ctx, "udp", netip.AddrPortFrom(localAddr, c.eventPort()).String())
+	if err != nil {
+		return err
+	}
+	event := pconn.(*net.UDPConn)
+	pconn, err = lc.ListenPacket(ctx, "udp", netip.AddrPortFrom(localAddr, c.generalPort()).String())
+	if err != nil {
+		_ = event.Close()
+		return err
+	}
+	general := pconn.(*net.UDPConn)
+
+	// Delay_Req messages are written to a duplicate of the event socket's file
+	// descriptor such that reading their transmit timestamps does not block
+	// while a read on the event socket is pending.
+	f, err := event.File()
+	if err != nil {
+		_ = event.Close()
+		_ = general.Close()
+		return err
+	}
+	eventW, err := net.FileConn(f)
+	_ = f.Close()
+	if err != nil {
+		_ = event.Close()
+		_ = general.Close()
+		return err
+	}
+
+	err = udp.EnableTimestamping(event, localAddr.Zone())
+	if err != nil {
+		c.Log.LogAttrs(ctx, slog.LevelError, "failed to enable timestamping", slog.Any("error", err))
+	}
+	for _, conn := range []*net.UDPConn{event, general} {
+		err = udp.SetDSCP(conn, c.DSCP)
+		if err != nil {
+			c.Log.LogAttrs(ctx, slog.LevelInfo, "failed to set DSCP", slog.Any("error", err))
+		}
+	}
+
+	c.started = true
+	c.event, c.eventW, c.general = event, eventW.(*net.UDPConn), general
+	c.servers = make(map[netip.Addr]*ptpServerState)
+	c.notify = make(chan struct{}, 1)
+
+	mtrcs := ptpIPMetrics.Load()
+	go c.run(mtrcs, event, true /* event */)
+	go c.run(mtrcs, general, false /* event */)
+	return nil
+}
+
+// Close cancels all grants and closes the client's sockets.
+func (c *PTPClientIP) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.started {
+		return nil
+	}
+	for addr, s := range c.servers {
+		var tlvs []ptp.UnicastTLV
+		for i := range s.grants {
+			if !s.grants[i].expiry.IsZero() {
+				tlvs = append(tlvs,
+					ptp.NewUnicastTLV(ptp.TLVTypeCancelUnicastTransmission, ptpGrantMessageTypes[i].msgType))
+			}
+		}
+		if len(tlvs) != 0 {
+			_ = c.sendSignaling(addr, tlvs)
+		}
+	}
+	c.started = false
+	return errors.Join(c.event.Close(), c.eventW.Close(), c.general.Close())
+}
+
+func (c *PTPClientIP) wakeUp() {
+	select {
+	case c.notify <- struct{}{}:
+	default:
+	}
+}
+
+// sendSignaling sends a Signaling message with the given unicast negotiation
+// TLVs to the server with address addr. c.mu must be held.
+func (c *PTPClientIP) sendSignaling(addr netip.Addr, tlvs []ptp.UnicastTLV) error {
+	n := ptp.SignalingMessageLength
+	for i := range tlvs {
+		n += ptp.EncodedUnicastTLVLength(&tlvs[i])
+	}
+	b := make([]byte, n)
+	msg := c.newMessage(ptp.MessageTypeSignaling, csptp.ControlOther, uint16(n), c.signalingSeq)
+	csptp.EncodeMessage(b[:csptp.MinMessageLength], &msg)
+	ptp.EncodePortID(b[34:ptp.SignalingMessageLength], ptp.AllPorts)
+	n = ptp.SignalingMessageLength
+	for i := range tlvs {
+		ptp.EncodeUnicastTLV(b[n:], &tlvs[i])
+		n += ptp.EncodedUnicastTLVLength(&tlvs[i])
+	}
+	c.signalingSeq++
+	m, err := c.general.WriteToUDPAddrPort(b, netip.AddrPortFrom(addr, c.generalPort()))
+	if err != nil {
+		return err
+	}
+	if m != len(b) {
+		return errWrite
+	}
+	return nil
+}
+
+// negotiate requests grants from the servers with addresses addrs that have
+// not been granted or expire within half of the requested duration. The Sync
+// grant of the selected server is also requested again if no Sync message has
+// been received from it for ptpSyncReceiptTimeout.
+func (c *PTPClientIP) negotiate(ctx context.Context, addrs []netip.Addr) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	for _, addr := range addrs {
+		s, ok := c.servers[addr]
+		if !ok {
+			s = &ptpServerState{}
+			c.servers[addr] = s
+		}
+		var tlvs []ptp.UnicastTLV
+		for i := range s.grants {
+			g := &s.grants[i]
+			stalled := i == ptpGrantSync && addr == c.selected && now.Sub(s.syncTime) > ptpSyncReceiptTimeout
+			if g.expiry.Sub(now) > ptpGrantDuration*time.Second/2 && !stalled ||
+				now.Sub(g.requested) < ptpRequestInterval {
+				continue
+			}
+			g.requested = now
+			tlv := ptp.NewUnicastTLV(ptp.TLVTypeRequestUnicastTransmission, ptpGrantMessageTypes[i].msgType)
+			tlv.LogInterMessagePeriod = ptpGrantMessageTypes[i].logInterval
+			tlv.DurationField = ptpGrantDuration
+			tlvs = append(tlvs, tlv)
+		}
+		if len(tlvs) == 0 {
+			continue
+		}
+		err := c.sendSignaling(addr, tlvs)
+		if err != nil {
+			c.Log.LogAttrs(ctx, slog.LevelInfo, "failed to request unicast transmission",
+				slog.String("to", addr.String()), slog.Any("error", err))
+		}
+	}
+}
+
+// selectServer selects the best of the servers with addresses addrs by the
+// datasets of their latest Announce messages. c.mu must be held.
+func (c *PTPClientIP) selectServer(ctx context.Context, now time.Time, addrs []netip.Addr) (
+	netip.Addr, *ptpServerState, bool) {
+	var best netip.Addr
+	var bestState *ptpServerState
+	for _, addr := range addrs {
+		s, ok := c.servers[addr]
+		if !ok || s.announceTime.IsZero() ||
+			now.Sub(s.announceTime) > ptpAnnounceReceiptTimeout*s.announceInterval ||
+			!s.grants[ptpGrantSync].expiry.After(now) ||
+			!s.grants[ptpGrantDelayResp].expiry.After(now) ||
+			s.announce.DS.StepsRemoved >= 255 {
+			continue
+		}
+		if bestState == nil {
+			best, bestState = addr, s
+			continue
+		}
+		x := ptp.CompareDatasets(&s.announce.DS, &bestState.announce.DS)
+		if x < 0 || x == 0 && addr == c.selected {
+			best, bestState = addr, s
+		}
+	}
+	if bestState == nil {
+		return netip.Addr{}, nil, false
+	}
+	if best != c.selected {
+		c.Log.LogAttrs(ctx, slog.LevelInfo, "selected PTP server",
+			slog.String("addr", best.String()),
+			slog.Uint64("gm_clock_id", bestState.announce.DS.GMClockID),
+			slog.Int("gm_clock_class", int(bestState.announce.DS.GMClockClass)),
+			slog.Int("steps_removed", int(bestState.announce.DS.StepsRemoved)),
+		)
+		c.selected = best
+	}
+	return best, bestState, true
+}
+
+// Selected returns the address of the currently selected server, if any.
+func (c *PTPClientIP) Selected() netip.Addr {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.selected
+}
+
+func (c *PTPClientIP) wait(ctx context.Context) error {
+	t := time.NewTimer(ptpPollInterval)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-c.notify:
+	case <-t.C:
+	}
+	return nil
+}
+
+func (c *PTPClientIP) MeasureClockOffset(ctx context.Context, localAddr netip.Addr, remoteAddrs []netip.Addr) (
+	timestamp time.Time, offset time.Duration, err error) {
+	mtrcs := ptpIPMetrics.Load()
+
+	c.mu.Lock()
+	if !c.started {
+		err = c.start(ctx, localAddr)
+	}
+	c.mu.Unlock()
+	if err != nil {
+		return time.Time{}, 0, err
+	}
+
+	// Wait for a new Sync measurement of the best server.
+	var addr netip.Addr
+	var seq uint16
+	var t2, t3 time.Time
+	var t3Corr, utcCorr time.Duration
+	for {
+		c.negotiate(ctx, remoteAddrs)
+		c.mu.Lock()
+		a, s, ok := c.selectServer(ctx, time.Now(), remoteAddrs)
+		if ok && s.sample.ok && !s.sample.used {
+			s.sample.used = true
+			addr = a
+			t2, t3, t3Corr = s.sample.t2, s.sample.t3, s.sample.corr
+			if s.announceFlags&csptp.FlagPTPTimescale == csptp.FlagPTPTimescale {
+				utcCorr = time.Duration(int64(s.announce.CurrentUTCOffset) * time.Second.Nanoseconds())
+			}
+			seq = c.delayReqSeq
+			c.delayReq.addr = addr
+			c.delayReq.seq = seq
+			c.delayReq.pending = true
+			c.delayResp.ok = false
+			c.delayReqSeq++
+		}
+		c.mu.Unlock()
+		if addr.IsValid() {
+			break
+		}
+		err = c.wait(ctx)
+		if err != nil {
+			if !ok {
+				err = errNoPTPServer
+			}
+			return time.Time{}, 0, err
+		}
+	}
+	defer func() {
+		c.mu.Lock()
+		c.delayReq.pending = false
+		c.mu.Unlock()
+	}()
+
+	buf := make([]byte, ptp.DelayReqMessageLength)
+	msg := c.newMessage(ptp.MessageTypeDelayReq, ptp.ControlDelayReq,
+		ptp.DelayReqMessageLength, seq)
+	csptp.EncodeMessage(buf, &msg)
+	n, err := c.eventW.WriteToUDPAddrPort(buf, netip.AddrPortFrom(addr, c.eventPort()))
+	if err != nil {
+		return time.Time{}, 0, err
+	}
+	if n != len(buf) {
+		return time.Time{}, 0, errWrite
+	}
+	t0, id, err := udp.ReadTXTimestamp(c.eventW)
+	if err != nil || id != c.txid {
+		t0 = timebase.Now()
+		c.Log.LogAttrs(ctx, slog.LevelError, "failed to read packet tx timestamp", slog.Any("error", err))
+		if err == nil {
+			c.txid = id
+		}
+	}
+	c.txid++
+	mtrcs.reqsSent.Inc()
+
+	var respmsg csptp.Message
+	for {
+		var ok bool
+		c.mu.Lock()
+		respmsg, ok = c.delayResp.msg, c.delayResp.ok
+		c.mu.Unlock()
+		if ok {
+			break
+		}
+		err = c.wait(ctx)
+		if err != nil {
+			return time.Time{}, 0, err
+		}
+	}
+	mtrcs.respsAccepted.Inc()
+
+	// Server timestamps in the PTP timescale are converted to UTC.
+	t1 := csptp.TimeFromTimestamp(respmsg.Timestamp).Add(-utcCorr)
+	t1Corr := csptp.DurationFromTimeInterval(respmsg.CorrectionField)
+	t2 = t2.Add(-utcCorr)
+
+	clockOffset := csptp.ClockOffset(t0, t1, t2, t3, t1Corr, t3Corr)
+	meanPathDelay := csptp.MeanPathDelay(t0, t1, t2, t3, t1Corr, t3Corr)
+
+	c.Log.LogAttrs(ctx, slog.LevelDebug, "evaluated response",
+		slog.String("from", addr.String()),
+		slog.Duration("C2S delay", csptp.C2SDelay(t0, t1, t1Corr, 0)),
+		slog.Duration("S2C delay", csptp.S2CDelay(t2, t3, t3Corr, 0)),
+		slog.Duration("clock offset", clockOffset),
+		slog.Duration("mean path delay", meanPathDelay),
+	)
+
+	timestamp = t0
+	if c.Filter == nil {
+		offset = clockOffset
+	} else {
+		// Correction fields are applied to the timestamps they refer to s.t.
+		// filters can evaluate the exchange like an NTP exchange.
+		offset = c.Filter.Do(t0, t1.Add(-t1Corr), t2, t3.Add(-t3Corr))
+	}
+	return
+}
+
+// pairSync completes the Sync measurement of server s if both messages of a
+// two-step Sync have been received. c.mu must be held.
+func (c *PTPClientIP) pairSync(s *ptpServerState) {
+	if !s.sync.ok || !s.followUp.ok ||
+		s.sync.msg.SequenceID != s.followUp.msg.SequenceID ||
+		s.sync.msg.SourcePortIdentity != s.followUp.msg.SourcePortIdentity {
+		return
+	}
+	s.sync.ok, s.followUp.ok = false, false
+	s.sample.t2 = csptp.TimeFromTimestamp(s.followUp.msg.Timestamp)
+	s.sample.t3 = s.sync.rxTime
+	s.sample.corr = csptp.DurationFromTimeInterval(s.sync.msg.CorrectionField) +
+		csptp.DurationFromTimeInterval(s.followUp.msg.CorrectionField)
+	s.sample.used, s.sample.ok = false, true
+	s.syncTime = time.Now()
+	c.wakeUp()
+}
+
+// run reads messages from the event or the general socket conn until it is
+// closed.
+func (c *PTPClientIP) run(mtrcs *ptpIPClientMetrics, conn *net.UDPConn, event bool) {
+	ctx := context.Background()
+	buf := make([]byte, ptp.MaxMessageLength)
+	oob := make([]byte, udp.TimestampLen())
+	for {
+		buf = buf[:cap(buf)]
+		oob = oob[:cap(oob)]
+		n, oobn, flags, srcAddr, err := conn.ReadMsgUDPAddrPort(buf, oob)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			c.Log.LogAttrs(ctx, slog.LevelInfo, "failed to read packet", slog.Any("error", err))
+			continue
+		}
+		if flags != 0 {
+			c.Log.LogAttrs(ctx, slog.LevelInfo, "failed to read packet", slog.Int("flags", flags))
+			continue
+		}
+		rxt := timebase.Now()
+		if event {
+			t, err := udp.TimestampFromOOBData(oob[:oobn])
+			if err != nil {
+				c.Log.LogAttrs(ctx, slog.LevelError, "failed to read packet rx timestamp", slog.Any("error", err))
+			} else {
+				rxt = t
+			}
+		}
+		buf = buf[:n]
+		mtrcs.pktsReceived.Inc()
+
+		var msg csptp.Message
+		err = csptp.DecodeMessage(&msg, buf)
+		if err != nil || len(buf) < int(msg.MessageLength) || msg.DomainNumber != csptp.DomainNumber {
+			c.Log.LogAttrs(ctx, slog.LevelInfo, "received unexpected message",
+				slog.String("from", srcAddr.String()))
+			continue
+		}
+		buf = buf[:msg.MessageLength]
+
+		c.mu.Lock()
+		s, ok := c.servers[srcAddr.Addr().Unmap()]
+		if ok {
+			c.handleMessage(ctx, mtrcs, s, srcAddr.Addr().Unmap(), &msg, buf, rxt, event)
+		}
+		c.mu.Unlock()
+	}
+}
+
+// handleMessage processes message msg with payload b received from server s
+// with address addr. c.mu must be held.
+func (c *PTPClientIP) handleMessage(ctx context.Context, mtrcs *ptpIPClientMetrics, s *ptpServerState,
+	addr netip.Addr, msg *csptp.Message, b []byte, rxt time.Time, event bool) {
+	switch {
+	case event && msg.SdoIDMessageType == csptp.MessageTypeSync:
+		if msg.FlagField&csptp.FlagTwoStep == csptp.FlagTwoStep {
+			s.sync.msg, s.sync.rxTime, s.sync.ok = *msg, rxt, true
+			c.pairSync(s)
+			return
+		}
+		s.sample.t2 = csptp.TimeFromTimestamp(msg.Timestamp)
+		s.sample.t3 = rxt
+		s.sample.corr = csptp.DurationFromTimeInterval(msg.CorrectionField)
+		s.sample.used, s.sample.ok = false, true
+		s.syncTime = time.Now()
+		c.wakeUp()
+	case !event && msg.SdoIDMessageType == csptp.MessageTypeFollowUp:
+		s.followUp.msg, s.followUp.ok = *msg, true
+		c.pairSync(s)
+	case !event && msg.SdoIDMessageType == ptp.MessageTypeAnnounce:
+		err := ptp.DecodeAnnounce(&s.announce, b[csptp.MinMessageLength:])
+		if err != nil {
+			c.Log.LogAttrs(ctx, slog.LevelInfo, "failed to decode packet payload", slog.Any("error", err))
+			return
+		}
+		s.announceFlags = msg.FlagField
+		s.announceTime = time.Now()
+		s.announceInterval = time.Second
+		if l := msg.LogMessageInterval; l >= -7 && l <= 7 {
+			if l < 0 {
+				s.announceInterval = time.Second >> -l
+			} else {
+				s.announceInterval = time.Second << l
+			}
+		}
+		c.wakeUp()
+	case !event && msg.SdoIDMessageType == ptp.MessageTypeDelayResp:
+		if len(b) < ptp.DelayRespMessageLength || !c.delayReq.pending || c.delayReq.addr != addr ||
+			c.delayReq.seq != msg.SequenceID ||
+			ptp.DecodePortID(b[csptp.MinMessageLength:ptp.DelayRespMessageLength]) != c.portID {
+			return
+		}
+		c.delayReq.pending = false
+		c.delayResp.msg, c.delayResp.ok = *msg, true
+		c.wakeUp()
+	case !event && msg.SdoIDMessageType == ptp.MessageTypeSignaling:
+		if len(b) < ptp.SignalingMessageLength ||
+			ptp.DecodePortID(b[34:ptp.SignalingMessageLength]) != c.portID {
+			return
+		}
+		for b = b[ptp.SignalingMessageLength:]; len(b) != 0; {
+			typ, n, err := ptp.DecodeTLVHeader(b)
+			if err != nil {
+				c.Log.LogAttrs(ctx, slog.LevelInfo, "failed to decode packet payload", slog.Any("error", err))
+				return
+			}
+			tlvb := b[:n]
+			b = b[n:]
+			if typ != ptp.TLVTypeGrantUnicastTransmission {
+				continue
+			}
+			var tlv ptp.UnicastTLV
+			err = ptp.DecodeUnicastTLV(&tlv, tlvb)
+			if err != nil {
+				c.Log.LogAttrs(ctx, slog.LevelInfo, "failed to decode packet payload", slog.Any("error", err))
+				continue
+			}
+			for i, x := range ptpGrantMessageTypes {
+				if x.msgType != tlv.MessageType {
+					continue
+				}
+				if tlv.DurationField == 0 {
+					s.grants[i].expiry = time.Time{}
+					c.Log.LogAttrs(ctx, slog.LevelInfo, "unicast transmission denied",
+						slog.String("from", addr.String()),
+						slog.Int("msg_type", int(tlv.MessageType)))
+					break
+				}
+				s.grants[i].expiry = time.Now().Add(time.Duration(tlv.DurationField) * time.Second)
+				mtrcs.grantsReceived.Inc()
+			}
+		}
+	}
+}
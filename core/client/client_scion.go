@@ -37,7 +37,18 @@ type SCIONClient struct {
 	Log             *slog.Logger
 	DSCP            uint8
 	InterleavedMode bool
-	Auth            struct {
+	// NTPv5 enables NTPv5 with servers supporting it. Support is negotiated
+	// in NTPv4 requests or, with NTS, in the key exchange, for which
+	// Auth.NTSKEFetcher.NTPv5 must be set as well.
+	NTPv5 bool
+	// RefIDs, if not nil, enables loop detection with NTPv5 servers, see
+	// server.RefIDs.
+	RefIDs *ntp.RefIDs
+	// Symmetric enables symmetric active mode, in which c acts as a peer of
+	// the server instead of as its client, see RFC 5905, Section 9. NTPv5 is
+	// not used in symmetric mode or with a MAC.
+	Symmetric bool
+	Auth      struct {
 		Enabled      bool
 		NTSEnabled   bool
 		DRKeyFetcher *scion.Fetcher
@@ -45,16 +56,23 @@ type SCIONClient struct {
 		buf          []byte
 		mac          []byte
 		NTSKEFetcher ntske.Fetcher
+		// SymmetricKey authenticates packets by a MAC if its ID is not 0 and
+		// NTS is not enabled, see RFC 8573.
+		SymmetricKey ntp.SymmetricKey
 	}
 	Filter    measurements.Filter
 	Histogram *hdrhistogram.Histogram
+	kod       kodState
+	ntpv5     ntpv5State
+	stats     statsState
 	prev      struct {
-		reference   string
-		path        string
-		interleaved bool
-		cTxTime     ntp.Time64
-		cRxTime     ntp.Time64
-		sRxTime     ntp.Time64
+		reference    string
+		path         string
+		interleaved  bool
+		cTxTime      ntp.Time64
+		cRxTime      ntp.Time64
+		sRxTime      ntp.Time64
+		serverCookie uint64
 	}
 }
 
@@ -65,6 +83,7 @@ type scionClientMetrics struct {
 	pktsAuthenticated        prometheus.Counter
 	respsAccepted            prometheus.Counter
 	respsAcceptedInterleaved prometheus.Counter
+	kodsReceived             prometheus.Counter
 }
 
 func newSCIONClientMetrics() *scionClientMetrics {
@@ -93,6 +112,10 @@ func newSCIONClientMetrics() *scionClientMetrics {
 			Name: metrics.SCIONClientRespsAcceptedInterleavedN,
 			Help: metrics.SCIONClientRespsAcceptedInterleavedH,
 		}),
+		kodsReceived: promauto.NewCounter(prometheus.CounterOpts{
+			Name: metrics.SCIONClientKoDsReceivedN,
+			Help: metrics.SCIONClientKoDsReceivedH,
+		}),
 	}
 }
 
@@ -123,6 +146,16 @@ func (c *SCIONClient) InterleavedModePath() string {
 	return c.prev.path
 }
 
+// Status returns the current source state of c.
+func (c *SCIONClient) Status() Status {
+	return c.kod.status()
+}
+
+// Stats returns the statistics of the exchanges of c with its server.
+func (c *SCIONClient) Stats() Stats {
+	return c.stats.get()
+}
+
 func (c *SCIONClient) ResetInterleavedMode() {
 	c.prev.reference = ""
 }
@@ -130,6 +163,11 @@ func (c *SCIONClient) ResetInterleavedMode() {
 func (c *SCIONClient) measureClockOffsetSCION(ctx context.Context, mtrcs *scionClientMetrics,
 	localAddr, remoteAddr udp.UDPAddr, path snet.Path) (
 	timestamp time.Time, offset time.Duration, err error) {
+	err = c.kod.check(timebase.Now())
+	if err != nil {
+		return time.Time{}, 0, err
+	}
+
 	if c.Auth.Enabled && c.Auth.opt == nil {
 		c.Auth.opt = &slayers.EndToEndOption{}
 		c.Auth.opt.OptData = make([]byte, scion.PacketAuthOptDataLen)
@@ -204,19 +242,52 @@ func (c *SCIONClient) measureClockOffsetSCION(ctx context.Context, mtrcs *scionC
 	cTxTime0 := timebase.Now()
 	interleavedReq := false
 
+	mac := !c.Auth.NTSEnabled && c.Auth.SymmetricKey.ID != 0
+	v5 := !c.Symmetric && !mac && c.ntpv5.useNTPv5(c.NTPv5, reference, c.Auth.NTSEnabled, &ntskeData)
+	if v5 && !c.Auth.NTSEnabled {
+		defer func() {
+			if err != nil && err != errSynchronizationLoop {
+				// fall back to NTPv4 and renegotiate
+				c.ntpv5.reference = ""
+			}
+		}()
+	}
+
 	ntpreq := ntp.Packet{}
-	ntpreq.SetVersion(ntp.VersionMax)
-	ntpreq.SetMode(ntp.ModeClient)
-	if c.InterleavedMode && reference == c.prev.reference &&
-		cTxTime0.Sub(ntp.TimeFromTime64(c.prev.cTxTime, cTxTime0)) < 3*time.Second {
-		interleavedReq = true
-		ntpreq.OriginTime = c.prev.sRxTime
-		ntpreq.ReceiveTime = c.prev.cRxTime
-		ntpreq.TransmitTime = c.prev.cTxTime
+	ntpreqV5 := ntp.PacketV5{}
+	if v5 {
+		ntpreqV5.SetVersion(ntp.Version5)
+		ntpreqV5.SetMode(ntp.ModeClient)
+		ntpreqV5.Timescale = ntp.TimescaleUTC
+		ntpreqV5.ClientCookie = newClientCookie()
+		if c.InterleavedMode && reference == c.prev.reference && c.prev.serverCookie != 0 &&
+			cTxTime0.Sub(ntp.TimeFromTime64(c.prev.cTxTime, cTxTime0)) < 3*time.Second {
+			interleavedReq = true
+			ntpreqV5.ServerCookie = c.prev.serverCookie
+		}
+		ntp.EncodePacketV5(&buf, &ntpreqV5)
+		buf = c.ntpv5.appendRequestExt(buf, reference, c.RefIDs)
 	} else {
-		ntpreq.TransmitTime = ntp.Time64FromTime(cTxTime0)
+		ntpreq.SetVersion(ntp.VersionMax)
+		if c.Symmetric {
+			ntpreq.SetMode(ntp.ModeSymmetricActive)
+		} else {
+			ntpreq.SetMode(ntp.ModeClient)
+		}
+		if c.NTPv5 && !c.Auth.NTSEnabled && !c.Symmetric && !mac {
+			ntpreq.ReferenceTime = ntp.NegotiationReferenceTime
+		}
+		if c.InterleavedMode && reference == c.prev.reference &&
+			cTxTime0.Sub(ntp.TimeFromTime64(c.prev.cTxTime, cTxTime0)) < 3*time.Second {
+			interleavedReq = true
+			ntpreq.OriginTime = c.prev.sRxTime
+			ntpreq.ReceiveTime = c.prev.cRxTime
+			ntpreq.TransmitTime = c.prev.cTxTime
+		} else {
+			ntpreq.TransmitTime = ntp.Time64FromTime(cTxTime0)
+		}
+		ntp.EncodePacket(&buf, &ntpreq)
 	}
-	ntp.EncodePacket(&buf, &ntpreq)
 
 	var requestID []byte
 	var ntsreq nts.Packet
@@ -224,6 +295,9 @@ func (c *SCIONClient) measureClockOffsetSCION(ctx context.Context, mtrcs *scionC
 		ntsreq, requestID = nts.NewRequestPacket(ntskeData)
 		nts.EncodePacket(&buf, &ntsreq)
 	}
+	if mac {
+		buf = ntp.AppendMAC(buf, c.Auth.SymmetricKey)
+	}
 
 	var scionLayer slayers.SCION
 	scionLayer.TrafficClass = c.DSCP << 2
@@ -501,6 +575,10 @@ func (c *SCIONClient) measureClockOffsetSCION(ctx context.Context, mtrcs *scionC
 			}
 			return time.Time{}, 0, err
 		}
+		var ntprespV5 ntp.PacketV5
+		if v5 {
+			_ = ntp.DecodePacketV5(&ntprespV5, udpLayer.Payload)
+		}
 
 		ntsAuthenticated := false
 		var ntsresp nts.Packet
@@ -515,7 +593,19 @@ func (c *SCIONClient) measureClockOffsetSCION(ctx context.Context, mtrcs *scionC
 				return time.Time{}, 0, err
 			}
 
-			err = nts.ProcessResponse(udpLayer.Payload, ntskeData.S2cKey, &c.Auth.NTSKEFetcher, &ntsresp, requestID)
+			err = nts.ProcessResponse(udpLayer.Payload, ntskeData, &c.Auth.NTSKEFetcher, &ntsresp, requestID)
+			if err == nts.ErrNAK && (v5 && ntprespV5.ClientCookie == ntpreqV5.ClientCookie ||
+				!v5 && ntpresp.OriginTime == ntpreq.TransmitTime) {
+				mtrcs.kodsReceived.Inc()
+				c.Log.LogAttrs(ctx, slog.LevelInfo, "received NTS NAK",
+					slog.String("from", reference),
+				)
+				err = c.Auth.NTSKEFetcher.Rekey(ctx)
+				if err != nil {
+					c.Log.LogAttrs(ctx, slog.LevelInfo, "failed to fetch key exchange data", slog.Any("error", err))
+				}
+				return time.Time{}, 0, c.kod.handleNAK()
+			}
 			if err != nil {
 				if numRetries != maxNumRetries && deadlineIsSet && timebase.Now().Before(deadline) {
 					c.Log.LogAttrs(ctx, slog.LevelInfo, "failed to process NTS packet", slog.Any("error", err))
@@ -526,11 +616,28 @@ func (c *SCIONClient) measureClockOffsetSCION(ctx context.Context, mtrcs *scionC
 			}
 			ntsAuthenticated = true
 		}
+		macAuthenticated := false
+		if mac {
+			err = ntp.VerifyMAC(udpLayer.Payload, c.Auth.SymmetricKey)
+			if err != nil {
+				if numRetries != maxNumRetries && deadlineIsSet && timebase.Now().Before(deadline) {
+					c.Log.LogAttrs(ctx, slog.LevelInfo, "failed to verify MAC", slog.Any("error", err))
+					numRetries++
+					continue
+				}
+				return time.Time{}, 0, err
+			}
+			macAuthenticated = true
+		}
 
 		interleavedResp := false
-		if interleavedReq && ntpresp.OriginTime == ntpreq.ReceiveTime {
+		if v5 {
+			interleavedResp = ntprespV5.Flags&ntp.FlagInterleaved != 0
+		} else if interleavedReq && ntpresp.OriginTime == ntpreq.ReceiveTime {
 			interleavedResp = true
-		} else if ntpresp.OriginTime != ntpreq.TransmitTime {
+		}
+		if v5 && (ntprespV5.ClientCookie != ntpreqV5.ClientCookie || interleavedResp && !interleavedReq) ||
+			!v5 && !interleavedResp && ntpresp.OriginTime != ntpreq.TransmitTime {
 			err = errUnexpectedPacket
 			if numRetries != maxNumRetries && deadlineIsSet && timebase.Now().Before(deadline) {
 				c.Log.LogAttrs(ctx, slog.LevelInfo, "received packet with unexpected type or structure")
@@ -540,7 +647,32 @@ func (c *SCIONClient) measureClockOffsetSCION(ctx context.Context, mtrcs *scionC
 			return time.Time{}, 0, err
 		}
 
-		err = ntp.ValidateResponseMetadata(&ntpresp)
+		if !v5 && ntp.IsKissOfDeath(&ntpresp) && !interleavedResp {
+			err = c.kod.handleKiss(ntpresp.ReferenceID, ntpresp.Poll, timebase.Now())
+			if err != nil {
+				mtrcs.kodsReceived.Inc()
+				c.Log.LogAttrs(ctx, slog.LevelInfo, "received Kiss-o'-Death",
+					slog.String("from", reference),
+					slog.String("code", ntp.KissCodeString(ntpresp.ReferenceID)),
+					slog.Bool("auth", authenticated),
+					slog.Bool("ntsauth", ntsAuthenticated),
+				)
+				return time.Time{}, 0, err
+			}
+		}
+
+		var data slog.LogValuer = ntp.PacketLogValuer{Pkt: &ntpresp}
+		if v5 {
+			err = ntp.ValidateResponseMetadataV5(&ntprespV5)
+			if err == nil {
+				err = c.ntpv5.processResponseExt(udpLayer.Payload, c.RefIDs)
+			}
+			data = ntp.PacketV5LogValuer{Pkt: &ntprespV5}
+		} else if c.Symmetric {
+			err = ntp.ValidateSymmetricResponseMetadata(&ntpresp)
+		} else {
+			err = ntp.ValidateResponseMetadata(&ntpresp)
+		}
 		if err != nil {
 			return time.Time{}, 0, err
 		}
@@ -554,11 +686,21 @@ func (c *SCIONClient) measureClockOffsetSCION(ctx context.Context, mtrcs *scionC
 			slog.Uint64("DSCP", uint64(dscp)),
 			slog.Bool("auth", authenticated),
 			slog.Bool("ntsauth", ntsAuthenticated),
-			slog.Any("data", ntp.PacketLogValuer{Pkt: &ntpresp}),
+			slog.Bool("macauth", macAuthenticated),
+			slog.Any("data", data),
 		)
 
-		sRxTime := ntp.TimeFromTime64(ntpresp.ReceiveTime, cTxTime0)
-		sTxTime := ntp.TimeFromTime64(ntpresp.TransmitTime, cTxTime0)
+		var sRxTime, sTxTime time.Time
+		if v5 {
+			sRxTime = ntp.TimeFromTime64Era(ntprespV5.ReceiveTime, ntprespV5.Era)
+			sTxTime = ntp.TimeFromTime64(ntprespV5.TransmitTime, sRxTime)
+		} else {
+			sRxTime = ntp.TimeFromTime64(ntpresp.ReceiveTime, cTxTime0)
+			sTxTime = ntp.TimeFromTime64(ntpresp.TransmitTime, cTxTime0)
+			if c.NTPv5 && !c.Auth.NTSEnabled && ntpresp.ReferenceTime == ntp.NegotiationReferenceTime {
+				c.ntpv5.reference = reference
+			}
+		}
 
 		var t0, t1, t2, t3 time.Time
 		if interleavedResp {
@@ -582,6 +724,12 @@ func (c *SCIONClient) measureClockOffsetSCION(ctx context.Context, mtrcs *scionC
 		rtd := ntp.RoundTripDelay(t0, t1, t2, t3)
 
 		mtrcs.respsAccepted.Inc()
+		c.kod.accept()
+		if v5 {
+			c.stats.accept(ntprespV5.LeapIndicator(), ntprespV5.Stratum, 0, cRxTime, off, rtd)
+		} else {
+			c.stats.accept(ntpresp.LeapIndicator(), ntpresp.Stratum, ntpresp.ReferenceID, cRxTime, off, rtd)
+		}
 		if interleavedResp {
 			mtrcs.respsAcceptedInterleaved.Inc()
 		}
@@ -601,6 +749,7 @@ func (c *SCIONClient) measureClockOffsetSCION(ctx context.Context, mtrcs *scionC
 			c.prev.cTxTime = ntp.Time64FromTime(cTxTime1)
 			c.prev.cRxTime = ntp.Time64FromTime(cRxTime)
 			c.prev.sRxTime = ntpresp.ReceiveTime
+			c.prev.serverCookie = ntprespV5.ServerCookie
 		}
 
 		timestamp = cRxTime
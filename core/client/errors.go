@@ -11,4 +11,8 @@ var (
 	errUnexpectedPacket       = errors.New("failed to read packet: unexpected type or structure")
 
 	errInvalidPacketAuthenticator = errors.New("invalid authenticator")
+
+	errSourceRateLimited = errors.New("source rate limited by server")
+	errSourceDenied      = errors.New("source denied access by server")
+	errNTSNAK            = errors.New("NTS NAK received from server")
 )
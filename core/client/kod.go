@@ -0,0 +1,129 @@
+package client
+
+import (
+	"sync"
+	"time"
+
+	"example.com/scion-time/net/ntp"
+)
+
+const (
+	minRateHoldoff = 16 * time.Second
+	maxRateHoldoff = 1024 * time.Second
+)
+
+// SourceState describes whether a client currently uses its server as a time
+// source.
+type SourceState int
+
+const (
+	SourceActive SourceState = iota
+	// SourceRateLimited indicates that the server has sent a KoD RATE and that
+	// the client backs off before sending further requests.
+	SourceRateLimited
+	// SourceDenied indicates that the server has sent a KoD DENY or RSTR and
+	// that the client does not use it anymore.
+	SourceDenied
+)
+
+func (s SourceState) String() string {
+	switch s {
+	case SourceActive:
+		return "active"
+	case SourceRateLimited:
+		return "rate limited"
+	case SourceDenied:
+		return "denied"
+	}
+	return "unknown"
+}
+
+// Status reports the source state of a client together with the most recent
+// kiss code received, if any.
+type Status struct {
+	State        SourceState
+	KissCode     uint32
+	HoldoffUntil time.Time
+}
+
+type kodState struct {
+	mu           sync.Mutex
+	state        SourceState
+	kissCode     uint32
+	holdoff      time.Duration
+	holdoffUntil time.Time
+}
+
+func (s *kodState) status() Status {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return Status{
+		State:        s.state,
+		KissCode:     s.kissCode,
+		HoldoffUntil: s.holdoffUntil,
+	}
+}
+
+// check returns an error if no request may be sent to the server at time now.
+func (s *kodState) check(now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case s.state == SourceDenied:
+		return errSourceDenied
+	case s.state == SourceRateLimited && now.Before(s.holdoffUntil):
+		return errSourceRateLimited
+	}
+	return nil
+}
+
+// accept resets the rate limiting back-off after a valid response.
+func (s *kodState) accept() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.state == SourceRateLimited {
+		s.state = SourceActive
+		s.holdoff = 0
+		s.holdoffUntil = time.Time{}
+	}
+}
+
+// handleKiss updates the state according to a validated Kiss-o'-Death packet
+// with kiss code code and poll exponent poll received at time now. It returns
+// nil for kiss codes not handled by the client. NTS NAKs are handled by
+// handleNAK.
+func (s *kodState) handleKiss(code uint32, poll int8, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch code {
+	case ntp.KissCodeRATE:
+		s.kissCode = code
+		if s.state == SourceDenied {
+			return errSourceDenied
+		}
+		d := max(2*s.holdoff, minRateHoldoff)
+		if poll > 0 && poll < 31 {
+			d = max(d, time.Duration(1<<poll)*time.Second)
+		}
+		s.state = SourceRateLimited
+		s.holdoff = min(d, maxRateHoldoff)
+		s.holdoffUntil = now.Add(s.holdoff)
+		return errSourceRateLimited
+	case ntp.KissCodeDENY, ntp.KissCodeRSTR:
+		s.kissCode = code
+		s.state = SourceDenied
+		s.holdoff = 0
+		s.holdoffUntil = time.Time{}
+		return errSourceDenied
+	}
+	return nil
+}
+
+// handleNAK records a validated NTS NAK, i.e., a Kiss-o'-Death packet with kiss
+// code NTSN.
+func (s *kodState) handleNAK() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.kissCode = ntp.KissCodeNTSN
+	return errNTSNAK
+}
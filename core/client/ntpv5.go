@@ -0,0 +1,110 @@
+package client
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+
+	"example.com/scion-time/net/ntp"
+	"example.com/scion-time/net/ntske"
+)
+
+// refIDsChunkLen is the length of the reference ID filter chunks requested
+// from NTPv5 servers, the full filter is obtained in four exchanges.
+const refIDsChunkLen = ntp.RefIDFilterLen / 4
+
+var errSynchronizationLoop = errors.New("server synchronized to local instance")
+
+// ntpv5State is the NTPv5 state of a client.
+type ntpv5State struct {
+	// reference is the server that indicated support for NTPv5 in response
+	// to an NTPv4 request.
+	reference    string
+	refIDsSource string
+	refIDsOffset int
+	refIDs       ntp.RefIDFilter
+	loop         bool
+}
+
+func newClientCookie() uint64 {
+	var b [8]byte
+	_, err := rand.Read(b[:])
+	if err != nil {
+		panic(err)
+	}
+	return binary.BigEndian.Uint64(b[:])
+}
+
+// useNTPv5 reports whether NTPv5 is to be used with server reference. With NTS,
+// support for NTPv5 is negotiated in the key exchange.
+func (s *ntpv5State) useNTPv5(enabled bool, reference string, nts bool, ntskeData *ntske.Data) bool {
+	if !enabled {
+		return false
+	}
+	if nts {
+		return ntskeData.NTPv5
+	}
+	return reference == s.reference
+}
+
+// appendRequestExt appends the extension fields of an NTPv5 request to server
+// reference to b. If refIDs is not nil, the next chunk of the server's
+// reference ID filter is requested.
+func (s *ntpv5State) appendRequestExt(b []byte, reference string, refIDs *ntp.RefIDs) []byte {
+	b = ntp.AppendDraftIdentification(b)
+	if refIDs != nil {
+		if reference != s.refIDsSource {
+			s.refIDsSource = reference
+			s.refIDsOffset = 0
+			s.loop = false
+		}
+		b = ntp.AppendRefIDsRequest(b, s.refIDsOffset, refIDsChunkLen)
+	}
+	return b
+}
+
+// processResponseExt processes the extension fields of the NTPv5 response b.
+// Once the full reference ID filter of the server is known, it is reported to
+// refIDs, unless the server is synchronized to the local instance, in which
+// case errSynchronizationLoop is returned.
+func (s *ntpv5State) processResponseExt(b []byte, refIDs *ntp.RefIDs) error {
+	var draftID bool
+	var chunk []byte
+	pos := ntp.PacketLen
+	for pos != len(b) {
+		typ, v, n, err := ntp.DecodeExtensionField(b[pos:])
+		if err != nil {
+			return err
+		}
+		switch typ {
+		case ntp.ExtDraftIdentification:
+			draftID = ntp.IsDraftIdentification(v)
+		case ntp.ExtRefIDsResponse:
+			chunk = v
+		}
+		pos += n
+	}
+	if !draftID {
+		return errUnexpectedPacket
+	}
+	if refIDs != nil {
+		if len(chunk) != refIDsChunkLen {
+			return errUnexpectedPacket
+		}
+		copy(s.refIDs[s.refIDsOffset:], chunk)
+		s.refIDsOffset += len(chunk)
+		if s.refIDsOffset == ntp.RefIDFilterLen {
+			s.refIDsOffset = 0
+			s.loop = s.refIDs.Contains(refIDs.ID())
+			if s.loop {
+				refIDs.Remove(s.refIDsSource)
+			} else {
+				refIDs.Update(s.refIDsSource, &s.refIDs)
+			}
+		}
+		if s.loop {
+			return errSynchronizationLoop
+		}
+	}
+	return nil
+}
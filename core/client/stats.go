@@ -0,0 +1,71 @@
+package client
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// Stats describes the exchanges of a client with its server as reported to
+// monitoring tools, see RFC 5905, Section 9.
+type Stats struct {
+	// Reach is a shift register of the outcome of the 8 most recent
+	// measurements, the least significant bit being the most recent one.
+	Reach uint8
+	// Leap, Stratum and RefID are those of the most recent accepted
+	// response, LastRx its receive time.
+	Leap    uint8
+	Stratum uint8
+	RefID   uint32
+	LastRx  time.Time
+	// Offset and Delay are the clock offset and the round trip delay
+	// measured in the most recent accepted exchange, Jitter is an
+	// exponentially weighted root mean square of the differences between
+	// successive offsets.
+	Offset time.Duration
+	Delay  time.Duration
+	Jitter time.Duration
+}
+
+const statsJitterWeight = 1.0 / 4.0
+
+type statsState struct {
+	mu    sync.Mutex
+	stats Stats
+	// variance is the weighted mean square of offset differences in seconds
+	// squared.
+	variance float64
+}
+
+func (s *statsState) get() Stats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.stats
+}
+
+// measured shifts the outcome of a measurement into the reach register.
+func (s *statsState) measured(ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stats.Reach <<= 1
+	if ok {
+		s.stats.Reach |= 1
+	}
+}
+
+// accept records an accepted response received at rxt.
+func (s *statsState) accept(leap, stratum uint8, refID uint32, rxt time.Time, off, rtd time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.stats.LastRx.IsZero() {
+		d := (off - s.stats.Offset).Seconds()
+		s.variance += statsJitterWeight * (d*d - s.variance)
+	}
+	s.stats.Leap = leap
+	s.stats.Stratum = stratum
+	s.stats.RefID = refID
+	s.stats.LastRx = rxt
+	s.stats.Offset = off
+	s.stats.Delay = rtd
+	s.stats.Jitter = time.Duration(math.Sqrt(s.variance) * float64(time.Second))
+}
@@ -0,0 +1,140 @@
+package server
+
+import (
+	"net/netip"
+	"slices"
+
+	"github.com/scionproto/scion/pkg/addr"
+
+	"example.com/scion-time/net/ntp"
+)
+
+type ACLAction int
+
+const (
+	ACLAllow ACLAction = iota
+	ACLDeny
+)
+
+// ACLAuth is an authentication requirement of an ACL rule. NTS-KE connections
+// satisfy ACLAuthNTS.
+type ACLAuth int
+
+const (
+	ACLAuthNone ACLAuth = iota
+	ACLAuthNTS
+	ACLAuthSPAO
+	// ACLAuthAny requires NTS, SPAO or MAC authentication.
+	ACLAuthAny
+	// ACLAuthMAC requires a MAC computed with a symmetric key, see RFC 8573.
+	ACLAuthMAC
+)
+
+// ACLResponse determines how denied requests are answered.
+type ACLResponse int
+
+const (
+	ACLResponseDrop ACLResponse = iota
+	ACLResponseDENY
+	ACLResponseRSTR
+)
+
+// ACLRule matches a client if its address is covered by one of Prefixes (if
+// any), if its ISD-AS matches one of IAs (if any), if its identity matches one
+// of Identities (if any), if its request is authenticated by a MAC computed
+// with one of the keys KeyIDs (if any), and if its request satisfies the
+// authentication requirement Auth. An IA with AS 0 matches all ASes of the
+// ISD. Rules with IAs never match IP clients. Identities are only known for NTS
+// requests of clients authenticated by a certificate during the key exchange.
+type ACLRule struct {
+	Action     ACLAction
+	Prefixes   []netip.Prefix
+	IAs        []addr.IA
+	Identities []string
+	KeyIDs     []uint32
+	Auth       ACLAuth
+	Response   ACLResponse
+}
+
+// ACL is an ordered list of access control rules. The first matching rule
+// decides; requests not matched by any rule are allowed. A nil *ACL allows all
+// requests.
+type ACL struct {
+	rules []ACLRule
+}
+
+type aclClient struct {
+	scion bool
+	ia    addr.IA
+	addr  netip.Addr
+	nts   bool
+	spao  bool
+	// identity is the identity of an NTS client authenticated by a
+	// certificate, if any.
+	identity string
+	// keyID is the ID of the key with which the request is authenticated by
+	// a MAC, 0 if none.
+	keyID uint32
+}
+
+func NewACL(rules []ACLRule) *ACL {
+	if len(rules) == 0 {
+		return nil
+	}
+	return &ACL{rules: slices.Clone(rules)}
+}
+
+func (r *ACLRule) matches(c aclClient) bool {
+	if len(r.Prefixes) != 0 && !slices.ContainsFunc(r.Prefixes, func(p netip.Prefix) bool {
+		return p.Contains(c.addr.Unmap())
+	}) {
+		return false
+	}
+	if len(r.IAs) != 0 && (!c.scion || !slices.ContainsFunc(r.IAs, func(ia addr.IA) bool {
+		return ia.ISD() == c.ia.ISD() && (ia.AS() == 0 || ia.AS() == c.ia.AS())
+	})) {
+		return false
+	}
+	if len(r.Identities) != 0 && (!c.nts || !slices.Contains(r.Identities, c.identity)) {
+		return false
+	}
+	if len(r.KeyIDs) != 0 && (c.keyID == 0 || !slices.Contains(r.KeyIDs, c.keyID)) {
+		return false
+	}
+	switch r.Auth {
+	case ACLAuthNTS:
+		return c.nts
+	case ACLAuthSPAO:
+		return c.spao
+	case ACLAuthAny:
+		return c.nts || c.spao || c.keyID != 0
+	case ACLAuthMAC:
+		return c.keyID != 0
+	}
+	return true
+}
+
+// check reports whether client c is allowed and, if not, the kiss code to
+// respond with. A kiss code of 0 indicates that the request is to be dropped.
+func (acl *ACL) check(c aclClient) (allowed bool, kissCode uint32) {
+	if acl == nil {
+		return true, 0
+	}
+	for i := range acl.rules {
+		r := &acl.rules[i]
+		if !r.matches(c) {
+			continue
+		}
+		if r.Action == ACLAllow {
+			return true, 0
+		}
+		switch r.Response {
+		case ACLResponseDENY:
+			return false, ntp.KissCodeDENY
+		case ACLResponseRSTR:
+			return false, ntp.KissCodeRSTR
+		}
+		return false, 0
+	}
+	return true, 0
+}
@@ -0,0 +1,158 @@
+package server_test
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/scionproto/scion/pkg/addr"
+
+	"example.com/scion-time/core/server"
+
+	"example.com/scion-time/net/ntp"
+)
+
+func TestACLDefault(t *testing.T) {
+	var acl *server.ACL
+	allowed, _ := acl.CheckIP(netip.MustParseAddr("192.0.2.1"), false /* nts */)
+	if !allowed {
+		t.Errorf("nil ACL denied request")
+	}
+
+	acl = server.NewACL([]server.ACLRule{{
+		Action:   server.ACLDeny,
+		Prefixes: []netip.Prefix{netip.MustParsePrefix("198.51.100.0/24")},
+	}})
+	allowed, _ = acl.CheckIP(netip.MustParseAddr("192.0.2.1"), false /* nts */)
+	if !allowed {
+		t.Errorf("ACL denied request not matched by any rule")
+	}
+	allowed, kissCode := acl.CheckIP(netip.MustParseAddr("198.51.100.1"), false /* nts */)
+	if allowed || kissCode != 0 {
+		t.Errorf("CheckIP() = %t, %#x; want false, 0", allowed, kissCode)
+	}
+}
+
+func TestACLTiers(t *testing.T) {
+	// public unauthenticated tier for local clients and ISD 1, restricted
+	// authenticated tier for everyone else
+	acl := server.NewACL([]server.ACLRule{
+		{
+			Action:   server.ACLAllow,
+			Prefixes: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")},
+		},
+		{
+			Action: server.ACLAllow,
+			IAs:    []addr.IA{addr.MustParseIA("1-0")},
+		},
+		{
+			Action: server.ACLAllow,
+			Auth:   server.ACLAuthAny,
+		},
+		{
+			Action:   server.ACLDeny,
+			Response: server.ACLResponseRSTR,
+		},
+	})
+
+	tests := []struct {
+		name     string
+		scion    bool
+		ia       string
+		addr     string
+		nts      bool
+		spao     bool
+		allowed  bool
+		kissCode uint32
+	}{
+		{name: "local", addr: "10.1.2.3", allowed: true},
+		{name: "local mapped", addr: "::ffff:10.1.2.3", allowed: true},
+		{name: "remote", addr: "192.0.2.1", kissCode: ntp.KissCodeRSTR},
+		{name: "remote NTS", addr: "192.0.2.1", nts: true, allowed: true},
+		{name: "ISD 1", scion: true, ia: "1-ff00:0:110", addr: "192.0.2.1", allowed: true},
+		{name: "ISD 2", scion: true, ia: "2-ff00:0:210", addr: "192.0.2.1", kissCode: ntp.KissCodeRSTR},
+		{name: "ISD 2 SPAO", scion: true, ia: "2-ff00:0:210", addr: "192.0.2.1", spao: true, allowed: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var allowed bool
+			var kissCode uint32
+			if tt.scion {
+				allowed, kissCode = acl.CheckSCION(addr.MustParseIA(tt.ia), netip.MustParseAddr(tt.addr), tt.nts, tt.spao)
+			} else {
+				allowed, kissCode = acl.CheckIP(netip.MustParseAddr(tt.addr), tt.nts)
+			}
+			if allowed != tt.allowed || kissCode != tt.kissCode {
+				t.Errorf("check() = %t, %#x; want %t, %#x", allowed, kissCode, tt.allowed, tt.kissCode)
+			}
+		})
+	}
+}
+
+func TestACLIdentities(t *testing.T) {
+	// fleet members authenticated during the key exchange are allowed, other
+	// NTS clients are denied
+	acl := server.NewACL([]server.ACLRule{
+		{
+			Action:     server.ACLAllow,
+			Identities: []string{"node1.fleet.example", "node2.fleet.example"},
+		},
+		{
+			Action:   server.ACLDeny,
+			Response: server.ACLResponseDENY,
+		},
+	})
+
+	a := netip.MustParseAddr("192.0.2.1")
+	if allowed, _ := acl.CheckNTSIdentity(a, "node2.fleet.example"); !allowed {
+		t.Error("ACL denied fleet member")
+	}
+	if allowed, kissCode := acl.CheckNTSIdentity(a, "other.example"); allowed || kissCode != ntp.KissCodeDENY {
+		t.Errorf("check() = %t, %#x; want false, %#x", allowed, kissCode, ntp.KissCodeDENY)
+	}
+	if allowed, _ := acl.CheckNTSIdentity(a, ""); allowed {
+		t.Error("ACL allowed NTS client without identity")
+	}
+	if allowed, _ := acl.CheckIP(a, false /* nts */); allowed {
+		t.Error("ACL allowed unauthenticated client")
+	}
+}
+
+func TestACLKeyIDs(t *testing.T) {
+	// key 1 is restricted to local clients, requests authenticated with other
+	// keys are allowed, unauthenticated ones are not
+	acl := server.NewACL([]server.ACLRule{
+		{
+			Action:   server.ACLAllow,
+			Prefixes: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")},
+			KeyIDs:   []uint32{1},
+		},
+		{
+			Action:   server.ACLDeny,
+			KeyIDs:   []uint32{1},
+			Response: server.ACLResponseDENY,
+		},
+		{
+			Action: server.ACLAllow,
+			Auth:   server.ACLAuthMAC,
+		},
+		{
+			Action:   server.ACLDeny,
+			Response: server.ACLResponseRSTR,
+		},
+	})
+
+	local := netip.MustParseAddr("10.1.2.3")
+	remote := netip.MustParseAddr("192.0.2.1")
+	if allowed, _ := acl.CheckMAC(local, 1); !allowed {
+		t.Error("ACL denied local client with key 1")
+	}
+	if allowed, kissCode := acl.CheckMAC(remote, 1); allowed || kissCode != ntp.KissCodeDENY {
+		t.Errorf("check() = %t, %#x; want false, %#x", allowed, kissCode, ntp.KissCodeDENY)
+	}
+	if allowed, _ := acl.CheckMAC(remote, 2); !allowed {
+		t.Error("ACL denied remote client with key 2")
+	}
+	if allowed, kissCode := acl.CheckMAC(local, 0); allowed || kissCode != ntp.KissCodeRSTR {
+		t.Errorf("check() = %t, %#x; want false, %#x", allowed, kissCode, ntp.KissCodeRSTR)
+	}
+}
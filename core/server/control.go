@@ -0,0 +1,377 @@
+package server
+
+// See RFC 9327, Control Messages Protocol for Use with Network Time Protocol
+// Version 4 (mode 6)
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"log/slog"
+	"math"
+	"net"
+	"net/netip"
+	"strings"
+	"time"
+
+	"github.com/scionproto/scion/pkg/addr"
+
+	clocksync "example.com/scion-time/core/sync"
+	"example.com/scion-time/core/timebase"
+
+	"example.com/scion-time/net/ntp"
+)
+
+const (
+	// controlLineLen is the length after which variables in responses
+	// continue on a new line, as ntpd does.
+	controlLineLen = 72
+
+	// controlMaxResponseLen bounds the data of all fragments of a response,
+	// longer association lists are truncated. It is a multiple of the length
+	// of an association list entry.
+	controlMaxResponseLen = 4 * ntp.ControlMaxDataLen
+
+	// Control requests of a client are limited independently of NTP requests
+	// to a burst of controlRateLimitBurst and a rate of controlRateLimit
+	// requests per second.
+	controlRateLimit      = 1.0
+	controlRateLimitBurst = 4
+)
+
+// ControlAssociation is a snapshot of an association of the local clock with a
+// reference clock or a symmetric mode peer as reported in control responses.
+// Sources reachable via IP have the zero IA.
+type ControlAssociation struct {
+	IA        addr.IA
+	Addr      netip.AddrPort
+	Symmetric bool
+	// Reach is the reachability shift register of the association.
+	Reach uint8
+	// Leap, Stratum and RefID are those of the most recent accepted
+	// response, LastRx its receive time.
+	Leap    uint8
+	Stratum uint8
+	RefID   uint32
+	LastRx  time.Time
+	Offset  time.Duration
+	Delay   time.Duration
+	Jitter  time.Duration
+}
+
+// Control answers read-only NTP control queries, as sent by ntpq, for the
+// system variables derived from the synchronization state of the local clock
+// and for the variables of the associations returned by associations. A nil
+// *Control does not answer any control queries.
+type Control struct {
+	associations func() []ControlAssociation
+	limiter      *RateLimiter
+}
+
+// NewControl returns a Control reporting the associations returned by
+// associations, which must be safe for concurrent use.
+func NewControl(associations func() []ControlAssociation) *Control {
+	return &Control{
+		associations: associations,
+		limiter: NewRateLimiter(RateLimitConfig{
+			Rate:  controlRateLimit,
+			Burst: controlRateLimitBurst,
+		}),
+	}
+}
+
+// allow reports whether a control request of the client at a received at
+// local time now is within the control rate limit.
+func (c *Control) allow(a netip.Addr, now time.Time) bool {
+	return c.limiter.check(c.limiter.ipClientKey(a), now) < rateLimitKoD
+}
+
+type controlVar struct {
+	name, value string
+}
+
+func controlTime(t time.Time) string {
+	var t64 ntp.Time64
+	if !t.IsZero() {
+		t64 = ntp.Time64FromTime(t)
+	}
+	return fmt.Sprintf("0x%08x.%08x", t64.Seconds, t64.Fraction)
+}
+
+func controlMillis(d time.Duration) string {
+	return fmt.Sprintf("%.6f", float64(d)/float64(time.Millisecond))
+}
+
+func controlRefID(stratum uint8, refID uint32) string {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], refID)
+	if stratum <= 1 || stratum >= 16 {
+		return strings.TrimRight(string(b[:]), "\x00")
+	}
+	return netip.AddrFrom4(b).String()
+}
+
+// controlSystemLeap returns the leap indicator and the stratum reported for
+// the local clock in synchronization state s.
+func controlSystemLeap(s clocksync.State) (leap, stratum uint8) {
+	if s.Synchronized {
+		return ntp.LeapIndicatorNoWarning, 1
+	}
+	return ntp.LeapIndicatorUnknown, 16
+}
+
+func controlSystemStatus(s clocksync.State) uint16 {
+	leap, _ := controlSystemLeap(s)
+	src := uint8(ntp.ControlClockSourceUnspecified)
+	if s.Synchronized {
+		src = ntp.ControlClockSourceNTP
+	}
+	return ntp.ControlSystemStatus(leap, src, 0, 0)
+}
+
+func controlSystemVars(s clocksync.State, now time.Time) []controlVar {
+	leap, stratum := controlSystemLeap(s)
+	rootDisp := time.Duration(10) * time.Second / (1 << 16)
+	return []controlVar{
+		{"leap", fmt.Sprintf("%d", leap)},
+		{"stratum", fmt.Sprintf("%d", stratum)},
+		{"precision", "-32"},
+		{"rootdelay", controlMillis(0)},
+		{"rootdisp", controlMillis(rootDisp)},
+		{"refid", controlRefID(1, serverRefID)},
+		{"reftime", controlTime(s.LastSynchronized)},
+		{"clock", controlTime(now)},
+		{"offset", controlMillis(s.Offset)},
+		{"sys_jitter", controlMillis(time.Duration(math.Sqrt(s.Variance) * float64(time.Second)))},
+	}
+}
+
+func controlPeerStatus(a *ControlAssociation) uint16 {
+	flags := uint8(ntp.ControlPeerConfigured)
+	sel := uint8(ntp.ControlSelectReject)
+	if a.Reach != 0 {
+		flags |= ntp.ControlPeerReachable
+		sel = ntp.ControlSelectCandidate
+	}
+	return ntp.ControlPeerStatus(flags, sel, 0, 0)
+}
+
+func controlPeerVars(a *ControlAssociation) []controlVar {
+	leap, stratum, refID := a.Leap, a.Stratum, a.RefID
+	if a.LastRx.IsZero() {
+		leap, stratum, refID = ntp.LeapIndicatorUnknown, 16, 0x494e4954 // "INIT"
+	}
+	hmode := "3"
+	if a.Symmetric {
+		hmode = "1"
+	}
+	vars := []controlVar{
+		{"srcadr", a.Addr.Addr().String()},
+		{"srcport", fmt.Sprintf("%d", a.Addr.Port())},
+	}
+	if !a.IA.IsZero() {
+		vars = append(vars, controlVar{"srcia", a.IA.String()})
+	}
+	return append(vars,
+		controlVar{"leap", fmt.Sprintf("%d", leap)},
+		controlVar{"stratum", fmt.Sprintf("%d", stratum)},
+		controlVar{"refid", controlRefID(stratum, refID)},
+		controlVar{"reach", fmt.Sprintf("0x%02x", a.Reach)},
+		controlVar{"hmode", hmode},
+		controlVar{"rec", controlTime(a.LastRx)},
+		controlVar{"offset", controlMillis(a.Offset)},
+		controlVar{"delay", controlMillis(a.Delay)},
+		controlVar{"jitter", controlMillis(a.Jitter)},
+	)
+}
+
+// selectControlVars returns the variables of vars named in the comma separated
+// list of the request data b, or all of vars if b is empty.
+func selectControlVars(vars []controlVar, b []byte) ([]controlVar, bool) {
+	var sel []controlVar
+	for name := range strings.SplitSeq(string(b), ",") {
+		name, _, _ = strings.Cut(name, "=")
+		name = strings.TrimSpace(strings.TrimRight(name, "\x00"))
+		if name == "" {
+			continue
+		}
+		i := 0
+		for i != len(vars) && vars[i].name != name {
+			i++
+		}
+		if i == len(vars) {
+			return nil, false
+		}
+		sel = append(sel, vars[i])
+	}
+	if sel == nil {
+		return vars, true
+	}
+	return sel, true
+}
+
+func encodeControlVars(vars []controlVar) []byte {
+	var b []byte
+	lineLen := 0
+	for i, v := range vars {
+		s := v.name + "=" + v.value
+		if i != 0 {
+			if lineLen+2+len(s) > controlLineLen {
+				b = append(b, ",\r\n"...)
+				lineLen = 0
+			} else {
+				b = append(b, ", "...)
+				lineLen += 2
+			}
+		}
+		b = append(b, s...)
+		lineLen += len(s)
+	}
+	return append(b, "\r\n"...)
+}
+
+func controlError(req *ntp.ControlPacket, leap uint8, code uint16) []ntp.ControlPacket {
+	return []ntp.ControlPacket{{
+		LVM:           leap<<6 | req.Version()<<3 | ntp.ModeControl,
+		REMOp:         ntp.ControlFlagResponse | ntp.ControlFlagError | req.Opcode(),
+		Sequence:      req.Sequence,
+		Status:        code << 8,
+		AssociationID: req.AssociationID,
+	}}
+}
+
+// respond returns the response fragments to the control request req given the
+// synchronization state s of the local clock at local time now.
+func (c *Control) respond(req *ntp.ControlPacket, s clocksync.State, now time.Time) []ntp.ControlPacket {
+	leap, _ := controlSystemLeap(s)
+	switch req.Opcode() {
+	case ntp.ControlOpReadStatus, ntp.ControlOpReadVariables:
+	case ntp.ControlOpWriteVariables, ntp.ControlOpWriteClock, ntp.ControlOpSetTrap,
+		ntp.ControlOpConfigure, ntp.ControlOpSaveConfig, ntp.ControlOpUnsetTrap:
+		return controlError(req, leap, ntp.ControlErrorProhibited)
+	default:
+		return controlError(req, leap, ntp.ControlErrorOpcode)
+	}
+
+	var status uint16
+	var data []byte
+	if req.AssociationID == 0 {
+		status = controlSystemStatus(s)
+		if req.Opcode() == ntp.ControlOpReadStatus {
+			assocs := c.associations()
+			for i := range assocs {
+				data = binary.BigEndian.AppendUint16(data, uint16(i+1))
+				data = binary.BigEndian.AppendUint16(data, controlPeerStatus(&assocs[i]))
+			}
+		} else {
+			vars, ok := selectControlVars(controlSystemVars(s, now), req.Data)
+			if !ok {
+				return controlError(req, leap, ntp.ControlErrorUnknownVariable)
+			}
+			data = encodeControlVars(vars)
+		}
+	} else {
+		assocs := c.associations()
+		i := int(req.AssociationID) - 1
+		if i >= len(assocs) {
+			return controlError(req, leap, ntp.ControlErrorUnknownAssociation)
+		}
+		status = controlPeerStatus(&assocs[i])
+		reqData := req.Data
+		if req.Opcode() == ntp.ControlOpReadStatus {
+			reqData = nil
+		}
+		vars, ok := selectControlVars(controlPeerVars(&assocs[i]), reqData)
+		if !ok {
+			return controlError(req, leap, ntp.ControlErrorUnknownVariable)
+		}
+		data = encodeControlVars(vars)
+	}
+	data = data[:min(len(data), controlMaxResponseLen)]
+
+	var resps []ntp.ControlPacket
+	for off := 0; off == 0 || off < len(data); off += ntp.ControlMaxDataLen {
+		resp := ntp.ControlPacket{
+			LVM:           leap<<6 | req.Version()<<3 | ntp.ModeControl,
+			REMOp:         ntp.ControlFlagResponse | req.Opcode(),
+			Sequence:      req.Sequence,
+			Status:        status,
+			AssociationID: req.AssociationID,
+			Offset:        uint16(off),
+			Data:          data[off:min(off+ntp.ControlMaxDataLen, len(data))],
+		}
+		if off+ntp.ControlMaxDataLen < len(data) {
+			resp.REMOp |= ntp.ControlFlagMore
+		}
+		resps = append(resps, resp)
+	}
+	return resps
+}
+
+// serveControlRequestIP answers the control request b of the client at
+// srcAddr on conn. Requests are subject to acl, limiter and the rate limit of
+// control but are never answered by a KoD.
+func serveControlRequestIP(ctx context.Context, log *slog.Logger, mtrcs *ipServerMetrics,
+	conn *net.UDPConn, control *Control, acl *ACL, limiter *RateLimiter, b []byte, srcAddr netip.AddrPort) {
+	clientID := srcAddr.Addr().String()
+	if control == nil {
+		log.LogAttrs(ctx, slog.LevelDebug, "dropped request",
+			slog.String("from", clientID),
+			slog.String("cause", "control messages not supported"),
+		)
+		return
+	}
+
+	allowed, _ := acl.check(aclClient{addr: srcAddr.Addr()})
+	if !allowed {
+		mtrcs.reqsDenied.Inc()
+		log.LogAttrs(ctx, slog.LevelDebug, "dropped request",
+			slog.String("from", clientID),
+			slog.String("cause", "access denied"),
+		)
+		return
+	}
+
+	var req ntp.ControlPacket
+	err := ntp.DecodeControlPacket(&req, b)
+	if err == nil {
+		err = ntp.ValidateControlRequest(&req)
+	}
+	if err != nil {
+		log.LogAttrs(ctx, slog.LevelDebug, "failed to decode control message",
+			slog.String("from", clientID),
+			slog.Any("error", err),
+		)
+		return
+	}
+
+	now := time.Now()
+	rl := limiter.check(limiter.ipClientKey(srcAddr.Addr()), now)
+	if rl == rateLimitKoD || rl == rateLimitDrop || !control.allow(srcAddr.Addr(), now) {
+		mtrcs.reqsRateLimited.Inc()
+		mtrcs.reqsDropped.Inc()
+		log.LogAttrs(ctx, slog.LevelDebug, "dropped request",
+			slog.String("from", clientID),
+			slog.String("cause", "rate limit exceeded"),
+		)
+		return
+	}
+
+	mtrcs.reqsAccepted.Inc()
+	log.LogAttrs(ctx, slog.LevelDebug, "received control request",
+		slog.String("from", clientID),
+		slog.Int("opcode", int(req.Opcode())),
+		slog.Int("association", int(req.AssociationID)),
+	)
+
+	var buf []byte
+	for _, resp := range control.respond(&req, clocksync.CurrentState(), timebase.Now()) {
+		ntp.EncodeControlPacket(&buf, &resp)
+		n, err := conn.WriteToUDPAddrPort(buf, srcAddr)
+		if err != nil || n != len(buf) {
+			log.LogAttrs(ctx, slog.LevelError, "failed to write packet", slog.Any("error", err))
+			return
+		}
+	}
+	mtrcs.reqsServed.Inc()
+}
@@ -0,0 +1,173 @@
+package server_test
+
+import (
+	"encoding/binary"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/scionproto/scion/pkg/addr"
+
+	"example.com/scion-time/core/server"
+	clocksync "example.com/scion-time/core/sync"
+
+	"example.com/scion-time/net/ntp"
+)
+
+func TestControlSystemVariables(t *testing.T) {
+	c := server.NewControl(func() []server.ControlAssociation { return nil })
+	now := time.Now()
+	req := ntp.ControlPacket{
+		LVM:   4<<3 | ntp.ModeControl,
+		REMOp: ntp.ControlOpReadVariables,
+		Data:  []byte("leap, stratum,refid"),
+	}
+	for _, tc := range []struct {
+		state  clocksync.State
+		lvm    uint8
+		status uint16
+		data   string
+	}{
+		{
+			state:  clocksync.State{},
+			lvm:    ntp.LeapIndicatorUnknown<<6 | 4<<3 | ntp.ModeControl,
+			status: 0xc000,
+			data:   "leap=3, stratum=16, refid=XSTS\r\n",
+		},
+		{
+			state:  clocksync.State{Synchronized: true, LastSynchronized: now, Offset: time.Millisecond},
+			lvm:    4<<3 | ntp.ModeControl,
+			status: 0x0600,
+			data:   "leap=0, stratum=1, refid=XSTS\r\n",
+		},
+	} {
+		resps := c.Respond(&req, tc.state, now)
+		if len(resps) != 1 {
+			t.Fatalf("Respond() returned %d responses; want 1", len(resps))
+		}
+		resp := resps[0]
+		if resp.LVM != tc.lvm || resp.REMOp != ntp.ControlFlagResponse|ntp.ControlOpReadVariables ||
+			resp.Status != tc.status || string(resp.Data) != tc.data {
+			t.Errorf("Respond() = %+v with data %q; want LVM %#x, status %#04x and data %q",
+				resp, resp.Data, tc.lvm, tc.status, tc.data)
+		}
+	}
+
+	resps := c.Respond(&ntp.ControlPacket{LVM: req.LVM, REMOp: req.REMOp}, clocksync.State{}, now)
+	if len(resps) != 1 || !strings.Contains(string(resps[0].Data), "sys_jitter=") {
+		t.Errorf("Respond() = %+v; want all system variables", resps)
+	}
+}
+
+func TestControlAssociations(t *testing.T) {
+	ia := addr.MustParseIA("1-ff00:0:110")
+	assocs := []server.ControlAssociation{{
+		Addr:    netip.MustParseAddrPort("192.0.2.1:123"),
+		Reach:   0x03,
+		Stratum: 1,
+		RefID:   0x47505300, // "GPS"
+		LastRx:  time.Now(),
+		Offset:  1500 * time.Microsecond,
+	}, {
+		IA:        ia,
+		Addr:      netip.MustParseAddrPort("10.0.0.1:10123"),
+		Symmetric: true,
+	}}
+	c := server.NewControl(func() []server.ControlAssociation { return assocs })
+	now := time.Now()
+
+	resps := c.Respond(&ntp.ControlPacket{LVM: 2<<3 | ntp.ModeControl, REMOp: ntp.ControlOpReadStatus},
+		clocksync.State{}, now)
+	if len(resps) != 1 || len(resps[0].Data) != 8 ||
+		binary.BigEndian.Uint16(resps[0].Data[0:]) != 1 || binary.BigEndian.Uint16(resps[0].Data[2:]) != 0x9400 ||
+		binary.BigEndian.Uint16(resps[0].Data[4:]) != 2 || binary.BigEndian.Uint16(resps[0].Data[6:]) != 0x8000 {
+		t.Errorf("Respond() = %+v; want the status of two associations", resps)
+	}
+
+	for _, tc := range []struct {
+		assocID uint16
+		names   string
+		data    string
+	}{
+		{1, "srcadr,refid,reach,offset", "srcadr=192.0.2.1, refid=GPS, reach=0x03, offset=1.500000\r\n"},
+		{2, "srcia,stratum,refid,hmode", "srcia=1-ff00:0:110, stratum=16, refid=INIT, hmode=1\r\n"},
+	} {
+		resps := c.Respond(&ntp.ControlPacket{
+			LVM:           2<<3 | ntp.ModeControl,
+			REMOp:         ntp.ControlOpReadVariables,
+			AssociationID: tc.assocID,
+			Data:          []byte(tc.names),
+		}, clocksync.State{}, now)
+		if len(resps) != 1 || resps[0].AssociationID != tc.assocID || string(resps[0].Data) != tc.data {
+			t.Errorf("Respond() = %+v; want data %q", resps, tc.data)
+		}
+	}
+
+	for _, tc := range []struct {
+		req  ntp.ControlPacket
+		code uint16
+	}{
+		{ntp.ControlPacket{REMOp: ntp.ControlOpReadVariables, AssociationID: 3}, ntp.ControlErrorUnknownAssociation},
+		{ntp.ControlPacket{REMOp: ntp.ControlOpReadVariables, Data: []byte("foo")}, ntp.ControlErrorUnknownVariable},
+		{ntp.ControlPacket{REMOp: ntp.ControlOpConfigure}, ntp.ControlErrorProhibited},
+		{ntp.ControlPacket{REMOp: ntp.ControlOpAsyncMessage}, ntp.ControlErrorOpcode},
+	} {
+		tc.req.LVM = 2<<3 | ntp.ModeControl
+		resps := c.Respond(&tc.req, clocksync.State{}, now)
+		if len(resps) != 1 || resps[0].REMOp&ntp.ControlFlagError == 0 || resps[0].Status>>8 != tc.code ||
+			len(resps[0].Data) != 0 {
+			t.Errorf("Respond() = %+v; want error %d", resps, tc.code)
+		}
+	}
+}
+
+func TestControlFragments(t *testing.T) {
+	assocs := make([]server.ControlAssociation, 200)
+	c := server.NewControl(func() []server.ControlAssociation { return assocs })
+	resps := c.Respond(&ntp.ControlPacket{LVM: 2<<3 | ntp.ModeControl, REMOp: ntp.ControlOpReadStatus},
+		clocksync.State{}, time.Now())
+	if len(resps) != 2 {
+		t.Fatalf("Respond() returned %d responses; want 2", len(resps))
+	}
+	if resps[0].REMOp&ntp.ControlFlagMore == 0 || resps[0].Offset != 0 || len(resps[0].Data) != ntp.ControlMaxDataLen {
+		t.Errorf("unexpected first fragment %+v", resps[0])
+	}
+	if resps[1].REMOp&ntp.ControlFlagMore != 0 || resps[1].Offset != ntp.ControlMaxDataLen ||
+		len(resps[1].Data) != 4*len(assocs)-ntp.ControlMaxDataLen {
+		t.Errorf("unexpected last fragment %+v", resps[1])
+	}
+}
+
+func TestControlResponseLimit(t *testing.T) {
+	assocs := make([]server.ControlAssociation, 1000)
+	c := server.NewControl(func() []server.ControlAssociation { return assocs })
+	resps := c.Respond(&ntp.ControlPacket{LVM: 2<<3 | ntp.ModeControl, REMOp: ntp.ControlOpReadStatus},
+		clocksync.State{}, time.Now())
+	if len(resps) != 4 {
+		t.Fatalf("Respond() returned %d responses; want 4", len(resps))
+	}
+	if resps[3].REMOp&ntp.ControlFlagMore != 0 || len(resps[3].Data) != ntp.ControlMaxDataLen {
+		t.Errorf("unexpected last fragment %+v", resps[3])
+	}
+}
+
+func TestControlRateLimit(t *testing.T) {
+	c := server.NewControl(func() []server.ControlAssociation { return nil })
+	a, b := netip.MustParseAddr("192.0.2.1"), netip.MustParseAddr("192.0.2.2")
+	now := time.Now()
+	for i := range server.ControlRateLimitBurst {
+		if !c.Allow(a, now) {
+			t.Fatalf("Allow() rejected request %d within burst", i)
+		}
+	}
+	if c.Allow(a, now) {
+		t.Error("Allow() accepted request beyond burst")
+	}
+	if !c.Allow(b, now) {
+		t.Error("Allow() rejected request of other client")
+	}
+	if !c.Allow(a, now.Add(time.Second)) {
+		t.Error("Allow() rejected request after refill")
+	}
+}
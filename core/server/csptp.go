@@ -0,0 +1,549 @@
+package server
+
+import (
+	"container/heap"
+	"context"
+	"encoding/binary"
+	"errors"
+	"log/slog"
+	"math"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+
+	"example.com/scion-time/base/leapsecond"
+
+	"example.com/scion-time/core/timebase"
+
+	"example.com/scion-time/net/csptp"
+	"example.com/scion-time/net/ntske"
+	"example.com/scion-time/net/udp"
+
+	clocksync "example.com/scion-time/core/sync"
+)
+
+const (
+	csptpContextCap = 8
+
+	// csptpContextTimeout is the maximum time between the reception of the
+	// Sync and the Follow Up request of a sequence.
+	csptpContextTimeout = 1 * time.Second
+
+	// csptpHoldoverTimeout is the maximum time since the last synchronization
+	// of the local clock for which holdover is announced.
+	csptpHoldoverTimeout = 1 * time.Hour
+
+	csptpPriority = 128
+
+	// csptpMaxClients is the default maximum number of clients whose requests
+	// are paired at a time.
+	csptpMaxClients = 1 << 20
+)
+
+// CSPTPServerConfig describes the local clock as announced by a CSPTP server
+// in the ServerStateDS of its responses.
+type CSPTPServerConfig struct {
+	// ClockID is the PTP clock identity of the server. If zero, it is derived
+	// from the MAC address of the network interface the server listens on.
+	ClockID uint64
+	// TimeSource is the PTP time source of the local clock. If zero,
+	// csptp.TimeSourceInternalOscillator is announced.
+	TimeSource uint8
+	// StepsRemoved is the number of communication paths between the server
+	// and its grandmaster.
+	StepsRemoved uint16
+	// Keys are the static keys for AUTHENTICATION TLVs by key ID, see
+	// csptp.LoadKeys.
+	Keys map[uint32][]byte
+	// Provider, if set, recovers the keys for AUTHENTICATION TLVs provisioned
+	// by NTS-KE servers sharing it. Static keys take precedence.
+	Provider *ntske.Provider
+	// Primary reports whether the local clock is synchronized to a primary
+	// reference time source, e.g., a GNSS receiver or a PHC. Otherwise, a
+	// clock class for application-specific sources is announced.
+	Primary bool
+	// LeapSeconds, if set, provides the UTC offset announced with timestamps,
+	// which are in UTC. Otherwise, a UTC offset of 0 is announced.
+	LeapSeconds *leapsecond.Table
+	// MaxClients is the maximum number of clients whose requests are paired
+	// at a time. If zero, a default of 2^20 clients is used.
+	MaxClients int
+}
+
+// authKey returns the key for AUTHENTICATION TLVs with ID keyID.
+func (cfg *CSPTPServerConfig) authKey(keyID uint32) ([]byte, bool) {
+	if key, ok := cfg.Keys[keyID]; ok {
+		return key, true
+	}
+	if cfg.Provider != nil {
+		return cfg.Provider.CSPTPKey(keyID)
+	}
+	return nil, false
+}
+
+// utcOffset returns the UTC offset, i.e., TAI - UTC in seconds, at time now
+// and whether it is known.
+func (cfg *CSPTPServerConfig) utcOffset(now time.Time) (int16, bool) {
+	if cfg.LeapSeconds == nil {
+		return 0, false
+	}
+	off, ok := cfg.LeapSeconds.Offset(now)
+	return int16(off), ok
+}
+
+var csptpClockAccuracies = [...]struct {
+	limit time.Duration
+	value uint8
+}{
+	{25 * time.Nanosecond, 0x20},
+	{100 * time.Nanosecond, 0x21},
+	{250 * time.Nanosecond, 0x22},
+	{1 * time.Microsecond, 0x23},
+	{2500 * time.Nanosecond, 0x24},
+	{10 * time.Microsecond, 0x25},
+	{25 * time.Microsecond, 0x26},
+	{100 * time.Microsecond, 0x27},
+	{250 * time.Microsecond, 0x28},
+	{1 * time.Millisecond, 0x29},
+	{2500 * time.Microsecond, 0x2a},
+	{10 * time.Millisecond, 0x2b},
+	{25 * time.Millisecond, 0x2c},
+	{100 * time.Millisecond, 0x2d},
+	{250 * time.Millisecond, 0x2e},
+	{1 * time.Second, 0x2f},
+	{10 * time.Second, 0x30},
+}
+
+var (
+	errUnexpectedCSPTPMessage       = errors.New("unexpected CSPTP message")
+	errUnexpectedCSPTPMessageLength = errors.New("unexpected CSPTP message length")
+	errUnknownCSPTPKey              = errors.New("unknown CSPTP key")
+	errInvalidCSPTPICV              = errors.New("invalid CSPTP integrity check value")
+)
+
+// udpConn is a UDP connection on which responses may be written by other
+// goroutines than the one reading requests from it. Responses are written to
+// a duplicate w of the connection's file descriptor such that reading their
+// transmit timestamps does not block while a read on c is pending.
+type udpConn struct {
+	c    *net.UDPConn
+	w    *net.UDPConn
+	mu   sync.Mutex
+	txid uint32
+}
+
+func newUDPConn(c *net.UDPConn) (*udpConn, error) {
+	f, err := c.File()
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+	w, err := net.FileConn(f)
+	if err != nil {
+		return nil, err
+	}
+	return &udpConn{c: c, w: w.(*net.UDPConn)}, nil
+}
+
+// writeTo writes b to addr and returns the transmit timestamp of the packet.
+func (c *udpConn) writeTo(ctx context.Context, log *slog.Logger,
+	b []byte, addr netip.AddrPort) (time.Time, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n, err := c.w.WriteToUDPAddrPort(b, addr)
+	if err != nil || n != len(b) {
+		log.LogAttrs(ctx, slog.LevelError, "failed to write packet", slog.Any("error", err))
+		return time.Time{}, false
+	}
+	txt, id, err := udp.ReadTXTimestamp(c.w)
+	if err != nil {
+		txt = timebase.Now()
+		log.LogAttrs(ctx, slog.LevelError, "failed to read packet tx timestamp",
+			slog.Any("error", err))
+	} else if id != c.txid {
+		txt = timebase.Now()
+		log.LogAttrs(ctx, slog.LevelError, "failed to read packet tx timestamp",
+			slog.Uint64("id", uint64(id)), slog.Uint64("expected", uint64(c.txid)))
+		c.txid = id + 1
+	} else {
+		c.txid++
+	}
+	return txt, true
+}
+
+// csptpContext holds the data of a Sync or Follow Up request until the
+// request of the other type of the same sequence has been received.
+type csptpContext struct {
+	conn          *udpConn
+	srcPort       uint16
+	rxTime        time.Time
+	portID        csptp.PortID
+	sequenceID    uint16
+	msgType       uint8
+	correction    int64
+	serverStateDS bool
+	authenticated bool
+	authKeyID     uint32
+	authKey       []byte
+}
+
+type csptpClient struct {
+	key   string
+	ctxts [csptpContextCap]csptpContext
+	len   int
+	qval  time.Time
+	qidx  int
+}
+
+type csptpClientQueue []*csptpClient
+
+// csptpClientTable holds the request contexts of up to maxClients clients,
+// evicting the least recently seen client if needed.
+type csptpClientTable struct {
+	mu         sync.Mutex
+	maxClients int
+	clients    map[string]*csptpClient
+	q          csptpClientQueue
+}
+
+func newCSPTPClientTable(maxClients int) *csptpClientTable {
+	if maxClients <= 0 {
+		maxClients = csptpMaxClients
+	}
+	return &csptpClientTable{
+		maxClients: maxClients,
+		clients:    make(map[string]*csptpClient),
+	}
+}
+
+func (q csptpClientQueue) Len() int { return len(q) }
+
+func (q csptpClientQueue) Less(i, j int) bool {
+	return q[i].qval.Before(q[j].qval)
+}
+
+func (q csptpClientQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].qidx = i
+	q[j].qidx = j
+}
+
+func (q *csptpClientQueue) Push(x any) {
+	c := x.(*csptpClient)
+	c.qidx = len(*q)
+	*q = append(*q, c)
+}
+
+func (q *csptpClientQueue) Pop() any {
+	n := len(*q)
+	c := (*q)[n-1]
+	(*q)[n-1] = nil
+	*q = (*q)[0 : n-1]
+	return c
+}
+
+// decodeCSPTPRequest decodes and validates a Sync request or a Follow Up
+// request with a request TLV, either of which may end with an AUTHENTICATION
+// TLV. It reports whether authtlv has been decoded.
+func decodeCSPTPRequest(msg *csptp.Message, tlv *csptp.RequestTLV, authtlv *csptp.AuthenticationTLV,
+	b []byte) (bool, error) {
+	if len(b) < csptp.MinMessageLength {
+		return false, errUnexpectedCSPTPMessageLength
+	}
+	err := csptp.DecodeMessage(msg, b[:csptp.MinMessageLength])
+	if err != nil {
+		return false, err
+	}
+	if len(b) != int(msg.MessageLength) {
+		return false, errUnexpectedCSPTPMessageLength
+	}
+	var n int
+	switch msg.SdoIDMessageType {
+	case csptp.MessageTypeSync:
+		n = csptp.MinMessageLength
+	case csptp.MessageTypeFollowUp:
+		err = csptp.DecodeRequestTLV(tlv, b[csptp.MinMessageLength:])
+		if err != nil {
+			return false, err
+		}
+		if tlv.Type != csptp.TLVTypeOrganizationExtension ||
+			tlv.OrganizationID[0] != csptp.OrganizationIDMeinberg0 ||
+			tlv.OrganizationID[1] != csptp.OrganizationIDMeinberg1 ||
+			tlv.OrganizationID[2] != csptp.OrganizationIDMeinberg2 ||
+			tlv.OrganizationSubType[0] != csptp.OrganizationSubTypeRequest0 ||
+			tlv.OrganizationSubType[1] != csptp.OrganizationSubTypeRequest1 ||
+			tlv.OrganizationSubType[2] != csptp.OrganizationSubTypeRequest2 {
+			return false, errUnexpectedCSPTPMessage
+		}
+		n = csptp.MinMessageLength + csptp.EncodedRequestTLVLength(tlv)
+	default:
+		return false, errUnexpectedCSPTPMessage
+	}
+	switch len(b) - n {
+	case 0:
+		return false, nil
+	case csptp.EncodedAuthenticationTLVLength:
+		err = csptp.DecodeAuthenticationTLV(authtlv, b[n:])
+		if err != nil {
+			return false, err
+		}
+		return true, nil
+	default:
+		return false, errUnexpectedCSPTPMessageLength
+	}
+}
+
+// verifyCSPTPRequest verifies the integrity check value of request b, which
+// ends with AUTHENTICATION TLV authtlv, and returns the key used.
+func verifyCSPTPRequest(cfg *CSPTPServerConfig, authtlv *csptp.AuthenticationTLV, b []byte) ([]byte, error) {
+	key, ok := cfg.authKey(authtlv.KeyID)
+	if !ok {
+		return nil, errUnknownCSPTPKey
+	}
+	if !csptp.ValidICV(b, key) {
+		return nil, errInvalidCSPTPICV
+	}
+	return key, nil
+}
+
+// pair records the request context c of the client identified by key. If the
+// request of the other type of the same sequence has already been recorded,
+// its context is removed and returned instead.
+func (t *csptpClientTable) pair(key string, c csptpContext) (csptpContext, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	cl, ok := t.clients[key]
+	if !ok {
+		if len(t.clients) == t.maxClients {
+			// evict least recently seen client
+			x := heap.Pop(&t.q).(*csptpClient)
+			delete(t.clients, x.key)
+		}
+		cl = &csptpClient{key: key, qval: c.rxTime}
+		t.clients[key] = cl
+		heap.Push(&t.q, cl)
+	} else if c.rxTime.After(cl.qval) {
+		cl.qval = c.rxTime
+		heap.Fix(&t.q, cl.qidx)
+	}
+
+	oldest := -1
+	for i := 0; i != cl.len; {
+		x := &cl.ctxts[i]
+		if c.rxTime.Sub(x.rxTime).Abs() > csptpContextTimeout {
+			// remove expired context
+			cl.len--
+			cl.ctxts[i] = cl.ctxts[cl.len]
+			continue
+		}
+		if x.portID == c.portID && x.sequenceID == c.sequenceID {
+			if x.msgType != c.msgType {
+				p := *x
+				cl.len--
+				cl.ctxts[i] = cl.ctxts[cl.len]
+				return p, true
+			}
+			// replace duplicate request
+			*x = c
+			return csptpContext{}, false
+		}
+		if oldest == -1 || x.rxTime.Before(cl.ctxts[oldest].rxTime) {
+			oldest = i
+		}
+		i++
+	}
+	if cl.len == csptpContextCap {
+		cl.ctxts[oldest] = c
+	} else {
+		cl.ctxts[cl.len] = c
+		cl.len++
+	}
+	return csptpContext{}, false
+}
+
+// csptpClockID derives a PTP clock identity from the MAC address of the
+// network interface with IP address ip, see IEEE 1588-2019, 7.5.2.2.2.
+func csptpClockID(ip net.IP) uint64 {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return 1
+	}
+	for _, iface := range ifaces {
+		if len(iface.HardwareAddr) != 6 {
+			continue
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, a := range addrs {
+			n, ok := a.(*net.IPNet)
+			if ok && (ip.IsUnspecified() || n.IP.Equal(ip)) {
+				m := iface.HardwareAddr
+				return binary.BigEndian.Uint64([]byte{m[0], m[1], m[2], 0xff, 0xfe, m[3], m[4], m[5]})
+			}
+		}
+	}
+	return 1
+}
+
+func csptpClockAccuracy(off time.Duration) uint8 {
+	off = off.Abs()
+	for _, a := range csptpClockAccuracies {
+		if off <= a.limit {
+			return a.value
+		}
+	}
+	return 0x31
+}
+
+// csptpClockVariance returns the offsetScaledLogVariance for variance v in
+// seconds squared, see IEEE 1588-2019, 7.6.3.3.
+func csptpClockVariance(v float64) uint16 {
+	if v <= 0 {
+		return 0
+	}
+	x := math.Round(math.Log2(v)*256) + 0x8000
+	if x < 0 {
+		return 0
+	}
+	if x > csptp.ClockVarianceUnknown-1 {
+		return csptp.ClockVarianceUnknown - 1
+	}
+	return uint16(x)
+}
+
+// csptpServerStateDS derives the ServerStateDS announced in responses from the
+// synchronization state s of the local clock at local time now.
+func csptpServerStateDS(cfg *CSPTPServerConfig, s clocksync.State, now time.Time) csptp.ServerStateDS {
+	ds := csptp.ServerStateDS{
+		GMPriority1:     csptpPriority,
+		GMClockClass:    csptp.ClockClassDefault,
+		GMClockAccuracy: csptp.ClockAccuracyUnknown,
+		GMClockVariance: csptp.ClockVarianceUnknown,
+		GMPriority2:     csptpPriority,
+		GMClockID:       cfg.ClockID,
+		StepsRemoved:    cfg.StepsRemoved,
+		TimeSource:      cfg.TimeSource,
+	}
+	if ds.TimeSource == 0 {
+		ds.TimeSource = csptp.TimeSourceInternalOscillator
+	}
+	if s.Synchronized {
+		if cfg.Primary {
+			ds.GMClockClass = csptp.ClockClassLocked
+		} else {
+			ds.GMClockClass = csptp.ClockClassApplicationSpecific
+		}
+		ds.GMClockAccuracy = csptpClockAccuracy(s.Offset)
+		ds.GMClockVariance = csptpClockVariance(s.Variance)
+	} else if cfg.Primary && !s.LastSynchronized.IsZero() && now.Sub(s.LastSynchronized) <= csptpHoldoverTimeout {
+		ds.GMClockClass = csptp.ClockClassHoldover
+	}
+	return ds
+}
+
+func newCSPTPSyncResponse(clockID uint64, sequenceID uint16) csptp.Message {
+	return csptp.Message{
+		SdoIDMessageType:    csptp.MessageTypeSync,
+		PTPVersion:          csptp.PTPVersion,
+		MessageLength:       csptp.MinMessageLength,
+		DomainNumber:        csptp.DomainNumber,
+		MinorSdoID:          csptp.MinorSdoID,
+		FlagField:           csptp.FlagTwoStep | csptp.FlagUnicast,
+		CorrectionField:     0,
+		MessageTypeSpecific: 0,
+		SourcePortIdentity: csptp.PortID{
+			ClockID: clockID,
+			Port:    1,
+		},
+		SequenceID:         sequenceID,
+		ControlField:       csptp.ControlSync,
+		LogMessageInterval: csptp.LogMessageInterval,
+		Timestamp:          csptp.Timestamp{},
+	}
+}
+
+// newCSPTPFollowUpResponse returns the Follow Up response for a Sync request
+// received at rxTime with the given correction, answered by a Sync response
+// transmitted at txTime. The response TLV announces utcOffset and, if ds is not
+// nil, includes ds.
+func newCSPTPFollowUpResponse(clockID uint64, sequenceID uint16, rxTime, txTime time.Time, correction int64,
+	utcOffset int16, ds *csptp.ServerStateDS) (csptp.Message, csptp.ResponseTLV) {
+	msg := csptp.Message{
+		SdoIDMessageType:    csptp.MessageTypeFollowUp,
+		PTPVersion:          csptp.PTPVersion,
+		MessageLength:       csptp.MinMessageLength,
+		DomainNumber:        csptp.DomainNumber,
+		MinorSdoID:          csptp.MinorSdoID,
+		FlagField:           csptp.FlagUnicast,
+		CorrectionField:     0,
+		MessageTypeSpecific: 0,
+		SourcePortIdentity: csptp.PortID{
+			ClockID: clockID,
+			Port:    1,
+		},
+		SequenceID:         sequenceID,
+		ControlField:       csptp.ControlFollowUp,
+		LogMessageInterval: csptp.LogMessageInterval,
+		Timestamp:          csptp.TimestampFromTime(txTime),
+	}
+	tlv := csptp.ResponseTLV{
+		Type:   csptp.TLVTypeOrganizationExtension,
+		Length: 0,
+		OrganizationID: [3]uint8{
+			csptp.OrganizationIDMeinberg0,
+			csptp.OrganizationIDMeinberg1,
+			csptp.OrganizationIDMeinberg2},
+		OrganizationSubType: [3]uint8{
+			csptp.OrganizationSubTypeResponse0,
+			csptp.OrganizationSubTypeResponse1,
+			csptp.OrganizationSubTypeResponse2},
+		FlagField:               0,
+		Error:                   0,
+		RequestIngressTimestamp: csptp.TimestampFromTime(rxTime),
+		RequestCorrectionField:  correction,
+		UTCOffset:               utcOffset,
+	}
+	if ds != nil {
+		tlv.FlagField |= csptp.TLVFlagServerStateDS
+		tlv.ServerStateDS = *ds
+	}
+	msg.MessageLength += uint16(csptp.EncodedResponseTLVLength(&tlv))
+	tlv.Length = uint16(csptp.EncodedResponseTLVLength(&tlv))
+	return msg, tlv
+}
+
+// encodeCSPTPResponse encodes msg and, if not nil, tlv into b. If authKey is
+// not nil, the response ends with an AUTHENTICATION TLV for key authKeyID and
+// the message length of msg is updated accordingly.
+func encodeCSPTPResponse(b []byte, msg *csptp.Message, tlv *csptp.ResponseTLV,
+	authKeyID uint32, authKey []byte) []byte {
+	n := int(msg.MessageLength)
+	if authKey != nil {
+		msg.MessageLength += csptp.EncodedAuthenticationTLVLength
+	}
+	b = b[:msg.MessageLength]
+	csptp.EncodeMessage(b[:csptp.MinMessageLength], msg)
+	if tlv != nil {
+		csptp.EncodeResponseTLV(b[csptp.MinMessageLength:], tlv)
+	}
+	if authKey != nil {
+		authtlv := csptp.NewAuthenticationTLV(authKeyID)
+		csptp.EncodeAuthenticationTLV(b[n:], &authtlv)
+		csptp.ComputeICV(b, authKey)
+	}
+	return b
+}
+
+// csptpResponseAuth returns the key to authenticate the responses to the
+// Sync request syncctx and the Follow Up request followupctx. Responses are
+// only authenticated if both requests were authenticated with the same key.
+func csptpResponseAuth(syncctx, followupctx *csptpContext) (uint32, []byte) {
+	if syncctx.authKey == nil || followupctx.authKey == nil || syncctx.authKeyID != followupctx.authKeyID {
+		return 0, nil
+	}
+	return followupctx.authKeyID, followupctx.authKey
+}
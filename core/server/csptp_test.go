@@ -0,0 +1,549 @@
+package server_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"log/slog"
+	"net"
+	"net/netip"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"example.com/scion-time/core/client"
+	"example.com/scion-time/core/server"
+
+	"example.com/scion-time/net/csptp"
+	"example.com/scion-time/net/ntp"
+	"example.com/scion-time/net/ntske"
+
+	clocksync "example.com/scion-time/core/sync"
+)
+
+const csptpTestClockID = 0x007665fffe746831
+
+func TestCSPTPSyncResponseEncoding(t *testing.T) {
+	// Expected encoding from net/csptp TestSyncResponse1
+	b0 := []byte{
+		0x00, 0x12, 0x00, 0x2c, 0x00, 0x00, 0x06, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x76, 0x65, 0xff,
+		0xfe, 0x74, 0x68, 0x31, 0x00, 0x01, 0x00, 0x01,
+		0x00, 0x7f, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00}
+	msg := server.NewCSPTPSyncResponse(csptpTestClockID, 1)
+	b1 := server.EncodeCSPTPResponse(make([]byte, csptp.MaxMessageLength), &msg, nil, 0, nil)
+	if !bytes.Equal(b1, b0) {
+		t.Errorf("EncodeCSPTPResponse() = %x; want %x", b1, b0)
+	}
+}
+
+func TestCSPTPFollowUpResponseEncoding(t *testing.T) {
+	tests := []struct {
+		name       string
+		sequenceID uint16
+		rxTime     time.Time
+		txTime     time.Time
+		ds         *csptp.ServerStateDS
+		want       []byte
+	}{
+		{
+			// Expected encoding from net/csptp TestFollowUpResponse0
+			name:       "with ServerStateDS",
+			sequenceID: 0,
+			rxTime:     time.Unix(1737196455, 482166607).UTC(),
+			txTime:     time.Unix(1737196455, 486627530).UTC(),
+			ds: &csptp.ServerStateDS{
+				GMPriority1:     128,
+				GMClockClass:    csptp.ClockClassDefault,
+				GMClockAccuracy: 0x2f,
+				GMClockVariance: csptp.ClockVarianceUnknown,
+				GMPriority2:     128,
+				GMClockID:       csptpTestClockID,
+				StepsRemoved:    0,
+				TimeSource:      csptp.TimeSourceHandSet,
+			},
+			want: []byte{
+				0x08, 0x12, 0x00, 0x62, 0x00, 0x00, 0x04, 0x00,
+				0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+				0x00, 0x00, 0x00, 0x00, 0x00, 0x76, 0x65, 0xff,
+				0xfe, 0x74, 0x68, 0x31, 0x00, 0x01, 0x00, 0x00,
+				0x02, 0x7f, 0x00, 0x00, 0x67, 0x8b, 0x83, 0xa7,
+				0x1d, 0x01, 0x58, 0xca, 0x00, 0x03, 0x00, 0x36,
+				0xec, 0x46, 0x70, 0x52, 0x65, 0x73, 0x00, 0x00,
+				0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x67, 0x8b,
+				0x83, 0xa7, 0x1c, 0xbd, 0x47, 0x4f, 0x00, 0x00,
+				0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+				0x80, 0xf8, 0x2f, 0xff, 0xff, 0x80, 0x00, 0x76,
+				0x65, 0xff, 0xfe, 0x74, 0x68, 0x31, 0x00, 0x00,
+				0x60, 0x0},
+		},
+		{
+			// Expected encoding from net/csptp TestFollowUpResponse1
+			name:       "without ServerStateDS",
+			sequenceID: 1,
+			rxTime:     time.Unix(1737196456, 493401778).UTC(),
+			txTime:     time.Unix(1737196456, 494391756).UTC(),
+			ds:         nil,
+			want: []byte{
+				0x08, 0x12, 0x00, 0x50, 0x00, 0x00, 0x04, 0x00,
+				0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+				0x00, 0x00, 0x00, 0x00, 0x00, 0x76, 0x65, 0xff,
+				0xfe, 0x74, 0x68, 0x31, 0x00, 0x01, 0x00, 0x01,
+				0x02, 0x7f, 0x00, 0x00, 0x67, 0x8b, 0x83, 0xa8,
+				0x1d, 0x77, 0xd1, 0xcc, 0x00, 0x03, 0x00, 0x24,
+				0xec, 0x46, 0x70, 0x52, 0x65, 0x73, 0x00, 0x00,
+				0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x67, 0x8b,
+				0x83, 0xa8, 0x1d, 0x68, 0xb6, 0xb2, 0x00, 0x00,
+				0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x0},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg, tlv := server.NewCSPTPFollowUpResponse(csptpTestClockID, tt.sequenceID,
+				tt.rxTime, tt.txTime, 0 /* correction */, 0 /* UTC offset */, tt.ds)
+			b := server.EncodeCSPTPResponse(make([]byte, csptp.MaxMessageLength), &msg, &tlv, 0, nil)
+			if !bytes.Equal(b, tt.want) {
+				t.Errorf("EncodeCSPTPResponse() = %x; want %x", b, tt.want)
+			}
+		})
+	}
+}
+
+func TestCSPTPFollowUpResponseCorrection(t *testing.T) {
+	rxTime := time.Unix(1737196455, 482166607).UTC()
+	msg, tlv := server.NewCSPTPFollowUpResponse(csptpTestClockID, 0, rxTime, rxTime, 1<<16, 0, nil)
+	b := server.EncodeCSPTPResponse(make([]byte, csptp.MaxMessageLength), &msg, &tlv, 0, nil)
+	var tlv1 csptp.ResponseTLV
+	err := csptp.DecodeResponseTLV(&tlv1, b[csptp.MinMessageLength:])
+	if err != nil {
+		t.Fatalf("DecodeResponseTLV() failed: %v", err)
+	}
+	if d := csptp.DurationFromTimeInterval(tlv1.RequestCorrectionField); d != time.Nanosecond {
+		t.Errorf("request correction = %v; want %v", d, time.Nanosecond)
+	}
+	if !csptp.TimeFromTimestamp(tlv1.RequestIngressTimestamp).Equal(rxTime) {
+		t.Errorf("request ingress timestamp = %v; want %v",
+			csptp.TimeFromTimestamp(tlv1.RequestIngressTimestamp), rxTime)
+	}
+}
+
+func TestCSPTPAuthenticatedResponse(t *testing.T) {
+	key := bytes.Repeat([]byte{0x5a}, 32)
+	rxTime := time.Unix(1737196455, 482166607).UTC()
+	msg, tlv := server.NewCSPTPFollowUpResponse(csptpTestClockID, 0, rxTime, rxTime, 0, 0, nil)
+	n := int(msg.MessageLength)
+	b := server.EncodeCSPTPResponse(make([]byte, csptp.MaxMessageLength), &msg, &tlv, 7, key)
+	if len(b) != n+csptp.EncodedAuthenticationTLVLength || int(msg.MessageLength) != len(b) {
+		t.Fatalf("EncodeCSPTPResponse() returned %d bytes with message length %d; want %d",
+			len(b), msg.MessageLength, n+csptp.EncodedAuthenticationTLVLength)
+	}
+	var msg1 csptp.Message
+	err := csptp.DecodeMessage(&msg1, b[:csptp.MinMessageLength])
+	if err != nil || int(msg1.MessageLength) != len(b) {
+		t.Errorf("DecodeMessage() = %v with message length %d; want %d", err, msg1.MessageLength, len(b))
+	}
+	var authtlv csptp.AuthenticationTLV
+	err = csptp.DecodeAuthenticationTLV(&authtlv, b[n:])
+	if err != nil || authtlv.KeyID != 7 {
+		t.Errorf("DecodeAuthenticationTLV() = %v with key ID %d; want key ID 7", err, authtlv.KeyID)
+	}
+	if !csptp.ValidICV(b, key) {
+		t.Error("ValidICV() rejected response")
+	}
+}
+
+func TestCSPTPServerStateDS(t *testing.T) {
+	now := time.Unix(1737196455, 0)
+	cfg := server.CSPTPServerConfig{
+		ClockID:      csptpTestClockID,
+		TimeSource:   csptp.TimeSourceGNSS,
+		StepsRemoved: 0,
+		Primary:      true,
+	}
+	secondary := server.CSPTPServerConfig{
+		ClockID:      csptpTestClockID,
+		TimeSource:   csptp.TimeSourceNTP,
+		StepsRemoved: 1,
+	}
+	tests := []struct {
+		name     string
+		cfg      server.CSPTPServerConfig
+		state    clocksync.State
+		class    uint8
+		accuracy uint8
+		variance uint16
+		source   uint8
+	}{
+		{
+			name:     "unsynchronized",
+			cfg:      cfg,
+			state:    clocksync.State{},
+			class:    csptp.ClockClassDefault,
+			accuracy: csptp.ClockAccuracyUnknown,
+			variance: csptp.ClockVarianceUnknown,
+			source:   csptp.TimeSourceGNSS,
+		},
+		{
+			name: "synchronized",
+			cfg:  cfg,
+			state: clocksync.State{
+				Synchronized:     true,
+				LastSynchronized: now,
+				Offset:           -500 * time.Nanosecond,
+				Variance:         1e-12,
+			},
+			class:    csptp.ClockClassLocked,
+			accuracy: 0x23,
+			variance: 22563,
+			source:   csptp.TimeSourceGNSS,
+		},
+		{
+			name: "synchronized coarsely",
+			cfg:  cfg,
+			state: clocksync.State{
+				Synchronized:     true,
+				LastSynchronized: now,
+				Offset:           20 * time.Second,
+				Variance:         1e-6,
+			},
+			class:    csptp.ClockClassLocked,
+			accuracy: 0x31,
+			variance: 27666,
+			source:   csptp.TimeSourceGNSS,
+		},
+		{
+			name: "holdover",
+			cfg:  cfg,
+			state: clocksync.State{
+				LastSynchronized: now.Add(-time.Minute),
+				Offset:           1 * time.Microsecond,
+				Variance:         1e-12,
+			},
+			class:    csptp.ClockClassHoldover,
+			accuracy: csptp.ClockAccuracyUnknown,
+			variance: csptp.ClockVarianceUnknown,
+			source:   csptp.TimeSourceGNSS,
+		},
+		{
+			name: "holdover expired",
+			cfg:  cfg,
+			state: clocksync.State{
+				LastSynchronized: now.Add(-2 * time.Hour),
+			},
+			class:    csptp.ClockClassDefault,
+			accuracy: csptp.ClockAccuracyUnknown,
+			variance: csptp.ClockVarianceUnknown,
+			source:   csptp.TimeSourceGNSS,
+		},
+		{
+			name: "synchronized to secondary source",
+			cfg:  secondary,
+			state: clocksync.State{
+				Synchronized:     true,
+				LastSynchronized: now,
+				Offset:           -500 * time.Nanosecond,
+				Variance:         1e-12,
+			},
+			class:    csptp.ClockClassApplicationSpecific,
+			accuracy: 0x23,
+			variance: 22563,
+			source:   csptp.TimeSourceNTP,
+		},
+		{
+			name: "holdover of secondary source",
+			cfg:  secondary,
+			state: clocksync.State{
+				LastSynchronized: now.Add(-time.Minute),
+			},
+			class:    csptp.ClockClassDefault,
+			accuracy: csptp.ClockAccuracyUnknown,
+			variance: csptp.ClockVarianceUnknown,
+			source:   csptp.TimeSourceNTP,
+		},
+		{
+			name:     "default time source",
+			cfg:      server.CSPTPServerConfig{ClockID: csptpTestClockID},
+			state:    clocksync.State{},
+			class:    csptp.ClockClassDefault,
+			accuracy: csptp.ClockAccuracyUnknown,
+			variance: csptp.ClockVarianceUnknown,
+			source:   csptp.TimeSourceInternalOscillator,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ds := server.CSPTPServerStateDS(&tt.cfg, tt.state, now)
+			want := csptp.ServerStateDS{
+				GMPriority1:     128,
+				GMClockClass:    tt.class,
+				GMClockAccuracy: tt.accuracy,
+				GMClockVariance: tt.variance,
+				GMPriority2:     128,
+				GMClockID:       csptpTestClockID,
+				StepsRemoved:    tt.cfg.StepsRemoved,
+				TimeSource:      tt.source,
+			}
+			if ds != want {
+				t.Errorf("CSPTPServerStateDS() = %+v; want %+v", ds, want)
+			}
+		})
+	}
+}
+
+func TestCSPTPRequestPairing(t *testing.T) {
+	clients := server.NewCSPTPClientTable(2)
+
+	now := time.Unix(1737196455, 0)
+	portID := csptp.PortID{ClockID: 1, Port: 1}
+
+	// Sync and Follow Up are paired in either order
+	if _, ok := server.PairCSPTPRequest(clients, "a", portID, 0, csptp.MessageTypeSync, now); ok {
+		t.Fatal("PairCSPTPRequest() paired first request")
+	}
+	rxt, ok := server.PairCSPTPRequest(clients, "a", portID, 0, csptp.MessageTypeFollowUp, now.Add(time.Millisecond))
+	if !ok || !rxt.Equal(now) {
+		t.Fatalf("PairCSPTPRequest() = %v, %t; want %v, true", rxt, ok, now)
+	}
+	if _, ok := server.PairCSPTPRequest(clients, "a", portID, 1, csptp.MessageTypeFollowUp, now); ok {
+		t.Fatal("PairCSPTPRequest() paired first request")
+	}
+	rxt, ok = server.PairCSPTPRequest(clients, "a", portID, 1, csptp.MessageTypeSync, now.Add(time.Millisecond))
+	if !ok || !rxt.Equal(now) {
+		t.Fatalf("PairCSPTPRequest() = %v, %t; want %v, true", rxt, ok, now)
+	}
+
+	// paired contexts are removed
+	if _, ok := server.PairCSPTPRequest(clients, "a", portID, 0, csptp.MessageTypeFollowUp, now); ok {
+		t.Error("PairCSPTPRequest() paired request with consumed context")
+	}
+
+	// requests of different clients, ports and sequences are not paired
+	if _, ok := server.PairCSPTPRequest(clients, "a", portID, 2, csptp.MessageTypeSync, now); ok {
+		t.Error("PairCSPTPRequest() paired first request")
+	}
+	if _, ok := server.PairCSPTPRequest(clients, "b", portID, 2, csptp.MessageTypeFollowUp, now); ok {
+		t.Error("PairCSPTPRequest() paired requests of different clients")
+	}
+	if _, ok := server.PairCSPTPRequest(clients, "a", csptp.PortID{ClockID: 2, Port: 1}, 2,
+		csptp.MessageTypeFollowUp, now); ok {
+		t.Error("PairCSPTPRequest() paired requests of different ports")
+	}
+	if _, ok := server.PairCSPTPRequest(clients, "a", portID, 3, csptp.MessageTypeFollowUp, now); ok {
+		t.Error("PairCSPTPRequest() paired requests of different sequences")
+	}
+
+	// duplicate requests replace earlier ones
+	if _, ok := server.PairCSPTPRequest(clients, "a", portID, 2, csptp.MessageTypeSync, now.Add(time.Millisecond)); ok {
+		t.Errorf("PairCSPTPRequest() paired duplicate requests")
+	}
+	rxt, ok = server.PairCSPTPRequest(clients, "a", portID, 2, csptp.MessageTypeFollowUp, now.Add(time.Millisecond))
+	if !ok || !rxt.Equal(now.Add(time.Millisecond)) {
+		t.Errorf("PairCSPTPRequest() = %v, %t; want %v, true", rxt, ok, now.Add(time.Millisecond))
+	}
+
+	// expired contexts are not paired
+	if _, ok := server.PairCSPTPRequest(clients, "c", portID, 0, csptp.MessageTypeSync, now); ok {
+		t.Fatal("PairCSPTPRequest() paired first request")
+	}
+	if _, ok := server.PairCSPTPRequest(clients, "c", portID, 0, csptp.MessageTypeFollowUp, now.Add(2*time.Second)); ok {
+		t.Error("PairCSPTPRequest() paired expired request")
+	}
+}
+
+func TestCSPTPRequestPairingEviction(t *testing.T) {
+	clients := server.NewCSPTPClientTable(2)
+
+	now := time.Unix(1737196455, 0)
+	portID := csptp.PortID{ClockID: 1, Port: 1}
+
+	// the oldest context of a client is replaced when its capacity is reached
+	for i := range server.CSPTPContextCap + 1 {
+		if _, ok := server.PairCSPTPRequest(clients, "a", portID, uint16(i), csptp.MessageTypeSync,
+			now.Add(time.Duration(i)*time.Millisecond)); ok {
+			t.Fatal("PairCSPTPRequest() paired first request")
+		}
+	}
+	for i := 1; i != server.CSPTPContextCap+1; i++ {
+		if _, ok := server.PairCSPTPRequest(clients, "a", portID, uint16(i), csptp.MessageTypeFollowUp, now); !ok {
+			t.Errorf("PairCSPTPRequest() did not pair request %d", i)
+		}
+	}
+	if _, ok := server.PairCSPTPRequest(clients, "a", portID, 0, csptp.MessageTypeFollowUp, now); ok {
+		t.Error("PairCSPTPRequest() paired evicted request")
+	}
+
+	// the least recently seen client is evicted when the capacity is reached
+	for i, key := range []string{"b", "c", "d"} {
+		if _, ok := server.PairCSPTPRequest(clients, key, portID, 0, csptp.MessageTypeSync,
+			now.Add(time.Second+time.Duration(i)*time.Millisecond)); ok {
+			t.Fatal("PairCSPTPRequest() paired first request")
+		}
+	}
+	for _, key := range []string{"c", "d"} {
+		if _, ok := server.PairCSPTPRequest(clients, key, portID, 0, csptp.MessageTypeFollowUp, now.Add(time.Second)); !ok {
+			t.Errorf("PairCSPTPRequest() did not pair request of recently seen client %s", key)
+		}
+	}
+	if _, ok := server.PairCSPTPRequest(clients, "b", portID, 0, csptp.MessageTypeFollowUp, now.Add(time.Second)); ok {
+		t.Error("PairCSPTPRequest() paired request of evicted client")
+	}
+}
+
+func TestCSPTPIPExchange(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("CSPTP via IP requires privileged ports " +
+			strconv.Itoa(csptp.EventPortIP) + " and " + strconv.Itoa(csptp.GeneralPortIP))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	log := slog.New(slog.DiscardHandler)
+
+	serverIP := netip.MustParseAddr("127.0.0.19")
+	clientIP := netip.MustParseAddr("127.0.0.20")
+	authClientIP := netip.MustParseAddr("127.0.0.36")
+	limitedClientIP := netip.MustParseAddr("127.0.0.37")
+
+	// Requests from authClientIP must be authenticated, all clients are
+	// limited to a burst of csptpTestBurst requests.
+	const csptpTestBurst = 40
+	acl := server.NewACL([]server.ACLRule{{
+		Action:   server.ACLAllow,
+		Prefixes: []netip.Prefix{netip.PrefixFrom(authClientIP, 32)},
+		Auth:     server.ACLAuthMAC,
+	}, {
+		Action:   server.ACLDeny,
+		Prefixes: []netip.Prefix{netip.PrefixFrom(authClientIP, 32)},
+	}})
+	limiter := server.NewRateLimiter(server.RateLimitConfig{Rate: 0.001, Burst: csptpTestBurst})
+
+	key := bytes.Repeat([]byte{0x5a}, 32)
+	provider := ntske.NewProvider()
+	server.StartCSPTPServerIP(ctx, log, &net.UDPAddr{IP: serverIP.AsSlice()},
+		0 /* DSCP */, acl, limiter, server.CSPTPServerConfig{
+			Keys:     map[uint32][]byte{1: key},
+			Provider: provider,
+		})
+
+	cert := newTestCertificate(t, serverIP.AsSlice())
+	server.StartNTSKEServerIP(ctx, log, serverIP.AsSlice(), ntp.ServerPortIP, &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		NextProtos:   []string{"ntske/1"},
+		MinVersion:   tls.VersionTLS13,
+	}, provider, nil /* ACL */, server.NTSKEServerConfig{})
+
+	measureFrom := func(c *client.CSPTPClientIP, localIP netip.Addr, timeout time.Duration) error {
+		mctx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+		_, off, err := c.MeasureClockOffset(mctx, localIP, serverIP)
+		if err != nil {
+			return err
+		}
+		if off.Abs() > 100*time.Millisecond {
+			t.Errorf("MeasureClockOffset() = %v; want offset close to 0", off)
+		}
+		return nil
+	}
+	measure := func(c *client.CSPTPClientIP, timeout time.Duration) error {
+		return measureFrom(c, clientIP, timeout)
+	}
+
+	t.Run("unauthenticated", func(t *testing.T) {
+		c := &client.CSPTPClientIP{Log: log}
+		for range 3 {
+			err := measure(c, 5*time.Second)
+			if err != nil {
+				t.Fatalf("MeasureClockOffset() failed: %v", err)
+			}
+		}
+	})
+
+	t.Run("static key", func(t *testing.T) {
+		c := &client.CSPTPClientIP{Log: log}
+		c.Auth.Enabled = true
+		c.Auth.KeyID = 1
+		c.Auth.Key = key
+		for range 3 {
+			err := measure(c, 5*time.Second)
+			if err != nil {
+				t.Fatalf("MeasureClockOffset() failed: %v", err)
+			}
+		}
+	})
+
+	t.Run("wrong key", func(t *testing.T) {
+		for _, keyID := range []uint32{1, 2} {
+			c := &client.CSPTPClientIP{Log: log}
+			c.Auth.Enabled = true
+			c.Auth.KeyID = keyID
+			c.Auth.Key = bytes.Repeat([]byte{0xa5}, 32)
+			err := measure(c, 500*time.Millisecond)
+			if err == nil {
+				t.Errorf("MeasureClockOffset() succeeded with wrong key %d", keyID)
+			}
+		}
+	})
+
+	t.Run("authentication required", func(t *testing.T) {
+		c := &client.CSPTPClientIP{Log: log}
+		err := measureFrom(c, authClientIP, 500*time.Millisecond)
+		if err == nil {
+			t.Error("MeasureClockOffset() succeeded without authentication")
+		}
+		c.Auth.Enabled = true
+		c.Auth.KeyID = 1
+		c.Auth.Key = key
+		err = measureFrom(c, authClientIP, 5*time.Second)
+		if err != nil {
+			t.Errorf("MeasureClockOffset() failed: %v", err)
+		}
+	})
+
+	t.Run("rate limit", func(t *testing.T) {
+		// Each measurement takes a Sync and a Follow_Up request.
+		c := &client.CSPTPClientIP{Log: log}
+		for i := range csptpTestBurst/2 + 1 {
+			err := measureFrom(c, limitedClientIP, 500*time.Millisecond)
+			if i < csptpTestBurst/2 && err != nil {
+				t.Fatalf("MeasureClockOffset() failed within burst: %v", err)
+			}
+			if i == csptpTestBurst/2 && err == nil {
+				t.Error("MeasureClockOffset() succeeded beyond burst")
+			}
+		}
+	})
+
+	t.Run("NTS-KE key", func(t *testing.T) {
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		roots := x509.NewCertPool()
+		roots.AddCert(leaf)
+
+		c := &client.CSPTPClientIP{Log: log}
+		c.Auth.Enabled = true
+		c.Auth.NTSKEFetcher.Log = log
+		c.Auth.NTSKEFetcher.TLSConfig = tls.Config{
+			ServerName: serverIP.String(),
+			RootCAs:    roots,
+			MinVersion: tls.VersionTLS13,
+		}
+		c.Auth.NTSKEFetcher.Port = strconv.Itoa(ntske.ServerPortIP)
+		c.Auth.NTSKEFetcher.CSPTP = true
+		for range 3 {
+			err := measure(c, 5*time.Second)
+			if err != nil {
+				t.Fatalf("MeasureClockOffset() failed: %v", err)
+			}
+		}
+		if n := c.Auth.NTSKEFetcher.NumCookies(); n == 0 {
+			t.Error("FetchCSPTPKey() consumed cookies")
+		}
+	})
+}
@@ -1,13 +1,92 @@
 package server
 
 import (
+	"net/netip"
 	"testing"
+	"time"
+
+	"github.com/scionproto/scion/pkg/addr"
+
+	clocksync "example.com/scion-time/core/sync"
+
+	"example.com/scion-time/net/csptp"
+	"example.com/scion-time/net/ntp"
 )
 
-var HandleRequest = handleRequest
+var (
+	HandleRequest   = handleRequest
+	HandleRequestV5 = handleRequestV5
+)
 
 func LogTSS(t *testing.T, prefix string) {
 	t.Helper()
 	t.Logf("%s:tss = %+v", prefix, tss)
 	t.Logf("%s:tssQ = %+v", prefix, tssQ)
 }
+
+const (
+	RateLimitAccept      = int(rateLimitAccept)
+	RateLimitAcceptBasic = int(rateLimitAcceptBasic)
+	RateLimitKoD         = int(rateLimitKoD)
+	RateLimitDrop        = int(rateLimitDrop)
+)
+
+func (l *RateLimiter) Check(key string, now time.Time) int {
+	return int(l.check(key, now))
+}
+
+func (l *RateLimiter) IPClientKey(a netip.Addr) string {
+	return l.ipClientKey(a)
+}
+
+func (acl *ACL) CheckIP(a netip.Addr, nts bool) (bool, uint32) {
+	return acl.check(aclClient{addr: a, nts: nts})
+}
+
+func (acl *ACL) CheckSCION(ia addr.IA, a netip.Addr, nts, spao bool) (bool, uint32) {
+	return acl.check(aclClient{scion: true, ia: ia, addr: a, nts: nts, spao: spao})
+}
+
+func (acl *ACL) CheckNTSIdentity(a netip.Addr, identity string) (bool, uint32) {
+	return acl.check(aclClient{addr: a, nts: true, identity: identity})
+}
+
+func (acl *ACL) CheckMAC(a netip.Addr, keyID uint32) (bool, uint32) {
+	return acl.check(aclClient{addr: a, keyID: keyID})
+}
+
+const ControlRateLimitBurst = controlRateLimitBurst
+
+func (c *Control) Allow(a netip.Addr, now time.Time) bool {
+	return c.allow(a, now)
+}
+
+func (c *Control) Respond(req *ntp.ControlPacket, s clocksync.State, now time.Time) []ntp.ControlPacket {
+	return c.respond(req, s, now)
+}
+
+var (
+	CSPTPServerStateDS       = csptpServerStateDS
+	NewCSPTPSyncResponse     = newCSPTPSyncResponse
+	NewCSPTPFollowUpResponse = newCSPTPFollowUpResponse
+	EncodeCSPTPResponse      = encodeCSPTPResponse
+)
+
+const CSPTPContextCap = csptpContextCap
+
+type CSPTPClientTable = csptpClientTable
+
+var NewCSPTPClientTable = newCSPTPClientTable
+
+// PairCSPTPRequest records a request of type msgType in table t and returns the
+// receive time of the paired request of the other type, if any.
+func PairCSPTPRequest(t *CSPTPClientTable, key string, portID csptp.PortID, sequenceID uint16, msgType uint8,
+	rxTime time.Time) (time.Time, bool) {
+	c, ok := t.pair(key, csptpContext{
+		rxTime:     rxTime,
+		portID:     portID,
+		sequenceID: sequenceID,
+		msgType:    msgType,
+	})
+	return c.rxTime, ok
+}
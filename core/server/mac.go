@@ -0,0 +1,26 @@
+package server
+
+// See RFC 5905, Section 7.3 and RFC 8573, client mode requests authenticated by
+// a MAC
+
+import (
+	"errors"
+
+	"example.com/scion-time/net/ntp"
+)
+
+var errUnknownKey = errors.New("unknown key")
+
+// verifyMAC verifies the MAC of the request b with the key of keys it refers
+// to and returns that key.
+func verifyMAC(keys map[uint32]ntp.SymmetricKey, b []byte) (ntp.SymmetricKey, error) {
+	key, ok := keys[ntp.MACKeyID(b)]
+	if !ok {
+		return ntp.SymmetricKey{}, errUnknownKey
+	}
+	err := ntp.VerifyMAC(b, key)
+	if err != nil {
+		return ntp.SymmetricKey{}, err
+	}
+	return key, nil
+}
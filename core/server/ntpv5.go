@@ -0,0 +1,235 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"example.com/scion-time/base/leapsecond"
+
+	"example.com/scion-time/core/timebase"
+
+	"example.com/scion-time/net/ntp"
+)
+
+// ntpv5Ext holds the NTPv5 extension fields of a request answered by the
+// server.
+type ntpv5Ext struct {
+	draftID      bool
+	refIDsOffset int
+	refIDsLen    int
+}
+
+var (
+	errUnexpectedDraftID = errors.New("unexpected NTPv5 draft identification")
+
+	// refIDs are the reference IDs announced by NTPv5 servers.
+	refIDs = ntp.NewRefIDs()
+
+	// serverCookieKey is the key from which NTPv5 server cookies are derived.
+	serverCookieKey = func() []byte {
+		b := make([]byte, sha256.Size)
+		_, err := rand.Read(b)
+		if err != nil {
+			panic(err)
+		}
+		return b
+	}()
+
+	// serverCookieSeq numbers the requests for which server cookies are
+	// issued.
+	serverCookieSeq atomic.Uint64
+
+	// leapSeconds is the leap second table by which NTPv5 responses in TAI are
+	// converted from UTC.
+	leapSeconds atomic.Pointer[leapsecond.Table]
+)
+
+// SetLeapSeconds sets the leap second table used to answer NTPv5 requests for
+// TAI. Without a table or after it has expired, such requests are answered in
+// UTC.
+func SetLeapSeconds(t *leapsecond.Table) {
+	leapSeconds.Store(t)
+}
+
+// RefIDs returns the reference ID of the local instance and the reference ID
+// filters of its sources as announced by NTPv5 servers. Clients synchronizing
+// the local clock are expected to report the filters of their NTPv5 sources.
+func RefIDs() *ntp.RefIDs {
+	return refIDs
+}
+
+// serverCookie returns a new NTPv5 server cookie for a response to a request
+// from client clientID. A client requests interleaved mode by sending the
+// cookie back to the server in its next request. Cookies are a MAC of client
+// and request sequence number, they are stored with the timestamps of the
+// request and do not reveal them.
+func serverCookie(clientID string) uint64 {
+	mac := hmac.New(sha256.New, serverCookieKey)
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], serverCookieSeq.Add(1))
+	_, _ = mac.Write(b[:])
+	_, _ = mac.Write([]byte(clientID))
+	cookie := binary.BigEndian.Uint64(mac.Sum(nil))
+	if cookie == 0 {
+		// 0 indicates the absence of a cookie
+		cookie = 1
+	}
+	return cookie
+}
+
+// serverCookieRxTime returns the rx timestamp of the request from client
+// clientID in the timestamp store whose response carried cookie, or the zero
+// value if there is none.
+func serverCookieRxTime(clientID string, cookie uint64) ntp.Time64 {
+	if cookie == 0 {
+		return ntp.Time64{}
+	}
+
+	tssMu.Lock()
+	defer tssMu.Unlock()
+
+	tssi, ok := tss[clientID]
+	if ok {
+		for i := range tssi.len {
+			if tssi.buf[i].cookie == cookie {
+				return tssi.buf[i].rxt
+			}
+		}
+	}
+	return ntp.Time64{}
+}
+
+// responseTimescale returns the timescale of the response to a request for
+// timescale ts received at rxt and its offset from UTC. Requests for TAI are
+// answered in TAI while the leap second table is valid, all other requests
+// in UTC. UT1 and leap-smeared UTC are not supported.
+func responseTimescale(ts uint8, rxt time.Time) (uint8, time.Duration) {
+	if ts == ntp.TimescaleTAI {
+		if t := leapSeconds.Load(); t != nil {
+			if offset, ok := t.Offset(rxt); ok {
+				return ntp.TimescaleTAI, time.Duration(offset) * time.Second
+			}
+		}
+	}
+	return ntp.TimescaleUTC, 0
+}
+
+// addSeconds returns t shifted by offset, a whole number of seconds.
+func addSeconds(t ntp.Time64, offset time.Duration) ntp.Time64 {
+	t.Seconds += uint32(int64(offset / time.Second))
+	return t
+}
+
+// decodeNTPv5Ext decodes the extension fields of the NTPv5 request b. Requests
+// identifying a different draft version are rejected.
+func decodeNTPv5Ext(ext *ntpv5Ext, b []byte) error {
+	*ext = ntpv5Ext{}
+	pos := ntp.PacketLen
+	for pos != len(b) {
+		typ, v, n, err := ntp.DecodeExtensionField(b[pos:])
+		if err != nil {
+			return err
+		}
+		switch typ {
+		case ntp.ExtDraftIdentification:
+			if !ntp.IsDraftIdentification(v) {
+				return errUnexpectedDraftID
+			}
+			ext.draftID = true
+		case ntp.ExtRefIDsRequest:
+			ext.refIDsOffset, ext.refIDsLen, err = ntp.DecodeRefIDsRequest(v)
+			if err != nil {
+				return err
+			}
+		}
+		pos += n
+	}
+	return nil
+}
+
+// appendNTPv5Ext appends the extension fields of the response to a request with
+// extension fields ext to b. Each is as long as the corresponding extension
+// field of the request.
+func appendNTPv5Ext(b []byte, ext *ntpv5Ext) []byte {
+	if ext.draftID {
+		b = ntp.AppendDraftIdentification(b)
+	}
+	if ext.refIDsLen != 0 {
+		f := refIDs.Filter()
+		b = ntp.AppendRefIDsResponse(b, &f, ext.refIDsOffset, ext.refIDsLen)
+	}
+	return b
+}
+
+// prepareResponseV5 prepares the response to req received at rxt and returns
+// the offset of its timescale from UTC.
+func prepareResponseV5(req *ntp.PacketV5, rxt time.Time, resp *ntp.PacketV5) time.Duration {
+	var offset time.Duration
+	resp.SetVersion(ntp.Version5)
+	resp.SetMode(ntp.ModeServer)
+	resp.Stratum = 1
+	resp.Poll = req.Poll
+	resp.Precision = -32
+	resp.Timescale, offset = responseTimescale(req.Timescale, rxt)
+	resp.RootDispersion = 10 << 12
+	resp.ClientCookie = req.ClientCookie
+	return offset
+}
+
+// handleNAKRequestV5 prepares an NTS NAK response, NTPv5 has no Kiss-o'-Death
+// packets. The response carries no timestamps.
+func handleNAKRequestV5(req *ntp.PacketV5, resp *ntp.PacketV5) {
+	resp.SetLeapIndicator(ntp.LeapIndicatorUnknown)
+	resp.SetVersion(ntp.Version5)
+	resp.SetMode(ntp.ModeServer)
+	resp.Stratum = 0
+	resp.Poll = req.Poll
+	resp.Precision = -32
+	resp.Flags = ntp.FlagAuthNAK
+	resp.ClientCookie = req.ClientCookie
+}
+
+// handleBasicRequestV5 prepares a response without server cookie s.t. the
+// client cannot request interleaved mode.
+func handleBasicRequestV5(req *ntp.PacketV5, rxt, txt *time.Time, resp *ntp.PacketV5) {
+	offset := prepareResponseV5(req, *rxt, resp)
+
+	*txt = timebase.Now()
+	if !rxt.Before(*txt) {
+		*txt = rxt.Add(1)
+	}
+
+	resp.Era = ntp.EraFromTime(rxt.Add(offset))
+	resp.ReceiveTime = ntp.Time64FromTime(rxt.Add(offset))
+	resp.TransmitTime = ntp.Time64FromTime(txt.Add(offset))
+}
+
+// handleRequestV5 prepares a response in interleaved mode if the request
+// carries the server cookie of an earlier response whose tx timestamp is
+// available in the timestamp store, or in basic mode otherwise.
+func handleRequestV5(clientID string, req *ntp.PacketV5, rxt, txt *time.Time, resp *ntp.PacketV5) {
+	offset := prepareResponseV5(req, *rxt, resp)
+
+	cookie := serverCookie(clientID)
+	rxt64, txt64, prevTxt, ok := storeTimestamps(clientID, serverCookieRxTime(clientID, req.ServerCookie),
+		cookie, rxt, txt)
+
+	// The timestamp store holds UTC timestamps, responses in TAI are shifted
+	// by the offset at rxt.
+	resp.Era = ntp.EraFromTime(rxt.Add(offset))
+	resp.ServerCookie = cookie
+	resp.ReceiveTime = addSeconds(rxt64, offset)
+	if req.ServerCookie != 0 && ok {
+		// interleaved mode: serve from timestamp store
+		resp.Flags |= ntp.FlagInterleaved
+		resp.TransmitTime = addSeconds(prevTxt, offset)
+		tssMetrics.reqsServedInterleaved.Inc()
+	} else {
+		resp.TransmitTime = addSeconds(txt64, offset)
+	}
+}
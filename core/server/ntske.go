@@ -2,24 +2,229 @@ package server
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
+	"io"
 	"log/slog"
 	"net"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"example.com/scion-time/base/metrics"
 
 	"example.com/scion-time/net/ntske"
 )
 
-var errNoCookie = errors.New("failed to add at least one cookie")
+const (
+	defaultNTSKEMaxHandshakes    = 256
+	defaultNTSKEHandshakeTimeout = 5 * time.Second
+	defaultNTSKEReadTimeout      = 5 * time.Second
+)
+
+var (
+	errNoCookie = errors.New("failed to add at least one cookie")
+	errNoAlgo   = errors.New("no supported AEAD algorithm proposed")
+
+	errIdentityTooLong = errors.New("client identity too long")
+)
+
+// NTSKEServerConfig configures the resource limits of NTS-KE servers. Zero
+// values select the defaults.
+type NTSKEServerConfig struct {
+	// MaxHandshakes is the maximum number of key exchanges, including their
+	// handshakes, handled concurrently. Connections beyond the limit are closed
+	// immediately.
+	MaxHandshakes int
+	// HandshakeTimeout bounds the TLS handshake of a connection.
+	HandshakeTimeout time.Duration
+	// ReadTimeout bounds reading the request of a client and writing the
+	// response.
+	ReadTimeout time.Duration
+	// RateLimit limits the rate of new connections per client.
+	RateLimit RateLimitConfig
+	// Backends are the NTP servers advertised to clients. If nil, the NTS-KE
+	// server advertises its own address and the configured NTP port.
+	Backends *NTPBackendPool
+}
+
+type ntskeServerMetrics struct {
+	connsRateLimited    prometheus.Counter
+	connsRejected       prometheus.Counter
+	handshakeDuration   prometheus.Histogram
+	handshakeErrors     prometheus.Counter
+	handshakes          prometheus.Counter
+	keyExchangeDuration prometheus.Histogram
+	keyExchangeErrors   prometheus.Counter
+	keyExchanges        prometheus.Counter
+}
+
+func newNTSKEServerMetrics() *ntskeServerMetrics {
+	return &ntskeServerMetrics{
+		connsRateLimited: promauto.NewCounter(prometheus.CounterOpts{
+			Name: metrics.NTSKEServerConnsRateLimitedN,
+			Help: metrics.NTSKEServerConnsRateLimitedH,
+		}),
+		connsRejected: promauto.NewCounter(prometheus.CounterOpts{
+			Name: metrics.NTSKEServerConnsRejectedN,
+			Help: metrics.NTSKEServerConnsRejectedH,
+		}),
+		handshakeDuration: promauto.NewHistogram(prometheus.HistogramOpts{
+			Name:    metrics.NTSKEServerHandshakeDurationN,
+			Help:    metrics.NTSKEServerHandshakeDurationH,
+			Buckets: prometheus.ExponentialBuckets(0.001, 2, 14),
+		}),
+		handshakeErrors: promauto.NewCounter(prometheus.CounterOpts{
+			Name: metrics.NTSKEServerHandshakeErrorsN,
+			Help: metrics.NTSKEServerHandshakeErrorsH,
+		}),
+		handshakes: promauto.NewCounter(prometheus.CounterOpts{
+			Name: metrics.NTSKEServerHandshakesN,
+			Help: metrics.NTSKEServerHandshakesH,
+		}),
+		keyExchangeDuration: promauto.NewHistogram(prometheus.HistogramOpts{
+			Name:    metrics.NTSKEServerKeyExchangeDurationN,
+			Help:    metrics.NTSKEServerKeyExchangeDurationH,
+			Buckets: prometheus.ExponentialBuckets(0.001, 2, 14),
+		}),
+		keyExchangeErrors: promauto.NewCounter(prometheus.CounterOpts{
+			Name: metrics.NTSKEServerKeyExchangeErrorsN,
+			Help: metrics.NTSKEServerKeyExchangeErrorsH,
+		}),
+		keyExchanges: promauto.NewCounter(prometheus.CounterOpts{
+			Name: metrics.NTSKEServerKeyExchangesN,
+			Help: metrics.NTSKEServerKeyExchangesH,
+		}),
+	}
+}
+
+var ntskeServerMtrcs atomic.Pointer[ntskeServerMetrics]
+
+func init() {
+	ntskeServerMtrcs.Store(newNTSKEServerMetrics())
+}
+
+// ntskeLimits enforces the resource limits of an NTS-KE server.
+type ntskeLimits struct {
+	sem              chan struct{}
+	limiter          *RateLimiter
+	handshakeTimeout time.Duration
+	readTimeout      time.Duration
+}
+
+func newNTSKELimits(cfg NTSKEServerConfig) *ntskeLimits {
+	if cfg.MaxHandshakes <= 0 {
+		cfg.MaxHandshakes = defaultNTSKEMaxHandshakes
+	}
+	if cfg.HandshakeTimeout <= 0 {
+		cfg.HandshakeTimeout = defaultNTSKEHandshakeTimeout
+	}
+	if cfg.ReadTimeout <= 0 {
+		cfg.ReadTimeout = defaultNTSKEReadTimeout
+	}
+	return &ntskeLimits{
+		sem:              make(chan struct{}, cfg.MaxHandshakes),
+		limiter:          NewRateLimiter(cfg.RateLimit),
+		handshakeTimeout: cfg.HandshakeTimeout,
+		readTimeout:      cfg.ReadTimeout,
+	}
+}
+
+// admit reports whether a new connection from the client identified by key
+// may be handled. If so, release must be called once the connection is done.
+func (l *ntskeLimits) admit(key string) bool {
+	mtrcs := ntskeServerMtrcs.Load()
+	switch l.limiter.check(key, time.Now()) {
+	case rateLimitKoD, rateLimitDrop:
+		mtrcs.connsRateLimited.Inc()
+		return false
+	}
+	select {
+	case l.sem <- struct{}{}:
+		return true
+	default:
+		mtrcs.connsRejected.Inc()
+		return false
+	}
+}
+
+func (l *ntskeLimits) release() {
+	<-l.sem
+}
+
+// ntskeErrorCode returns the code of the error record to be sent to a client
+// whose request could not be read because of err. No error record is sent if
+// the client closed the connection or did not send its request in time.
+func ntskeErrorCode(err error) (int, bool) {
+	switch {
+	case errors.Is(err, ntske.ErrUnrecognizedCritical):
+		return ntske.ErrorCodeUnrecognizedCritical, true
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF),
+		errors.Is(err, os.ErrDeadlineExceeded), errors.Is(err, context.DeadlineExceeded),
+		errors.Is(err, context.Canceled):
+		return 0, false
+	default:
+		return ntske.ErrorCodeBadRequest, true
+	}
+}
+
+// negotiateAlgo selects the AEAD algorithm to be used with the client by
+// honoring the client's order of preference in data.Algos.
+func negotiateAlgo(data *ntske.Data) error {
+	algo, ok := ntske.SelectAlgorithm(data.Algos)
+	if !ok {
+		return errNoAlgo
+	}
+	data.Algo = algo
+	return nil
+}
+
+// ntskeIdentity returns the identity of a client authenticated by a verified
+// certificate, if any: the common name of its certificate or, if empty, its
+// first DNS name or URI.
+func ntskeIdentity(cs tls.ConnectionState) (string, error) {
+	if len(cs.VerifiedChains) == 0 || len(cs.VerifiedChains[0]) == 0 {
+		return "", nil
+	}
+	leaf := cs.VerifiedChains[0][0]
+	identity := leaf.Subject.CommonName
+	if identity == "" && len(leaf.DNSNames) != 0 {
+		identity = leaf.DNSNames[0]
+	}
+	if identity == "" && len(leaf.URIs) != 0 {
+		identity = leaf.URIs[0].String()
+	}
+	if len(identity) > ntske.MaxIdentityLen {
+		return "", errIdentityTooLong
+	}
+	return identity, nil
+}
+
+// ntpServerAddr returns the address of the NTP server to be advertised to an
+// NTS-KE client.
+func ntpServerAddr(backends *NTPBackendPool, localIP net.IP, localPort int) (net.IP, int, error) {
+	if backends == nil {
+		return localIP, localPort, nil
+	}
+	return backends.pick()
+}
 
 func newNTSKEMsg(ctx context.Context, log *slog.Logger,
-	localIP net.IP, localPort int, data *ntske.Data, provider *ntske.Provider) (
+	localIP net.IP, localPort int, data *ntske.Data, identity string, provider *ntske.Provider) (
 	ntske.ExchangeMsg, error) {
+	nextProto := ntske.NTPv4
+	if data.NTPv5 {
+		nextProto = ntske.NTPv5
+	}
 	var msg ntske.ExchangeMsg
 	msg.AddRecord(ntske.NextProto{
-		NextProto: ntske.NTPv4,
+		NextProto: nextProto,
 	})
 	msg.AddRecord(ntske.Algorithm{
-		Algo: []uint16{ntske.AES_SIV_CMAC_256},
+		Algo: []uint16{data.Algo},
 	})
 	msg.AddRecord(ntske.Server{
 		Addr: []byte(localIP.String()),
@@ -29,9 +234,10 @@ func newNTSKEMsg(ctx context.Context, log *slog.Logger,
 	})
 
 	var plaintextCookie ntske.ServerCookie
-	plaintextCookie.Algo = ntske.AES_SIV_CMAC_256
+	plaintextCookie.Algo = data.Algo
 	plaintextCookie.C2S = data.C2sKey
 	plaintextCookie.S2C = data.S2cKey
+	plaintextCookie.Identity = identity
 	key := provider.Current()
 	addedCookie := false
 	for range 8 {
@@ -51,6 +257,14 @@ func newNTSKEMsg(ctx context.Context, log *slog.Logger,
 		return ntske.ExchangeMsg{}, errNoCookie
 	}
 
+	if data.CSPTP {
+		keyID, key := provider.NewCSPTPKey()
+		msg.AddRecord(ntske.CSPTPKey{
+			KeyID: keyID,
+			Key:   key,
+		})
+	}
+
 	msg.AddRecord(ntske.End{})
 
 	return msg, nil
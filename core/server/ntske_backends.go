@@ -0,0 +1,129 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultNTPBackendCheckInterval = 10 * time.Second
+	ntpBackendCheckTimeout         = 2 * time.Second
+
+	// A backend is considered unhealthy after ntpBackendMaxFailures
+	// consecutive failed health checks and healthy again after a successful
+	// one.
+	ntpBackendMaxFailures = 2
+)
+
+var errNoNTPBackend = errors.New("no healthy NTP server available")
+
+// NTPBackend is an NTP server NTS-KE clients may be delegated to. The server
+// must share the cookie key of the NTS-KE server, e.g., by using the same key
+// seed.
+type NTPBackend struct {
+	IP   net.IP
+	Port int
+	// Probe checks the health of the server. Servers without probe are always
+	// considered healthy.
+	Probe func(ctx context.Context) error
+}
+
+type ntpBackend struct {
+	NTPBackend
+	failures int
+	healthy  atomic.Bool
+}
+
+// NTPBackendPool is a set of NTP servers advertised to NTS-KE clients instead
+// of the NTS-KE server itself. Healthy servers are advertised in round-robin
+// order.
+type NTPBackendPool struct {
+	backends []*ntpBackend
+	next     atomic.Uint32
+}
+
+// NewNTPBackendPool returns a pool of the given NTP servers or nil if
+// backends is empty.
+func NewNTPBackendPool(backends []NTPBackend) *NTPBackendPool {
+	if len(backends) == 0 {
+		return nil
+	}
+	p := &NTPBackendPool{}
+	for _, b := range backends {
+		pb := &ntpBackend{NTPBackend: b}
+		pb.healthy.Store(true)
+		p.backends = append(p.backends, pb)
+	}
+	return p
+}
+
+func (p *NTPBackendPool) check(ctx context.Context, log *slog.Logger) {
+	var wg sync.WaitGroup
+	for _, b := range p.backends {
+		if b.Probe == nil {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			cctx, cancel := context.WithTimeout(ctx, ntpBackendCheckTimeout)
+			err := b.Probe(cctx)
+			cancel()
+			if err == nil {
+				b.failures = 0
+				if !b.healthy.Swap(true) {
+					log.LogAttrs(ctx, slog.LevelInfo, "NTP server healthy",
+						slog.Any("ip", b.IP), slog.Int("port", b.Port))
+				}
+				return
+			}
+			b.failures++
+			if b.failures >= ntpBackendMaxFailures && b.healthy.Swap(false) {
+				log.LogAttrs(ctx, slog.LevelWarn, "NTP server unhealthy",
+					slog.Any("ip", b.IP), slog.Int("port", b.Port), slog.Any("error", err))
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// StartHealthChecks periodically probes the servers of the pool until ctx is
+// done. The default interval is used if interval is not positive.
+func (p *NTPBackendPool) StartHealthChecks(ctx context.Context, log *slog.Logger, interval time.Duration) {
+	if p == nil {
+		return
+	}
+	if interval <= 0 {
+		interval = defaultNTPBackendCheckInterval
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			p.check(ctx, log)
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// pick returns the next healthy server of the pool.
+func (p *NTPBackendPool) pick() (net.IP, int, error) {
+	n := uint32(len(p.backends))
+	i := p.next.Add(1)
+	for j := range n {
+		b := p.backends[(i+j)%n]
+		if b.healthy.Load() {
+			return b.IP, b.Port, nil
+		}
+	}
+	return nil, 0, errNoNTPBackend
+}
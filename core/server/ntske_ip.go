@@ -8,6 +8,7 @@ import (
 	"net"
 	"os"
 	"strconv"
+	"time"
 
 	"example.com/scion-time/net/ntske"
 )
@@ -31,15 +32,73 @@ func writeNTSKEErrorMsgTLS(ctx context.Context, log *slog.Logger, conn *tls.Conn
 	}
 }
 
-func handleKeyExchangeTLS(ctx context.Context, log *slog.Logger, conn *tls.Conn, localPort int, provider *ntske.Provider) {
+func handleKeyExchangeTLS(ctx context.Context, log *slog.Logger, conn *tls.Conn, localPort int,
+	provider *ntske.Provider, acl *ACL, limits *ntskeLimits, backends *NTPBackendPool) {
 	defer func() { _ = conn.Close() }()
+	mtrcs := ntskeServerMtrcs.Load()
+	startTime := time.Now()
+
+	hctx, cancel := context.WithTimeout(ctx, limits.handshakeTimeout)
+	err := conn.HandshakeContext(hctx)
+	cancel()
+	if err != nil {
+		log.LogAttrs(ctx, slog.LevelDebug, "failed to perform handshake",
+			slog.Any("remote", conn.RemoteAddr()), slog.Any("error", err))
+		mtrcs.handshakeErrors.Inc()
+		return
+	}
+	mtrcs.handshakes.Inc()
+	mtrcs.handshakeDuration.Observe(time.Since(startTime).Seconds())
+
+	ok := false
+	defer func() {
+		if ok {
+			mtrcs.keyExchanges.Inc()
+			mtrcs.keyExchangeDuration.Observe(time.Since(startTime).Seconds())
+		} else {
+			mtrcs.keyExchangeErrors.Inc()
+		}
+	}()
+
+	err = conn.SetWriteDeadline(time.Now().Add(limits.readTimeout))
+	if err != nil {
+		log.LogAttrs(ctx, slog.LevelInfo, "failed to set deadline", slog.Any("error", err))
+		return
+	}
+
+	identity, err := ntskeIdentity(conn.ConnectionState())
+	if err != nil {
+		log.LogAttrs(ctx, slog.LevelInfo, "failed to identify client", slog.Any("error", err))
+		writeNTSKEErrorMsgTLS(ctx, log, conn, ntske.ErrorCodeBadRequest)
+		return
+	}
+
+	remoteAddr := conn.RemoteAddr().(*net.TCPAddr).AddrPort().Addr()
+	allowed, kissCode := acl.check(aclClient{addr: remoteAddr, nts: true, identity: identity})
+	if !allowed {
+		log.LogAttrs(ctx, slog.LevelDebug, "denied key exchange", slog.Any("from", remoteAddr))
+		if kissCode != 0 {
+			writeNTSKEErrorMsgTLS(ctx, log, conn, ntske.ErrorCodeBadRequest)
+		}
+		return
+	}
 
-	var err error
 	var data ntske.Data
+	rctx, cancel := context.WithTimeout(ctx, limits.readTimeout)
 	reader := bufio.NewReader(conn)
-	err = ntske.ReadData(ctx, log, reader, &data)
+	err = ntske.ReadData(rctx, log, conn, reader, &data)
+	cancel()
 	if err != nil {
 		log.LogAttrs(ctx, slog.LevelInfo, "failed to read key exchange", slog.Any("error", err))
+		if code, ok := ntskeErrorCode(err); ok {
+			writeNTSKEErrorMsgTLS(ctx, log, conn, code)
+		}
+		return
+	}
+
+	err = negotiateAlgo(&data)
+	if err != nil {
+		log.LogAttrs(ctx, slog.LevelInfo, "failed to negotiate AEAD algorithm", slog.Any("error", err))
 		writeNTSKEErrorMsgTLS(ctx, log, conn, ntske.ErrorCodeBadRequest)
 		return
 	}
@@ -51,9 +110,14 @@ func handleKeyExchangeTLS(ctx context.Context, log *slog.Logger, conn *tls.Conn,
 		return
 	}
 
-	localIP := conn.LocalAddr().(*net.TCPAddr).IP
+	ntpIP, ntpPort, err := ntpServerAddr(backends, conn.LocalAddr().(*net.TCPAddr).IP, localPort)
+	if err != nil {
+		log.LogAttrs(ctx, slog.LevelWarn, "failed to select NTP server", slog.Any("error", err))
+		writeNTSKEErrorMsgTLS(ctx, log, conn, ntske.ErrorCodeInternalServer)
+		return
+	}
 
-	msg, err := newNTSKEMsg(ctx, log, localIP, localPort, &data, provider)
+	msg, err := newNTSKEMsg(ctx, log, ntpIP, ntpPort, &data, identity, provider)
 	if err != nil {
 		log.LogAttrs(ctx, slog.LevelInfo, "failed to create packet", slog.Any("error", err))
 		writeNTSKEErrorMsgTLS(ctx, log, conn, ntske.ErrorCodeInternalServer)
@@ -72,10 +136,12 @@ func handleKeyExchangeTLS(ctx context.Context, log *slog.Logger, conn *tls.Conn,
 		log.LogAttrs(ctx, slog.LevelInfo, "failed to write response", slog.Any("error", err))
 		return
 	}
+	ok = true
 }
 
 func runNTSKEServerTLS(ctx context.Context, log *slog.Logger,
-	listener net.Listener, localPort int, provider *ntske.Provider) {
+	listener net.Listener, localPort int, provider *ntske.Provider, acl *ACL,
+	limits *ntskeLimits, backends *NTPBackendPool) {
 	defer func() { _ = listener.Close() }()
 	for {
 		conn, err := ntske.AcceptTLSConn(listener)
@@ -83,11 +149,21 @@ func runNTSKEServerTLS(ctx context.Context, log *slog.Logger,
 			log.LogAttrs(ctx, slog.LevelInfo, "failed to accept client", slog.Any("error", err))
 			continue
 		}
-		go handleKeyExchangeTLS(ctx, log, conn, localPort, provider)
+		remoteAddr := conn.RemoteAddr().(*net.TCPAddr).AddrPort().Addr()
+		if !limits.admit(limits.limiter.ipClientKey(remoteAddr)) {
+			log.LogAttrs(ctx, slog.LevelDebug, "rejected connection", slog.Any("from", remoteAddr))
+			_ = conn.NetConn().Close()
+			continue
+		}
+		go func() {
+			defer limits.release()
+			handleKeyExchangeTLS(ctx, log, conn, localPort, provider, acl, limits, backends)
+		}()
 	}
 }
 
-func StartNTSKEServerIP(ctx context.Context, log *slog.Logger, localIP net.IP, localPort int, config *tls.Config, provider *ntske.Provider) {
+func StartNTSKEServerIP(ctx context.Context, log *slog.Logger, localIP net.IP, localPort int,
+	config *tls.Config, provider *ntske.Provider, acl *ACL, cfg NTSKEServerConfig) {
 	ntskeAddr := net.JoinHostPort(localIP.String(), strconv.Itoa(ntske.ServerPortIP))
 	log.LogAttrs(ctx, slog.LevelInfo,
 		"server listening via IP",
@@ -101,5 +177,5 @@ func StartNTSKEServerIP(ctx context.Context, log *slog.Logger, localIP net.IP, l
 		os.Exit(1)
 	}
 
-	go runNTSKEServerTLS(ctx, log, listener, localPort, provider)
+	go runNTSKEServerTLS(ctx, log, listener, localPort, provider, acl, newNTSKELimits(cfg), cfg.Backends)
 }
@@ -6,7 +6,9 @@ import (
 	"crypto/tls"
 	"errors"
 	"log/slog"
+	"net/netip"
 	"os"
+	"time"
 
 	"github.com/quic-go/quic-go"
 
@@ -35,18 +37,70 @@ func writeNTSKEErrorMsgQUIC(ctx context.Context, log *slog.Logger, stream *quic.
 }
 
 func handleKeyExchangeQUIC(ctx context.Context, log *slog.Logger,
-	conn *quic.Conn, localPort int, provider *ntske.Provider) error {
-	stream, err := conn.AcceptStream(context.Background())
+	conn *quic.Conn, localPort int, provider *ntske.Provider, acl *ACL,
+	limits *ntskeLimits, backends *NTPBackendPool) error {
+	mtrcs := ntskeServerMtrcs.Load()
+	startTime := time.Now()
+	ok := false
+	defer func() {
+		if ok {
+			mtrcs.keyExchanges.Inc()
+			mtrcs.keyExchangeDuration.Observe(time.Since(startTime).Seconds())
+		} else {
+			mtrcs.keyExchangeErrors.Inc()
+		}
+	}()
+
+	rctx, cancel := context.WithTimeout(ctx, limits.readTimeout)
+	defer cancel()
+	stream, err := conn.AcceptStream(rctx)
 	if err != nil {
 		return err
 	}
 	defer func() { _ = stream.Close() }()
 
+	err = stream.SetWriteDeadline(time.Now().Add(limits.readTimeout))
+	if err != nil {
+		return err
+	}
+
+	identity, err := ntskeIdentity(conn.ConnectionState().TLS)
+	if err != nil {
+		log.LogAttrs(ctx, slog.LevelInfo, "failed to identify client", slog.Any("error", err))
+		writeNTSKEErrorMsgQUIC(ctx, log, stream, ntske.ErrorCodeBadRequest)
+		return err
+	}
+
+	remoteAddr, remoteIP := quicRemoteAddr(conn)
+	allowed, kissCode := acl.check(aclClient{
+		scion:    true,
+		ia:       remoteAddr.IA,
+		addr:     remoteIP,
+		nts:      true,
+		identity: identity,
+	})
+	if !allowed {
+		log.LogAttrs(ctx, slog.LevelDebug, "denied key exchange", slog.Any("from", remoteAddr))
+		if kissCode != 0 {
+			writeNTSKEErrorMsgQUIC(ctx, log, stream, ntske.ErrorCodeBadRequest)
+		}
+		return nil
+	}
+
 	var data ntske.Data
 	reader := bufio.NewReader(stream)
-	err = ntske.ReadData(ctx, log, reader, &data)
+	err = ntske.ReadData(rctx, log, stream, reader, &data)
 	if err != nil {
 		log.LogAttrs(ctx, slog.LevelInfo, "failed to read key exchange", slog.Any("error", err))
+		if code, ok := ntskeErrorCode(err); ok {
+			writeNTSKEErrorMsgQUIC(ctx, log, stream, code)
+		}
+		return err
+	}
+
+	err = negotiateAlgo(&data)
+	if err != nil {
+		log.LogAttrs(ctx, slog.LevelInfo, "failed to negotiate AEAD algorithm", slog.Any("error", err))
 		writeNTSKEErrorMsgQUIC(ctx, log, stream, ntske.ErrorCodeBadRequest)
 		return err
 	}
@@ -58,9 +112,14 @@ func handleKeyExchangeQUIC(ctx context.Context, log *slog.Logger,
 		return err
 	}
 
-	localIP := conn.LocalAddr().(udp.UDPAddr).Host.IP
+	ntpIP, ntpPort, err := ntpServerAddr(backends, conn.LocalAddr().(udp.UDPAddr).Host.IP, localPort)
+	if err != nil {
+		log.LogAttrs(ctx, slog.LevelWarn, "failed to select NTP server", slog.Any("error", err))
+		writeNTSKEErrorMsgQUIC(ctx, log, stream, ntske.ErrorCodeInternalServer)
+		return err
+	}
 
-	msg, err := newNTSKEMsg(ctx, log, localIP, localPort, &data, provider)
+	msg, err := newNTSKEMsg(ctx, log, ntpIP, ntpPort, &data, identity, provider)
 	if err != nil {
 		log.LogAttrs(ctx, slog.LevelInfo, "failed to create packet", slog.Any("error", err))
 		writeNTSKEErrorMsgQUIC(ctx, log, stream, ntske.ErrorCodeInternalServer)
@@ -80,11 +139,25 @@ func handleKeyExchangeQUIC(ctx context.Context, log *slog.Logger,
 		return err
 	}
 
+	ok = true
 	return nil
 }
 
+func quicRemoteAddr(conn *quic.Conn) (udp.UDPAddr, netip.Addr) {
+	remoteAddr, ok := scion.UDPAddrOf(conn.RemoteAddr())
+	if !ok {
+		panic("unexpected remote address type")
+	}
+	remoteIP, ok := netip.AddrFromSlice(remoteAddr.Host.IP)
+	if !ok {
+		panic("unexpected IP address byte slice")
+	}
+	return remoteAddr, remoteIP
+}
+
 func runNTSKEServerQUIC(ctx context.Context, log *slog.Logger,
-	listener *scion.QUICListener, localPort int, provider *ntske.Provider) {
+	listener *scion.QUICListener, localPort int, provider *ntske.Provider, acl *ACL,
+	limits *ntskeLimits, backends *NTPBackendPool) {
 	defer func() { _ = listener.Close() }()
 	for {
 		conn, err := listener.Accept(ctx)
@@ -92,9 +165,20 @@ func runNTSKEServerQUIC(ctx context.Context, log *slog.Logger,
 			log.LogAttrs(ctx, slog.LevelInfo, "failed to accept connection", slog.Any("error", err))
 			continue
 		}
+		// The QUIC handshake is complete once a connection is accepted, its
+		// duration is bounded by the handshake idle timeout of the listener.
+		ntskeServerMtrcs.Load().handshakes.Inc()
+
+		remoteAddr, remoteIP := quicRemoteAddr(conn)
+		if !limits.admit(limits.limiter.scionClientKey(remoteAddr.IA, remoteIP)) {
+			log.LogAttrs(ctx, slog.LevelDebug, "rejected connection", slog.Any("from", remoteAddr))
+			_ = conn.CloseWithError(quic.ApplicationErrorCode(0), "")
+			continue
+		}
 
 		go func() {
-			err := handleKeyExchangeQUIC(ctx, log, conn, localPort, provider)
+			defer limits.release()
+			err := handleKeyExchangeQUIC(ctx, log, conn, localPort, provider, acl, limits, backends)
 			var errApplication *quic.ApplicationError
 			if err != nil && !(errors.As(err, &errApplication) && errApplication.ErrorCode == 0) {
 				log.Info("failed to handle connection",
@@ -106,7 +190,8 @@ func runNTSKEServerQUIC(ctx context.Context, log *slog.Logger,
 	}
 }
 
-func StartNTSKEServerSCION(ctx context.Context, log *slog.Logger, localAddr udp.UDPAddr, config *tls.Config, provider *ntske.Provider) {
+func StartNTSKEServerSCION(ctx context.Context, log *slog.Logger, localAddr udp.UDPAddr,
+	config *tls.Config, provider *ntske.Provider, acl *ACL, cfg NTSKEServerConfig) {
 	log.LogAttrs(ctx, slog.LevelInfo,
 		"server listening via SCION",
 		slog.Any("ip", localAddr.Host.IP),
@@ -116,11 +201,14 @@ func StartNTSKEServerSCION(ctx context.Context, log *slog.Logger, localAddr udp.
 	localPort := localAddr.Host.Port
 	localAddr.Host.Port = ntske.ServerPortSCION
 
-	listener, err := scion.ListenQUIC(ctx, localAddr, config, nil /* quicCfg */)
+	limits := newNTSKELimits(cfg)
+	listener, err := scion.ListenQUIC(ctx, localAddr, config, &quic.Config{
+		HandshakeIdleTimeout: limits.handshakeTimeout,
+	})
 	if err != nil {
 		log.LogAttrs(ctx, slog.LevelError, "failed to create QUIC listener")
 		os.Exit(1)
 	}
 
-	go runNTSKEServerQUIC(ctx, log, listener, localPort, provider)
+	go runNTSKEServerQUIC(ctx, log, listener, localPort, provider, acl, limits, cfg.Backends)
 }
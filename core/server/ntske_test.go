@@ -0,0 +1,268 @@
+package server_test
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"example.com/scion-time/core/server"
+
+	"example.com/scion-time/net/ntp"
+	"example.com/scion-time/net/ntske"
+)
+
+func TestNTSKEServerLimits(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	log := slog.New(slog.DiscardHandler)
+
+	serverIP := net.IPv4(127, 0, 0, 11).To4()
+	serverAddr := net.JoinHostPort(serverIP.String(), strconv.Itoa(ntske.ServerPortIP))
+	cert := newTestCertificate(t, serverIP)
+	server.StartNTSKEServerIP(ctx, log, serverIP, ntp.ServerPortIP, &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		NextProtos:   []string{"ntske/1"},
+		MinVersion:   tls.VersionTLS13,
+	}, ntske.NewProvider(), nil /* ACL */, server.NTSKEServerConfig{
+		MaxHandshakes:    1,
+		HandshakeTimeout: 500 * time.Millisecond,
+		ReadTimeout:      500 * time.Millisecond,
+	})
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(leaf)
+	clientConfig := &tls.Config{
+		ServerName: serverIP.String(),
+		RootCAs:    roots,
+		NextProtos: []string{"ntske/1"},
+		MinVersion: tls.VersionTLS13,
+	}
+
+	// An idle client occupies the only handshake slot until the handshake
+	// times out, other clients are rejected in the meantime.
+	idle, err := net.Dial("tcp", serverAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = idle.Close() }()
+	conn, err := tls.Dial("tcp", serverAddr, clientConfig)
+	if err == nil {
+		_ = conn.Close()
+		t.Error("handshake succeeded beyond the concurrency limit")
+	}
+	_ = idle.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err = idle.Read(make([]byte, 1))
+	if err != io.EOF {
+		t.Fatalf("idle connection not closed after handshake timeout: %v", err)
+	}
+
+	// Requests with unrecognized critical records are answered with the
+	// corresponding error record. The slot of the idle client is released
+	// shortly after its connection is closed.
+	for range 10 {
+		conn, err = tls.Dial("tcp", serverAddr, clientConfig)
+		if err == nil {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = conn.Close() }()
+	_, err = conn.Write([]byte{
+		0x80, 0x01, 0x00, 0x02, 0x00, 0x00, // Next Protocol Negotiation: NTPv4
+		0xc0, 0x00, 0x00, 0x00, // unknown critical record
+		0x80, 0x00, 0x00, 0x00, // End of Message
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var resp struct {
+		Type, BodyLen, Code uint16
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	err = binary.Read(conn, binary.BigEndian, &resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Type&^(1<<15) != ntske.RecError || resp.Code != ntske.ErrorCodeUnrecognizedCritical {
+		t.Errorf("response record type %d, code %d; want type %d, code %d",
+			resp.Type&^(1<<15), resp.Code, ntske.RecError, ntske.ErrorCodeUnrecognizedCritical)
+	}
+}
+
+func TestNTSKEServerBackends(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	log := slog.New(slog.DiscardHandler)
+
+	var probesA, probesB atomic.Int32
+	var failA atomic.Bool
+	backendA := server.NTPBackend{
+		IP:   net.IPv4(127, 0, 0, 13).To4(),
+		Port: 10123,
+		Probe: func(ctx context.Context) error {
+			probesA.Add(1)
+			if failA.Load() {
+				return errors.New("probe failed")
+			}
+			return nil
+		},
+	}
+	backendB := server.NTPBackend{
+		IP:   net.IPv4(127, 0, 0, 14).To4(),
+		Port: 10123,
+		Probe: func(ctx context.Context) error {
+			probesB.Add(1)
+			return errors.New("probe failed")
+		},
+	}
+	backends := server.NewNTPBackendPool([]server.NTPBackend{backendA, backendB})
+	backends.StartHealthChecks(ctx, log, 10*time.Millisecond)
+
+	serverIP := net.IPv4(127, 0, 0, 12).To4()
+	cert := newTestCertificate(t, serverIP)
+	server.StartNTSKEServerIP(ctx, log, serverIP, ntp.ServerPortIP, &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		NextProtos:   []string{"ntske/1"},
+		MinVersion:   tls.VersionTLS13,
+	}, ntske.NewProvider(), nil /* ACL */, server.NTSKEServerConfig{Backends: backends})
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(leaf)
+
+	f := &ntske.Fetcher{Log: log}
+	f.TLSConfig = tls.Config{
+		ServerName: serverIP.String(),
+		RootCAs:    roots,
+		MinVersion: tls.VersionTLS13,
+	}
+	f.Port = strconv.Itoa(ntske.ServerPortIP)
+
+	waitForProbes := func(probes *atomic.Int32) {
+		// Health checks are complete once the next round has started.
+		n := probes.Load() + 3
+		for probes.Load() < n {
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	// Unhealthy backends are not advertised.
+	waitForProbes(&probesB)
+	for range 4 {
+		err := f.Rekey(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := f.FetchData(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if data.Server != backendA.IP.String() || int(data.Port) != backendA.Port {
+			t.Errorf("advertised NTP server %s:%d; want %s:%d",
+				data.Server, data.Port, backendA.IP, backendA.Port)
+		}
+	}
+
+	// Key exchanges fail if no backend is healthy.
+	failA.Store(true)
+	waitForProbes(&probesA)
+	err = f.Rekey(ctx)
+	if err == nil {
+		t.Error("key exchange succeeded without healthy NTP server")
+	}
+}
+
+func TestNTSKEServerIdentity(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	log := slog.New(slog.DiscardHandler)
+
+	dir := t.TempDir()
+	const identity = "node1.fleet.example"
+	certFile, keyFile := filepath.Join(dir, "client.crt"), filepath.Join(dir, "client.key")
+	clientDER := writeTestKeyPair(t, certFile, keyFile, identity, time.Now().Add(time.Hour))
+	clientCert, err := x509.ParseCertificate(clientDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(clientCert)
+
+	serverIP := net.IPv4(127, 0, 0, 15).To4()
+	cert := newTestCertificate(t, serverIP)
+	provider := ntske.NewProvider()
+	server.StartNTSKEServerIP(ctx, log, serverIP, ntp.ServerPortIP, &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		NextProtos:   []string{"ntske/1"},
+		MinVersion:   tls.VersionTLS13,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    clientCAs,
+	}, provider, nil /* ACL */, server.NTSKEServerConfig{})
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(leaf)
+
+	newFetcher := func() *ntske.Fetcher {
+		f := &ntske.Fetcher{Log: log}
+		f.TLSConfig = tls.Config{
+			ServerName: serverIP.String(),
+			RootCAs:    roots,
+			MinVersion: tls.VersionTLS13,
+		}
+		f.Port = strconv.Itoa(ntske.ServerPortIP)
+		return f
+	}
+
+	f := newFetcher()
+	if err := f.Rekey(ctx); err == nil {
+		t.Error("key exchange succeeded without client certificate")
+	}
+
+	// The identity of authenticated clients is embedded in their cookies.
+	f = newFetcher()
+	f.ClientCertFile, f.ClientKeyFile = certFile, keyFile
+	data, err := f.FetchData(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var encryptedCookie ntske.EncryptedServerCookie
+	err = encryptedCookie.Decode(data.Cookie[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, ok := provider.Get(int(encryptedCookie.ID))
+	if !ok {
+		t.Fatal("cookie key not found")
+	}
+	cookie, err := encryptedCookie.Decrypt(key.Value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cookie.Identity != identity {
+		t.Errorf("cookie identity = %q; want %q", cookie.Identity, identity)
+	}
+}
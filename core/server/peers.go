@@ -0,0 +1,223 @@
+package server
+
+// See RFC 5905, Section 9 and Section 11, symmetric active/passive mode
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"net/netip"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/scionproto/scion/pkg/addr"
+
+	"example.com/scion-time/net/ntp"
+)
+
+var (
+	errUnknownPeer     = errors.New("unknown peer")
+	errUnexpectedMAC   = errors.New("unexpected MAC")
+	errMissingMAC      = errors.New("missing MAC")
+	errMissingPeerKey  = errors.New("missing peer key")
+	errUnsupportedPeer = errors.New("symmetric mode not supported")
+	errDuplicatePacket = errors.New("duplicate packet")
+)
+
+// Peer is a configured symmetric mode peer. Peers reachable via IP have the
+// zero IA. If KeyID is not 0, requests of the peer must be authenticated by a
+// MAC computed with the symmetric key KeyID.
+type Peer struct {
+	IA    addr.IA
+	Addr  netip.Addr
+	KeyID uint32
+}
+
+// PeerAssociation is a snapshot of the association of the server with a
+// symmetric mode peer.
+//
+// Org, Rec and Xmt are the peer variables of RFC 5905, Section 9.1: the
+// transmit timestamp of the most recent request of the peer, its receive
+// timestamp and the transmit timestamp of the response. Offset and Delay are
+// the clock offset of the peer relative to the local clock and the round-trip
+// delay measured by the server from the timestamps of the peer, in basic as
+// well as interleaved mode. They are informational: the local clock is
+// synchronized to a peer only by the measurements of its own symmetric active
+// association with the peer.
+type PeerAssociation struct {
+	Peer
+	// LastRx is the receive time of the most recent request of the peer.
+	LastRx  time.Time
+	Leap    uint8
+	Stratum uint8
+	Poll    int8
+	Org     ntp.Time64
+	Rec     ntp.Time64
+	Xmt     ntp.Time64
+	// Measured is the receive time of the request from which Offset and Delay
+	// were derived, the zero value if there is none.
+	Measured time.Time
+	Offset   time.Duration
+	Delay    time.Duration
+	// Packets is the number of valid requests received from the peer,
+	// including denied and rate limited ones, Authenticated the number of those
+	// authenticated by a MAC, NTS or SPAO.
+	Packets       uint64
+	Authenticated uint64
+}
+
+type peerKey struct {
+	ia   addr.IA
+	addr netip.Addr
+}
+
+// Peers holds the configured symmetric mode peers of a server and the state of
+// their associations. A nil *Peers does not answer any symmetric mode
+// requests.
+type Peers struct {
+	keys   map[uint32]ntp.SymmetricKey
+	mu     sync.Mutex
+	assocs map[peerKey]*PeerAssociation
+}
+
+// NewPeers returns the peer associations of peers with MACs based on keys,
+// which must contain the keys of all peers with a KeyID other than 0.
+func NewPeers(peers []Peer, keys map[uint32]ntp.SymmetricKey) (*Peers, error) {
+	if len(peers) == 0 {
+		return nil, nil
+	}
+	p := &Peers{
+		keys:   maps.Clone(keys),
+		assocs: make(map[peerKey]*PeerAssociation, len(peers)),
+	}
+	for _, peer := range peers {
+		if peer.KeyID != 0 {
+			if _, ok := keys[peer.KeyID]; !ok {
+				return nil, fmt.Errorf("%w %d of peer %v", errMissingPeerKey, peer.KeyID, peer.Addr)
+			}
+		}
+		p.assocs[peerKey{ia: peer.IA, addr: peer.Addr.Unmap()}] = &PeerAssociation{Peer: peer}
+	}
+	return p, nil
+}
+
+// accept checks the symmetric mode request req in b of the peer at ia, a
+// received at rxt and updates the association with the peer accordingly. It
+// returns the key with which the response is to be authenticated, if any.
+// Duplicates of the most recent request are rejected.
+func (p *Peers) accept(ia addr.IA, a netip.Addr, req *ntp.Packet, b []byte, nts bool, rxt time.Time) (
+	ntp.SymmetricKey, error) {
+	if p == nil {
+		return ntp.SymmetricKey{}, errUnsupportedPeer
+	}
+	assoc, ok := p.assocs[peerKey{ia: ia, addr: a.Unmap()}]
+	if !ok {
+		return ntp.SymmetricKey{}, errUnknownPeer
+	}
+	var key ntp.SymmetricKey
+	if ntp.HasMAC(b) {
+		if assoc.KeyID == 0 || ntp.MACKeyID(b) != assoc.KeyID {
+			return ntp.SymmetricKey{}, errUnexpectedMAC
+		}
+		key, ok = p.keys[assoc.KeyID]
+		if !ok {
+			return ntp.SymmetricKey{}, errMissingPeerKey
+		}
+		err := ntp.VerifyMAC(b, key)
+		if err != nil {
+			return ntp.SymmetricKey{}, err
+		}
+	} else if assoc.KeyID != 0 {
+		return ntp.SymmetricKey{}, errMissingMAC
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if req.TransmitTime == assoc.Org {
+		return ntp.SymmetricKey{}, errDuplicatePacket
+	}
+	assoc.Org = req.TransmitTime
+	assoc.LastRx = rxt
+	assoc.Leap = req.LeapIndicator()
+	assoc.Stratum = req.Stratum
+	assoc.Poll = req.Poll
+	assoc.Packets++
+	if key.ID != 0 || nts {
+		assoc.Authenticated++
+	}
+	return key, nil
+}
+
+// exchanged updates the association with the peer at ia, a after the response
+// resp to its request req received at rxt has been prepared. If the request
+// refers to the previous response, the offset and delay of the peer are
+// derived from the timestamps of both exchanges, see RFC 5905, Section 8: in
+// basic mode, the origin timestamp of req is the transmit timestamp of the
+// previous response, in interleaved mode the receive timestamp of the previous
+// request, whose transmit timestamp is the transmit timestamp of req.
+func (p *Peers) exchanged(ia addr.IA, a netip.Addr, req, resp *ntp.Packet, rxt time.Time) {
+	if p == nil {
+		return
+	}
+	assoc, ok := p.assocs[peerKey{ia: ia, addr: a.Unmap()}]
+	if !ok {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var t0, t1, t2, t3 time.Time
+	measured := false
+	if resp.OriginTime != req.TransmitTime {
+		// interleaved mode
+		if req.OriginTime == assoc.Rec && assoc.Rec != (ntp.Time64{}) {
+			t0 = ntp.TimeFromTime64(resp.TransmitTime, rxt)
+			t1 = ntp.TimeFromTime64(req.ReceiveTime, rxt)
+			t2 = ntp.TimeFromTime64(req.TransmitTime, rxt)
+			t3 = ntp.TimeFromTime64(req.OriginTime, rxt)
+			measured = true
+		}
+	} else if req.OriginTime == assoc.Xmt && assoc.Xmt != (ntp.Time64{}) {
+		// basic mode
+		t0 = ntp.TimeFromTime64(assoc.Xmt, rxt)
+		t1 = ntp.TimeFromTime64(req.ReceiveTime, rxt)
+		t2 = ntp.TimeFromTime64(req.TransmitTime, rxt)
+		t3 = rxt
+		measured = true
+	}
+	if req.LeapIndicator() == ntp.LeapIndicatorUnknown {
+		// Measurements of unsynchronized peers are meaningless.
+		measured = false
+	}
+	if delay := ntp.RoundTripDelay(t0, t1, t2, t3); measured && delay >= 0 {
+		assoc.Measured = rxt
+		assoc.Offset = ntp.ClockOffset(t0, t1, t2, t3)
+		assoc.Delay = delay
+	}
+	assoc.Rec = ntp.Time64FromTime(rxt)
+	assoc.Xmt = resp.TransmitTime
+}
+
+// Associations returns a snapshot of the peer associations ordered by peer.
+func (p *Peers) Associations() []PeerAssociation {
+	if p == nil {
+		return nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	assocs := make([]PeerAssociation, 0, len(p.assocs))
+	for _, assoc := range p.assocs {
+		assocs = append(assocs, *assoc)
+	}
+	slices.SortFunc(assocs, func(x, y PeerAssociation) int {
+		if x.IA != y.IA {
+			if x.IA < y.IA {
+				return -1
+			}
+			return 1
+		}
+		return x.Addr.Compare(y.Addr)
+	})
+	return assocs
+}
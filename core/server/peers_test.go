@@ -0,0 +1,25 @@
+package server_test
+
+import (
+	"net/netip"
+	"testing"
+
+	"example.com/scion-time/core/server"
+
+	"example.com/scion-time/net/ntp"
+)
+
+func TestNewPeers(t *testing.T) {
+	key := ntp.SymmetricKey{ID: 1, Value: []byte("0123456789abcdef")}
+	peers := []server.Peer{
+		{Addr: netip.MustParseAddr("192.0.2.1")},
+		{Addr: netip.MustParseAddr("192.0.2.2"), KeyID: key.ID},
+	}
+	p, err := server.NewPeers(peers, map[uint32]ntp.SymmetricKey{key.ID: key})
+	if err != nil || len(p.Associations()) != len(peers) {
+		t.Errorf("NewPeers() = %v, %v", p, err)
+	}
+	if _, err := server.NewPeers(peers, nil /* keys */); err == nil {
+		t.Error("NewPeers() accepted peer with missing key")
+	}
+}
@@ -0,0 +1,142 @@
+package server_test
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"example.com/scion-time/core/client"
+	"example.com/scion-time/core/server"
+
+	"example.com/scion-time/net/csptp"
+	"example.com/scion-time/net/ptp"
+)
+
+// ptpTestPort replaces the privileged PTP event port, ptpTestPort+1 the PTP
+// general port.
+const ptpTestPort = 31319
+
+func TestPTPIPExchange(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	log := slog.New(slog.DiscardHandler)
+
+	serverIP0 := netip.MustParseAddr("127.0.0.21")
+	serverIP1 := netip.MustParseAddr("127.0.0.22")
+	deniedIP := netip.MustParseAddr("127.0.0.23")
+	clientIP := netip.MustParseAddr("127.0.0.24")
+	spoofedIP := netip.MustParseAddr("127.0.0.35")
+
+	// Both servers are unsynchronized, the server with the lower clock
+	// identity is the better grandmaster.
+	server.StartPTPServerIP(ctx, log, &net.UDPAddr{IP: serverIP0.AsSlice(), Port: ptpTestPort},
+		0 /* DSCP */, nil /* ACL */, nil /* limiter */, server.CSPTPServerConfig{ClockID: 2})
+	server.StartPTPServerIP(ctx, log, &net.UDPAddr{IP: serverIP1.AsSlice(), Port: ptpTestPort},
+		0 /* DSCP */, nil /* ACL */, nil /* limiter */, server.CSPTPServerConfig{ClockID: 1})
+	server.StartPTPServerIP(ctx, log, &net.UDPAddr{IP: deniedIP.AsSlice(), Port: ptpTestPort},
+		0 /* DSCP */, server.NewACL([]server.ACLRule{{
+			Action:   server.ACLDeny,
+			Prefixes: []netip.Prefix{netip.PrefixFrom(clientIP, 32)},
+		}}), nil /* limiter */, server.CSPTPServerConfig{ClockID: 3})
+
+	c := &client.PTPClientIP{Log: log, Port: ptpTestPort}
+	defer func() { _ = c.Close() }()
+
+	t.Run("best server", func(t *testing.T) {
+		for range 3 {
+			mctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+			_, off, err := c.MeasureClockOffset(mctx, clientIP,
+				[]netip.Addr{serverIP0, serverIP1, deniedIP})
+			cancel()
+			if err != nil {
+				t.Fatalf("MeasureClockOffset() failed: %v", err)
+			}
+			if off.Abs() > 100*time.Millisecond {
+				t.Errorf("MeasureClockOffset() = %v; want offset close to 0", off)
+			}
+		}
+		if s := c.Selected(); s != serverIP1 {
+			t.Errorf("Selected() = %v; want %v", s, serverIP1)
+		}
+	})
+
+	t.Run("access denied", func(t *testing.T) {
+		mctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+		defer cancel()
+		_, _, err := c.MeasureClockOffset(mctx, clientIP, []netip.Addr{deniedIP})
+		if err == nil {
+			t.Error("MeasureClockOffset() succeeded with server denying access")
+		}
+	})
+	t.Run("sync credit", func(t *testing.T) {
+		// A client that never sends Delay_Req messages, e.g., the victim of
+		// a spoofed grant request, only receives a bounded number of Sync
+		// messages.
+		event, err := net.ListenUDP("udp", net.UDPAddrFromAddrPort(netip.AddrPortFrom(spoofedIP, ptpTestPort)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer func() { _ = event.Close() }()
+		general, err := net.ListenUDP("udp", net.UDPAddrFromAddrPort(netip.AddrPortFrom(spoofedIP, ptpTestPort+1)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer func() { _ = general.Close() }()
+
+		tlv := ptp.NewUnicastTLV(ptp.TLVTypeRequestUnicastTransmission, csptp.MessageTypeSync)
+		tlv.LogInterMessagePeriod = -4
+		tlv.DurationField = 60
+		n := ptp.SignalingMessageLength + ptp.EncodedUnicastTLVLength(&tlv)
+		b := make([]byte, n)
+		msg := csptp.Message{
+			SdoIDMessageType:   ptp.MessageTypeSignaling,
+			PTPVersion:         csptp.PTPVersion,
+			MessageLength:      uint16(n),
+			DomainNumber:       csptp.DomainNumber,
+			MinorSdoID:         csptp.MinorSdoID,
+			FlagField:          csptp.FlagUnicast,
+			SourcePortIdentity: csptp.PortID{ClockID: 4, Port: 1},
+			LogMessageInterval: csptp.LogMessageInterval,
+		}
+		csptp.EncodeMessage(b[:csptp.MinMessageLength], &msg)
+		ptp.EncodePortID(b[34:ptp.SignalingMessageLength], ptp.AllPorts)
+		ptp.EncodeUnicastTLV(b[ptp.SignalingMessageLength:], &tlv)
+		_, err = general.WriteToUDPAddrPort(b, netip.AddrPortFrom(serverIP0, ptpTestPort+1))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer func() {
+			tlv := ptp.NewUnicastTLV(ptp.TLVTypeCancelUnicastTransmission, csptp.MessageTypeSync)
+			ptp.EncodeUnicastTLV(b[ptp.SignalingMessageLength:], &tlv)
+			_, _ = general.WriteToUDPAddrPort(b[:ptp.SignalingMessageLength+ptp.EncodedUnicastTLVLength(&tlv)],
+				netip.AddrPortFrom(serverIP0, ptpTestPort+1))
+		}()
+
+		// At the granted interval of 1/16 s, 16 Sync messages would be
+		// sent within a second.
+		var syncs int
+		buf := make([]byte, ptp.MaxMessageLength)
+		deadline := time.Now().Add(1 * time.Second)
+		for {
+			err = event.SetReadDeadline(deadline)
+			if err != nil {
+				t.Fatal(err)
+			}
+			n, err := event.Read(buf)
+			if err != nil {
+				break
+			}
+			var msg csptp.Message
+			if csptp.DecodeMessage(&msg, buf[:n]) == nil && msg.SdoIDMessageType == csptp.MessageTypeSync {
+				syncs++
+			}
+		}
+		if syncs == 0 || syncs > 4 {
+			t.Errorf("received %d Sync messages; want between 1 and 4", syncs)
+		}
+	})
+}
@@ -0,0 +1,176 @@
+package server
+
+import (
+	"container/list"
+	"math"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/scionproto/scion/pkg/addr"
+)
+
+const (
+	rateLimitClientsCap = 1 << 16
+
+	// Rate limited clients receive at most one KoD RATE response per
+	// rateLimitKoDInterval, all other requests beyond the limit are dropped.
+	rateLimitKoDInterval = 1 * time.Second
+
+	defaultRateLimitBurst         = 8
+	defaultRateLimitIPv4PrefixLen = 32
+	defaultRateLimitIPv6PrefixLen = 64
+)
+
+type rateLimitResult int
+
+const (
+	rateLimitAccept rateLimitResult = iota
+	// rateLimitAcceptBasic indicates that the request is within the limit but
+	// the client has recently exceeded it and must be served in basic mode.
+	rateLimitAcceptBasic
+	rateLimitKoD
+	rateLimitDrop
+)
+
+// RateLimitConfig configures per-client token bucket rate limiting. IP clients
+// are identified by their address prefix, SCION clients by their ISD-AS and
+// host address prefix. Requests are limited by address before they are
+// authenticated. NTS clients authenticated by a certificate during the key
+// exchange are additionally limited by their identity.
+type RateLimitConfig struct {
+	// Rate is the sustained number of requests per second allowed per client.
+	// Rate limiting is disabled if Rate is not positive.
+	Rate          float64
+	Burst         int
+	IPv4PrefixLen int
+	IPv6PrefixLen int
+}
+
+type rateLimitItem struct {
+	key       string
+	tokens    float64
+	updatedAt time.Time
+	limitedAt time.Time
+	kodAt     time.Time
+}
+
+// RateLimiter keeps track of the request rates of a bounded number of clients.
+// Least recently seen clients are evicted first.
+type RateLimiter struct {
+	cfg     RateLimitConfig
+	mu      sync.Mutex
+	clients map[string]*list.Element
+	lru     *list.List
+}
+
+// NewRateLimiter returns a rate limiter configured by cfg or nil if rate
+// limiting is disabled. A nil *RateLimiter accepts all requests.
+func NewRateLimiter(cfg RateLimitConfig) *RateLimiter {
+	if !(cfg.Rate > 0) {
+		return nil
+	}
+	if cfg.Burst <= 0 {
+		cfg.Burst = defaultRateLimitBurst
+	}
+	if cfg.IPv4PrefixLen <= 0 || cfg.IPv4PrefixLen > 32 {
+		cfg.IPv4PrefixLen = defaultRateLimitIPv4PrefixLen
+	}
+	if cfg.IPv6PrefixLen <= 0 || cfg.IPv6PrefixLen > 128 {
+		cfg.IPv6PrefixLen = defaultRateLimitIPv6PrefixLen
+	}
+	return &RateLimiter{
+		cfg:     cfg,
+		clients: make(map[string]*list.Element),
+		lru:     list.New(),
+	}
+}
+
+func (l *RateLimiter) prefix(a netip.Addr) netip.Prefix {
+	a = a.Unmap()
+	bits := l.cfg.IPv6PrefixLen
+	if a.Is4() {
+		bits = l.cfg.IPv4PrefixLen
+	}
+	p, err := a.Prefix(bits)
+	if err != nil {
+		panic(err)
+	}
+	return p
+}
+
+func (l *RateLimiter) ipClientKey(a netip.Addr) string {
+	if l == nil {
+		return ""
+	}
+	return l.prefix(a).String()
+}
+
+func (l *RateLimiter) scionClientKey(ia addr.IA, a netip.Addr) string {
+	if l == nil {
+		return ""
+	}
+	return ia.String() + "," + l.prefix(a).String()
+}
+
+// identityKey identifies NTS clients authenticated by a certificate during the
+// key exchange by their identity.
+func (l *RateLimiter) identityKey(identity string) string {
+	if l == nil {
+		return ""
+	}
+	return "nts:" + identity
+}
+
+// poll returns the minimum poll exponent compatible with the configured rate.
+func (l *RateLimiter) poll() int8 {
+	p := math.Ceil(math.Log2(1 / l.cfg.Rate))
+	return int8(max(0, min(17, p)))
+}
+
+func (l *RateLimiter) check(key string, now time.Time) rateLimitResult {
+	if l == nil {
+		return rateLimitAccept
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var c *rateLimitItem
+	e, ok := l.clients[key]
+	if ok {
+		c = e.Value.(*rateLimitItem)
+		l.lru.MoveToFront(e)
+		if dt := now.Sub(c.updatedAt); dt > 0 {
+			c.tokens = min(float64(l.cfg.Burst), c.tokens+dt.Seconds()*l.cfg.Rate)
+			c.updatedAt = now
+		}
+	} else {
+		if l.lru.Len() == rateLimitClientsCap {
+			e := l.lru.Back()
+			delete(l.clients, e.Value.(*rateLimitItem).key)
+			l.lru.Remove(e)
+		}
+		c = &rateLimitItem{
+			key:       key,
+			tokens:    float64(l.cfg.Burst),
+			updatedAt: now,
+		}
+		l.clients[key] = l.lru.PushFront(c)
+	}
+
+	if c.tokens < 1 {
+		c.limitedAt = now
+		if now.Sub(c.kodAt) >= rateLimitKoDInterval {
+			c.kodAt = now
+			return rateLimitKoD
+		}
+		return rateLimitDrop
+	}
+	c.tokens--
+	holdoff := time.Duration(float64(l.cfg.Burst) / l.cfg.Rate * float64(time.Second))
+	if !c.limitedAt.IsZero() && now.Sub(c.limitedAt) < holdoff {
+		return rateLimitAcceptBasic
+	}
+	return rateLimitAccept
+}
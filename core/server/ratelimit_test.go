@@ -0,0 +1,75 @@
+package server_test
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+
+	"example.com/scion-time/core/server"
+)
+
+func TestRateLimiterDisabled(t *testing.T) {
+	l := server.NewRateLimiter(server.RateLimitConfig{})
+	if l != nil {
+		t.Fatalf("NewRateLimiter() = %v; want nil", l)
+	}
+	now := time.Now()
+	for i := range 100 {
+		if r := l.Check("client-0", now); r != server.RateLimitAccept {
+			t.Fatalf("request %d: Check() = %d; want %d", i, r, server.RateLimitAccept)
+		}
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	l := server.NewRateLimiter(server.RateLimitConfig{
+		Rate:  1,
+		Burst: 4,
+	})
+	now := time.Now()
+	key := "client-0"
+
+	for i := range 4 {
+		if r := l.Check(key, now); r != server.RateLimitAccept {
+			t.Fatalf("request %d: Check() = %d; want %d", i, r, server.RateLimitAccept)
+		}
+	}
+	if r := l.Check(key, now); r != server.RateLimitKoD {
+		t.Errorf("Check() = %d; want %d", r, server.RateLimitKoD)
+	}
+	if r := l.Check(key, now.Add(100*time.Millisecond)); r != server.RateLimitDrop {
+		t.Errorf("Check() = %d; want %d", r, server.RateLimitDrop)
+	}
+	if r := l.Check("client-1", now); r != server.RateLimitAccept {
+		t.Errorf("Check() of other client = %d; want %d", r, server.RateLimitAccept)
+	}
+
+	// refilled, but recently limited
+	if r := l.Check(key, now.Add(2*time.Second)); r != server.RateLimitAcceptBasic {
+		t.Errorf("Check() = %d; want %d", r, server.RateLimitAcceptBasic)
+	}
+	if r := l.Check(key, now.Add(10*time.Second)); r != server.RateLimitAccept {
+		t.Errorf("Check() = %d; want %d", r, server.RateLimitAccept)
+	}
+}
+
+func TestRateLimiterClientKey(t *testing.T) {
+	l := server.NewRateLimiter(server.RateLimitConfig{
+		Rate:          1,
+		IPv4PrefixLen: 24,
+	})
+	k0 := l.IPClientKey(netip.MustParseAddr("192.0.2.1"))
+	k1 := l.IPClientKey(netip.MustParseAddr("192.0.2.254"))
+	k2 := l.IPClientKey(netip.MustParseAddr("192.0.3.1"))
+	if k0 != k1 {
+		t.Errorf("IPClientKey() = %q, %q; want equal keys", k0, k1)
+	}
+	if k0 == k2 {
+		t.Errorf("IPClientKey() = %q, %q; want different keys", k0, k2)
+	}
+	k3 := l.IPClientKey(netip.MustParseAddr("2001:db8:0:1::1"))
+	k4 := l.IPClientKey(netip.MustParseAddr("2001:db8:0:1::2"))
+	if k3 != k4 {
+		t.Errorf("IPClientKey() = %q, %q; want equal keys", k3, k4)
+	}
+}
@@ -13,6 +13,7 @@ import (
 	"example.com/scion-time/core/timebase"
 
 	"example.com/scion-time/net/ntp"
+	"example.com/scion-time/net/nts"
 )
 
 const (
@@ -26,6 +27,7 @@ type tssItem struct {
 	key string
 	buf [tssItemCap]struct {
 		rxt, txt ntp.Time64
+		cookie   uint64
 	}
 	len  int
 	qval ntp.Time64
@@ -99,7 +101,7 @@ func (q *tssQueue) Pop() any {
 	return tssi
 }
 
-func handleRequest(clientID string, req *ntp.Packet, rxt, txt *time.Time, resp *ntp.Packet) {
+func prepareResponse(req *ntp.Packet, resp *ntp.Packet) {
 	resp.SetVersion(ntp.VersionMax)
 	resp.SetMode(ntp.ModeServer)
 	resp.Stratum = 1
@@ -107,7 +109,86 @@ func handleRequest(clientID string, req *ntp.Packet, rxt, txt *time.Time, resp *
 	resp.Precision = -32
 	resp.RootDispersion = ntp.Time32{Seconds: 0, Fraction: 10}
 	resp.ReferenceID = serverRefID
+}
+
+// handleKoDRequest prepares a Kiss-o'-Death response with the given kiss code.
+// The response carries no timestamps other than the origin timestamp.
+func handleKoDRequest(req *ntp.Packet, kissCode uint32, poll int8, resp *ntp.Packet) {
+	resp.SetLeapIndicator(ntp.LeapIndicatorUnknown)
+	resp.SetVersion(ntp.VersionMax)
+	resp.SetMode(ntp.ModeServer)
+	resp.Stratum = 0
+	resp.Poll = max(req.Poll, poll)
+	resp.Precision = -32
+	resp.ReferenceID = kissCode
+	resp.OriginTime = req.TransmitTime
+}
+
+// handleBasicRequest prepares a basic mode response without maintaining
+// interleaved mode state for the client.
+func handleBasicRequest(req *ntp.Packet, rxt, txt *time.Time, resp *ntp.Packet) {
+	prepareResponse(req, resp)
 
+	*txt = timebase.Now()
+	if !rxt.Before(*txt) {
+		*txt = rxt.Add(1)
+	}
+
+	resp.ReferenceTime = ntp.Time64FromTime(*txt)
+	indicateNTPv5(req, resp)
+	resp.ReceiveTime = ntp.Time64FromTime(*rxt)
+	resp.OriginTime = req.TransmitTime
+	resp.TransmitTime = ntp.Time64FromTime(*txt)
+}
+
+// indicateNTPv5 echoes the reference timestamp of an NTPv4 request by which
+// the client indicates support for NTPv5, see ntp.NegotiationReferenceTime.
+func indicateNTPv5(req *ntp.Packet, resp *ntp.Packet) {
+	if req.ReferenceTime == ntp.NegotiationReferenceTime {
+		resp.ReferenceTime = ntp.NegotiationReferenceTime
+	}
+}
+
+func handleRequest(clientID string, req *ntp.Packet, rxt, txt *time.Time, resp *ntp.Packet) {
+	prepareResponse(req, resp)
+
+	rxt64, txt64, prevTxt, ok := storeTimestamps(clientID, req.OriginTime, 0 /* cookie */, rxt, txt)
+
+	resp.ReferenceTime = txt64
+	indicateNTPv5(req, resp)
+	resp.ReceiveTime = rxt64
+	if req.ReceiveTime != req.TransmitTime && ok {
+		// interleaved mode: serve from timestamp store
+		resp.OriginTime = req.ReceiveTime
+		resp.TransmitTime = prevTxt
+		tssMetrics.reqsServedInterleaved.Inc()
+	} else {
+		resp.OriginTime = req.TransmitTime
+		resp.TransmitTime = txt64
+	}
+}
+
+// handleSymmetricRequest prepares the response of a server in symmetric
+// passive mode to a request of a peer in symmetric active mode. Unless basic is
+// set, the timestamp store allows the peer to use interleaved mode as in client
+// server mode.
+func handleSymmetricRequest(peerID string, basic bool, req *ntp.Packet, rxt, txt *time.Time, resp *ntp.Packet) {
+	if basic {
+		handleBasicRequest(req, rxt, txt, resp)
+	} else {
+		handleRequest(peerID, req, rxt, txt, resp)
+	}
+	resp.SetMode(ntp.ModeSymmetricPassive)
+}
+
+// storeTimestamps records the rx timestamp rxt and the preliminary tx
+// timestamp txt of a request from client clientID in the timestamp store,
+// adjusting them as needed to keep them unique and strictly monotonic. If the
+// store holds the timestamps of an earlier request with rx timestamp prevRxt,
+// they are replaced and the earlier tx timestamp is returned with ok set. The
+// NTPv5 server cookie of the response, if any, is stored with the timestamps.
+func storeTimestamps(clientID string, prevRxt ntp.Time64, cookie uint64, rxt, txt *time.Time) (
+	ntp.Time64, ntp.Time64, ntp.Time64, bool) {
 	*txt = timebase.Now()
 
 	rxt64 := ntp.Time64FromTime(*rxt)
@@ -125,7 +206,7 @@ func handleRequest(clientID string, req *ntp.Packet, rxt, txt *time.Time, resp *
 				if tssi.buf[i].rxt == rxt64 {
 					break
 				}
-				if tssi.buf[i].rxt == req.OriginTime {
+				if tssi.buf[i].rxt == prevRxt {
 					o = i
 				}
 				if min == -1 || tssi.buf[i].rxt.Before(tssi.buf[min].rxt) {
@@ -172,16 +253,9 @@ func handleRequest(clientID string, req *ntp.Packet, rxt, txt *time.Time, resp *
 		o, min, max = -1, -1, -1
 	}
 
-	resp.ReferenceTime = txt64
-	resp.ReceiveTime = rxt64
-	if req.ReceiveTime != req.TransmitTime && o != -1 {
-		// interleaved mode: serve from timestamp store
-		resp.OriginTime = req.ReceiveTime
-		resp.TransmitTime = tssi.buf[o].txt
-		tssMetrics.reqsServedInterleaved.Inc()
-	} else {
-		resp.OriginTime = req.TransmitTime
-		resp.TransmitTime = txt64
+	var prevTxt ntp.Time64
+	if o != -1 {
+		prevTxt = tssi.buf[o].txt
 	}
 
 	if tssi != nil {
@@ -194,18 +268,22 @@ func handleRequest(clientID string, req *ntp.Packet, rxt, txt *time.Time, resp *
 			// maintain interleaved mode timestamp values
 			tssi.buf[o].rxt = rxt64
 			tssi.buf[o].txt = txt64
+			tssi.buf[o].cookie = cookie
 		} else if tssi.len == cap(tssi.buf) {
 			// replace minimum timestamp values
 			tssi.buf[min].rxt = rxt64
 			tssi.buf[min].txt = txt64
+			tssi.buf[min].cookie = cookie
 		} else {
 			// add timestamp values
 			tssi.buf[tssi.len].rxt = rxt64
 			tssi.buf[tssi.len].txt = txt64
+			tssi.buf[tssi.len].cookie = cookie
 			tssi.len++
 			tssMetrics.tssValues.Inc()
 		}
 	}
+	return rxt64, txt64, prevTxt, o != -1
 }
 
 func updateTXTimestamp(clientID string, rxt time.Time, txt *time.Time) {
@@ -260,3 +338,20 @@ func updateTXTimestamp(clientID string, rxt time.Time, txt *time.Time) {
 		}
 	}
 }
+
+// encodeNTSResponse appends the NTS extension fields to the NTP response in b,
+// which may already contain NTPv5 extension fields.
+// Cookies are omitted as needed s.t. the response is not larger than the
+// request of length reqLen.
+func encodeNTSResponse(b *[]byte, cookies [][]byte, algo uint16, key, uniqueID []byte, reqLen int) {
+	n := len(*b)
+	for {
+		ntsresp := nts.NewResponsePacket(cookies, algo, key, uniqueID)
+		nts.EncodePacket(b, &ntsresp)
+		if len(*b) <= reqLen || len(cookies) == 1 {
+			return
+		}
+		cookies = cookies[:len(cookies)-1]
+		*b = (*b)[:n]
+	}
+}
@@ -0,0 +1,178 @@
+package server
+
+// See RFC 5905, Section 8 and RFC 9769, Section 4, broadcast and interleaved
+// broadcast mode
+
+import (
+	"context"
+	"log/slog"
+	"math/bits"
+	"net"
+	"net/netip"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"example.com/scion-time/base/logbase"
+	"example.com/scion-time/base/metrics"
+
+	"example.com/scion-time/core/timebase"
+
+	"example.com/scion-time/net/ntp"
+	"example.com/scion-time/net/udp"
+)
+
+// BroadcastServerConfig configures the periodic transmission of NTP packets in
+// broadcast mode.
+type BroadcastServerConfig struct {
+	// Address is the broadcast or multicast address and port to which packets
+	// are sent.
+	Address netip.AddrPort
+	// Interval is the time between two packets.
+	Interval time.Duration
+	// Interleaved enables interleaved broadcast mode, in which each packet
+	// also carries the transmit timestamp of the previous packet as captured
+	// by the kernel or the network interface.
+	Interleaved bool
+	// Key, if its ID is not 0, authenticates packets by a MAC.
+	Key ntp.SymmetricKey
+}
+
+type broadcastIPServerMetrics struct {
+	pktsSent            prometheus.Counter
+	pktsSentInterleaved prometheus.Counter
+}
+
+func newBroadcastIPServerMetrics() *broadcastIPServerMetrics {
+	return &broadcastIPServerMetrics{
+		pktsSent: promauto.NewCounter(prometheus.CounterOpts{
+			Name: metrics.BroadcastIPServerPktsSentN,
+			Help: metrics.BroadcastIPServerPktsSentH,
+		}),
+		pktsSentInterleaved: promauto.NewCounter(prometheus.CounterOpts{
+			Name: metrics.BroadcastIPServerPktsSentInterleavedN,
+			Help: metrics.BroadcastIPServerPktsSentInterleavedH,
+		}),
+	}
+}
+
+var broadcastIPServerMtrcs atomic.Pointer[broadcastIPServerMetrics]
+
+func init() {
+	broadcastIPServerMtrcs.Store(newBroadcastIPServerMetrics())
+}
+
+// broadcastPoll returns the log2 of interval in seconds.
+func broadcastPoll(interval time.Duration) int8 {
+	return int8(max(bits.Len64(uint64(interval/time.Second)), 1) - 1)
+}
+
+func runBroadcastServer(ctx context.Context, log *slog.Logger, mtrcs *broadcastIPServerMetrics,
+	conn *net.UDPConn, cfg BroadcastServerConfig) {
+	defer func() { _ = conn.Close() }()
+
+	// Timestamps of the previous packet: the transmit timestamp as sent in
+	// the packet and as captured after transmission.
+	var prev struct {
+		txt0, txt1 ntp.Time64
+		ok         bool
+	}
+	var txid uint32
+	buf := make([]byte, ntp.PacketLen)
+	ticker := time.NewTicker(cfg.Interval)
+	defer ticker.Stop()
+	for {
+		var pkt ntp.Packet
+		pkt.SetVersion(ntp.VersionMax)
+		pkt.SetMode(ntp.ModeBroadcast)
+		pkt.Stratum = 1
+		pkt.Poll = broadcastPoll(cfg.Interval)
+		pkt.Precision = -32
+		pkt.RootDispersion = ntp.Time32{Seconds: 0, Fraction: 10}
+		pkt.ReferenceID = serverRefID
+
+		interleaved := cfg.Interleaved && prev.ok
+		if interleaved {
+			pkt.OriginTime = prev.txt1
+			pkt.ReceiveTime = prev.txt0
+		}
+		txt0 := timebase.Now()
+		pkt.ReferenceTime = ntp.Time64FromTime(txt0)
+		pkt.TransmitTime = ntp.Time64FromTime(txt0)
+
+		ntp.EncodePacket(&buf, &pkt)
+		if cfg.Key.ID != 0 {
+			buf = ntp.AppendMAC(buf, cfg.Key)
+		}
+
+		n, err := conn.WriteToUDPAddrPort(buf, cfg.Address)
+		if err != nil || n != len(buf) {
+			log.LogAttrs(ctx, slog.LevelError, "failed to write packet", slog.Any("error", err))
+			prev.ok = false
+		} else {
+			txt1, id, err := udp.ReadTXTimestamp(conn)
+			if err != nil {
+				txt1 = txt0
+				log.LogAttrs(ctx, slog.LevelError, "failed to read packet tx timestamp",
+					slog.Any("error", err))
+			} else if id != txid {
+				txt1 = txt0
+				log.LogAttrs(ctx, slog.LevelError, "failed to read packet tx timestamp",
+					slog.Uint64("id", uint64(id)), slog.Uint64("expected", uint64(txid)))
+				txid = id + 1
+			} else {
+				txid++
+			}
+			prev.txt0 = pkt.TransmitTime
+			prev.txt1 = ntp.Time64FromTime(txt1)
+			prev.ok = true
+			mtrcs.pktsSent.Inc()
+			if interleaved {
+				mtrcs.pktsSentInterleaved.Inc()
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// StartBroadcastServerIP starts sending NTP packets in broadcast mode from the
+// local address localHost as configured by cfg.
+func StartBroadcastServerIP(ctx context.Context, log *slog.Logger,
+	localHost *net.UDPAddr, dscp uint8, cfg BroadcastServerConfig) {
+	mtrcs := broadcastIPServerMtrcs.Load()
+
+	log.LogAttrs(ctx, slog.LevelInfo, "broadcast server sending via IP",
+		slog.Any("local host", localHost.IP),
+		slog.String("address", cfg.Address.String()),
+	)
+
+	if localHost.Port != 0 {
+		logbase.FatalContext(ctx, log, "unexpected listener port",
+			slog.Int("port", localHost.Port))
+	}
+	if !cfg.Address.IsValid() || cfg.Interval <= 0 {
+		logbase.FatalContext(ctx, log, "invalid broadcast server configuration")
+	}
+
+	var lc net.ListenConfig
+	conn, err := lc.ListenPacket(ctx, "udp", net.JoinHostPort(localHost.IP.String(), "0"))
+	if err != nil {
+		logbase.FatalContext(ctx, log, "failed to listen for packets", slog.Any("error", err))
+	}
+	err = udp.EnableTimestamping(conn.(*net.UDPConn), localHost.Zone)
+	if err != nil {
+		log.LogAttrs(ctx, slog.LevelError, "failed to enable timestamping", slog.Any("error", err))
+	}
+	err = udp.SetDSCP(conn.(*net.UDPConn), dscp)
+	if err != nil {
+		log.LogAttrs(ctx, slog.LevelInfo, "failed to set DSCP", slog.Any("error", err))
+	}
+	go runBroadcastServer(ctx, log, mtrcs, conn.(*net.UDPConn), cfg)
+}
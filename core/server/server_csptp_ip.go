@@ -6,81 +6,61 @@ import (
 	"net"
 	"net/netip"
 	"strconv"
-	"sync"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
 	"example.com/scion-time/base/logbase"
+	"example.com/scion-time/base/metrics"
 	"example.com/scion-time/core/timebase"
 	"example.com/scion-time/net/csptp"
 	"example.com/scion-time/net/udp"
-)
-
-const (
-	csptpContextCap = 8
-	csptpClientCap  = 1 << 20
-)
-
-type udpConn struct {
-	c    *net.UDPConn
-	mu   sync.Mutex
-	txid uint32
-}
-
-//lint:ignore U1000 work in progress
-type csptpContext struct {
-	conn       *udpConn
-	srcPort    uint16
-	rxTime     time.Time
-	sequenceID uint16
-	correction int64
-}
-
-//lint:ignore U1000 work in progress
-type csptpClient struct {
-	key   netip.Addr
-	ctxts [csptpContextCap]csptpContext
-	len   int
-	qval  time.Time
-	qidx  int
-}
-
-type csptpClientQueue []*csptpClient
-
-var (
-	csptpClients  = make(map[netip.Addr]*csptpClient)
-	csptpClientsQ = make(csptpClientQueue, 0, csptpClientCap)
 
-	csptpMu sync.Mutex
+	clocksync "example.com/scion-time/core/sync"
 )
 
-func (q csptpClientQueue) Len() int { return len(q) }
-
-func (q csptpClientQueue) Less(i, j int) bool {
-	return q[i].qval.Before(q[j].qval)
+type csptpIPServerMetrics struct {
+	pktsReceived      prometheus.Counter
+	pktsAuthenticated prometheus.Counter
+	authFailures      prometheus.Counter
+	reqsAccepted      prometheus.Counter
+	reqsDenied        prometheus.Counter
+	reqsServed        prometheus.Counter
 }
 
-func (q csptpClientQueue) Swap(i, j int) {
-	q[i], q[j] = q[j], q[i]
-	q[i].qidx = i
-	q[j].qidx = j
-}
-
-func (q *csptpClientQueue) Push(x any) {
-	c := x.(*csptpClient)
-	c.qidx = len(*q)
-	*q = append(*q, c)
-}
-
-func (q *csptpClientQueue) Pop() any {
-	n := len(*q)
-	c := (*q)[n-1]
-	(*q)[n-1] = nil
-	*q = (*q)[0 : n-1]
-	return c
+func newCSPTPIPServerMetrics() *csptpIPServerMetrics {
+	return &csptpIPServerMetrics{
+		pktsReceived: promauto.NewCounter(prometheus.CounterOpts{
+			Name: metrics.CSPTPIPServerPktsReceivedN,
+			Help: metrics.CSPTPIPServerPktsReceivedH,
+		}),
+		pktsAuthenticated: promauto.NewCounter(prometheus.CounterOpts{
+			Name: metrics.CSPTPIPServerPktsAuthenticatedN,
+			Help: metrics.CSPTPIPServerPktsAuthenticatedH,
+		}),
+		authFailures: promauto.NewCounter(prometheus.CounterOpts{
+			Name: metrics.CSPTPIPServerAuthFailuresN,
+			Help: metrics.CSPTPIPServerAuthFailuresH,
+		}),
+		reqsAccepted: promauto.NewCounter(prometheus.CounterOpts{
+			Name: metrics.CSPTPIPServerReqsAcceptedN,
+			Help: metrics.CSPTPIPServerReqsAcceptedH,
+		}),
+		reqsDenied: promauto.NewCounter(prometheus.CounterOpts{
+			Name: metrics.CSPTPIPServerReqsDeniedN,
+			Help: metrics.CSPTPIPServerReqsDeniedH,
+		}),
+		reqsServed: promauto.NewCounter(prometheus.CounterOpts{
+			Name: metrics.CSPTPIPServerReqsServedN,
+			Help: metrics.CSPTPIPServerReqsServedH,
+		}),
+	}
 }
 
-func runCSPTPServerIP(ctx context.Context, log *slog.Logger,
-	conn *udpConn, localHostIface string, localHostPort int, dscp uint8) {
+func runCSPTPServerIP(ctx context.Context, log *slog.Logger, mtrcs *csptpIPServerMetrics,
+	conn *udpConn, localHostIface string, localHostPort int, dscp uint8, acl *ACL, limiter *RateLimiter,
+	cfg *CSPTPServerConfig, clients *csptpClientTable) {
 	err := udp.EnableTimestamping(conn.c, localHostIface)
 	if err != nil {
 		log.LogAttrs(ctx, slog.LevelError, "failed to enable timestamping", slog.Any("error", err))
@@ -90,10 +70,9 @@ func runCSPTPServerIP(ctx context.Context, log *slog.Logger,
 		log.LogAttrs(ctx, slog.LevelInfo, "failed to set DSCP", slog.Any("error", err))
 	}
 
-	var eConn, gConn *udpConn
-
 	buf := make([]byte, csptp.MaxMessageLength)
 	oob := make([]byte, udp.TimestampLen())
+	resp := make([]byte, csptp.MaxMessageLength)
 	for {
 		buf = buf[:cap(buf)]
 		oob = oob[:cap(oob)]
@@ -114,217 +93,125 @@ func runCSPTPServerIP(ctx context.Context, log *slog.Logger,
 			log.LogAttrs(ctx, slog.LevelError, "failed to read packet rx timestamp", slog.Any("error", err))
 		}
 		buf = buf[:n]
-
-		if len(buf) < csptp.MinMessageLength {
-			log.LogAttrs(ctx, slog.LevelInfo, "failed to decode packet payload: unexpected structure")
-			continue
-		}
+		mtrcs.pktsReceived.Inc()
 
 		var reqmsg csptp.Message
-		err = csptp.DecodeMessage(&reqmsg, buf[:csptp.MinMessageLength])
+		var reqtlv csptp.RequestTLV
+		var authtlv csptp.AuthenticationTLV
+		authenticated, err := decodeCSPTPRequest(&reqmsg, &reqtlv, &authtlv, buf)
 		if err != nil {
-			log.LogAttrs(ctx, slog.LevelInfo, "failed to decode packet payload", slog.Any("error", err))
+			log.LogAttrs(ctx, slog.LevelInfo, "failed to validate packet payload", slog.Any("error", err))
 			continue
 		}
-
-		if len(buf) != int(reqmsg.MessageLength) {
-			log.LogAttrs(ctx, slog.LevelInfo, "failed to validate packet payload: unexpected message length")
+		if reqmsg.SdoIDMessageType == csptp.MessageTypeSync && localHostPort != csptp.EventPortIP ||
+			reqmsg.SdoIDMessageType == csptp.MessageTypeFollowUp && localHostPort != csptp.GeneralPortIP {
+			log.LogAttrs(ctx, slog.LevelInfo, "failed to validate packet payload",
+				slog.String("cause", "unexpected destination port"),
+				slog.Int("dst_port", localHostPort))
 			continue
 		}
 
-		if reqmsg.SdoIDMessageType == csptp.MessageTypeSync && localHostPort == csptp.EventPortIP {
-			if len(buf)-csptp.MinMessageLength != 0 {
-				log.LogAttrs(ctx, slog.LevelInfo, "failed to validate packet payload: unexpected Sync message length")
-				continue
-			}
-
-			log.LogAttrs(ctx, slog.LevelDebug, "received request",
-				slog.Time("at", rxt),
+		// Requests are rate limited by address before they are authenticated.
+		if limiter.check(limiter.ipClientKey(srcAddr.Addr()), time.Now()) >= rateLimitKoD {
+			log.LogAttrs(ctx, slog.LevelDebug, "dropped request",
 				slog.String("from", srcAddr.String()),
-				slog.Any("reqmsg", &reqmsg),
+				slog.String("cause", "rate limit exceeded"),
 			)
-		} else if reqmsg.SdoIDMessageType == csptp.MessageTypeFollowUp && localHostPort == csptp.GeneralPortIP {
-			var reqtlv csptp.RequestTLV
-			err = csptp.DecodeRequestTLV(&reqtlv, buf[csptp.MinMessageLength:])
+			continue
+		}
+
+		var authKey []byte
+		if authenticated {
+			authKey, err = verifyCSPTPRequest(cfg, &authtlv, buf)
 			if err != nil {
-				log.LogAttrs(ctx, slog.LevelInfo, "failed to decode packet payload", slog.Any("error", err))
-				continue
-			}
-			if reqtlv.Type != csptp.TLVTypeOrganizationExtension ||
-				reqtlv.OrganizationID[0] != csptp.OrganizationIDMeinberg0 ||
-				reqtlv.OrganizationID[1] != csptp.OrganizationIDMeinberg1 ||
-				reqtlv.OrganizationID[2] != csptp.OrganizationIDMeinberg2 ||
-				reqtlv.OrganizationSubType[0] != csptp.OrganizationSubTypeRequest0 ||
-				reqtlv.OrganizationSubType[1] != csptp.OrganizationSubTypeRequest1 ||
-				reqtlv.OrganizationSubType[2] != csptp.OrganizationSubTypeRequest2 {
-				log.LogAttrs(ctx, slog.LevelInfo, "failed to validate packet payload: unexpected Follow Up message")
-				continue
-			}
-			if len(buf)-csptp.MinMessageLength != csptp.EncodedRequestTLVLength(&reqtlv) {
-				log.LogAttrs(ctx, slog.LevelInfo, "failed to validate packet payload: unexpected Follow Up message length")
+				mtrcs.authFailures.Inc()
+				log.LogAttrs(ctx, slog.LevelInfo, "failed to authenticate packet",
+					slog.String("from", srcAddr.String()),
+					slog.Uint64("key_id", uint64(authtlv.KeyID)),
+					slog.Any("error", err))
 				continue
 			}
+			mtrcs.pktsAuthenticated.Inc()
+		}
 
-			log.LogAttrs(ctx, slog.LevelDebug, "received request",
-				slog.Time("at", rxt),
+		// CSPTP does not support KoD responses, denied requests are dropped.
+		client := aclClient{addr: srcAddr.Addr()}
+		if authenticated {
+			client.keyID = authtlv.KeyID
+		}
+		allowed, _ := acl.check(client)
+		if !allowed {
+			mtrcs.reqsDenied.Inc()
+			log.LogAttrs(ctx, slog.LevelDebug, "dropped request",
 				slog.String("from", srcAddr.String()),
-				slog.Any("reqmsg", &reqmsg),
-				slog.Any("reqtlv", &reqtlv),
+				slog.String("cause", "access denied"),
 			)
-		} else {
-			log.LogAttrs(ctx, slog.LevelInfo, "failed to validate packet payload: unexpected message")
 			continue
 		}
 
-		var (
-			sequenceID       uint16
-			sequenceComplete bool
-			syncSrcPort      uint16
-			followUpSrcPort  uint16
+		mtrcs.reqsAccepted.Inc()
+		log.LogAttrs(ctx, slog.LevelDebug, "received request",
+			slog.Time("at", rxt),
+			slog.String("from", srcAddr.String()),
+			slog.Bool("auth", authenticated),
+			slog.Any("reqmsg", &reqmsg),
 		)
 
-		csptpMu.Lock()
-		// maintain CSPTP client data structure
-		_ = len(csptpClients)
-		_ = len(csptpClientsQ)
-		csptpMu.Unlock()
-
-		if sequenceComplete {
-			var msg csptp.Message
-			var resptlv csptp.ResponseTLV
-
-			buf = buf[:cap(buf)]
-
-			msg = csptp.Message{
-				SdoIDMessageType:    csptp.MessageTypeSync,
-				PTPVersion:          csptp.PTPVersion,
-				MessageLength:       csptp.MinMessageLength,
-				DomainNumber:        csptp.DomainNumber,
-				MinorSdoID:          csptp.MinorSdoID,
-				FlagField:           csptp.FlagTwoStep | csptp.FlagUnicast,
-				CorrectionField:     0,
-				MessageTypeSpecific: 0,
-				SourcePortIdentity: csptp.PortID{
-					ClockID: 1,
-					Port:    1,
-				},
-				SequenceID:         sequenceID,
-				ControlField:       csptp.ControlSync,
-				LogMessageInterval: csptp.LogMessageInterval,
-				Timestamp:          csptp.Timestamp{},
-			}
-
-			buf = buf[:msg.MessageLength]
-			csptp.EncodeMessage(buf, &msg)
-
-			eConn.mu.Lock()
-			n, err = eConn.c.WriteToUDPAddrPort(
-				buf, netip.AddrPortFrom(srcAddr.Addr(), syncSrcPort))
-			if err != nil || n != len(buf) {
-				log.LogAttrs(ctx, slog.LevelError, "failed to write packet", slog.Any("error", err))
-				eConn.mu.Unlock()
-				continue
-			}
-			cTxTime0, id, err := udp.ReadTXTimestamp(eConn.c)
-			if err != nil {
-				cTxTime0 = timebase.Now()
-				log.LogAttrs(ctx, slog.LevelError, "failed to read packet tx timestamp",
-					slog.Any("error", err))
-			} else if id != eConn.txid {
-				cTxTime0 = timebase.Now()
-				log.LogAttrs(ctx, slog.LevelError, "failed to read packet tx timestamp",
-					slog.Uint64("id", uint64(id)), slog.Uint64("expected", uint64(eConn.txid)))
-				eConn.txid = id + 1
-			} else {
-				eConn.txid++
-			}
-			_ = cTxTime0
-			eConn.mu.Unlock()
-
-			buf = buf[:cap(buf)]
-
-			msg = csptp.Message{
-				SdoIDMessageType:    csptp.MessageTypeFollowUp,
-				PTPVersion:          csptp.PTPVersion,
-				MessageLength:       csptp.MinMessageLength,
-				DomainNumber:        csptp.DomainNumber,
-				MinorSdoID:          csptp.MinorSdoID,
-				FlagField:           csptp.FlagUnicast,
-				CorrectionField:     0,
-				MessageTypeSpecific: 0,
-				SourcePortIdentity: csptp.PortID{
-					ClockID: 1,
-					Port:    1,
-				},
-				SequenceID:         sequenceID,
-				ControlField:       csptp.ControlFollowUp,
-				LogMessageInterval: csptp.LogMessageInterval,
-				Timestamp:          csptp.Timestamp{}, /* TODO */
-			}
-			resptlv = csptp.ResponseTLV{
-				Type:   csptp.TLVTypeOrganizationExtension,
-				Length: 0,
-				OrganizationID: [3]uint8{
-					csptp.OrganizationIDMeinberg0,
-					csptp.OrganizationIDMeinberg1,
-					csptp.OrganizationIDMeinberg2},
-				OrganizationSubType: [3]uint8{
-					csptp.OrganizationSubTypeResponse0,
-					csptp.OrganizationSubTypeResponse1,
-					csptp.OrganizationSubTypeResponse2},
-				FlagField:               csptp.TLVFlagServerStateDS,
-				Error:                   0,
-				RequestIngressTimestamp: csptp.Timestamp{}, /* TODO */
-				RequestCorrectionField:  0,
-				UTCOffset:               0,
-				ServerStateDS: csptp.ServerStateDS{
-					GMPriority1:     0, /* TODO */
-					GMClockClass:    0, /* TODO */
-					GMClockAccuracy: 0, /* TODO */
-					GMClockVariance: 0, /* TODO */
-					GMPriority2:     0, /* TODO */
-					GMClockID:       0, /* TODO */
-					StepsRemoved:    0, /* TODO */
-					TimeSource:      0, /* TODO */
-					Reserved:        0,
-				},
-			}
-			msg.MessageLength += uint16(csptp.EncodedResponseTLVLength(&resptlv))
-			resptlv.Length = uint16(csptp.EncodedResponseTLVLength(&resptlv))
+		serverStateDS := reqmsg.SdoIDMessageType == csptp.MessageTypeFollowUp &&
+			reqtlv.FlagField&csptp.TLVFlagServerStateDS != 0
+		reqctx := csptpContext{
+			conn:          conn,
+			srcPort:       srcAddr.Port(),
+			rxTime:        rxt,
+			portID:        reqmsg.SourcePortIdentity,
+			sequenceID:    reqmsg.SequenceID,
+			msgType:       reqmsg.SdoIDMessageType,
+			correction:    reqmsg.CorrectionField,
+			serverStateDS: serverStateDS,
+			authKeyID:     authtlv.KeyID,
+			authKey:       authKey,
+		}
+		pairctx, ok := clients.pair(srcAddr.Addr().String(), reqctx)
+		if !ok {
+			continue
+		}
+		syncctx, followupctx := reqctx, pairctx
+		if reqctx.msgType == csptp.MessageTypeFollowUp {
+			syncctx, followupctx = pairctx, reqctx
+		}
+		authKeyID, authKey := csptpResponseAuth(&syncctx, &followupctx)
 
-			buf = buf[:msg.MessageLength]
-			csptp.EncodeMessage(buf[:csptp.MinMessageLength], &msg)
-			csptp.EncodeResponseTLV(buf[csptp.MinMessageLength:], &resptlv)
+		msg := newCSPTPSyncResponse(cfg.ClockID, reqmsg.SequenceID)
+		txt, ok := syncctx.conn.writeTo(ctx, log,
+			encodeCSPTPResponse(resp, &msg, nil /* TLV */, authKeyID, authKey),
+			netip.AddrPortFrom(srcAddr.Addr(), syncctx.srcPort))
+		if !ok {
+			continue
+		}
 
-			gConn.mu.Lock()
-			n, err = gConn.c.WriteToUDPAddrPort(
-				buf, netip.AddrPortFrom(srcAddr.Addr(), followUpSrcPort))
-			if err != nil || n != len(buf) {
-				log.LogAttrs(ctx, slog.LevelError, "failed to write packet", slog.Any("error", err))
-				gConn.mu.Unlock()
-				continue
-			}
-			cTxTime1, id, err := udp.ReadTXTimestamp(gConn.c)
-			if err != nil {
-				cTxTime1 = timebase.Now()
-				log.LogAttrs(ctx, slog.LevelError, "failed to read packet tx timestamp",
-					slog.Any("error", err))
-			} else if id != gConn.txid {
-				cTxTime1 = timebase.Now()
-				log.LogAttrs(ctx, slog.LevelError, "failed to read packet tx timestamp",
-					slog.Uint64("id", uint64(id)), slog.Uint64("expected", uint64(gConn.txid)))
-				gConn.txid = id + 1
-			} else {
-				gConn.txid++
-			}
-			_ = cTxTime1
-			gConn.mu.Unlock()
+		var ds *csptp.ServerStateDS
+		if followupctx.serverStateDS {
+			x := csptpServerStateDS(cfg, clocksync.CurrentState(), timebase.Now())
+			ds = &x
 		}
+		utcOffset, _ := cfg.utcOffset(txt)
+		msg, resptlv := newCSPTPFollowUpResponse(cfg.ClockID, reqmsg.SequenceID,
+			syncctx.rxTime, txt, syncctx.correction, utcOffset, ds)
+		_, ok = followupctx.conn.writeTo(ctx, log,
+			encodeCSPTPResponse(resp, &msg, &resptlv, authKeyID, authKey),
+			netip.AddrPortFrom(srcAddr.Addr(), followupctx.srcPort))
+		if !ok {
+			continue
+		}
+
+		mtrcs.reqsServed.Inc()
 	}
 }
 
 func StartCSPTPServerIP(ctx context.Context, log *slog.Logger,
-	localHost *net.UDPAddr, dscp uint8) {
+	localHost *net.UDPAddr, dscp uint8, acl *ACL, limiter *RateLimiter, cfg CSPTPServerConfig) {
+	mtrcs := newCSPTPIPServerMetrics()
+
 	log.LogAttrs(ctx, slog.LevelInfo, "CSPTP server listening via IP",
 		slog.Any("local host", localHost.IP),
 	)
@@ -334,6 +221,12 @@ func StartCSPTPServerIP(ctx context.Context, log *slog.Logger,
 			slog.Int("port", localHost.Port))
 	}
 
+	if cfg.ClockID == 0 {
+		cfg.ClockID = csptpClockID(localHost.IP)
+	}
+
+	clients := newCSPTPClientTable(cfg.MaxClients)
+
 	lc := net.ListenConfig{
 		Control: udp.SetsockoptReuseAddrPort,
 	}
@@ -344,7 +237,12 @@ func StartCSPTPServerIP(ctx context.Context, log *slog.Logger,
 			if err != nil {
 				logbase.FatalContext(ctx, log, "failed to listen for packets", slog.Any("error", err))
 			}
-			go runCSPTPServerIP(ctx, log, &udpConn{c: conn.(*net.UDPConn)}, localHost.Zone, localHostPort, dscp)
+			c, err := newUDPConn(conn.(*net.UDPConn))
+			if err != nil {
+				logbase.FatalContext(ctx, log, "failed to duplicate connection", slog.Any("error", err))
+			}
+			go runCSPTPServerIP(ctx, log, mtrcs, c, localHost.Zone, localHostPort, dscp, acl, limiter, &cfg,
+				clients)
 		}
 	}
 }
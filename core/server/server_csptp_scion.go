@@ -0,0 +1,336 @@
+package server
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"net/netip"
+	"strconv"
+	"time"
+
+	"github.com/google/gopacket"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/scionproto/scion/pkg/daemon"
+	"github.com/scionproto/scion/pkg/slayers"
+
+	"example.com/scion-time/base/logbase"
+	"example.com/scion-time/base/metrics"
+
+	"example.com/scion-time/core/timebase"
+
+	clocksync "example.com/scion-time/core/sync"
+
+	"example.com/scion-time/net/csptp"
+	"example.com/scion-time/net/scion"
+	"example.com/scion-time/net/udp"
+)
+
+type csptpSCIONServerMetrics struct {
+	pktsReceived      prometheus.Counter
+	pktsForwarded     prometheus.Counter
+	pktsAuthenticated prometheus.Counter
+	authFailures      prometheus.Counter
+	reqsAccepted      prometheus.Counter
+	reqsDenied        prometheus.Counter
+	reqsServed        prometheus.Counter
+}
+
+func newCSPTPSCIONServerMetrics() *csptpSCIONServerMetrics {
+	return &csptpSCIONServerMetrics{
+		pktsReceived: promauto.NewCounter(prometheus.CounterOpts{
+			Name: metrics.CSPTPSCIONServerPktsReceivedN,
+			Help: metrics.CSPTPSCIONServerPktsReceivedH,
+		}),
+		pktsForwarded: promauto.NewCounter(prometheus.CounterOpts{
+			Name: metrics.CSPTPSCIONServerPktsForwardedN,
+			Help: metrics.CSPTPSCIONServerPktsForwardedH,
+		}),
+		pktsAuthenticated: promauto.NewCounter(prometheus.CounterOpts{
+			Name: metrics.CSPTPSCIONServerPktsAuthenticatedN,
+			Help: metrics.CSPTPSCIONServerPktsAuthenticatedH,
+		}),
+		authFailures: promauto.NewCounter(prometheus.CounterOpts{
+			Name: metrics.CSPTPSCIONServerAuthFailuresN,
+			Help: metrics.CSPTPSCIONServerAuthFailuresH,
+		}),
+		reqsAccepted: promauto.NewCounter(prometheus.CounterOpts{
+			Name: metrics.CSPTPSCIONServerReqsAcceptedN,
+			Help: metrics.CSPTPSCIONServerReqsAcceptedH,
+		}),
+		reqsDenied: promauto.NewCounter(prometheus.CounterOpts{
+			Name: metrics.CSPTPSCIONServerReqsDeniedN,
+			Help: metrics.CSPTPSCIONServerReqsDeniedH,
+		}),
+		reqsServed: promauto.NewCounter(prometheus.CounterOpts{
+			Name: metrics.CSPTPSCIONServerReqsServedN,
+			Help: metrics.CSPTPSCIONServerReqsServedH,
+		}),
+	}
+}
+
+func runCSPTPServerSCION(ctx context.Context, log *slog.Logger, mtrcs *csptpSCIONServerMetrics,
+	conn *net.UDPConn, localHostIface string, dscp uint8, fetcher *scion.Fetcher, acl *ACL, limiter *RateLimiter,
+	cfg *CSPTPServerConfig, clients *csptpClientTable) {
+	defer func() { _ = conn.Close() }()
+
+	err := udp.EnableTimestamping(conn, localHostIface)
+	if err != nil {
+		log.LogAttrs(ctx, slog.LevelError, "failed to enable timestamping", slog.Any("error", err))
+	}
+	err = udp.SetDSCP(conn, dscp)
+	if err != nil {
+		log.LogAttrs(ctx, slog.LevelInfo, "failed to set DSCP", slog.Any("error", err))
+	}
+
+	c := newSCIONPacketConn(conn)
+	auth := newSCIONPacketAuth(fetcher)
+	resp := make([]byte, csptp.MaxMessageLength)
+
+	for {
+		lastHop, rxt, ok := c.read(ctx, log)
+		if !ok {
+			continue
+		}
+		mtrcs.pktsReceived.Inc()
+
+		if !c.decode(ctx, log) {
+			continue
+		}
+
+		if c.isSCMP() {
+			if c.handleSCMP(ctx, log, lastHop) {
+				mtrcs.pktsForwarded.Inc()
+			}
+			continue
+		}
+
+		if len(c.buf) < int(c.udpLayer.Length) {
+			log.LogAttrs(ctx, slog.LevelInfo, "failed to decode packet", slog.String("cause", "unexpected structure"))
+			continue
+		}
+
+		srcAddr, ok := netip.AddrFromSlice(c.scionLayer.RawSrcAddr)
+		if !ok {
+			panic("unexpected IP address byte slice")
+		}
+		dstAddr, ok := netip.AddrFromSlice(c.scionLayer.RawDstAddr)
+		if !ok {
+			panic("unexpected IP address byte slice")
+		}
+
+		if c.udpLayer.DstPort != csptp.EventPortSCION && c.udpLayer.DstPort != csptp.GeneralPortSCION {
+			if c.forward(ctx, log, dstAddr) {
+				mtrcs.pktsForwarded.Inc()
+			}
+			continue
+		}
+
+		if t, ok := c.forwardedRxTime(); ok {
+			rxt = t
+		}
+
+		authOpt, authKey, authenticated, ok := c.authenticate(ctx, log, fetcher, auth, rxt, srcAddr, dstAddr)
+		if !ok {
+			continue
+		}
+		if authenticated {
+			mtrcs.pktsAuthenticated.Inc()
+		}
+
+		var reqmsg csptp.Message
+		var reqtlv csptp.RequestTLV
+		var authtlv csptp.AuthenticationTLV
+		hasAuthTLV, err := decodeCSPTPRequest(&reqmsg, &reqtlv, &authtlv, c.udpLayer.Payload)
+		if err != nil {
+			log.LogAttrs(ctx, slog.LevelInfo, "failed to validate packet payload", slog.Any("error", err))
+			continue
+		}
+		if reqmsg.SdoIDMessageType == csptp.MessageTypeSync && c.udpLayer.DstPort != csptp.EventPortSCION ||
+			reqmsg.SdoIDMessageType == csptp.MessageTypeFollowUp && c.udpLayer.DstPort != csptp.GeneralPortSCION {
+			log.LogAttrs(ctx, slog.LevelInfo, "failed to validate packet payload",
+				slog.String("cause", "unexpected L4 destination port"),
+				slog.Int("l4_dst_port", int(c.udpLayer.DstPort)))
+			continue
+		}
+
+		clientID := c.scionLayer.SrcIA.String() + "," + srcAddr.String()
+
+		// Requests are rate limited by address before they are authenticated.
+		if limiter.check(limiter.scionClientKey(c.scionLayer.SrcIA, srcAddr), time.Now()) >= rateLimitKoD {
+			log.LogAttrs(ctx, slog.LevelDebug, "dropped request",
+				slog.String("from", clientID),
+				slog.String("cause", "rate limit exceeded"),
+			)
+			continue
+		}
+
+		var authTLVKey []byte
+		if hasAuthTLV {
+			authTLVKey, err = verifyCSPTPRequest(cfg, &authtlv, c.udpLayer.Payload)
+			if err != nil {
+				mtrcs.authFailures.Inc()
+				log.LogAttrs(ctx, slog.LevelInfo, "failed to authenticate packet",
+					slog.String("from", clientID),
+					slog.Uint64("key_id", uint64(authtlv.KeyID)),
+					slog.Any("error", err))
+				continue
+			}
+		}
+
+		// CSPTP does not support KoD responses, denied requests are dropped.
+		client := aclClient{
+			scion: true,
+			ia:    c.scionLayer.SrcIA,
+			addr:  srcAddr,
+			spao:  authenticated,
+		}
+		if hasAuthTLV {
+			client.keyID = authtlv.KeyID
+		}
+		allowed, _ := acl.check(client)
+		if !allowed {
+			mtrcs.reqsDenied.Inc()
+			log.LogAttrs(ctx, slog.LevelDebug, "dropped request",
+				slog.String("from", clientID),
+				slog.String("cause", "access denied"),
+			)
+			continue
+		}
+
+		mtrcs.reqsAccepted.Inc()
+		log.LogAttrs(ctx, slog.LevelDebug, "received request",
+			slog.Time("at", rxt),
+			slog.String("from", clientID),
+			slog.Bool("auth", authenticated),
+			slog.Any("reqmsg", &reqmsg),
+		)
+
+		serverStateDS := reqmsg.SdoIDMessageType == csptp.MessageTypeFollowUp &&
+			reqtlv.FlagField&csptp.TLVFlagServerStateDS != 0
+		reqctx := csptpContext{
+			srcPort:       c.udpLayer.SrcPort,
+			rxTime:        rxt,
+			portID:        reqmsg.SourcePortIdentity,
+			sequenceID:    reqmsg.SequenceID,
+			msgType:       reqmsg.SdoIDMessageType,
+			correction:    reqmsg.CorrectionField,
+			serverStateDS: serverStateDS,
+			authenticated: authenticated,
+			authKeyID:     authtlv.KeyID,
+			authKey:       authTLVKey,
+		}
+		pairctx, ok := clients.pair(clientID, reqctx)
+		if !ok {
+			continue
+		}
+		syncctx, followupctx := reqctx, pairctx
+		if reqctx.msgType == csptp.MessageTypeFollowUp {
+			syncctx, followupctx = pairctx, reqctx
+		}
+		authenticated = syncctx.authenticated && followupctx.authenticated
+		authKeyID, authTLVKey := csptpResponseAuth(&syncctx, &followupctx)
+
+		c.scionLayer.TrafficClass = dscp << 2
+		c.reverse()
+
+		msg := newCSPTPSyncResponse(cfg.ClockID, reqmsg.SequenceID)
+		c.scionLayer.NextHdr = slayers.L4UDP
+		c.udpLayer.SrcPort, c.udpLayer.DstPort = csptp.EventPortSCION, syncctx.srcPort
+		c.clearBuffer()
+		c.serializeLayer(gopacket.Payload(encodeCSPTPResponse(resp, &msg, nil /* TLV */, authKeyID, authTLVKey)))
+		c.serializeLayer(&c.udpLayer)
+		if authenticated {
+			c.serializeAuthenticated(auth, authOpt, authKey)
+		}
+		c.serializeLayer(&c.scionLayer)
+
+		txt, ok := c.writeTo(ctx, log, lastHop)
+		if !ok {
+			continue
+		}
+		if txt.IsZero() {
+			txt = timebase.Now()
+		}
+
+		var ds *csptp.ServerStateDS
+		if followupctx.serverStateDS {
+			x := csptpServerStateDS(cfg, clocksync.CurrentState(), timebase.Now())
+			ds = &x
+		}
+		utcOffset, _ := cfg.utcOffset(txt)
+		msg, resptlv := newCSPTPFollowUpResponse(cfg.ClockID, reqmsg.SequenceID,
+			syncctx.rxTime, txt, syncctx.correction, utcOffset, ds)
+		c.scionLayer.NextHdr = slayers.L4UDP
+		c.udpLayer.SrcPort, c.udpLayer.DstPort = csptp.GeneralPortSCION, followupctx.srcPort
+		c.clearBuffer()
+		c.serializeLayer(gopacket.Payload(encodeCSPTPResponse(resp, &msg, &resptlv, authKeyID, authTLVKey)))
+		c.serializeLayer(&c.udpLayer)
+		if authenticated {
+			c.serializeAuthenticated(auth, authOpt, authKey)
+		}
+		c.serializeLayer(&c.scionLayer)
+
+		_, ok = c.writeTo(ctx, log, lastHop)
+		if !ok {
+			continue
+		}
+
+		mtrcs.reqsServed.Inc()
+	}
+}
+
+func StartCSPTPServerSCION(ctx context.Context, log *slog.Logger,
+	daemonAddr string, localHost *net.UDPAddr, dscp uint8, acl *ACL, limiter *RateLimiter, cfg CSPTPServerConfig) {
+	startCSPTPServerSCION(ctx, log, func() daemon.Connector {
+		return scion.NewDaemonConnector(ctx, daemonAddr)
+	}, localHost, dscp, acl, limiter, cfg)
+}
+
+// StartCSPTPServerSCIONWithConnector starts a SCION CSPTP server that uses
+// the daemon connector dc in all of its goroutines.
+func StartCSPTPServerSCIONWithConnector(ctx context.Context, log *slog.Logger,
+	dc daemon.Connector, localHost *net.UDPAddr, dscp uint8, acl *ACL, limiter *RateLimiter, cfg CSPTPServerConfig) {
+	startCSPTPServerSCION(ctx, log, func() daemon.Connector {
+		return dc
+	}, localHost, dscp, acl, limiter, cfg)
+}
+
+func startCSPTPServerSCION(ctx context.Context, log *slog.Logger,
+	newDaemonConnector func() daemon.Connector, localHost *net.UDPAddr, dscp uint8, acl *ACL, limiter *RateLimiter,
+	cfg CSPTPServerConfig) {
+	mtrcs := newCSPTPSCIONServerMetrics()
+
+	log.LogAttrs(ctx, slog.LevelInfo, "CSPTP server listening via SCION",
+		slog.Any("local host", localHost.IP),
+	)
+
+	if localHost.Port != 0 {
+		logbase.FatalContext(ctx, log, "unexpected listener port",
+			slog.Int("port", localHost.Port))
+	}
+
+	if cfg.ClockID == 0 {
+		cfg.ClockID = csptpClockID(localHost.IP)
+	}
+
+	clients := newCSPTPClientTable(cfg.MaxClients)
+
+	lc := net.ListenConfig{
+		Control: udp.SetsockoptReuseAddrPort,
+	}
+	for _, localHostPort := range []int{csptp.EventPortSCION, csptp.GeneralPortSCION, scion.EndhostPort} {
+		address := net.JoinHostPort(localHost.IP.String(), strconv.Itoa(localHostPort))
+		for range scionServerNumGoroutine {
+			fetcher := scion.NewFetcher(newDaemonConnector())
+			conn, err := lc.ListenPacket(ctx, "udp", address)
+			if err != nil {
+				logbase.FatalContext(ctx, log, "failed to listen for packets", slog.Any("error", err))
+			}
+			go runCSPTPServerSCION(ctx, log, mtrcs, conn.(*net.UDPConn), localHost.Zone, dscp, fetcher, acl,
+				limiter, &cfg, clients)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package server_test
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/scionproto/scion/pkg/addr"
+	"github.com/scionproto/scion/pkg/daemon"
+
+	"example.com/scion-time/core/client"
+	"example.com/scion-time/core/measurements"
+	"example.com/scion-time/core/server"
+
+	"example.com/scion-time/net/csptp"
+	"example.com/scion-time/net/scion"
+	"example.com/scion-time/net/scion/sciontest"
+	"example.com/scion-time/net/udp"
+)
+
+func TestCSPTPSCIONExchange(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	log := slog.New(slog.DiscardHandler)
+
+	serverIA := addr.MustParseIA("1-ff00:0:111")
+	clientIA := addr.MustParseIA("1-ff00:0:112")
+	serverIP := net.IPv4(127, 0, 0, 17).To4()
+	clientIP := net.IPv4(127, 0, 0, 18).To4()
+
+	router, err := sciontest.StartRouter(ctx, log, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverDC := sciontest.NewDaemonConnector(serverIA, router.Addr())
+	clientDC := sciontest.NewDaemonConnector(clientIA, router.Addr())
+
+	server.StartCSPTPServerSCIONWithConnector(ctx, log, serverDC,
+		&net.UDPAddr{IP: serverIP}, 0 /* DSCP */, nil /* ACL */, nil /* limiter */, server.CSPTPServerConfig{})
+
+	localAddr := udp.UDPAddr{IA: clientIA, Host: &net.UDPAddr{IP: clientIP}}
+	remoteAddr := udp.UDPAddr{IA: serverIA, Host: &net.UDPAddr{IP: serverIP, Port: csptp.EventPortSCION}}
+
+	tests := []struct {
+		name      string
+		n         int
+		configure func(c *client.CSPTPClientSCION)
+		want      string
+	}{
+		{
+			name:      "plain",
+			n:         1,
+			configure: func(c *client.CSPTPClientSCION) {},
+			want:      "auth=false",
+		},
+		{
+			name: "SPAO",
+			n:    1,
+			configure: func(c *client.CSPTPClientSCION) {
+				c.Auth.Enabled = true
+				c.Auth.DRKeyFetcher = scion.NewFetcher(clientDC)
+			},
+			want: "auth=true",
+		},
+		{
+			name:      "multiple paths",
+			n:         2,
+			configure: func(c *client.CSPTPClientSCION) {},
+			want:      "auth=false",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var logBuf bytes.Buffer
+			clog := slog.New(slog.NewTextHandler(&logBuf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+			var cs []*client.CSPTPClientSCION
+			for range tt.n {
+				c := &client.CSPTPClientSCION{Log: clog}
+				tt.configure(c)
+				cs = append(cs, c)
+			}
+
+			ps, err := clientDC.Paths(ctx, serverIA, clientIA, daemon.PathReqFlags{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			mctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+			defer cancel()
+			_, off, err := client.MeasureClockOffsetCSPTPSCION(mctx, clog, cs, localAddr, remoteAddr, ps)
+			if err != nil {
+				t.Fatalf("MeasureClockOffsetCSPTPSCION() failed: %v\n%s", err, logBuf.String())
+			}
+			if off.Abs() > 100*time.Millisecond {
+				t.Errorf("MeasureClockOffsetCSPTPSCION() = %v; want offset close to 0", off)
+			}
+			if got := strings.Count(logBuf.String(), "evaluated response"); got != tt.n {
+				t.Errorf("client evaluated %d responses; want %d:\n%s", got, tt.n, logBuf.String())
+			}
+			if !strings.Contains(logBuf.String(), tt.want) {
+				t.Errorf("client log does not contain %q:\n%s", tt.want, logBuf.String())
+			}
+		})
+	}
+
+	// Filters are kept as long as clients measure over the same paths.
+	t.Run("filter", func(t *testing.T) {
+		f := &resetCountingFilter{Filter: client.NewNtimedFilter(log)}
+		c := &client.CSPTPClientSCION{Log: log, Filter: f}
+		for range 3 {
+			ps, err := clientDC.Paths(ctx, serverIA, clientIA, daemon.PathReqFlags{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			mctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+			_, _, err = client.MeasureClockOffsetCSPTPSCION(mctx, log,
+				[]*client.CSPTPClientSCION{c}, localAddr, remoteAddr, ps)
+			cancel()
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		if f.resets != 1 {
+			t.Errorf("filter was reset %d times; want 1", f.resets)
+		}
+	})
+}
+
+type resetCountingFilter struct {
+	measurements.Filter
+	resets int
+}
+
+func (f *resetCountingFilter) Reset() {
+	f.resets++
+	f.Filter.Reset()
+}
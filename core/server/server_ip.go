@@ -10,6 +10,8 @@ import (
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 
+	"github.com/scionproto/scion/pkg/addr"
+
 	"example.com/scion-time/base/logbase"
 	"example.com/scion-time/base/metrics"
 
@@ -26,9 +28,12 @@ const (
 )
 
 type ipServerMetrics struct {
-	pktsReceived prometheus.Counter
-	reqsAccepted prometheus.Counter
-	reqsServed   prometheus.Counter
+	pktsReceived    prometheus.Counter
+	reqsAccepted    prometheus.Counter
+	reqsDenied      prometheus.Counter
+	reqsRateLimited prometheus.Counter
+	reqsDropped     prometheus.Counter
+	reqsServed      prometheus.Counter
 }
 
 func newIPServerMetrics() *ipServerMetrics {
@@ -41,6 +46,18 @@ func newIPServerMetrics() *ipServerMetrics {
 			Name: metrics.IPServerReqsAcceptedN,
 			Help: metrics.IPServerReqsAcceptedH,
 		}),
+		reqsDenied: promauto.NewCounter(prometheus.CounterOpts{
+			Name: metrics.IPServerReqsDeniedN,
+			Help: metrics.IPServerReqsDeniedH,
+		}),
+		reqsRateLimited: promauto.NewCounter(prometheus.CounterOpts{
+			Name: metrics.IPServerReqsRateLimitedN,
+			Help: metrics.IPServerReqsRateLimitedH,
+		}),
+		reqsDropped: promauto.NewCounter(prometheus.CounterOpts{
+			Name: metrics.IPServerReqsDroppedN,
+			Help: metrics.IPServerReqsDroppedH,
+		}),
 		reqsServed: promauto.NewCounter(prometheus.CounterOpts{
 			Name: metrics.IPServerReqsServedN,
 			Help: metrics.IPServerReqsServedH,
@@ -49,7 +66,8 @@ func newIPServerMetrics() *ipServerMetrics {
 }
 
 func runIPServer(ctx context.Context, log *slog.Logger, mtrcs *ipServerMetrics,
-	conn *net.UDPConn, iface string, dscp uint8, provider *ntske.Provider) {
+	conn *net.UDPConn, iface string, dscp uint8, provider *ntske.Provider, acl *ACL, limiter *RateLimiter,
+	peers *Peers, keys map[uint32]ntp.SymmetricKey, control *Control) {
 	defer func() { _ = conn.Close() }()
 	err := udp.EnableTimestamping(conn, iface)
 	if err != nil {
@@ -83,8 +101,14 @@ func runIPServer(ctx context.Context, log *slog.Logger, mtrcs *ipServerMetrics,
 			log.LogAttrs(ctx, slog.LevelError, "failed to read packet rx timestamp", slog.Any("error", err))
 		}
 		buf = buf[:n]
+		reqLen := n
 		mtrcs.pktsReceived.Inc()
 
+		if len(buf) != 0 && buf[0]&0b0000_0111 == ntp.ModeControl {
+			serveControlRequestIP(ctx, log, mtrcs, conn, control, acl, limiter, buf, srcAddr)
+			continue
+		}
+
 		var ntpreq ntp.Packet
 		err = ntp.DecodePacket(&ntpreq, buf)
 		if err != nil {
@@ -92,10 +116,42 @@ func runIPServer(ctx context.Context, log *slog.Logger, mtrcs *ipServerMetrics,
 			continue
 		}
 
+		var ntpreqV5 ntp.PacketV5
+		var ntpreqV5Ext ntpv5Ext
+		v5 := ntpreq.Version() == ntp.Version5
+		if v5 {
+			err = ntp.DecodePacketV5(&ntpreqV5, buf)
+			if err == nil {
+				err = decodeNTPv5Ext(&ntpreqV5Ext, buf)
+			}
+			if err != nil {
+				log.LogAttrs(ctx, slog.LevelInfo, "failed to decode NTPv5 packet", slog.Any("error", err))
+				continue
+			}
+		}
+
+		symmetric := !v5 && ntpreq.Mode() == ntp.ModeSymmetricActive
+		mac := !v5 && ntp.HasMAC(buf)
+
+		clientID := srcAddr.Addr().String()
+
+		// Requests are rate limited by address before any NTS processing.
+		rl := limiter.check(limiter.ipClientKey(srcAddr.Addr()), time.Now())
+		if rl == rateLimitDrop {
+			mtrcs.reqsRateLimited.Inc()
+			mtrcs.reqsDropped.Inc()
+			log.LogAttrs(ctx, slog.LevelDebug, "dropped request",
+				slog.String("from", clientID),
+				slog.String("cause", "rate limit exceeded"),
+			)
+			continue
+		}
+
 		var authenticated bool
 		var ntsreq nts.Packet
 		var serverCookie ntske.ServerCookie
-		if len(buf) > ntp.PacketLen {
+		var ntsNAK bool
+		if len(buf) > ntp.PacketLen && (!v5 || nts.ContainsUniqueID(buf)) && !mac {
 			err = nts.DecodePacket(&ntsreq, buf)
 			if err != nil {
 				log.LogAttrs(ctx, slog.LevelInfo, "failed to decode NTS packet", slog.Any("error", err))
@@ -118,44 +174,137 @@ func runIPServer(ctx context.Context, log *slog.Logger, mtrcs *ipServerMetrics,
 			key, ok := provider.Get(int(encryptedCookie.ID))
 			if !ok {
 				log.LogAttrs(ctx, slog.LevelInfo, "failed to get key")
-				continue
+				ntsNAK = true
+			} else {
+				serverCookie, err = encryptedCookie.Decrypt(key.Value)
+				if err != nil {
+					log.LogAttrs(ctx, slog.LevelInfo, "failed to decrypt cookie", slog.Any("error", err))
+					ntsNAK = true
+				}
 			}
 
-			serverCookie, err = encryptedCookie.Decrypt(key.Value)
+			if !ntsNAK {
+				err = nts.ProcessRequest(buf, serverCookie.Algo, serverCookie.C2S, &ntsreq)
+				if err != nil {
+					log.LogAttrs(ctx, slog.LevelInfo, "failed to process NTS packet", slog.Any("error", err))
+					continue
+				}
+				authenticated = true
+			}
+		}
+
+		switch {
+		case v5:
+			err = ntp.ValidateRequestV5(&ntpreqV5)
+		case symmetric:
+			err = ntp.ValidateSymmetricRequest(&ntpreq)
+		default:
+			err = ntp.ValidateRequest(&ntpreq, srcAddr.Port())
+		}
+		if err != nil {
+			log.LogAttrs(ctx, slog.LevelInfo, "failed to validate packet payload", slog.Any("error", err))
+			continue
+		}
+
+		var macKey ntp.SymmetricKey
+		if symmetric {
+			macKey, err = peers.accept(addr.IA(0), srcAddr.Addr(), &ntpreq, buf, authenticated, rxt)
 			if err != nil {
-				log.LogAttrs(ctx, slog.LevelInfo, "failed to decrypt cookie", slog.Any("error", err))
+				log.LogAttrs(ctx, slog.LevelInfo, "failed to accept symmetric mode request",
+					slog.String("from", clientID), slog.Any("error", err))
 				continue
 			}
-
-			err = nts.ProcessRequest(buf, serverCookie.C2S, &ntsreq)
+		} else if mac {
+			macKey, err = verifyMAC(keys, buf)
 			if err != nil {
-				log.LogAttrs(ctx, slog.LevelInfo, "failed to process NTS packet", slog.Any("error", err))
+				log.LogAttrs(ctx, slog.LevelInfo, "failed to verify MAC",
+					slog.String("from", clientID), slog.Any("error", err))
 				continue
 			}
-			authenticated = true
 		}
 
-		err = ntp.ValidateRequest(&ntpreq, srcAddr.Port())
-		if err != nil {
-			log.LogAttrs(ctx, slog.LevelInfo, "failed to validate packet payload", slog.Any("error", err))
-			continue
+		var identity string
+		if authenticated {
+			identity = serverCookie.Identity
 		}
 
-		clientID := srcAddr.Addr().String()
+		allowed, kissCode := acl.check(aclClient{addr: srcAddr.Addr(), nts: authenticated, identity: identity,
+			keyID: macKey.ID})
+		if !allowed {
+			mtrcs.reqsDenied.Inc()
+			if kissCode == 0 || v5 || symmetric {
+				log.LogAttrs(ctx, slog.LevelDebug, "dropped request",
+					slog.String("from", clientID),
+					slog.String("cause", "access denied"),
+				)
+				continue
+			}
+		}
+
+		if identity != "" {
+			rl = max(rl, limiter.check(limiter.identityKey(identity), time.Now()))
+		}
+		if rl == rateLimitKoD || rl == rateLimitDrop {
+			mtrcs.reqsRateLimited.Inc()
+		}
+		if rl == rateLimitDrop || (!allowed || v5 || symmetric) && rl == rateLimitKoD {
+			mtrcs.reqsDropped.Inc()
+			log.LogAttrs(ctx, slog.LevelDebug, "dropped request",
+				slog.String("from", clientID),
+				slog.String("cause", "rate limit exceeded"),
+			)
+			continue
+		}
 
 		mtrcs.reqsAccepted.Inc()
+		var data slog.LogValuer = ntp.PacketLogValuer{Pkt: &ntpreq}
+		if v5 {
+			data = ntp.PacketV5LogValuer{Pkt: &ntpreqV5}
+		}
 		log.LogAttrs(ctx, slog.LevelDebug, "received request",
 			slog.Time("at", rxt),
 			slog.String("from", clientID),
 			slog.Bool("ntsauth", authenticated),
-			slog.Any("data", ntp.PacketLogValuer{Pkt: &ntpreq}),
+			slog.Bool("macauth", macKey.ID != 0),
+			slog.Any("data", data),
 		)
 
 		var txt0 time.Time
 		var ntpresp ntp.Packet
-		handleRequest(clientID, &ntpreq, &rxt, &txt0, &ntpresp)
+		var ntprespV5 ntp.PacketV5
+		switch {
+		case v5 && ntsNAK:
+			handleNAKRequestV5(&ntpreqV5, &ntprespV5)
+		case v5 && rl == rateLimitAcceptBasic:
+			handleBasicRequestV5(&ntpreqV5, &rxt, &txt0, &ntprespV5)
+		case v5:
+			handleRequestV5(clientID, &ntpreqV5, &rxt, &txt0, &ntprespV5)
+		case ntsNAK:
+			handleKoDRequest(&ntpreq, ntp.KissCodeNTSN, 0 /* poll */, &ntpresp)
+		case symmetric:
+			handleSymmetricRequest(clientID, rl == rateLimitAcceptBasic, &ntpreq, &rxt, &txt0, &ntpresp)
+			peers.exchanged(addr.IA(0), srcAddr.Addr(), &ntpreq, &ntpresp, rxt)
+		case !allowed:
+			handleKoDRequest(&ntpreq, kissCode, 0 /* poll */, &ntpresp)
+		case rl == rateLimitKoD:
+			handleKoDRequest(&ntpreq, ntp.KissCodeRATE, limiter.poll(), &ntpresp)
+		case rl == rateLimitAcceptBasic:
+			handleBasicRequest(&ntpreq, &rxt, &txt0, &ntpresp)
+		default:
+			handleRequest(clientID, &ntpreq, &rxt, &txt0, &ntpresp)
+		}
 
-		ntp.EncodePacket(&buf, &ntpresp)
+		if v5 {
+			ntp.EncodePacketV5(&buf, &ntprespV5)
+			if !ntsNAK {
+				buf = appendNTPv5Ext(buf, &ntpreqV5Ext)
+			}
+		} else {
+			ntp.EncodePacket(&buf, &ntpresp)
+			if macKey.ID != 0 {
+				buf = ntp.AppendMAC(buf, macKey)
+			}
+		}
 
 		if authenticated {
 			var cookies [][]byte
@@ -176,8 +325,22 @@ func runIPServer(ctx context.Context, log *slog.Logger, mtrcs *ipServerMetrics,
 				continue
 			}
 
-			ntsresp := nts.NewResponsePacket(cookies, serverCookie.S2C, ntsreq.UniqueID.ID)
-			nts.EncodePacket(&buf, &ntsresp)
+			encodeNTSResponse(&buf, cookies, serverCookie.Algo, serverCookie.S2C, ntsreq.UniqueID.ID, reqLen)
+		} else if ntsNAK {
+			err = nts.EncodeNAK(&buf, ntsreq.UniqueID.ID)
+			if err != nil {
+				log.LogAttrs(ctx, slog.LevelInfo, "failed to encode NTS NAK", slog.Any("error", err))
+				continue
+			}
+		}
+
+		if len(buf) > reqLen {
+			mtrcs.reqsDropped.Inc()
+			log.LogAttrs(ctx, slog.LevelInfo, "dropped request",
+				slog.String("from", clientID),
+				slog.String("cause", "response larger than request"),
+			)
+			continue
 		}
 
 		n, err = conn.WriteToUDPAddrPort(buf, srcAddr)
@@ -198,14 +361,17 @@ func runIPServer(ctx context.Context, log *slog.Logger, mtrcs *ipServerMetrics,
 		} else {
 			txid++
 		}
-		updateTXTimestamp(clientID, rxt, &txt1)
+		if allowed && !ntsNAK && rl == rateLimitAccept {
+			updateTXTimestamp(clientID, rxt, &txt1)
+		}
 
 		mtrcs.reqsServed.Inc()
 	}
 }
 
 func StartIPServer(ctx context.Context, log *slog.Logger,
-	localHost *net.UDPAddr, dscp uint8, provider *ntske.Provider) {
+	localHost *net.UDPAddr, dscp uint8, provider *ntske.Provider, acl *ACL, limiter *RateLimiter, peers *Peers,
+	keys map[uint32]ntp.SymmetricKey, control *Control) {
 	log.LogAttrs(ctx, slog.LevelInfo, "server listening via IP",
 		slog.Any("local host", localHost),
 	)
@@ -221,6 +387,7 @@ func StartIPServer(ctx context.Context, log *slog.Logger,
 		if err != nil {
 			logbase.FatalContext(ctx, log, "failed to listen for packets", slog.Any("error", err))
 		}
-		go runIPServer(ctx, log, mtrcs, conn.(*net.UDPConn), localHost.Zone, dscp, provider)
+		go runIPServer(ctx, log, mtrcs, conn.(*net.UDPConn), localHost.Zone, dscp, provider, acl, limiter, peers, keys,
+			control)
 	}
 }
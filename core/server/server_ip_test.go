@@ -0,0 +1,474 @@
+package server_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"log/slog"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"example.com/scion-time/core/client"
+	"example.com/scion-time/core/server"
+
+	"example.com/scion-time/net/ntp"
+	"example.com/scion-time/net/ntske"
+)
+
+type lockedBuffer struct {
+	mu sync.Mutex
+	b  bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.b.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.b.String()
+}
+
+// ipTestServer is the NTP server via IP shared by the tests below. Servers
+// register global metrics, so StartIPServer can only be called once per test
+// binary.
+type ipTestServer struct {
+	addr      *net.UDPAddr
+	key       ntp.SymmetricKey
+	sha1Key   ntp.SymmetricKey
+	deniedKey ntp.SymmetricKey
+	peers     *server.Peers
+	roots     *x509.CertPool
+}
+
+var (
+	ipTestServerOnce sync.Once
+	ipTestServerInst *ipTestServer
+)
+
+// startIPTestServer starts the shared server on first use. Requests of
+// 127.0.0.5 and requests authenticated with deniedKey are denied, 127.0.0.26
+// and 127.0.0.27 are symmetric mode peers with key, and all clients are limited
+// to a single request.
+func startIPTestServer(t *testing.T) *ipTestServer {
+	t.Helper()
+	ipTestServerOnce.Do(func() {
+		ctx := context.Background()
+		log := slog.New(slog.DiscardHandler)
+
+		s := &ipTestServer{
+			addr:      &net.UDPAddr{IP: net.IPv4(127, 0, 0, 4).To4(), Port: 10124},
+			key:       ntp.SymmetricKey{ID: 1, Value: []byte("0123456789abcdef")},
+			sha1Key:   ntp.SymmetricKey{ID: 2, Type: ntp.KeyTypeSHA1, Value: []byte("secret")},
+			deniedKey: ntp.SymmetricKey{ID: 3, Type: ntp.KeyTypeSHA256, Value: []byte("secret")},
+		}
+		keys := map[uint32]ntp.SymmetricKey{s.key.ID: s.key, s.sha1Key.ID: s.sha1Key, s.deniedKey.ID: s.deniedKey}
+		var err error
+		s.peers, err = server.NewPeers([]server.Peer{
+			{Addr: netip.AddrFrom4([4]byte{127, 0, 0, 26}), KeyID: s.key.ID},
+			{Addr: netip.AddrFrom4([4]byte{127, 0, 0, 27}), KeyID: s.key.ID},
+		}, keys)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		acl := server.NewACL([]server.ACLRule{{
+			Action:   server.ACLDeny,
+			Prefixes: []netip.Prefix{netip.MustParsePrefix("127.0.0.5/32")},
+			Response: server.ACLResponseDENY,
+		}, {
+			Action:   server.ACLDeny,
+			KeyIDs:   []uint32{s.deniedKey.ID},
+			Response: server.ACLResponseDENY,
+		}})
+		limiter := server.NewRateLimiter(server.RateLimitConfig{Rate: 0.001, Burst: 1})
+		control := server.NewControl(func() []server.ControlAssociation {
+			return []server.ControlAssociation{{
+				Addr:    netip.MustParseAddrPort("192.0.2.1:123"),
+				Reach:   0x01,
+				Stratum: 2,
+				RefID:   0xc0000202,
+				LastRx:  time.Now(),
+			}}
+		})
+		server.StartIPServer(ctx, log, s.addr, 0 /* DSCP */, ntske.NewProvider(), acl, limiter, s.peers, keys,
+			control)
+
+		// The NTS-KE server hands out cookies the NTP server cannot decrypt.
+		cert := newTestCertificate(t, s.addr.IP)
+		server.StartNTSKEServerIP(ctx, log, s.addr.IP, s.addr.Port, &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			NextProtos:   []string{"ntske/1"},
+			MinVersion:   tls.VersionTLS13,
+		}, ntske.NewProvider(), nil /* ACL */, server.NTSKEServerConfig{})
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.roots = x509.NewCertPool()
+		s.roots.AddCert(leaf)
+
+		ipTestServerInst = s
+	})
+	if ipTestServerInst == nil {
+		t.Fatal("failed to start shared server")
+	}
+	return ipTestServerInst
+}
+
+// measure measures the clock offset to the shared server with client c bound
+// to localIP.
+func (s *ipTestServer) measure(ctx context.Context, c *client.IPClient, localIP net.IP) error {
+	mctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+	_, _, err := client.MeasureClockOffsetIP(mctx, slog.New(slog.DiscardHandler), c,
+		&net.UDPAddr{IP: localIP}, &net.UDPAddr{IP: s.addr.IP, Port: s.addr.Port})
+	return err
+}
+
+func TestIPKissOfDeath(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	log := slog.New(slog.DiscardHandler)
+	srv := startIPTestServer(t)
+
+	deniedIP := net.IPv4(127, 0, 0, 5).To4()
+	limitedIP := net.IPv4(127, 0, 0, 6).To4()
+	ntsIP := net.IPv4(127, 0, 0, 7).To4()
+
+	measure := func(c *client.IPClient, localIP net.IP) error {
+		return srv.measure(ctx, c, localIP)
+	}
+
+	t.Run("DENY", func(t *testing.T) {
+		c := &client.IPClient{Log: log}
+		if err := measure(c, deniedIP); err == nil {
+			t.Fatal("MeasureClockOffsetIP() succeeded; want error")
+		}
+		s := c.Status()
+		if s.State != client.SourceDenied || s.KissCode != ntp.KissCodeDENY {
+			t.Errorf("Status() = %+v; want state %v with kiss code DENY", s, client.SourceDenied)
+		}
+	})
+
+	t.Run("RATE", func(t *testing.T) {
+		c := &client.IPClient{Log: log}
+		if err := measure(c, limitedIP); err != nil {
+			t.Fatalf("MeasureClockOffsetIP() failed: %v", err)
+		}
+		if s := c.Status(); s.State != client.SourceActive {
+			t.Fatalf("Status() = %+v; want state %v", s, client.SourceActive)
+		}
+		if err := measure(c, limitedIP); err == nil {
+			t.Fatal("MeasureClockOffsetIP() succeeded; want error")
+		}
+		s := c.Status()
+		if s.State != client.SourceRateLimited || s.KissCode != ntp.KissCodeRATE {
+			t.Errorf("Status() = %+v; want state %v with kiss code RATE", s, client.SourceRateLimited)
+		}
+		if d := time.Until(s.HoldoffUntil); d < 16*time.Second {
+			t.Errorf("Status().HoldoffUntil is %v from now; want at least 16s", d)
+		}
+	})
+
+	t.Run("NTSN", func(t *testing.T) {
+		c := &client.IPClient{Log: log}
+		c.Auth.Enabled = true
+		c.Auth.NTSKEFetcher.TLSConfig = tls.Config{
+			NextProtos: []string{"ntske/1"},
+			ServerName: srv.addr.IP.String(),
+			RootCAs:    srv.roots,
+			MinVersion: tls.VersionTLS13,
+		}
+		c.Auth.NTSKEFetcher.Port = strconv.Itoa(ntske.ServerPortIP)
+		c.Auth.NTSKEFetcher.Log = log
+		if err := measure(c, ntsIP); err == nil {
+			t.Fatal("MeasureClockOffsetIP() succeeded; want error")
+		}
+		s := c.Status()
+		if s.State != client.SourceActive || s.KissCode != ntp.KissCodeNTSN {
+			t.Errorf("Status() = %+v; want state %v with kiss code NTSN", s, client.SourceActive)
+		}
+	})
+}
+
+func TestNTPv5IP(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	log := slog.New(slog.DiscardHandler)
+	srv := startIPTestServer(t)
+
+	ntpv5IP := net.IPv4(127, 0, 0, 25).To4()
+
+	measure := func(c *client.IPClient, localIP net.IP) error {
+		return srv.measure(ctx, c, localIP)
+	}
+
+	t.Run("RATE", func(t *testing.T) {
+		// NTPv5 has no Kiss-o'-Death packets, rate limited requests are
+		// dropped.
+		c := &client.IPClient{Log: log, NTPv5: true}
+		if err := measure(c, ntpv5IP); err != nil {
+			t.Fatalf("MeasureClockOffsetIP() failed: %v", err)
+		}
+		if err := measure(c, ntpv5IP); err == nil {
+			t.Fatal("MeasureClockOffsetIP() succeeded; want error")
+		}
+		if s := c.Status(); s.State != client.SourceActive || s.KissCode != 0 {
+			t.Errorf("Status() = %+v; want state %v without kiss code", s, client.SourceActive)
+		}
+	})
+}
+
+func TestSymmetricIP(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	log := slog.New(slog.DiscardHandler)
+	srv := startIPTestServer(t)
+
+	symmetricIP := net.IPv4(127, 0, 0, 26).To4()
+	symmetricBadKeyIP := net.IPv4(127, 0, 0, 27).To4()
+
+	measure := func(c *client.IPClient, localIP net.IP) error {
+		return srv.measure(ctx, c, localIP)
+	}
+
+	t.Run("RATE", func(t *testing.T) {
+		// Symmetric mode requests are authenticated by a MAC, rate limited
+		// requests are dropped.
+		c := &client.IPClient{Log: log, Symmetric: true}
+		c.Auth.SymmetricKey = srv.key
+		if err := measure(c, symmetricIP); err != nil {
+			t.Fatalf("MeasureClockOffsetIP() failed: %v", err)
+		}
+		if err := measure(c, symmetricIP); err == nil {
+			t.Fatal("MeasureClockOffsetIP() succeeded; want error")
+		}
+		if s := c.Status(); s.State != client.SourceActive || s.KissCode != 0 {
+			t.Errorf("Status() = %+v; want state %v without kiss code", s, client.SourceActive)
+		}
+	})
+
+	t.Run("bad key", func(t *testing.T) {
+		c := &client.IPClient{Log: log, Symmetric: true}
+		c.Auth.SymmetricKey = ntp.SymmetricKey{ID: srv.key.ID, Value: []byte("fedcba9876543210")}
+		if err := measure(c, symmetricBadKeyIP); err == nil {
+			t.Fatal("MeasureClockOffsetIP() succeeded; want error")
+		}
+		assocs := srv.peers.Associations()
+		// Requests of peers are counted before rate limiting.
+		if len(assocs) != 2 ||
+			assocs[0].Packets != 2 || assocs[0].Authenticated != 2 ||
+			assocs[1].Packets != 0 {
+			t.Errorf("Associations() = %+v; want two authenticated packets of the first peer only", assocs)
+		}
+	})
+}
+
+func TestMACIP(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	log := slog.New(slog.DiscardHandler)
+	srv := startIPTestServer(t)
+
+	macIP := net.IPv4(127, 0, 0, 30).To4()
+	macDeniedIP := net.IPv4(127, 0, 0, 31).To4()
+	macUnknownKeyIP := net.IPv4(127, 0, 0, 32).To4()
+
+	measure := func(c *client.IPClient, localIP net.IP) error {
+		return srv.measure(ctx, c, localIP)
+	}
+
+	t.Run("RATE", func(t *testing.T) {
+		// Kiss-o'-Death packets are authenticated by a MAC as well.
+		c := &client.IPClient{Log: log}
+		c.Auth.SymmetricKey = srv.sha1Key
+		if err := measure(c, macIP); err != nil {
+			t.Fatalf("MeasureClockOffsetIP() failed: %v", err)
+		}
+		if err := measure(c, macIP); err == nil {
+			t.Fatal("MeasureClockOffsetIP() succeeded; want error")
+		}
+		s := c.Status()
+		if s.State != client.SourceRateLimited || s.KissCode != ntp.KissCodeRATE {
+			t.Errorf("Status() = %+v; want state %v with kiss code RATE", s, client.SourceRateLimited)
+		}
+	})
+
+	t.Run("DENY", func(t *testing.T) {
+		c := &client.IPClient{Log: log}
+		c.Auth.SymmetricKey = srv.deniedKey
+		if err := measure(c, macDeniedIP); err == nil {
+			t.Fatal("MeasureClockOffsetIP() succeeded; want error")
+		}
+		s := c.Status()
+		if s.State != client.SourceDenied || s.KissCode != ntp.KissCodeDENY {
+			t.Errorf("Status() = %+v; want state %v with kiss code DENY", s, client.SourceDenied)
+		}
+	})
+
+	t.Run("unknown key", func(t *testing.T) {
+		// Requests with a MAC computed with an unknown key are dropped.
+		c := &client.IPClient{Log: log}
+		c.Auth.SymmetricKey = ntp.SymmetricKey{ID: 9, Value: srv.key.Value}
+		if err := measure(c, macUnknownKeyIP); err == nil {
+			t.Fatal("MeasureClockOffsetIP() succeeded; want error")
+		}
+		if s := c.Status(); s.KissCode != 0 {
+			t.Errorf("Status() = %+v; want no kiss code", s)
+		}
+	})
+}
+
+func TestBroadcastIP(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	log := slog.New(slog.DiscardHandler)
+	srv := startIPTestServer(t)
+
+	broadcastIP := net.IPv4(127, 0, 0, 28).To4()
+	broadcastBadKeyIP := net.IPv4(127, 0, 0, 29).To4()
+
+	// Broadcast packets are sent via the loopback interface. The delay is
+	// calibrated in a unicast exchange with the server.
+	listenAddr := netip.MustParseAddrPort("0.0.0.0:10125")
+	bcfg := server.BroadcastServerConfig{
+		Address:     netip.MustParseAddrPort("127.255.255.255:10125"),
+		Interval:    50 * time.Millisecond,
+		Interleaved: true,
+		Key:         srv.key,
+	}
+	server.StartBroadcastServerIP(ctx, log, &net.UDPAddr{IP: srv.addr.IP}, 0 /* DSCP */, bcfg)
+
+	// The client logs received broadcast packets concurrently.
+	var logBuf lockedBuffer
+	clog := slog.New(slog.NewTextHandler(&logBuf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	c := &client.BroadcastClientIP{Log: clog, ListenAddr: listenAddr, Unicast: &client.IPClient{Log: clog}}
+	c.Auth.SymmetricKey = srv.key
+	defer func() { _ = c.Close() }()
+	d := &client.BroadcastClientIP{Log: log, ListenAddr: listenAddr, Unicast: &client.IPClient{Log: log}}
+	d.Auth.SymmetricKey = ntp.SymmetricKey{ID: srv.key.ID, Value: []byte("fedcba9876543210")}
+	defer func() { _ = d.Close() }()
+
+	measure := func(c *client.BroadcastClientIP, localIP net.IP) error {
+		mctx, cancel := context.WithTimeout(ctx, 1*time.Second)
+		defer cancel()
+		_, off, err := c.MeasureClockOffset(mctx,
+			&net.UDPAddr{IP: localIP}, &net.UDPAddr{IP: srv.addr.IP, Port: srv.addr.Port})
+		if err == nil && off.Abs() > 100*time.Millisecond {
+			t.Errorf("MeasureClockOffset() = %v; want offset close to 0", off)
+		}
+		return err
+	}
+	for i := range 5 {
+		if err := measure(c, broadcastIP); err != nil {
+			t.Fatalf("MeasureClockOffset() #%d failed: %v\n%s", i, err, logBuf.String())
+		}
+	}
+	const want = "auth=true interleaved=true"
+	if !strings.Contains(logBuf.String(), want) {
+		t.Errorf("client log does not contain %q:\n%s", want, logBuf.String())
+	}
+
+	if err := measure(d, broadcastBadKeyIP); err != nil {
+		t.Fatalf("MeasureClockOffset() failed: %v", err)
+	}
+	if err := measure(d, broadcastBadKeyIP); err == nil {
+		t.Error("MeasureClockOffset() succeeded with unauthenticated broadcast packets; want error")
+	}
+
+	// Without a key, clients only accept broadcast packets if explicitly
+	// configured to accept unauthenticated packets.
+	e := &client.BroadcastClientIP{Log: log, ListenAddr: listenAddr, Unicast: &client.IPClient{Log: log}}
+	defer func() { _ = e.Close() }()
+	if err := measure(e, broadcastIP); err == nil {
+		t.Error("MeasureClockOffset() succeeded without key; want error")
+	}
+}
+
+func TestControlIP(t *testing.T) {
+	srv := startIPTestServer(t)
+
+	controlIP := net.IPv4(127, 0, 0, 33).To4()
+	controlWriteIP := net.IPv4(127, 0, 0, 34).To4()
+
+	// Control queries are answered, unless rate limited, but cannot modify
+	// any variables.
+	query := func(ip net.IP, req *ntp.ControlPacket) (*ntp.ControlPacket, error) {
+		conn, err := net.DialUDP("udp", &net.UDPAddr{IP: ip}, srv.addr)
+		if err != nil {
+			return nil, err
+		}
+		defer func() { _ = conn.Close() }()
+		var buf []byte
+		ntp.EncodeControlPacket(&buf, req)
+		_, err = conn.Write(buf)
+		if err != nil {
+			return nil, err
+		}
+		err = conn.SetReadDeadline(time.Now().Add(500 * time.Millisecond))
+		if err != nil {
+			return nil, err
+		}
+		buf = make([]byte, 1024)
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		var resp ntp.ControlPacket
+		err = ntp.DecodeControlPacket(&resp, buf[:n])
+		if err != nil {
+			return nil, err
+		}
+		return &resp, nil
+	}
+
+	req := ntp.ControlPacket{
+		LVM:           2<<3 | ntp.ModeControl,
+		REMOp:         ntp.ControlOpReadVariables,
+		Sequence:      1,
+		AssociationID: 1,
+		Data:          []byte("stratum,reach"),
+	}
+	resp, err := query(controlIP, &req)
+	if err != nil {
+		t.Fatalf("failed to read variables: %v", err)
+	}
+	if resp.REMOp != ntp.ControlFlagResponse|ntp.ControlOpReadVariables || resp.Sequence != 1 ||
+		resp.AssociationID != 1 || string(resp.Data) != "stratum=2, reach=0x01\r\n" {
+		t.Errorf("unexpected response %+v with data %q", resp, resp.Data)
+	}
+	req.Sequence = 2
+	if _, err := query(controlIP, &req); err == nil {
+		t.Error("rate limited request was answered")
+	}
+
+	req = ntp.ControlPacket{
+		LVM:           2<<3 | ntp.ModeControl,
+		REMOp:         ntp.ControlOpWriteVariables,
+		Sequence:      3,
+		AssociationID: 0,
+		Data:          []byte("stratum=2"),
+	}
+	resp, err = query(controlWriteIP, &req)
+	if err != nil {
+		t.Fatalf("failed to write variables: %v", err)
+	}
+	if resp.REMOp&ntp.ControlFlagError == 0 || resp.Status>>8 != ntp.ControlErrorProhibited {
+		t.Errorf("unexpected response %+v; want error %d", resp, ntp.ControlErrorProhibited)
+	}
+}
@@ -0,0 +1,616 @@
+package server
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"net/netip"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"example.com/scion-time/base/logbase"
+	"example.com/scion-time/base/metrics"
+	"example.com/scion-time/core/timebase"
+	"example.com/scion-time/net/csptp"
+	"example.com/scion-time/net/ptp"
+	"example.com/scion-time/net/udp"
+
+	clocksync "example.com/scion-time/core/sync"
+)
+
+const (
+	// ptpMaxGrantDuration is the maximum duration of unicast transmission
+	// grants in seconds.
+	ptpMaxGrantDuration = 300
+
+	// Requests for message intervals outside of [2^ptpMinLogInterval,
+	// 2^ptpMaxLogInterval] seconds are denied, for Announce messages outside
+	// of [2^ptpMinLogAnnounceInterval, 2^ptpMaxLogInterval] seconds.
+	ptpMinLogInterval         = -4
+	ptpMinLogAnnounceInterval = 0
+	ptpMaxLogInterval         = 4
+
+	// ptpMaxTxRate is the maximum number of Announce, Sync and Follow_Up
+	// messages per second granted to all clients together.
+	ptpMaxTxRate = 4096
+
+	// ptpSyncCredit is the number of Sync messages sent to a client after a
+	// grant request or a Delay_Req. Clients that stop sending Delay_Req
+	// messages stop receiving Sync messages until they renew their grant, so
+	// that a spoofed grant request results in a bounded number of messages.
+	ptpSyncCredit = 4
+
+	// ptpMaxIdle is the maximum time the transmitter sleeps between checks
+	// for expired grants.
+	ptpMaxIdle = 1 * time.Second
+
+	ptpClientCap = 1 << 16
+)
+
+// Grants are recorded per message type transmitted by the server.
+const (
+	ptpGrantAnnounce = iota
+	ptpGrantSync
+	ptpGrantDelayResp
+	ptpNumGrants
+)
+
+type ptpIPServerMetrics struct {
+	pktsReceived prometheus.Counter
+	grantsIssued prometheus.Counter
+	grantsDenied prometheus.Counter
+	reqsServed   prometheus.Counter
+}
+
+func newPTPIPServerMetrics() *ptpIPServerMetrics {
+	return &ptpIPServerMetrics{
+		pktsReceived: promauto.NewCounter(prometheus.CounterOpts{
+			Name: metrics.PTPIPServerPktsReceivedN,
+			Help: metrics.PTPIPServerPktsReceivedH,
+		}),
+		grantsIssued: promauto.NewCounter(prometheus.CounterOpts{
+			Name: metrics.PTPIPServerGrantsIssuedN,
+			Help: metrics.PTPIPServerGrantsIssuedH,
+		}),
+		grantsDenied: promauto.NewCounter(prometheus.CounterOpts{
+			Name: metrics.PTPIPServerGrantsDeniedN,
+			Help: metrics.PTPIPServerGrantsDeniedH,
+		}),
+		reqsServed: promauto.NewCounter(prometheus.CounterOpts{
+			Name: metrics.PTPIPServerReqsServedN,
+			Help: metrics.PTPIPServerReqsServedH,
+		}),
+	}
+}
+
+var ptpIPServerMtrcs atomic.Pointer[ptpIPServerMetrics]
+
+func init() {
+	ptpIPServerMtrcs.Store(newPTPIPServerMetrics())
+}
+
+type ptpGrant struct {
+	logInterval int8
+	expiry      time.Time
+	next        time.Time
+	sequenceID  uint16
+	credit      int
+}
+
+type ptpUnicastClient struct {
+	portID csptp.PortID
+	grants [ptpNumGrants]ptpGrant
+}
+
+// ptpTransmission is an Announce or a Sync message due to be sent to a client.
+type ptpTransmission struct {
+	addr        netip.Addr
+	portID      csptp.PortID
+	grant       int
+	sequenceID  uint16
+	logInterval int8
+}
+
+// ptpServer is a PTP unicast server, see IEEE 1588-2019, 16.1. Announce and
+// Sync messages are sent to clients as long as they hold grants for them,
+// Delay_Req messages are answered as long as clients hold grants for
+// Delay_Resp messages.
+type ptpServer struct {
+	log         *slog.Logger
+	mtrcs       *ptpIPServerMetrics
+	acl         *ACL
+	limiter     *RateLimiter
+	cfg         *CSPTPServerConfig
+	portID      csptp.PortID
+	eventPort   int
+	generalPort int
+	event       *udpConn
+	general     *udpConn
+	mu          sync.Mutex
+	clients     map[netip.Addr]*ptpUnicastClient
+	// txRate is the sum of the message rates of all Announce and Sync grants
+	// in messages per 2^ptpMaxLogInterval seconds.
+	txRate int
+	wake   chan struct{}
+}
+
+func ptpGrantIndex(msgType uint8) (int, bool) {
+	switch msgType {
+	case ptp.MessageTypeAnnounce:
+		return ptpGrantAnnounce, true
+	case csptp.MessageTypeSync:
+		return ptpGrantSync, true
+	case ptp.MessageTypeDelayResp:
+		return ptpGrantDelayResp, true
+	default:
+		return 0, false
+	}
+}
+
+// ptpGrantRate returns the message rate of grant i with message interval
+// 2^logInterval seconds in messages per 2^ptpMaxLogInterval seconds.
+func ptpGrantRate(i int, logInterval int8) int {
+	switch i {
+	case ptpGrantAnnounce:
+		return 1 << (ptpMaxLogInterval - logInterval)
+	case ptpGrantSync:
+		// Sync and Follow_Up message
+		return 2 << (ptpMaxLogInterval - logInterval)
+	default:
+		return 0
+	}
+}
+
+// release removes grant i with state g from the transmission rate and resets
+// it. s.mu must be held.
+func (s *ptpServer) release(i int, g *ptpGrant) {
+	if !g.expiry.IsZero() {
+		s.txRate -= ptpGrantRate(i, g.logInterval)
+	}
+	*g = ptpGrant{}
+}
+
+// ptpInterval returns the message interval 2^logInterval seconds.
+func ptpInterval(logInterval int8) time.Duration {
+	if logInterval < 0 {
+		return time.Second >> -logInterval
+	}
+	return time.Second << logInterval
+}
+
+func (s *ptpServer) newMessage(msgType, control uint8, length, sequenceID uint16, logInterval int8) csptp.Message {
+	return csptp.Message{
+		SdoIDMessageType:    msgType,
+		PTPVersion:          csptp.PTPVersion,
+		MessageLength:       length,
+		DomainNumber:        csptp.DomainNumber,
+		MinorSdoID:          csptp.MinorSdoID,
+		FlagField:           csptp.FlagUnicast,
+		CorrectionField:     0,
+		MessageTypeSpecific: 0,
+		SourcePortIdentity:  s.portID,
+		SequenceID:          sequenceID,
+		ControlField:        control,
+		LogMessageInterval:  logInterval,
+		Timestamp:           csptp.Timestamp{},
+	}
+}
+
+// grant processes the REQUEST_UNICAST_TRANSMISSION TLV req of the client with
+// address addr and port identity portID and returns the corresponding
+// GRANT_UNICAST_TRANSMISSION TLV. A duration of 0 denies the request. Requests
+// are denied if granting them would exceed the total transmission rate of
+// ptpMaxTxRate.
+func (s *ptpServer) grant(now time.Time, addr netip.Addr, portID csptp.PortID,
+	req *ptp.UnicastTLV, allowed bool) ptp.UnicastTLV {
+	tlv := ptp.NewUnicastTLV(ptp.TLVTypeGrantUnicastTransmission, req.MessageType)
+	tlv.LogInterMessagePeriod = req.LogInterMessagePeriod
+
+	i, ok := ptpGrantIndex(req.MessageType)
+	minLogInterval := int8(ptpMinLogInterval)
+	if i == ptpGrantAnnounce {
+		minLogInterval = ptpMinLogAnnounceInterval
+	}
+	if !ok || !allowed || req.DurationField == 0 ||
+		req.LogInterMessagePeriod < minLogInterval || req.LogInterMessagePeriod > ptpMaxLogInterval {
+		s.mtrcs.grantsDenied.Inc()
+		return tlv
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cl, ok := s.clients[addr]
+	if !ok && len(s.clients) == ptpClientCap {
+		s.mtrcs.grantsDenied.Inc()
+		return tlv
+	}
+	var rate int
+	if ok && !cl.grants[i].expiry.IsZero() {
+		rate = ptpGrantRate(i, cl.grants[i].logInterval)
+	}
+	rate = ptpGrantRate(i, req.LogInterMessagePeriod) - rate
+	if s.txRate+rate > ptpMaxTxRate<<ptpMaxLogInterval {
+		s.mtrcs.grantsDenied.Inc()
+		return tlv
+	}
+	s.txRate += rate
+	if !ok {
+		cl = &ptpUnicastClient{}
+		s.clients[addr] = cl
+	}
+	cl.portID = portID
+	g := &cl.grants[i]
+	if !g.expiry.After(now) || g.logInterval != req.LogInterMessagePeriod {
+		g.next = now
+	}
+	g.logInterval = req.LogInterMessagePeriod
+	g.credit = ptpSyncCredit
+	g.expiry = now.Add(time.Duration(min(req.DurationField, ptpMaxGrantDuration)) * time.Second)
+
+	tlv.DurationField = min(req.DurationField, ptpMaxGrantDuration)
+	tlv.Flags = ptp.GrantFlagRenewalInvited
+	s.mtrcs.grantsIssued.Inc()
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+	return tlv
+}
+
+// cancel processes the CANCEL_UNICAST_TRANSMISSION TLV req of the client with
+// address addr and returns the corresponding ACKNOWLEDGE_CANCEL_UNICAST_TRANSMISSION
+// TLV.
+func (s *ptpServer) cancel(addr netip.Addr, req *ptp.UnicastTLV) ptp.UnicastTLV {
+	if i, ok := ptpGrantIndex(req.MessageType); ok {
+		s.mu.Lock()
+		if cl, ok := s.clients[addr]; ok {
+			s.release(i, &cl.grants[i])
+		}
+		s.mu.Unlock()
+	}
+	return ptp.NewUnicastTLV(ptp.TLVTypeAcknowledgeCancelUnicastTransmission, req.MessageType)
+}
+
+// delayRespGrant reports whether the client with address addr holds a grant
+// for Delay_Resp messages and returns its message interval. The Sync credit of
+// the client is restored.
+func (s *ptpServer) delayRespGrant(now time.Time, addr netip.Addr) (int8, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cl, ok := s.clients[addr]
+	if !ok || !cl.grants[ptpGrantDelayResp].expiry.After(now) {
+		return 0, false
+	}
+	cl.grants[ptpGrantSync].credit = ptpSyncCredit
+	return cl.grants[ptpGrantDelayResp].logInterval, true
+}
+
+func (s *ptpServer) handleSignaling(ctx context.Context, msg *csptp.Message, b []byte, srcAddr netip.AddrPort) {
+	target := ptp.DecodePortID(b[34:ptp.SignalingMessageLength])
+	if target != ptp.AllPorts && target != s.portID {
+		s.log.LogAttrs(ctx, slog.LevelDebug, "dropped signaling message",
+			slog.String("from", srcAddr.String()),
+			slog.String("cause", "unexpected target port identity"),
+		)
+		return
+	}
+
+	now := time.Now()
+	if s.limiter.check(s.limiter.ipClientKey(srcAddr.Addr()), now) >= rateLimitKoD {
+		s.log.LogAttrs(ctx, slog.LevelDebug, "dropped signaling message",
+			slog.String("from", srcAddr.String()),
+			slog.String("cause", "rate limit exceeded"),
+		)
+		return
+	}
+	allowed, _ := s.acl.check(aclClient{addr: srcAddr.Addr()})
+
+	resp := make([]byte, ptp.MaxMessageLength)
+	n := ptp.SignalingMessageLength
+	for b = b[ptp.SignalingMessageLength:]; len(b) != 0; {
+		typ, m, err := ptp.DecodeTLVHeader(b)
+		if err != nil {
+			s.log.LogAttrs(ctx, slog.LevelInfo, "failed to decode packet payload", slog.Any("error", err))
+			return
+		}
+		tlvb := b[:m]
+		b = b[m:]
+		if typ != ptp.TLVTypeRequestUnicastTransmission && typ != ptp.TLVTypeCancelUnicastTransmission {
+			continue
+		}
+		var tlv ptp.UnicastTLV
+		err = ptp.DecodeUnicastTLV(&tlv, tlvb)
+		if err != nil {
+			s.log.LogAttrs(ctx, slog.LevelInfo, "failed to decode packet payload", slog.Any("error", err))
+			continue
+		}
+		var resptlv ptp.UnicastTLV
+		if typ == ptp.TLVTypeRequestUnicastTransmission {
+			resptlv = s.grant(now, srcAddr.Addr(), msg.SourcePortIdentity, &tlv, allowed)
+			s.log.LogAttrs(ctx, slog.LevelDebug, "processed unicast transmission request",
+				slog.String("from", srcAddr.String()),
+				slog.Int("msg_type", int(tlv.MessageType)),
+				slog.Int("log_interval", int(tlv.LogInterMessagePeriod)),
+				slog.Uint64("duration", uint64(resptlv.DurationField)),
+			)
+		} else {
+			resptlv = s.cancel(srcAddr.Addr(), &tlv)
+		}
+		if n+ptp.EncodedUnicastTLVLength(&resptlv) > len(resp) {
+			break
+		}
+		ptp.EncodeUnicastTLV(resp[n:], &resptlv)
+		n += ptp.EncodedUnicastTLVLength(&resptlv)
+	}
+	if n == ptp.SignalingMessageLength {
+		return
+	}
+
+	respmsg := s.newMessage(ptp.MessageTypeSignaling, csptp.ControlOther,
+		uint16(n), msg.SequenceID, csptp.LogMessageInterval)
+	csptp.EncodeMessage(resp[:csptp.MinMessageLength], &respmsg)
+	ptp.EncodePortID(resp[34:ptp.SignalingMessageLength], msg.SourcePortIdentity)
+	_, _ = s.general.writeTo(ctx, s.log, resp[:n], srcAddr)
+}
+
+func (s *ptpServer) handleDelayReq(ctx context.Context, msg *csptp.Message, rxt time.Time, srcAddr netip.AddrPort) {
+	logInterval, ok := s.delayRespGrant(time.Now(), srcAddr.Addr())
+	if !ok {
+		s.log.LogAttrs(ctx, slog.LevelDebug, "dropped request",
+			slog.String("from", srcAddr.String()),
+			slog.String("cause", "no grant"),
+		)
+		return
+	}
+
+	resp := make([]byte, ptp.DelayRespMessageLength)
+	respmsg := s.newMessage(ptp.MessageTypeDelayResp, ptp.ControlDelayResp,
+		ptp.DelayRespMessageLength, msg.SequenceID, logInterval)
+	respmsg.CorrectionField = msg.CorrectionField
+	respmsg.Timestamp = csptp.TimestampFromTime(rxt)
+	csptp.EncodeMessage(resp[:csptp.MinMessageLength], &respmsg)
+	ptp.EncodePortID(resp[csptp.MinMessageLength:], msg.SourcePortIdentity)
+	_, ok = s.general.writeTo(ctx, s.log, resp,
+		netip.AddrPortFrom(srcAddr.Addr(), uint16(s.generalPort)))
+	if !ok {
+		return
+	}
+
+	s.mtrcs.reqsServed.Inc()
+}
+
+func (s *ptpServer) run(ctx context.Context, conn *udpConn, event bool) {
+	buf := make([]byte, ptp.MaxMessageLength)
+	oob := make([]byte, udp.TimestampLen())
+	for {
+		buf = buf[:cap(buf)]
+		oob = oob[:cap(oob)]
+		n, oobn, flags, srcAddr, err := conn.c.ReadMsgUDPAddrPort(buf, oob)
+		if err != nil {
+			s.log.LogAttrs(ctx, slog.LevelError, "failed to read packet", slog.Any("error", err))
+			continue
+		}
+		if flags != 0 {
+			s.log.LogAttrs(ctx, slog.LevelError, "failed to read packet", slog.Int("flags", flags))
+			continue
+		}
+		oob = oob[:oobn]
+		rxt, err := udp.TimestampFromOOBData(oob)
+		if err != nil {
+			rxt = timebase.Now()
+			s.log.LogAttrs(ctx, slog.LevelError, "failed to read packet rx timestamp", slog.Any("error", err))
+		}
+		buf = buf[:n]
+		s.mtrcs.pktsReceived.Inc()
+
+		var msg csptp.Message
+		err = csptp.DecodeMessage(&msg, buf)
+		if err != nil {
+			s.log.LogAttrs(ctx, slog.LevelInfo, "failed to decode packet payload", slog.Any("error", err))
+			continue
+		}
+		if len(buf) != int(msg.MessageLength) || msg.PTPVersion&0xf != csptp.PTPVersion&0xf ||
+			msg.DomainNumber != csptp.DomainNumber {
+			s.log.LogAttrs(ctx, slog.LevelInfo, "failed to validate packet payload",
+				slog.String("from", srcAddr.String()))
+			continue
+		}
+
+		switch {
+		case msg.SdoIDMessageType == ptp.MessageTypeDelayReq && event:
+			s.handleDelayReq(ctx, &msg, rxt, srcAddr)
+		case msg.SdoIDMessageType == ptp.MessageTypeSignaling && !event:
+			s.handleSignaling(ctx, &msg, buf, srcAddr)
+		default:
+			s.log.LogAttrs(ctx, slog.LevelDebug, "dropped packet",
+				slog.String("from", srcAddr.String()),
+				slog.String("cause", "unexpected message type"),
+				slog.Int("msg_type", int(msg.SdoIDMessageType)),
+				slog.Bool("event", event),
+			)
+		}
+	}
+}
+
+// dueTransmissions appends the Announce and Sync messages due at time now to
+// ts, releases expired grants, removes clients without valid grants and
+// returns the time at which the next message is due. Sync messages are only
+// due as long as the client has Sync credit left.
+func (s *ptpServer) dueTransmissions(now time.Time, ts []ptpTransmission) ([]ptpTransmission, time.Time) {
+	next := now.Add(ptpMaxIdle)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for addr, cl := range s.clients {
+		valid := cl.grants[ptpGrantDelayResp].expiry.After(now)
+		for _, i := range []int{ptpGrantAnnounce, ptpGrantSync} {
+			g := &cl.grants[i]
+			if !g.expiry.After(now) {
+				s.release(i, g)
+				continue
+			}
+			valid = true
+			if !g.next.After(now) {
+				if i != ptpGrantSync || g.credit != 0 {
+					ts = append(ts, ptpTransmission{
+						addr:        addr,
+						portID:      cl.portID,
+						grant:       i,
+						sequenceID:  g.sequenceID,
+						logInterval: g.logInterval,
+					})
+					g.sequenceID++
+					if i == ptpGrantSync {
+						g.credit--
+					}
+				}
+				g.next = g.next.Add(ptpInterval(g.logInterval))
+				if !g.next.After(now) {
+					g.next = now.Add(ptpInterval(g.logInterval))
+				}
+			}
+			if g.next.Before(next) {
+				next = g.next
+			}
+		}
+		if !valid {
+			delete(s.clients, addr)
+		}
+	}
+	return ts, next
+}
+
+func (s *ptpServer) transmit(ctx context.Context, b []byte, t *ptpTransmission) {
+	switch t.grant {
+	case ptpGrantAnnounce:
+		now := timebase.Now()
+		msg := s.newMessage(ptp.MessageTypeAnnounce, csptp.ControlOther,
+			ptp.AnnounceMessageLength, t.sequenceID, t.logInterval)
+		msg.Timestamp = csptp.TimestampFromTime(now)
+		utcOffset, ok := s.cfg.utcOffset(now)
+		if ok {
+			msg.FlagField |= csptp.FlagCurrentUTCOffsetValid
+		}
+		a := ptp.Announce{
+			CurrentUTCOffset: utcOffset,
+			DS:               csptpServerStateDS(s.cfg, clocksync.CurrentState(), now),
+		}
+		b = b[:ptp.AnnounceMessageLength]
+		csptp.EncodeMessage(b[:csptp.MinMessageLength], &msg)
+		ptp.EncodeAnnounce(b[csptp.MinMessageLength:], &a)
+		_, _ = s.general.writeTo(ctx, s.log, b, netip.AddrPortFrom(t.addr, uint16(s.generalPort)))
+	case ptpGrantSync:
+		msg := s.newMessage(csptp.MessageTypeSync, csptp.ControlSync,
+			csptp.MinMessageLength, t.sequenceID, t.logInterval)
+		msg.FlagField |= csptp.FlagTwoStep
+		b = b[:csptp.MinMessageLength]
+		csptp.EncodeMessage(b, &msg)
+		txt, ok := s.event.writeTo(ctx, s.log, b, netip.AddrPortFrom(t.addr, uint16(s.eventPort)))
+		if !ok {
+			return
+		}
+		msg = s.newMessage(csptp.MessageTypeFollowUp, csptp.ControlFollowUp,
+			csptp.MinMessageLength, t.sequenceID, t.logInterval)
+		msg.Timestamp = csptp.TimestampFromTime(txt)
+		csptp.EncodeMessage(b, &msg)
+		_, _ = s.general.writeTo(ctx, s.log, b, netip.AddrPortFrom(t.addr, uint16(s.generalPort)))
+	}
+}
+
+func (s *ptpServer) runTransmitter(ctx context.Context) {
+	b := make([]byte, ptp.MaxMessageLength)
+	var ts []ptpTransmission
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		case <-s.wake:
+		}
+		var next time.Time
+		ts, next = s.dueTransmissions(time.Now(), ts[:0])
+		for i := range ts {
+			s.transmit(ctx, b, &ts[i])
+		}
+		timer.Reset(time.Until(next))
+	}
+}
+
+// StartPTPServerIP starts a PTP unicast server on the PTP event and general
+// ports of localHost. If localHost.Port is not 0, the server uses it as event
+// port and the next port as general port instead, e.g., for tests. Signaling
+// messages are subject to access control by acl and rate limiting by limiter.
+func StartPTPServerIP(ctx context.Context, log *slog.Logger,
+	localHost *net.UDPAddr, dscp uint8, acl *ACL, limiter *RateLimiter, cfg CSPTPServerConfig) {
+	mtrcs := ptpIPServerMtrcs.Load()
+
+	log.LogAttrs(ctx, slog.LevelInfo, "PTP server listening via IP",
+		slog.Any("local host", localHost.IP),
+	)
+
+	eventPort, generalPort := csptp.EventPortIP, csptp.GeneralPortIP
+	if localHost.Port != 0 {
+		if localHost.Port < 0 || localHost.Port >= 1<<16-1 {
+			logbase.FatalContext(ctx, log, "unexpected listener port",
+				slog.Int("port", localHost.Port))
+		}
+		eventPort, generalPort = localHost.Port, localHost.Port+1
+	}
+
+	if cfg.ClockID == 0 {
+		cfg.ClockID = csptpClockID(localHost.IP)
+	}
+
+	s := &ptpServer{
+		log:     log,
+		mtrcs:   mtrcs,
+		acl:     acl,
+		limiter: limiter,
+		cfg:     &cfg,
+		portID: csptp.PortID{
+			ClockID: cfg.ClockID,
+			Port:    1,
+		},
+		eventPort:   eventPort,
+		generalPort: generalPort,
+		clients:     make(map[netip.Addr]*ptpUnicastClient),
+		wake:        make(chan struct{}, 1),
+	}
+
+	lc := net.ListenConfig{
+		Control: udp.SetsockoptReuseAddrPort,
+	}
+	for _, localHostPort := range []int{eventPort, generalPort} {
+		address := net.JoinHostPort(localHost.IP.String(), strconv.Itoa(localHostPort))
+		conn, err := lc.ListenPacket(ctx, "udp", address)
+		if err != nil {
+			logbase.FatalContext(ctx, log, "failed to listen for packets", slog.Any("error", err))
+		}
+		c, err := newUDPConn(conn.(*net.UDPConn))
+		if err != nil {
+			logbase.FatalContext(ctx, log, "failed to duplicate connection", slog.Any("error", err))
+		}
+		err = udp.EnableTimestamping(c.c, localHost.Zone)
+		if err != nil {
+			log.LogAttrs(ctx, slog.LevelError, "failed to enable timestamping", slog.Any("error", err))
+		}
+		err = udp.SetDSCP(c.c, dscp)
+		if err != nil {
+			log.LogAttrs(ctx, slog.LevelInfo, "failed to set DSCP", slog.Any("error", err))
+		}
+		if localHostPort == eventPort {
+			s.event = c
+		} else {
+			s.general = c
+		}
+	}
+	go s.run(ctx, s.event, true /* event */)
+	go s.run(ctx, s.general, false /* event */)
+	go s.runTransmitter(ctx)
+}
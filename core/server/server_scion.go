@@ -14,6 +14,7 @@ import (
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 
+	"github.com/scionproto/scion/pkg/daemon"
 	"github.com/scionproto/scion/pkg/drkey"
 	"github.com/scionproto/scion/pkg/slayers"
 	"github.com/scionproto/scion/pkg/spao"
@@ -39,6 +40,9 @@ type scionServerMetrics struct {
 	pktsForwarded     prometheus.Counter
 	pktsAuthenticated prometheus.Counter
 	reqsAccepted      prometheus.Counter
+	reqsDenied        prometheus.Counter
+	reqsRateLimited   prometheus.Counter
+	reqsDropped       prometheus.Counter
 	reqsServed        prometheus.Counter
 }
 
@@ -60,6 +64,18 @@ func newSCIONServerMetrics() *scionServerMetrics {
 			Name: metrics.SCIONServerReqsAcceptedN,
 			Help: metrics.SCIONServerReqsAcceptedH,
 		}),
+		reqsDenied: promauto.NewCounter(prometheus.CounterOpts{
+			Name: metrics.SCIONServerReqsDeniedN,
+			Help: metrics.SCIONServerReqsDeniedH,
+		}),
+		reqsRateLimited: promauto.NewCounter(prometheus.CounterOpts{
+			Name: metrics.SCIONServerReqsRateLimitedN,
+			Help: metrics.SCIONServerReqsRateLimitedH,
+		}),
+		reqsDropped: promauto.NewCounter(prometheus.CounterOpts{
+			Name: metrics.SCIONServerReqsDroppedN,
+			Help: metrics.SCIONServerReqsDroppedH,
+		}),
 		reqsServed: promauto.NewCounter(prometheus.CounterOpts{
 			Name: metrics.SCIONServerReqsServedN,
 			Help: metrics.SCIONServerReqsServedH,
@@ -67,572 +83,749 @@ func newSCIONServerMetrics() *scionServerMetrics {
 	}
 }
 
-func runSCIONServer(ctx context.Context, log *slog.Logger, mtrcs *scionServerMetrics,
-	conn *net.UDPConn, localHostIface string, localHostPort int, dscp uint8,
-	fetcher *scion.Fetcher, provider *ntske.Provider) {
-	defer func() { _ = conn.Close() }()
+// scionPacketConn reads and writes raw SCION packets on an underlay
+// connection. It implements the packet handling shared by all SCION servers:
+// SCMP echo and traceroute replies as well as the forwarding of packets
+// received on the end host port.
+type scionPacketConn struct {
+	conn          *net.UDPConn
+	localConnPort int
+	txid          uint32
+	buf           []byte
+	oob           []byte
+
+	scionLayer slayers.SCION
+	hbhLayer   slayers.HopByHopExtnSkipper
+	e2eLayer   slayers.EndToEndExtn
+	udpLayer   slayers.UDP
+	scmpLayer  slayers.SCMP
+	parser     *gopacket.DecodingLayerParser
+	decoded    []gopacket.LayerType
+	buffer     gopacket.SerializeBuffer
+	options    gopacket.SerializeOptions
+	tsOpt      *slayers.EndToEndOption
+}
 
-	localConnPort := conn.LocalAddr().(*net.UDPAddr).Port
+func newSCIONPacketConn(conn *net.UDPConn) *scionPacketConn {
+	c := &scionPacketConn{
+		conn:          conn,
+		localConnPort: conn.LocalAddr().(*net.UDPAddr).Port,
+		buf:           make([]byte, scion.MTU),
+		oob:           make([]byte, udp.TimestampLen()),
+		decoded:       make([]gopacket.LayerType, 4),
+		buffer:        gopacket.NewSerializeBuffer(),
+		options: gopacket.SerializeOptions{
+			ComputeChecksums: true,
+			FixLengths:       true,
+		},
+		tsOpt: &slayers.EndToEndOption{},
+	}
+	c.scionLayer.RecyclePaths()
+	c.udpLayer.SetNetworkLayerForChecksum(&c.scionLayer)
+	c.scmpLayer.SetNetworkLayerForChecksum(&c.scionLayer)
+	c.parser = gopacket.NewDecodingLayerParser(
+		slayers.LayerTypeSCION, &c.scionLayer, &c.hbhLayer, &c.e2eLayer, &c.udpLayer, &c.scmpLayer,
+	)
+	c.parser.IgnoreUnsupported = true
+	return c
+}
 
-	err := udp.EnableTimestamping(conn, localHostIface)
+// read reads the next packet into c.buf and returns its underlay source
+// address and its rx timestamp.
+func (c *scionPacketConn) read(ctx context.Context, log *slog.Logger) (
+	lastHop netip.AddrPort, rxt time.Time, ok bool) {
+	c.buf = c.buf[:cap(c.buf)]
+	c.oob = c.oob[:cap(c.oob)]
+	n, oobn, flags, lastHop, err := c.conn.ReadMsgUDPAddrPort(c.buf, c.oob)
 	if err != nil {
-		log.LogAttrs(ctx, slog.LevelError, "failed to enable timestamping", slog.Any("error", err))
+		log.LogAttrs(ctx, slog.LevelError, "failed to read packet", slog.Any("error", err))
+		return netip.AddrPort{}, time.Time{}, false
 	}
-	err = udp.SetDSCP(conn, dscp)
+	if flags != 0 {
+		log.LogAttrs(ctx, slog.LevelError, "failed to read packet", slog.Int("flags", flags))
+		return netip.AddrPort{}, time.Time{}, false
+	}
+	c.oob = c.oob[:oobn]
+	rxt, err = udp.TimestampFromOOBData(c.oob)
 	if err != nil {
-		log.LogAttrs(ctx, slog.LevelInfo, "failed to set DSCP", slog.Any("error", err))
+		c.oob = c.oob[:0]
+		rxt = timebase.Now()
+		log.LogAttrs(ctx, slog.LevelError, "failed to read packet rx timestamp", slog.Any("error", err))
 	}
+	c.buf = c.buf[:n]
+	return lastHop, rxt, true
+}
 
-	var txid uint32
-	buf := make([]byte, scion.MTU)
-	oob := make([]byte, udp.TimestampLen())
+// decode decodes the packet in c.buf and reports whether it is a SCION/UDP or
+// a SCMP packet.
+func (c *scionPacketConn) decode(ctx context.Context, log *slog.Logger) bool {
+	err := c.parser.DecodeLayers(c.buf, &c.decoded)
+	if err != nil {
+		log.LogAttrs(ctx, slog.LevelInfo, "failed to decode packet", slog.Any("error", err))
+		return false
+	}
+	validType := len(c.decoded) >= 2 && (c.decoded[len(c.decoded)-1] == slayers.LayerTypeSCIONUDP ||
+		c.decoded[len(c.decoded)-1] == slayers.LayerTypeSCMP)
+	if !validType {
+		log.LogAttrs(ctx, slog.LevelInfo, "failed to decode packet", slog.String("cause", "unexpected type or structure"))
+		return false
+	}
+	return true
+}
 
-	var (
-		scionLayer slayers.SCION
-		hbhLayer   slayers.HopByHopExtnSkipper
-		e2eLayer   slayers.EndToEndExtn
-		udpLayer   slayers.UDP
-		scmpLayer  slayers.SCMP
-	)
-	scionLayer.RecyclePaths()
-	udpLayer.SetNetworkLayerForChecksum(&scionLayer)
-	scmpLayer.SetNetworkLayerForChecksum(&scionLayer)
-	parser := gopacket.NewDecodingLayerParser(
-		slayers.LayerTypeSCION, &scionLayer, &hbhLayer, &e2eLayer, &udpLayer, &scmpLayer,
-	)
-	parser.IgnoreUnsupported = true
-	decoded := make([]gopacket.LayerType, 4)
-	buffer := gopacket.NewSerializeBuffer()
-	options := gopacket.SerializeOptions{
-		ComputeChecksums: true,
-		FixLengths:       true,
+func (c *scionPacketConn) isSCMP() bool {
+	return c.decoded[len(c.decoded)-1] == slayers.LayerTypeSCMP
+}
+
+func (c *scionPacketConn) hasEndToEndExtn() bool {
+	return len(c.decoded) >= 3 && c.decoded[len(c.decoded)-2] == slayers.LayerTypeEndToEndExtn
+}
+
+func (c *scionPacketConn) serializeLayer(l gopacket.SerializableLayer) {
+	err := l.SerializeTo(c.buffer, c.options)
+	if err != nil {
+		panic(err)
 	}
+	c.buffer.PushLayer(l.LayerType())
+}
 
-	var authBuf, authMAC, authMockKey []byte
-	if fetcher != nil {
-		authBuf = make([]byte, spao.MACBufferSize)
-		authMAC = make([]byte, scion.PacketAuthMACLen)
-		if scion.UseMockKeys() {
-			authMockKey = new(drkey.Key)[:]
-		}
+func (c *scionPacketConn) clearBuffer() {
+	err := c.buffer.Clear()
+	if err != nil {
+		panic(err)
 	}
-	tsOpt := &slayers.EndToEndOption{}
+}
 
-	for {
-		buf = buf[:cap(buf)]
-		oob = oob[:cap(oob)]
-		n, oobn, flags, lastHop, err := conn.ReadMsgUDPAddrPort(buf, oob)
+// reverse turns the decoded SCION header into the header of a reply.
+func (c *scionPacketConn) reverse() {
+	var err error
+	c.scionLayer.DstIA, c.scionLayer.SrcIA = c.scionLayer.SrcIA, c.scionLayer.DstIA
+	c.scionLayer.DstAddrType, c.scionLayer.SrcAddrType = c.scionLayer.SrcAddrType, c.scionLayer.DstAddrType
+	c.scionLayer.RawDstAddr, c.scionLayer.RawSrcAddr = c.scionLayer.RawSrcAddr, c.scionLayer.RawDstAddr
+	c.scionLayer.Path, err = c.scionLayer.Path.Reverse()
+	if err != nil {
+		panic(err)
+	}
+}
+
+// writeTo writes the packet in c.buffer to addr and returns its tx timestamp
+// if available.
+func (c *scionPacketConn) writeTo(ctx context.Context, log *slog.Logger, addr netip.AddrPort) (
+	txt time.Time, ok bool) {
+	n, err := c.conn.WriteToUDPAddrPort(c.buffer.Bytes(), addr)
+	if err != nil || n != len(c.buffer.Bytes()) {
+		log.LogAttrs(ctx, slog.LevelError, "failed to write packet", slog.Any("error", err))
+		return time.Time{}, false
+	}
+	txt, id, err := udp.ReadTXTimestamp(c.conn)
+	if err != nil {
+		log.LogAttrs(ctx, slog.LevelError, "failed to read packet tx timestamp",
+			slog.Any("error", err))
+		return time.Time{}, true
+	} else if id != c.txid {
+		log.LogAttrs(ctx, slog.LevelError, "failed to read packet tx timestamp",
+			slog.Uint64("id", uint64(id)), slog.Uint64("expected", uint64(c.txid)))
+		c.txid = id + 1
+		return time.Time{}, true
+	}
+	c.txid++
+	return txt, true
+}
+
+// handleSCMP replies to SCMP echo and traceroute requests and forwards SCMP
+// echo replies received on the end host port. It reports whether the packet
+// has been forwarded.
+func (c *scionPacketConn) handleSCMP(ctx context.Context, log *slog.Logger, lastHop netip.AddrPort) bool {
+	if c.scmpLayer.TypeCode.Type() == slayers.SCMPTypeEchoReply {
+		var scmpEcho slayers.SCMPEcho
+		err := scmpEcho.DecodeFromBytes(c.scmpLayer.Payload, gopacket.NilDecodeFeedback)
 		if err != nil {
-			log.LogAttrs(ctx, slog.LevelError, "failed to read packet", slog.Any("error", err))
-			continue
+			log.LogAttrs(ctx, slog.LevelInfo, "failed to decode packet", slog.Any("error", err))
+			return false
 		}
-		if flags != 0 {
-			log.LogAttrs(ctx, slog.LevelError, "failed to read packet", slog.Int("flags", flags))
-			continue
+		if c.localConnPort != scion.EndhostPort || scmpEcho.Identifier == scion.EndhostPort {
+			log.LogAttrs(ctx, slog.LevelInfo, "failed to forward packet",
+				slog.String("cause", "unexpected underlay or L4 destination port"),
+				slog.Int("underlay_dst_port", c.localConnPort),
+				slog.Int("l4_dst_port", int(scmpEcho.Identifier)))
+			return false
 		}
-		oob = oob[:oobn]
-		rxt, err := udp.TimestampFromOOBData(oob)
-		if err != nil {
-			oob = oob[:0]
-			rxt = timebase.Now()
-			log.LogAttrs(ctx, slog.LevelError, "failed to read packet rx timestamp", slog.Any("error", err))
+		dstAddr, ok := netip.AddrFromSlice(c.scionLayer.RawDstAddr)
+		if !ok {
+			panic("unexpected IP address byte slice")
 		}
-		buf = buf[:n]
-		mtrcs.pktsReceived.Inc()
-
-		err = parser.DecodeLayers(buf, &decoded)
-		if err != nil {
-			log.LogAttrs(ctx, slog.LevelInfo, "failed to decode packet", slog.Any("error", err))
-			continue
+		c.clearBuffer()
+		c.serializeLayer(gopacket.Payload(c.scmpLayer.Payload))
+		c.serializeLayer(&c.scmpLayer)
+		if c.scionLayer.NextHdr == slayers.End2EndClass {
+			c.serializeLayer(&c.e2eLayer)
 		}
-		validType := len(decoded) >= 2 && (decoded[len(decoded)-1] == slayers.LayerTypeSCIONUDP ||
-			decoded[len(decoded)-1] == slayers.LayerTypeSCMP)
-		if !validType {
-			log.LogAttrs(ctx, slog.LevelInfo, "failed to decode packet", slog.String("cause", "unexpected type or structure"))
-			continue
+		c.serializeLayer(&c.scionLayer)
+		_, ok = c.writeTo(ctx, log, netip.AddrPortFrom(dstAddr, scmpEcho.Identifier))
+		return ok
+	}
+
+	var payload gopacket.Payload
+	switch c.scmpLayer.TypeCode.Type() {
+	case slayers.SCMPTypeEchoRequest:
+		payload = gopacket.Payload(c.scmpLayer.Payload)
+		c.scmpLayer.TypeCode = slayers.CreateSCMPTypeCode(
+			slayers.SCMPTypeEchoReply, 0 /* code */)
+	case slayers.SCMPTypeTracerouteRequest:
+		payload = gopacket.Payload(c.scmpLayer.Payload)
+		c.scmpLayer.TypeCode = slayers.CreateSCMPTypeCode(
+			slayers.SCMPTypeTracerouteReply, 0 /* code */)
+	default:
+		log.LogAttrs(ctx, slog.LevelInfo, "failed to handle packet",
+			slog.String("cause", "unexpected SCMP message type"),
+			slog.Uint64("type", uint64(c.scmpLayer.TypeCode.Type())),
+			slog.Uint64("code", uint64(c.scmpLayer.TypeCode.Code())))
+		return false
+	}
+
+	c.reverse()
+	c.scionLayer.NextHdr = slayers.L4SCMP
+
+	c.clearBuffer()
+	c.serializeLayer(payload)
+	c.serializeLayer(&c.scmpLayer)
+	c.serializeLayer(&c.scionLayer)
+	_, _ = c.writeTo(ctx, log, lastHop)
+	return false
+}
+
+// forward forwards a SCION/UDP packet received on the end host port to its
+// L4 destination port on the local host. The packet's rx timestamp is passed
+// on in a timestamp option. forward reports whether the packet has been
+// forwarded.
+func (c *scionPacketConn) forward(ctx context.Context, log *slog.Logger, dstAddr netip.Addr) bool {
+	if c.localConnPort != scion.EndhostPort || c.udpLayer.DstPort == scion.EndhostPort {
+		log.LogAttrs(ctx, slog.LevelInfo, "failed to forward packet",
+			slog.String("cause", "unexpected underlay or L4 destination port"),
+			slog.Int("underlay_dst_port", c.localConnPort),
+			slog.Int("l4_dst_port", int(c.udpLayer.DstPort)))
+		return false
+	}
+
+	c.clearBuffer()
+	c.serializeLayer(gopacket.Payload(c.udpLayer.Payload))
+	c.serializeLayer(&c.udpLayer)
+
+	if len(c.oob) != 0 {
+		c.tsOpt.OptType = scion.OptTypeTimestamp
+		c.tsOpt.OptData = c.oob
+		c.tsOpt.OptAlign[0] = 0
+		c.tsOpt.OptAlign[1] = 0
+		c.tsOpt.OptDataLen = 0
+		c.tsOpt.ActualLength = 0
+
+		if c.scionLayer.NextHdr != slayers.End2EndClass {
+			c.e2eLayer = slayers.EndToEndExtn{}
+			c.e2eLayer.NextHdr = slayers.L4UDP
+			c.scionLayer.NextHdr = slayers.End2EndClass
 		}
+		c.e2eLayer.Options = append(c.e2eLayer.Options, c.tsOpt)
+	}
 
-		if decoded[len(decoded)-1] == slayers.LayerTypeSCMP {
-			if scmpLayer.TypeCode.Type() == slayers.SCMPTypeEchoReply {
-				var scmpEcho slayers.SCMPEcho
-				err = scmpEcho.DecodeFromBytes(scmpLayer.Payload, gopacket.NilDecodeFeedback)
-				if err != nil {
-					log.LogAttrs(ctx, slog.LevelInfo, "failed to decode packet", slog.Any("error", err))
-					continue
-				}
-				if localConnPort != scion.EndhostPort || scmpEcho.Identifier == scion.EndhostPort {
-					log.LogAttrs(ctx, slog.LevelInfo, "failed to forward packet",
-						slog.String("cause", "unexpected underlay or L4 destination port"),
-						slog.Int("underlay_dst_port", localConnPort),
-						slog.Int("l4_dst_port", int(scmpEcho.Identifier)))
-					continue
-				}
-				dstAddr, ok := netip.AddrFromSlice(scionLayer.RawDstAddr)
-				if !ok {
-					panic("unexpected IP address byte slice")
-				}
-				dstAddrPort := netip.AddrPortFrom(dstAddr, scmpEcho.Identifier)
-				err = buffer.Clear()
-				if err != nil {
-					panic(err)
-				}
-				payload := gopacket.Payload(scmpLayer.Payload)
-				err = payload.SerializeTo(buffer, options)
-				if err != nil {
-					panic(err)
-				}
-				buffer.PushLayer(payload.LayerType())
-				err = scmpLayer.SerializeTo(buffer, options)
-				if err != nil {
-					panic(err)
-				}
-				buffer.PushLayer(udpLayer.LayerType())
-				if scionLayer.NextHdr == slayers.End2EndClass {
-					err = e2eLayer.SerializeTo(buffer, options)
-					if err != nil {
-						panic(err)
-					}
-					buffer.PushLayer(e2eLayer.LayerType())
-				}
-				err = scionLayer.SerializeTo(buffer, options)
-				if err != nil {
-					panic(err)
-				}
-				buffer.PushLayer(scionLayer.LayerType())
-				m, err := conn.WriteToUDPAddrPort(buffer.Bytes(), dstAddrPort)
-				if err != nil || m != len(buffer.Bytes()) {
-					log.LogAttrs(ctx, slog.LevelError, "failed to write packet", slog.Any("error", err))
-					continue
-				}
-				_, id, err := udp.ReadTXTimestamp(conn)
-				if err != nil {
-					log.LogAttrs(ctx, slog.LevelError, "failed to read packet tx timestamp",
-						slog.Any("error", err))
-				} else if id != txid {
-					log.LogAttrs(ctx, slog.LevelError, "failed to read packet tx timestamp",
-						slog.Uint64("id", uint64(id)), slog.Uint64("expected", uint64(txid)))
-					txid = id + 1
-				} else {
-					txid++
-				}
-				mtrcs.pktsForwarded.Inc()
-				continue
-			}
+	if c.scionLayer.NextHdr == slayers.End2EndClass {
+		c.serializeLayer(&c.e2eLayer)
+	}
+	c.serializeLayer(&c.scionLayer)
 
-			var payload gopacket.Payload
-			switch scmpLayer.TypeCode.Type() {
-			case slayers.SCMPTypeEchoRequest:
-				payload = gopacket.Payload(scmpLayer.Payload)
-				scmpLayer.TypeCode = slayers.CreateSCMPTypeCode(
-					slayers.SCMPTypeEchoReply, 0 /* code */)
-			case slayers.SCMPTypeTracerouteRequest:
-				payload = gopacket.Payload(scmpLayer.Payload)
-				scmpLayer.TypeCode = slayers.CreateSCMPTypeCode(
-					slayers.SCMPTypeTracerouteReply, 0 /* code */)
-			default:
-				log.LogAttrs(ctx, slog.LevelInfo, "failed to handle packet",
-					slog.String("cause", "unexpected SCMP message type"),
-					slog.Uint64("type", uint64(scmpLayer.TypeCode.Type())),
-					slog.Uint64("code", uint64(scmpLayer.TypeCode.Code())))
-				continue
-			}
+	_, ok := c.writeTo(ctx, log, netip.AddrPortFrom(dstAddr, c.udpLayer.DstPort))
+	return ok
+}
 
-			scionLayer.DstIA, scionLayer.SrcIA = scionLayer.SrcIA, scionLayer.DstIA
-			scionLayer.DstAddrType, scionLayer.SrcAddrType = scionLayer.SrcAddrType, scionLayer.DstAddrType
-			scionLayer.RawDstAddr, scionLayer.RawSrcAddr = scionLayer.RawSrcAddr, scionLayer.RawDstAddr
-			scionLayer.Path, err = scionLayer.Path.Reverse()
-			if err != nil {
-				panic(err)
-			}
-			scionLayer.NextHdr = slayers.L4SCMP
+// forwardedRxTime returns the rx timestamp passed on in a timestamp option by
+// the forwarding end host port listener, if any.
+func (c *scionPacketConn) forwardedRxTime() (time.Time, bool) {
+	if !c.hasEndToEndExtn() {
+		return time.Time{}, false
+	}
+	tsOpt, err := c.e2eLayer.FindOption(scion.OptTypeTimestamp)
+	if err != nil {
+		return time.Time{}, false
+	}
+	rxt, err := udp.TimestampFromOOBData(tsOpt.OptData)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return rxt, true
+}
 
-			err = buffer.Clear()
-			if err != nil {
-				panic(err)
-			}
+// authenticate verifies the SPAO authenticator of the decoded SCION/UDP
+// packet, if any, and returns the DRKey used s.t. the response can be
+// authenticated with the same key. A packet that carries an invalid
+// authenticator is rejected.
+func (c *scionPacketConn) authenticate(ctx context.Context, log *slog.Logger,
+	fetcher *scion.Fetcher, auth *scionPacketAuth, rxt time.Time, srcAddr, dstAddr netip.Addr) (
+	authOpt *slayers.EndToEndOption, authKey []byte, authenticated bool, ok bool) {
+	if fetcher == nil || !c.hasEndToEndExtn() {
+		return nil, nil, false, true
+	}
+	authOpt, err := c.e2eLayer.FindOption(slayers.OptTypeAuthenticator)
+	if err != nil {
+		return nil, nil, false, true
+	}
+	spi, algo := scion.PacketAuthOptMetadata(authOpt)
+	if spi != scion.PacketAuthSPIClient || algo != scion.PacketAuthAlgorithm {
+		return nil, nil, false, true
+	}
+	hostASKey, err := fetcher.FetchHostASKey(ctx, drkey.HostASMeta{
+		ProtoId:  scion.DRKeyProtocolTS,
+		Validity: rxt,
+		SrcIA:    c.scionLayer.DstIA,
+		DstIA:    c.scionLayer.SrcIA,
+		SrcHost:  dstAddr.String(),
+	})
+	if err != nil {
+		log.LogAttrs(ctx, slog.LevelError, "failed to fetch DRKey level 2: host-AS", slog.Any("error", err))
+		return nil, nil, false, true
+	}
+	hostHostKey, err := scion.DeriveHostHostKey(hostASKey, srcAddr.String())
+	if err != nil {
+		panic(err)
+	}
+	authKey = hostHostKey.Key[:]
+	if auth.mockKey != nil {
+		authKey = auth.mockKey
+	}
+	_, err = spao.ComputeAuthCMAC(
+		spao.MACInput{
+			Key:        authKey,
+			Header:     slayers.PacketAuthOption{EndToEndOption: authOpt},
+			ScionLayer: &c.scionLayer,
+			PldType:    slayers.L4UDP,
+			Pld:        c.buf[len(c.buf)-int(c.udpLayer.Length):],
+		},
+		auth.buf,
+		auth.mac,
+	)
+	if err != nil {
+		panic(err)
+	}
+	authenticated = subtle.ConstantTimeCompare(scion.PacketAuthOptMAC(authOpt), auth.mac) != 0
+	if !authenticated {
+		log.LogAttrs(ctx, slog.LevelInfo, "failed to authenticate packet")
+		return nil, nil, false, false
+	}
+	return authOpt, authKey, true, true
+}
 
-			err = payload.SerializeTo(buffer, options)
-			if err != nil {
-				panic(err)
-			}
-			buffer.PushLayer(payload.LayerType())
+// serializeAuthenticated serializes an authenticator for the payload and L4
+// layers in c.buffer as well as the SCION header of the response.
+func (c *scionPacketConn) serializeAuthenticated(auth *scionPacketAuth,
+	authOpt *slayers.EndToEndOption, authKey []byte) {
+	scion.PreparePacketAuthOpt(authOpt, scion.PacketAuthSPIServer, scion.PacketAuthAlgorithm)
+	_, err := spao.ComputeAuthCMAC(
+		spao.MACInput{
+			Key:        authKey,
+			Header:     slayers.PacketAuthOption{EndToEndOption: authOpt},
+			ScionLayer: &c.scionLayer,
+			PldType:    c.scionLayer.NextHdr,
+			Pld:        c.buffer.Bytes(),
+		},
+		auth.buf,
+		scion.PacketAuthOptMAC(authOpt),
+	)
+	if err != nil {
+		panic(err)
+	}
 
-			err = scmpLayer.SerializeTo(buffer, options)
-			if err != nil {
-				panic(err)
-			}
-			buffer.PushLayer(scmpLayer.LayerType())
+	e2eExtn := slayers.EndToEndExtn{}
+	e2eExtn.NextHdr = c.scionLayer.NextHdr
+	e2eExtn.Options = []*slayers.EndToEndOption{authOpt}
+	c.serializeLayer(&e2eExtn)
 
-			err = scionLayer.SerializeTo(buffer, options)
-			if err != nil {
-				panic(err)
-			}
-			buffer.PushLayer(scionLayer.LayerType())
+	c.scionLayer.NextHdr = slayers.End2EndClass
+}
 
-			m, err := conn.WriteToUDPAddrPort(buffer.Bytes(), lastHop)
-			if err != nil || m != len(buffer.Bytes()) {
-				log.LogAttrs(ctx, slog.LevelError, "failed to write packet", slog.Any("error", err))
-				continue
-			}
-			_, id, err := udp.ReadTXTimestamp(conn)
-			if err != nil {
-				log.LogAttrs(ctx, slog.LevelError, "failed to read packet tx timestamp",
-					slog.Any("error", err))
-			} else if id != txid {
-				log.LogAttrs(ctx, slog.LevelError, "failed to read packet tx timestamp",
-					slog.Uint64("id", uint64(id)), slog.Uint64("expected", uint64(txid)))
-				txid = id + 1
-			} else {
-				txid++
-			}
+type scionPacketAuth struct {
+	buf     []byte
+	mac     []byte
+	mockKey []byte
+}
+
+func newSCIONPacketAuth(fetcher *scion.Fetcher) *scionPacketAuth {
+	auth := &scionPacketAuth{}
+	if fetcher != nil {
+		auth.buf = make([]byte, spao.MACBufferSize)
+		auth.mac = make([]byte, scion.PacketAuthMACLen)
+		if scion.UseMockKeys() {
+			auth.mockKey = new(drkey.Key)[:]
+		}
+	}
+	return auth
+}
 
+func runSCIONServer(ctx context.Context, log *slog.Logger, mtrcs *scionServerMetrics,
+	conn *net.UDPConn, localHostIface string, localHostPort int, dscp uint8,
+	fetcher *scion.Fetcher, provider *ntske.Provider, acl *ACL, limiter *RateLimiter, peers *Peers,
+	keys map[uint32]ntp.SymmetricKey) {
+	defer func() { _ = conn.Close() }()
+
+	err := udp.EnableTimestamping(conn, localHostIface)
+	if err != nil {
+		log.LogAttrs(ctx, slog.LevelError, "failed to enable timestamping", slog.Any("error", err))
+	}
+	err = udp.SetDSCP(conn, dscp)
+	if err != nil {
+		log.LogAttrs(ctx, slog.LevelInfo, "failed to set DSCP", slog.Any("error", err))
+	}
+
+	c := newSCIONPacketConn(conn)
+	auth := newSCIONPacketAuth(fetcher)
+
+	for {
+		lastHop, rxt, ok := c.read(ctx, log)
+		if !ok {
 			continue
 		}
+		mtrcs.pktsReceived.Inc()
 
-		if len(buf) < int(udpLayer.Length) {
+		if !c.decode(ctx, log) {
+			continue
+		}
+
+		if c.isSCMP() {
+			if c.handleSCMP(ctx, log, lastHop) {
+				mtrcs.pktsForwarded.Inc()
+			}
+			continue
+		}
+
+		if len(c.buf) < int(c.udpLayer.Length) {
 			log.LogAttrs(ctx, slog.LevelInfo, "failed to decode packet", slog.String("cause", "unexpected structure"))
 			continue
 		}
 
-		srcAddr, ok := netip.AddrFromSlice(scionLayer.RawSrcAddr)
+		srcAddr, ok := netip.AddrFromSlice(c.scionLayer.RawSrcAddr)
 		if !ok {
 			panic("unexpected IP address byte slice")
 		}
-		dstAddr, ok := netip.AddrFromSlice(scionLayer.RawDstAddr)
+		dstAddr, ok := netip.AddrFromSlice(c.scionLayer.RawDstAddr)
 		if !ok {
 			panic("unexpected IP address byte slice")
 		}
 
-		if int(udpLayer.DstPort) != localHostPort {
-			if localConnPort != scion.EndhostPort || udpLayer.DstPort == scion.EndhostPort {
-				log.LogAttrs(ctx, slog.LevelInfo, "failed to forward packet",
-					slog.String("cause", "unexpected underlay or L4 destination port"),
-					slog.Int("underlay_dst_port", localConnPort),
-					slog.Int("l4_dst_port", int(udpLayer.DstPort)))
-				continue
+		if int(c.udpLayer.DstPort) != localHostPort {
+			if c.forward(ctx, log, dstAddr) {
+				mtrcs.pktsForwarded.Inc()
 			}
+			continue
+		}
 
-			dstAddrPort := netip.AddrPortFrom(dstAddr, udpLayer.DstPort)
-			payload := gopacket.Payload(udpLayer.Payload)
+		if localHostPort == scion.EndhostPort {
+			log.LogAttrs(ctx, slog.LevelInfo, "failed to handle packet",
+				slog.String("cause", "unexpected underlay or L4 destination port"),
+				slog.Int("underlay_dst_port", c.localConnPort),
+				slog.Int("l4_dst_port", int(c.udpLayer.DstPort)))
+			continue
+		}
 
-			err = buffer.Clear()
-			if err != nil {
-				panic(err)
-			}
+		clientID := c.scionLayer.SrcIA.String() + "," + srcAddr.String()
 
-			err = payload.SerializeTo(buffer, options)
-			if err != nil {
-				panic(err)
-			}
-			buffer.PushLayer(payload.LayerType())
+		// Requests are rate limited by address before any SPAO or NTS
+		// processing.
+		rl := limiter.check(limiter.scionClientKey(c.scionLayer.SrcIA, srcAddr), time.Now())
+		if rl == rateLimitDrop {
+			mtrcs.reqsRateLimited.Inc()
+			mtrcs.reqsDropped.Inc()
+			log.LogAttrs(ctx, slog.LevelDebug, "dropped request",
+				slog.String("from", clientID),
+				slog.String("cause", "rate limit exceeded"),
+			)
+			continue
+		}
 
-			err = udpLayer.SerializeTo(buffer, options)
-			if err != nil {
-				panic(err)
-			}
-			buffer.PushLayer(udpLayer.LayerType())
-
-			if len(oob) != 0 {
-				tsOpt.OptType = scion.OptTypeTimestamp
-				tsOpt.OptData = oob
-				tsOpt.OptAlign[0] = 0
-				tsOpt.OptAlign[1] = 0
-				tsOpt.OptDataLen = 0
-				tsOpt.ActualLength = 0
-
-				if scionLayer.NextHdr != slayers.End2EndClass {
-					e2eLayer = slayers.EndToEndExtn{}
-					e2eLayer.NextHdr = slayers.L4UDP
-					scionLayer.NextHdr = slayers.End2EndClass
-				}
-				e2eLayer.Options = append(e2eLayer.Options, tsOpt)
-			}
+		authOpt, authKey, authenticated, ok := c.authenticate(ctx, log, fetcher, auth, rxt, srcAddr, dstAddr)
+		if !ok {
+			continue
+		}
+		if authenticated {
+			mtrcs.pktsAuthenticated.Inc()
+		}
 
-			if scionLayer.NextHdr == slayers.End2EndClass {
-				err = e2eLayer.SerializeTo(buffer, options)
-				if err != nil {
-					panic(err)
-				}
-				buffer.PushLayer(e2eLayer.LayerType())
-			}
+		reqLen := len(c.udpLayer.Payload)
 
-			err = scionLayer.SerializeTo(buffer, options)
-			if err != nil {
-				panic(err)
-			}
-			buffer.PushLayer(scionLayer.LayerType())
+		var ntpreq ntp.Packet
+		err = ntp.DecodePacket(&ntpreq, c.udpLayer.Payload)
+		if err != nil {
+			log.LogAttrs(ctx, slog.LevelInfo, "failed to decode packet payload", slog.Any("error", err))
+			continue
+		}
 
-			m, err := conn.WriteToUDPAddrPort(buffer.Bytes(), dstAddrPort)
-			if err != nil || m != len(buffer.Bytes()) {
-				log.LogAttrs(ctx, slog.LevelError, "failed to write packet", slog.Any("error", err))
-				continue
+		var ntpreqV5 ntp.PacketV5
+		var ntpreqV5Ext ntpv5Ext
+		v5 := ntpreq.Version() == ntp.Version5
+		if v5 {
+			err = ntp.DecodePacketV5(&ntpreqV5, c.udpLayer.Payload)
+			if err == nil {
+				err = decodeNTPv5Ext(&ntpreqV5Ext, c.udpLayer.Payload)
 			}
-			_, id, err := udp.ReadTXTimestamp(conn)
 			if err != nil {
-				log.LogAttrs(ctx, slog.LevelError, "failed to read packet tx timestamp",
-					slog.Any("error", err))
-			} else if id != txid {
-				log.LogAttrs(ctx, slog.LevelError, "failed to read packet tx timestamp",
-					slog.Uint64("id", uint64(id)), slog.Uint64("expected", uint64(txid)))
-				txid = id + 1
-			} else {
-				txid++
+				log.LogAttrs(ctx, slog.LevelInfo, "failed to decode NTPv5 packet", slog.Any("error", err))
+				continue
 			}
+		}
 
-			mtrcs.pktsForwarded.Inc()
-		} else {
-			if localHostPort == scion.EndhostPort {
-				log.LogAttrs(ctx, slog.LevelInfo, "failed to handle packet",
-					slog.String("cause", "unexpected underlay or L4 destination port"),
-					slog.Int("underlay_dst_port", localConnPort),
-					slog.Int("l4_dst_port", int(udpLayer.DstPort)))
+		symmetric := !v5 && ntpreq.Mode() == ntp.ModeSymmetricActive
+		mac := !v5 && ntp.HasMAC(c.udpLayer.Payload)
+
+		ntsAuthenticated := false
+		var ntsreq nts.Packet
+		var serverCookie ntske.ServerCookie
+		var ntsNAK bool
+		if len(c.udpLayer.Payload) > ntp.PacketLen && (!v5 || nts.ContainsUniqueID(c.udpLayer.Payload)) && !mac {
+			err = nts.DecodePacket(&ntsreq, c.udpLayer.Payload)
+			if err != nil {
+				log.LogAttrs(ctx, slog.LevelInfo, "failed to decode NTS packet", slog.Any("error", err))
 				continue
 			}
 
-			var (
-				authOpt *slayers.EndToEndOption
-				authKey []byte
-			)
-			authenticated := false
-
-			if fetcher != nil && len(decoded) >= 3 &&
-				decoded[len(decoded)-2] == slayers.LayerTypeEndToEndExtn {
-				authOpt, err = e2eLayer.FindOption(slayers.OptTypeAuthenticator)
-				if err == nil {
-					spi, algo := scion.PacketAuthOptMetadata(authOpt)
-					if spi == scion.PacketAuthSPIClient && algo == scion.PacketAuthAlgorithm {
-						hostASKey, err := fetcher.FetchHostASKey(ctx, drkey.HostASMeta{
-							ProtoId:  scion.DRKeyProtocolTS,
-							Validity: rxt,
-							SrcIA:    scionLayer.DstIA,
-							DstIA:    scionLayer.SrcIA,
-							SrcHost:  dstAddr.String(),
-						})
-						if err != nil {
-							log.LogAttrs(ctx, slog.LevelError, "failed to fetch DRKey level 2: host-AS", slog.Any("error", err))
-						} else {
-							hostHostKey, err := scion.DeriveHostHostKey(hostASKey, srcAddr.String())
-							if err != nil {
-								panic(err)
-							}
-							authKey = hostHostKey.Key[:]
-							if authMockKey != nil {
-								authKey = authMockKey
-							}
-							_, err = spao.ComputeAuthCMAC(
-								spao.MACInput{
-									Key:        authKey,
-									Header:     slayers.PacketAuthOption{EndToEndOption: authOpt},
-									ScionLayer: &scionLayer,
-									PldType:    slayers.L4UDP,
-									Pld:        buf[len(buf)-int(udpLayer.Length):],
-								},
-								authBuf,
-								authMAC,
-							)
-							if err != nil {
-								panic(err)
-							}
-							authenticated = subtle.ConstantTimeCompare(scion.PacketAuthOptMAC(authOpt), authMAC) != 0
-							if !authenticated {
-								log.LogAttrs(ctx, slog.LevelInfo, "failed to authenticate packet")
-								continue
-							}
-							mtrcs.pktsAuthenticated.Inc()
-						}
-					}
-				}
+			cookie, err := ntsreq.FirstCookie()
+			if err != nil {
+				log.LogAttrs(ctx, slog.LevelInfo, "failed to get cookie", slog.Any("error", err))
+				continue
 			}
 
-			var ntpreq ntp.Packet
-			err = ntp.DecodePacket(&ntpreq, udpLayer.Payload)
+			var encryptedCookie ntske.EncryptedServerCookie
+			err = encryptedCookie.Decode(cookie)
 			if err != nil {
-				log.LogAttrs(ctx, slog.LevelInfo, "failed to decode packet payload", slog.Any("error", err))
+				log.LogAttrs(ctx, slog.LevelInfo, "failed to decode cookie", slog.Any("error", err))
 				continue
 			}
 
-			ntsAuthenticated := false
-			var ntsreq nts.Packet
-			var serverCookie ntske.ServerCookie
-			if len(udpLayer.Payload) > ntp.PacketLen {
-				err = nts.DecodePacket(&ntsreq, udpLayer.Payload)
-				if err != nil {
-					log.LogAttrs(ctx, slog.LevelInfo, "failed to decode NTS packet", slog.Any("error", err))
-					continue
-				}
-
-				cookie, err := ntsreq.FirstCookie()
-				if err != nil {
-					log.LogAttrs(ctx, slog.LevelInfo, "failed to get cookie", slog.Any("error", err))
-					continue
-				}
-
-				var encryptedCookie ntske.EncryptedServerCookie
-				err = encryptedCookie.Decode(cookie)
-				if err != nil {
-					log.LogAttrs(ctx, slog.LevelInfo, "failed to decode cookie", slog.Any("error", err))
-					continue
-				}
-
-				key, ok := provider.Get(int(encryptedCookie.ID))
-				if !ok {
-					log.LogAttrs(ctx, slog.LevelInfo, "failed to get key")
-					continue
-				}
-
+			key, ok := provider.Get(int(encryptedCookie.ID))
+			if !ok {
+				log.LogAttrs(ctx, slog.LevelInfo, "failed to get key")
+				ntsNAK = true
+			} else {
 				serverCookie, err = encryptedCookie.Decrypt(key.Value)
 				if err != nil {
 					log.LogAttrs(ctx, slog.LevelInfo, "failed to decrypt cookie", slog.Any("error", err))
-					continue
+					ntsNAK = true
 				}
+			}
 
-				err = nts.ProcessRequest(udpLayer.Payload, serverCookie.C2S, &ntsreq)
+			if !ntsNAK {
+				err = nts.ProcessRequest(c.udpLayer.Payload, serverCookie.Algo, serverCookie.C2S, &ntsreq)
 				if err != nil {
 					log.LogAttrs(ctx, slog.LevelInfo, "failed to process NTS packet", slog.Any("error", err))
 					continue
 				}
 				ntsAuthenticated = true
 			}
+		}
 
-			err = ntp.ValidateRequest(&ntpreq, udpLayer.SrcPort)
+		switch {
+		case v5:
+			err = ntp.ValidateRequestV5(&ntpreqV5)
+		case symmetric:
+			err = ntp.ValidateSymmetricRequest(&ntpreq)
+		default:
+			err = ntp.ValidateRequest(&ntpreq, c.udpLayer.SrcPort)
+		}
+		if err != nil {
+			log.LogAttrs(ctx, slog.LevelInfo, "failed to validate packet payload", slog.Any("error", err))
+			continue
+		}
+
+		var macKey ntp.SymmetricKey
+		if symmetric {
+			macKey, err = peers.accept(c.scionLayer.SrcIA, srcAddr, &ntpreq, c.udpLayer.Payload,
+				ntsAuthenticated || authenticated, rxt)
+			if err != nil {
+				log.LogAttrs(ctx, slog.LevelInfo, "failed to accept symmetric mode request",
+					slog.String("from", clientID), slog.Any("error", err))
+				continue
+			}
+		} else if mac {
+			macKey, err = verifyMAC(keys, c.udpLayer.Payload)
 			if err != nil {
-				log.LogAttrs(ctx, slog.LevelInfo, "failed to validate packet payload", slog.Any("error", err))
+				log.LogAttrs(ctx, slog.LevelInfo, "failed to verify MAC",
+					slog.String("from", clientID), slog.Any("error", err))
 				continue
 			}
+		}
+
+		var identity string
+		if ntsAuthenticated {
+			identity = serverCookie.Identity
+		}
 
-			clientID := scionLayer.SrcIA.String() + "," + srcAddr.String()
+		allowed, kissCode := acl.check(aclClient{
+			scion:    true,
+			ia:       c.scionLayer.SrcIA,
+			addr:     srcAddr,
+			nts:      ntsAuthenticated,
+			spao:     authenticated,
+			identity: identity,
+			keyID:    macKey.ID,
+		})
+		if !allowed {
+			mtrcs.reqsDenied.Inc()
+			if kissCode == 0 || v5 || symmetric {
+				log.LogAttrs(ctx, slog.LevelDebug, "dropped request",
+					slog.String("from", clientID),
+					slog.String("cause", "access denied"),
+				)
+				continue
+			}
+		}
 
-			mtrcs.reqsAccepted.Inc()
-			log.LogAttrs(ctx, slog.LevelDebug, "received request",
-				slog.Time("at", rxt),
+		if identity != "" {
+			rl = max(rl, limiter.check(limiter.identityKey(identity), time.Now()))
+		}
+		if rl == rateLimitKoD || rl == rateLimitDrop {
+			mtrcs.reqsRateLimited.Inc()
+		}
+		if rl == rateLimitDrop || (!allowed || v5 || symmetric) && rl == rateLimitKoD {
+			mtrcs.reqsDropped.Inc()
+			log.LogAttrs(ctx, slog.LevelDebug, "dropped request",
 				slog.String("from", clientID),
-				slog.Bool("auth", authenticated),
-				slog.Bool("ntsauth", ntsAuthenticated),
-				slog.Any("data", ntp.PacketLogValuer{Pkt: &ntpreq}),
+				slog.String("cause", "rate limit exceeded"),
 			)
+			continue
+		}
 
-			var txt0 time.Time
-			var ntpresp ntp.Packet
+		mtrcs.reqsAccepted.Inc()
+		var data slog.LogValuer = ntp.PacketLogValuer{Pkt: &ntpreq}
+		if v5 {
+			data = ntp.PacketV5LogValuer{Pkt: &ntpreqV5}
+		}
+		log.LogAttrs(ctx, slog.LevelDebug, "received request",
+			slog.Time("at", rxt),
+			slog.String("from", clientID),
+			slog.Bool("auth", authenticated),
+			slog.Bool("ntsauth", ntsAuthenticated),
+			slog.Bool("macauth", macKey.ID != 0),
+			slog.Any("data", data),
+		)
+
+		var txt0 time.Time
+		var ntpresp ntp.Packet
+		var ntprespV5 ntp.PacketV5
+		switch {
+		case v5 && ntsNAK:
+			handleNAKRequestV5(&ntpreqV5, &ntprespV5)
+		case v5 && rl == rateLimitAcceptBasic:
+			handleBasicRequestV5(&ntpreqV5, &rxt, &txt0, &ntprespV5)
+		case v5:
+			handleRequestV5(clientID, &ntpreqV5, &rxt, &txt0, &ntprespV5)
+		case ntsNAK:
+			handleKoDRequest(&ntpreq, ntp.KissCodeNTSN, 0 /* poll */, &ntpresp)
+		case symmetric:
+			handleSymmetricRequest(clientID, rl == rateLimitAcceptBasic, &ntpreq, &rxt, &txt0, &ntpresp)
+			peers.exchanged(c.scionLayer.SrcIA, srcAddr, &ntpreq, &ntpresp, rxt)
+		case !allowed:
+			handleKoDRequest(&ntpreq, kissCode, 0 /* poll */, &ntpresp)
+		case rl == rateLimitKoD:
+			handleKoDRequest(&ntpreq, ntp.KissCodeRATE, limiter.poll(), &ntpresp)
+		case rl == rateLimitAcceptBasic:
+			handleBasicRequest(&ntpreq, &rxt, &txt0, &ntpresp)
+		default:
 			handleRequest(clientID, &ntpreq, &rxt, &txt0, &ntpresp)
+		}
 
-			scionLayer.TrafficClass = dscp << 2
-			scionLayer.DstIA, scionLayer.SrcIA = scionLayer.SrcIA, scionLayer.DstIA
-			scionLayer.DstAddrType, scionLayer.SrcAddrType = scionLayer.SrcAddrType, scionLayer.DstAddrType
-			scionLayer.RawDstAddr, scionLayer.RawSrcAddr = scionLayer.RawSrcAddr, scionLayer.RawDstAddr
-			scionLayer.Path, err = scionLayer.Path.Reverse()
-			if err != nil {
-				panic(err)
-			}
-			scionLayer.NextHdr = slayers.L4UDP
-
-			udpLayer.DstPort, udpLayer.SrcPort = udpLayer.SrcPort, udpLayer.DstPort
-			ntp.EncodePacket(&udpLayer.Payload, &ntpresp)
-
-			if ntsAuthenticated {
-				var cookies [][]byte
-				key := provider.Current()
-				addedCookie := false
-				for range len(ntsreq.Cookies) + len(ntsreq.CookiePlaceholders) {
-					encryptedCookie, err := serverCookie.EncryptWithNonce(key.Value, key.ID)
-					if err != nil {
-						log.LogAttrs(ctx, slog.LevelInfo, "failed to encrypt cookie", slog.Any("error", err))
-						continue
-					}
-					cookie := encryptedCookie.Encode()
-					cookies = append(cookies, cookie)
-					addedCookie = true
-				}
-				if !addedCookie {
-					log.LogAttrs(ctx, slog.LevelInfo, "failed to add at least one cookie")
-					continue
-				}
-
-				ntsresp := nts.NewResponsePacket(cookies, serverCookie.S2C, ntsreq.UniqueID.ID)
-				nts.EncodePacket(&udpLayer.Payload, &ntsresp)
-			}
-
-			payload := gopacket.Payload(udpLayer.Payload)
-
-			err = buffer.Clear()
-			if err != nil {
-				panic(err)
-			}
+		c.scionLayer.TrafficClass = dscp << 2
+		c.reverse()
+		c.scionLayer.NextHdr = slayers.L4UDP
 
-			err = payload.SerializeTo(buffer, options)
-			if err != nil {
-				panic(err)
+		c.udpLayer.DstPort, c.udpLayer.SrcPort = c.udpLayer.SrcPort, c.udpLayer.DstPort
+		if v5 {
+			ntp.EncodePacketV5(&c.udpLayer.Payload, &ntprespV5)
+			if !ntsNAK {
+				c.udpLayer.Payload = appendNTPv5Ext(c.udpLayer.Payload, &ntpreqV5Ext)
 			}
-			buffer.PushLayer(payload.LayerType())
-
-			err = udpLayer.SerializeTo(buffer, options)
-			if err != nil {
-				panic(err)
+		} else {
+			ntp.EncodePacket(&c.udpLayer.Payload, &ntpresp)
+			if macKey.ID != 0 {
+				c.udpLayer.Payload = ntp.AppendMAC(c.udpLayer.Payload, macKey)
 			}
-			buffer.PushLayer(udpLayer.LayerType())
-
-			if authenticated {
-				scion.PreparePacketAuthOpt(authOpt, scion.PacketAuthSPIServer, scion.PacketAuthAlgorithm)
-				_, err = spao.ComputeAuthCMAC(
-					spao.MACInput{
-						Key:        authKey,
-						Header:     slayers.PacketAuthOption{EndToEndOption: authOpt},
-						ScionLayer: &scionLayer,
-						PldType:    scionLayer.NextHdr,
-						Pld:        buffer.Bytes(),
-					},
-					authBuf,
-					scion.PacketAuthOptMAC(authOpt),
-				)
-				if err != nil {
-					panic(err)
-				}
-
-				e2eExtn := slayers.EndToEndExtn{}
-				e2eExtn.NextHdr = scionLayer.NextHdr
-				e2eExtn.Options = []*slayers.EndToEndOption{authOpt}
+		}
 
-				err = e2eExtn.SerializeTo(buffer, options)
+		if ntsAuthenticated {
+			var cookies [][]byte
+			key := provider.Current()
+			addedCookie := false
+			for range len(ntsreq.Cookies) + len(ntsreq.CookiePlaceholders) {
+				encryptedCookie, err := serverCookie.EncryptWithNonce(key.Value, key.ID)
 				if err != nil {
-					panic(err)
+					log.LogAttrs(ctx, slog.LevelInfo, "failed to encrypt cookie", slog.Any("error", err))
+					continue
 				}
-				buffer.PushLayer(e2eExtn.LayerType())
-
-				scionLayer.NextHdr = slayers.End2EndClass
+				cookie := encryptedCookie.Encode()
+				cookies = append(cookies, cookie)
+				addedCookie = true
 			}
-
-			err = scionLayer.SerializeTo(buffer, options)
-			if err != nil {
-				panic(err)
-			}
-			buffer.PushLayer(scionLayer.LayerType())
-
-			n, err = conn.WriteToUDPAddrPort(buffer.Bytes(), lastHop)
-			if err != nil || n != len(buffer.Bytes()) {
-				log.LogAttrs(ctx, slog.LevelError, "failed to write packet", slog.Any("error", err))
+			if !addedCookie {
+				log.LogAttrs(ctx, slog.LevelInfo, "failed to add at least one cookie")
 				continue
 			}
-			txt1, id, err := udp.ReadTXTimestamp(conn)
+
+			encodeNTSResponse(&c.udpLayer.Payload, cookies, serverCookie.Algo, serverCookie.S2C, ntsreq.UniqueID.ID, reqLen)
+		} else if ntsNAK {
+			err = nts.EncodeNAK(&c.udpLayer.Payload, ntsreq.UniqueID.ID)
 			if err != nil {
-				txt1 = txt0
-				log.LogAttrs(ctx, slog.LevelError, "failed to read packet tx timestamp",
-					slog.Any("error", err))
-			} else if id != txid {
-				txt1 = txt0
-				log.LogAttrs(ctx, slog.LevelError, "failed to read packet tx timestamp",
-					slog.Uint64("id", uint64(id)), slog.Uint64("expected", uint64(txid)))
-				txid = id + 1
-			} else {
-				txid++
+				log.LogAttrs(ctx, slog.LevelInfo, "failed to encode NTS NAK", slog.Any("error", err))
+				continue
 			}
-			updateTXTimestamp(clientID, rxt, &txt1)
+		}
 
-			mtrcs.reqsServed.Inc()
+		if len(c.udpLayer.Payload) > reqLen {
+			mtrcs.reqsDropped.Inc()
+			log.LogAttrs(ctx, slog.LevelInfo, "dropped request",
+				slog.String("from", clientID),
+				slog.String("cause", "response larger than request"),
+			)
+			continue
+		}
+
+		c.clearBuffer()
+		c.serializeLayer(gopacket.Payload(c.udpLayer.Payload))
+		c.serializeLayer(&c.udpLayer)
+		if authenticated {
+			c.serializeAuthenticated(auth, authOpt, authKey)
+		}
+		c.serializeLayer(&c.scionLayer)
+
+		txt1, ok := c.writeTo(ctx, log, lastHop)
+		if !ok {
+			continue
+		}
+		if txt1.IsZero() {
+			txt1 = txt0
 		}
+		if allowed && !ntsNAK && rl == rateLimitAccept {
+			updateTXTimestamp(clientID, rxt, &txt1)
+		}
+
+		mtrcs.reqsServed.Inc()
 	}
 }
 
 func StartSCIONServer(ctx context.Context, log *slog.Logger,
-	daemonAddr string, localHost *net.UDPAddr, dscp uint8, provider *ntske.Provider) {
+	daemonAddr string, localHost *net.UDPAddr, dscp uint8, provider *ntske.Provider,
+	acl *ACL, limiter *RateLimiter, peers *Peers, keys map[uint32]ntp.SymmetricKey) {
+	startSCIONServer(ctx, log, func() daemon.Connector {
+		return scion.NewDaemonConnector(ctx, daemonAddr)
+	}, localHost, dscp, provider, acl, limiter, peers, keys)
+}
+
+// StartSCIONServerWithConnector starts a SCION server that uses the daemon
+// connector dc in all of its goroutines.
+func StartSCIONServerWithConnector(ctx context.Context, log *slog.Logger,
+	dc daemon.Connector, localHost *net.UDPAddr, dscp uint8, provider *ntske.Provider,
+	acl *ACL, limiter *RateLimiter, peers *Peers, keys map[uint32]ntp.SymmetricKey) {
+	startSCIONServer(ctx, log, func() daemon.Connector {
+		return dc
+	}, localHost, dscp, provider, acl, limiter, peers, keys)
+}
+
+func startSCIONServer(ctx context.Context, log *slog.Logger,
+	newDaemonConnector func() daemon.Connector, localHost *net.UDPAddr, dscp uint8, provider *ntske.Provider,
+	acl *ACL, limiter *RateLimiter, peers *Peers, keys map[uint32]ntp.SymmetricKey) {
 	mtrcs := newSCIONServerMetrics()
 
 	log.LogAttrs(ctx, slog.LevelInfo,
@@ -651,12 +844,12 @@ func StartSCIONServer(ctx context.Context, log *slog.Logger,
 	for _, localHostPort := range []int{localHost.Port, scion.EndhostPort} {
 		address := net.JoinHostPort(localHost.IP.String(), strconv.Itoa(localHostPort))
 		for range scionServerNumGoroutine {
-			fetcher := scion.NewFetcher(scion.NewDaemonConnector(ctx, daemonAddr))
+			fetcher := scion.NewFetcher(newDaemonConnector())
 			conn, err := lc.ListenPacket(ctx, "udp", address)
 			if err != nil {
 				logbase.FatalContext(ctx, log, "failed to listen for packets", slog.Any("error", err))
 			}
-			go runSCIONServer(ctx, log, mtrcs, conn.(*net.UDPConn), localHost.Zone, localHost.Port, dscp, fetcher, provider)
+			go runSCIONServer(ctx, log, mtrcs, conn.(*net.UDPConn), localHost.Zone, localHost.Port, dscp, fetcher, provider, acl, limiter, peers, keys)
 		}
 	}
 }
@@ -684,5 +877,5 @@ func StartSCIONDispatcher(ctx context.Context, log *slog.Logger,
 		logbase.FatalContext(ctx, log, "failed to listen for packets", slog.Any("error", err))
 	}
 	go runSCIONServer(ctx, log, mtrcs, conn.(*net.UDPConn), localHost.Zone, localHost.Port,
-		0 /* DSCP */, nil /* DRKey fetcher */, nil /* NTSKE provider */)
+		0 /* DSCP */, nil /* DRKey fetcher */, nil /* NTSKE provider */, nil /* ACL */, nil /* rate limiter */, nil /* peers */, nil /* keys */)
 }
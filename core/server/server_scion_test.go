@@ -0,0 +1,310 @@
+package server_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"log/slog"
+	"math/big"
+	"net"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/scionproto/scion/pkg/addr"
+	"github.com/scionproto/scion/pkg/daemon"
+
+	"example.com/scion-time/core/client"
+	"example.com/scion-time/core/server"
+
+	"example.com/scion-time/net/ntp"
+	"example.com/scion-time/net/ntske"
+	"example.com/scion-time/net/scion"
+	"example.com/scion-time/net/scion/sciontest"
+	"example.com/scion-time/net/udp"
+)
+
+func newTestCertificate(t *testing.T, ip net.IP) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: ip.String()},
+		NotBefore:    now.Add(-1 * time.Hour),
+		NotAfter:     now.Add(1 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IPAddresses:  []net.IP{ip},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestSCIONExchange(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	log := slog.New(slog.DiscardHandler)
+
+	serverIA := addr.MustParseIA("1-ff00:0:111")
+	clientIA := addr.MustParseIA("1-ff00:0:112")
+	serverIP := net.IPv4(127, 0, 0, 2).To4()
+	clientIP := net.IPv4(127, 0, 0, 3).To4()
+
+	router, err := sciontest.StartRouter(ctx, log, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverDC := sciontest.NewDaemonConnector(serverIA, router.Addr())
+	clientDC := sciontest.NewDaemonConnector(clientIA, router.Addr())
+
+	cert := newTestCertificate(t, serverIP)
+	provider := ntske.NewProvider()
+	peers, err := server.NewPeers([]server.Peer{{IA: clientIA, Addr: netip.AddrFrom4([4]byte(clientIP))}}, nil /* keys */)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmacKey := ntp.SymmetricKey{ID: 1, Value: []byte("0123456789abcdef")}
+	sha256Key := ntp.SymmetricKey{ID: 2, Type: ntp.KeyTypeSHA256, Value: []byte("secret")}
+	keys := map[uint32]ntp.SymmetricKey{cmacKey.ID: cmacKey, sha256Key.ID: sha256Key}
+	server.StartSCIONServerWithConnector(ctx, log, serverDC,
+		&net.UDPAddr{IP: serverIP, Port: ntp.ServerPortSCION}, 0 /* DSCP */, provider,
+		nil /* ACL */, nil /* rate limiter */, peers, keys)
+	server.StartNTSKEServerSCION(ctx, log,
+		udp.UDPAddr{IA: serverIA, Host: &net.UDPAddr{IP: serverIP, Port: ntp.ServerPortSCION}},
+		&tls.Config{
+			Certificates: []tls.Certificate{cert},
+			NextProtos:   []string{"ntske/1"},
+			MinVersion:   tls.VersionTLS13,
+		}, provider, nil /* ACL */, server.NTSKEServerConfig{})
+
+	roots := x509.NewCertPool()
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots.AddCert(leaf)
+
+	localAddr := udp.UDPAddr{IA: clientIA, Host: &net.UDPAddr{IP: clientIP}}
+	remoteAddr := udp.UDPAddr{IA: serverIA, Host: &net.UDPAddr{IP: serverIP, Port: ntp.ServerPortSCION}}
+
+	configureNTS := func(c *client.SCIONClient, algos []uint16) {
+		c.Auth.NTSEnabled = true
+		c.Auth.NTSKEFetcher.TLSConfig = tls.Config{
+			NextProtos: []string{"ntske/1"},
+			ServerName: serverIP.String(),
+			RootCAs:    roots,
+			MinVersion: tls.VersionTLS13,
+		}
+		c.Auth.NTSKEFetcher.Log = log
+		c.Auth.NTSKEFetcher.QUIC.Enabled = true
+		c.Auth.NTSKEFetcher.QUIC.DaemonConnector = clientDC
+		c.Auth.NTSKEFetcher.QUIC.LocalAddr = localAddr
+		c.Auth.NTSKEFetcher.QUIC.RemoteAddr = udp.UDPAddr{
+			IA:   serverIA,
+			Host: &net.UDPAddr{IP: serverIP, Port: ntske.ServerPortSCION},
+		}
+		c.Auth.NTSKEFetcher.Algorithms = algos
+	}
+
+	tests := []struct {
+		name      string
+		configure func(c *client.SCIONClient)
+		want      string
+	}{
+		{
+			name:      "plain",
+			configure: func(c *client.SCIONClient) {},
+			want:      "auth=false ntsauth=false",
+		},
+		{
+			name: "SPAO",
+			configure: func(c *client.SCIONClient) {
+				c.Auth.Enabled = true
+				c.Auth.DRKeyFetcher = scion.NewFetcher(clientDC)
+			},
+			want: "auth=true ntsauth=false",
+		},
+		{
+			name: "NTS",
+			configure: func(c *client.SCIONClient) {
+				configureNTS(c, nil /* algos */)
+			},
+			want: "auth=false ntsauth=true",
+		},
+		{
+			name: "NTS AES-SIV-CMAC-512",
+			configure: func(c *client.SCIONClient) {
+				configureNTS(c, []uint16{0xffff, ntske.AES_SIV_CMAC_512})
+			},
+			want: "auth=false ntsauth=true",
+		},
+		{
+			name: "NTS AES-SIV-CMAC-384",
+			configure: func(c *client.SCIONClient) {
+				configureNTS(c, []uint16{ntske.AES_SIV_CMAC_384})
+			},
+			want: "auth=false ntsauth=true",
+		},
+		{
+			name: "NTS AES-128-GCM-SIV",
+			configure: func(c *client.SCIONClient) {
+				configureNTS(c, []uint16{ntske.AES_128_GCM_SIV})
+			},
+			want: "auth=false ntsauth=true",
+		},
+		{
+			name: "MAC AES-128-CMAC",
+			configure: func(c *client.SCIONClient) {
+				c.Auth.SymmetricKey = cmacKey
+			},
+			want: "ntsauth=false macauth=true",
+		},
+		{
+			name: "MAC SHA-256",
+			configure: func(c *client.SCIONClient) {
+				c.Auth.SymmetricKey = sha256Key
+			},
+			want: "ntsauth=false macauth=true",
+		},
+		{
+			name: "symmetric",
+			configure: func(c *client.SCIONClient) {
+				c.Symmetric = true
+			},
+			want: "auth=false ntsauth=false",
+		},
+		{
+			name: "symmetric NTS",
+			configure: func(c *client.SCIONClient) {
+				c.Symmetric = true
+				configureNTS(c, nil /* algos */)
+			},
+			want: "auth=false ntsauth=true",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var logBuf bytes.Buffer
+			clog := slog.New(slog.NewTextHandler(&logBuf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+			c := &client.SCIONClient{Log: clog}
+			tt.configure(c)
+
+			ps, err := clientDC.Paths(ctx, serverIA, clientIA, daemon.PathReqFlags{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			mctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+			defer cancel()
+			_, off, err := client.MeasureClockOffsetSCION(mctx, clog,
+				[]*client.SCIONClient{c}, localAddr, remoteAddr, ps)
+			if err != nil {
+				t.Fatalf("MeasureClockOffsetSCION() failed: %v\n%s", err, logBuf.String())
+			}
+			if off.Abs() > 100*time.Millisecond {
+				t.Errorf("MeasureClockOffsetSCION() = %v; want offset close to 0", off)
+			}
+			if !strings.Contains(logBuf.String(), tt.want) {
+				t.Errorf("client log does not contain %q:\n%s", tt.want, logBuf.String())
+			}
+		})
+	}
+
+	measure := func(c *client.SCIONClient, log *slog.Logger) error {
+		ps, err := clientDC.Paths(ctx, serverIA, clientIA, daemon.PathReqFlags{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		mctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+		_, _, err = client.MeasureClockOffsetSCION(mctx, log,
+			[]*client.SCIONClient{c}, localAddr, remoteAddr, ps)
+		return err
+	}
+
+	// Without NTS, the first exchange negotiates NTPv5. The reference ID
+	// filter of the server is then obtained in four NTPv5 exchanges.
+	const numExchangesNTPv5 = 5
+
+	t.Run("NTPv5", func(t *testing.T) {
+		refIDs := ntp.NewRefIDs()
+		c := &client.SCIONClient{Log: log, InterleavedMode: true, NTPv5: true, RefIDs: refIDs}
+		for i := range numExchangesNTPv5 {
+			if err := measure(c, log); err != nil {
+				t.Fatalf("MeasureClockOffsetSCION() #%d failed: %v", i, err)
+			}
+		}
+		if !c.InInterleavedMode() {
+			t.Error("InInterleavedMode() = false; want true")
+		}
+		f := refIDs.Filter()
+		if !f.Contains(server.RefIDs().ID()) {
+			t.Error("reference ID filter of server not reported")
+		}
+	})
+
+	t.Run("NTPv5 loop", func(t *testing.T) {
+		// Failed measurements are logged rather than returned.
+		var logBuf bytes.Buffer
+		clog := slog.New(slog.NewTextHandler(&logBuf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+		c := &client.SCIONClient{Log: clog, NTPv5: true, RefIDs: server.RefIDs()}
+		for i := range numExchangesNTPv5 {
+			if err := measure(c, clog); err != nil {
+				t.Fatalf("MeasureClockOffsetSCION() #%d failed: %v", i, err)
+			}
+		}
+		const want = "server synchronized to local instance"
+		if !strings.Contains(logBuf.String(), want) {
+			t.Errorf("client log does not contain %q:\n%s", want, logBuf.String())
+		}
+	})
+
+	t.Run("NTPv5 NTS", func(t *testing.T) {
+		refIDs := ntp.NewRefIDs()
+		c := &client.SCIONClient{Log: log, NTPv5: true, RefIDs: refIDs}
+		configureNTS(c, nil /* algos */)
+		c.Auth.NTSKEFetcher.NTPv5 = true
+		for i := range numExchangesNTPv5 - 1 {
+			if err := measure(c, log); err != nil {
+				t.Fatalf("MeasureClockOffsetSCION() #%d failed: %v", i, err)
+			}
+		}
+		f := refIDs.Filter()
+		if !f.Contains(server.RefIDs().ID()) {
+			t.Error("reference ID filter of server not reported")
+		}
+	})
+	t.Run("symmetric interleaved", func(t *testing.T) {
+		c := &client.SCIONClient{Log: log, InterleavedMode: true, Symmetric: true}
+		for i := range 3 {
+			if err := measure(c, log); err != nil {
+				t.Fatalf("MeasureClockOffsetSCION() #%d failed: %v", i, err)
+			}
+		}
+		if !c.InInterleavedMode() {
+			t.Error("InInterleavedMode() = false; want true")
+		}
+		assocs := peers.Associations()
+		if len(assocs) != 1 || assocs[0].Packets < 5 || assocs[0].Authenticated < 1 || assocs[0].LastRx.IsZero() {
+			t.Errorf("Associations() = %+v; want association with at least 5 packets", assocs)
+		}
+		// The server measures the peer from the timestamps of its requests.
+		if len(assocs) == 1 && (assocs[0].Measured.IsZero() ||
+			assocs[0].Offset.Abs() > 10*time.Millisecond || assocs[0].Delay < 0) {
+			t.Errorf("Associations() = %+v; want measurement of peer", assocs)
+		}
+	})
+}
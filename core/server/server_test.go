@@ -2,9 +2,13 @@ package server_test
 
 import (
 	"log/slog"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
+	"example.com/scion-time/base/leapsecond"
+
 	"example.com/scion-time/core/server"
 	"example.com/scion-time/core/timebase"
 
@@ -39,3 +43,76 @@ func TestSimpleRequest(t *testing.T) {
 
 	server.LogTSS(t, "post")
 }
+
+func TestRequestV5(t *testing.T) {
+	clientID := "client-v5"
+	request := func(cookie uint64, timescale uint8) (time.Time, ntp.PacketV5) {
+		var req ntp.PacketV5
+		req.SetVersion(ntp.Version5)
+		req.SetMode(ntp.ModeClient)
+		req.Timescale = timescale
+		req.ServerCookie = cookie
+		rxt := timebase.Now()
+		var txt time.Time
+		var resp ntp.PacketV5
+		server.HandleRequestV5(clientID, &req, &rxt, &txt, &resp)
+		return rxt, resp
+	}
+
+	t.Run("interleaved", func(t *testing.T) {
+		_, resp0 := request(0, ntp.TimescaleUTC)
+		if resp0.ServerCookie == 0 || resp0.Flags&ntp.FlagInterleaved != 0 {
+			t.Fatalf("unexpected first response: cookie %x, flags %x", resp0.ServerCookie, resp0.Flags)
+		}
+		_, resp1 := request(resp0.ServerCookie, ntp.TimescaleUTC)
+		if resp1.Flags&ntp.FlagInterleaved == 0 {
+			t.Error("request with server cookie not answered in interleaved mode")
+		}
+		if resp1.TransmitTime != resp0.TransmitTime {
+			t.Errorf("TransmitTime = %v; want %v", resp1.TransmitTime, resp0.TransmitTime)
+		}
+		_, resp2 := request(resp1.ServerCookie^1, ntp.TimescaleUTC)
+		if resp2.Flags&ntp.FlagInterleaved != 0 {
+			t.Error("request with forged server cookie answered in interleaved mode")
+		}
+	})
+
+	t.Run("timescale", func(t *testing.T) {
+		name := filepath.Join(t.TempDir(), "leap-seconds.list")
+		err := os.WriteFile(name, []byte("#@\t4102444800\n2272060800\t10\n3692217600\t37\n"), 0o644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tbl, err := leapsecond.Load(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		server.SetLeapSeconds(tbl)
+		defer server.SetLeapSeconds(nil)
+
+		tests := []struct {
+			req, resp uint8
+			offset    time.Duration
+		}{
+			{ntp.TimescaleUTC, ntp.TimescaleUTC, 0},
+			{ntp.TimescaleTAI, ntp.TimescaleTAI, 37 * time.Second},
+			{ntp.TimescaleUT1, ntp.TimescaleUTC, 0},
+			{ntp.TimescaleLeapSmearedUTC, ntp.TimescaleUTC, 0},
+		}
+		for _, tt := range tests {
+			rxt, resp := request(0, tt.req)
+			if resp.Timescale != tt.resp {
+				t.Errorf("Timescale = %d for request of %d; want %d", resp.Timescale, tt.req, tt.resp)
+			}
+			got := ntp.TimeFromTime64Era(resp.ReceiveTime, resp.Era).Sub(rxt)
+			if got.Round(time.Second) != tt.offset {
+				t.Errorf("ReceiveTime - rxt = %v for request of %d; want %v", got, tt.req, tt.offset)
+			}
+		}
+
+		server.SetLeapSeconds(nil)
+		if _, resp := request(0, ntp.TimescaleTAI); resp.Timescale != ntp.TimescaleUTC {
+			t.Errorf("Timescale = %d without leap second table; want %d", resp.Timescale, ntp.TimescaleUTC)
+		}
+	})
+}
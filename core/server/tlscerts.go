@@ -0,0 +1,211 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+const (
+	// File changes are applied once no further change has been observed for
+	// tlsCertReloadDelay s.t. certificate and key files can be replaced one
+	// after the other.
+	tlsCertReloadDelay = 1 * time.Second
+
+	// Certificates are polled for changes every tlsCertPollInterval if file
+	// change notifications are not available.
+	tlsCertPollInterval = 10 * time.Minute
+)
+
+var errNoTLSIdentity = errors.New("no TLS identity configured")
+
+// TLSIdentity is a certificate and key pair presented to clients requesting
+// ServerName via SNI.
+type TLSIdentity struct {
+	ServerName string
+	CertFile   string
+	KeyFile    string
+}
+
+// TLSCertificates serves the certificates of a set of TLS identities. The
+// first identity is presented to clients that do not request a configured
+// server name. Certificates are reloaded when their files change, new key
+// pairs only replace the current ones after successful validation.
+type TLSCertificates struct {
+	log   *slog.Logger
+	ids   []TLSIdentity
+	mu    sync.RWMutex
+	certs []*tls.Certificate
+}
+
+func loadTLSCertificate(id TLSIdentity) (*tls.Certificate, error) {
+	cert, err := tls.LoadX509KeyPair(id.CertFile, id.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	if cert.Leaf == nil {
+		cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &cert, nil
+}
+
+func validAt(cert *x509.Certificate, t time.Time) bool {
+	return !t.Before(cert.NotBefore) && !t.After(cert.NotAfter)
+}
+
+// validateTLSCertificate checks that the certificate next of identity id is
+// not worse than the current certificate cur: next must be valid at time now
+// and for the server name of id if cur is. Checks are relative to cur s.t.
+// a service with an inaccurate clock or a self-signed certificate without
+// server name keeps working.
+func validateTLSCertificate(id TLSIdentity, cur, next *x509.Certificate, now time.Time) error {
+	if validAt(cur, now) && !validAt(next, now) {
+		return fmt.Errorf("certificate %s not valid at %v (valid from %v until %v)",
+			id.CertFile, now, next.NotBefore, next.NotAfter)
+	}
+	if cur.VerifyHostname(id.ServerName) == nil {
+		err := next.VerifyHostname(id.ServerName)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// NewTLSCertificates loads the certificates of the given identities.
+func NewTLSCertificates(log *slog.Logger, ids []TLSIdentity) (*TLSCertificates, error) {
+	if len(ids) == 0 {
+		return nil, errNoTLSIdentity
+	}
+	c := &TLSCertificates{
+		log:   log,
+		ids:   ids,
+		certs: make([]*tls.Certificate, len(ids)),
+	}
+	for i, id := range ids {
+		cert, err := loadTLSCertificate(id)
+		if err != nil {
+			return nil, err
+		}
+		c.certs[i] = cert
+	}
+	return c, nil
+}
+
+// Reload reloads the certificates of all identities. Certificates that fail to
+// load or validate are kept unchanged.
+func (c *TLSCertificates) Reload(ctx context.Context) {
+	now := time.Now()
+	for i, id := range c.ids {
+		cert, err := loadTLSCertificate(id)
+		if err == nil {
+			c.mu.RLock()
+			cur := c.certs[i]
+			c.mu.RUnlock()
+			err = validateTLSCertificate(id, cur.Leaf, cert.Leaf, now)
+		}
+		if err != nil {
+			c.log.LogAttrs(ctx, slog.LevelError, "failed to reload TLS certificate, keeping current one",
+				slog.String("server_name", id.ServerName), slog.Any("error", err))
+			continue
+		}
+		c.mu.Lock()
+		c.certs[i] = cert
+		c.mu.Unlock()
+	}
+}
+
+// GetCertificate returns the certificate for the server name requested by a
+// client and is meant to be used as tls.Config.GetCertificate.
+func (c *TLSCertificates) GetCertificate(chi *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	for i, id := range c.ids {
+		if strings.EqualFold(id.ServerName, chi.ServerName) {
+			return c.certs[i], nil
+		}
+	}
+	return c.certs[0], nil
+}
+
+func (c *TLSCertificates) watch(ctx context.Context, w *fsnotify.Watcher) {
+	defer func() { _ = w.Close() }()
+	timer := time.NewTimer(0)
+	if !timer.Stop() {
+		<-timer.C
+	}
+	for {
+		select {
+		case ev, ok := <-w.Events:
+			if !ok {
+				return
+			}
+			c.log.LogAttrs(ctx, slog.LevelDebug, "TLS certificate files changed", slog.String("file", ev.Name))
+			timer.Reset(tlsCertReloadDelay)
+		case err, ok := <-w.Errors:
+			if !ok {
+				return
+			}
+			c.log.LogAttrs(ctx, slog.LevelError, "failed to watch TLS certificate files", slog.Any("error", err))
+		case <-timer.C:
+			c.Reload(ctx)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (c *TLSCertificates) poll(ctx context.Context) {
+	ticker := time.NewTicker(tlsCertPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			c.Reload(ctx)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// StartReloading reloads the certificates whenever their files change until
+// ctx is done. The directories containing the files are watched s.t. files
+// replaced by renaming, e.g., by certificate managers, are detected as well.
+// Certificates are polled periodically if file change notifications are not
+// available.
+func (c *TLSCertificates) StartReloading(ctx context.Context) {
+	w, err := fsnotify.NewWatcher()
+	if err == nil {
+		dirs := map[string]struct{}{}
+		for _, id := range c.ids {
+			dirs[filepath.Dir(id.CertFile)] = struct{}{}
+			dirs[filepath.Dir(id.KeyFile)] = struct{}{}
+		}
+		for dir := range dirs {
+			err = w.Add(dir)
+			if err != nil {
+				_ = w.Close()
+				break
+			}
+		}
+	}
+	if err != nil {
+		c.log.LogAttrs(ctx, slog.LevelInfo, "failed to watch TLS certificate files, polling instead",
+			slog.Any("error", err))
+		go c.poll(ctx)
+		return
+	}
+	go c.watch(ctx, w)
+}
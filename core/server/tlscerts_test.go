@@ -0,0 +1,130 @@
+package server_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"log/slog"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"example.com/scion-time/core/server"
+)
+
+func writeTestKeyPair(t *testing.T, certFile, keyFile, name string, notAfter time.Time) []byte {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    notAfter.Add(-2 * time.Hour),
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		DNSNames:     []string{name},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keyFile != "" {
+		err = os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return der
+}
+
+func TestTLSCertificates(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	log := slog.New(slog.DiscardHandler)
+
+	dir := t.TempDir()
+	valid := time.Now().Add(time.Hour)
+	ids := []server.TLSIdentity{{
+		ServerName: "public.example.com",
+		CertFile:   filepath.Join(dir, "public.crt"),
+		KeyFile:    filepath.Join(dir, "public.key"),
+	}, {
+		ServerName: "internal.example.com",
+		CertFile:   filepath.Join(dir, "internal.crt"),
+		KeyFile:    filepath.Join(dir, "internal.key"),
+	}}
+	publicDER := writeTestKeyPair(t, ids[0].CertFile, ids[0].KeyFile, ids[0].ServerName, valid)
+	internalDER := writeTestKeyPair(t, ids[1].CertFile, ids[1].KeyFile, ids[1].ServerName, valid)
+
+	certs, err := server.NewTLSCertificates(log, ids)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certificate := func(name string) []byte {
+		cert, err := certs.GetCertificate(&tls.ClientHelloInfo{ServerName: name})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cert.Certificate[0]
+	}
+	for _, tt := range []struct {
+		name string
+		want []byte
+	}{
+		{"public.example.com", publicDER},
+		{"INTERNAL.example.com", internalDER},
+		{"", publicDER},
+		{"unknown.example.com", publicDER},
+	} {
+		if !bytes.Equal(certificate(tt.name), tt.want) {
+			t.Errorf("GetCertificate(%q) returned wrong certificate", tt.name)
+		}
+	}
+
+	// Replaced certificates are picked up via file change notifications.
+	certs.StartReloading(ctx)
+	internalDER = writeTestKeyPair(t, ids[1].CertFile, ids[1].KeyFile, ids[1].ServerName, valid)
+	deadline := time.Now().Add(10 * time.Second)
+	for !bytes.Equal(certificate(ids[1].ServerName), internalDER) {
+		if time.Now().After(deadline) {
+			t.Fatal("certificate not reloaded after file change")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	// Invalid key pairs do not replace the current ones.
+	writeTestKeyPair(t, ids[0].CertFile, "" /* keyFile */, ids[0].ServerName, valid)
+	writeTestKeyPair(t, ids[1].CertFile, ids[1].KeyFile, "other.example.com", valid)
+	certs.Reload(ctx)
+	if !bytes.Equal(certificate(ids[0].ServerName), publicDER) {
+		t.Error("Reload() accepted certificate not matching its key")
+	}
+	if !bytes.Equal(certificate(ids[1].ServerName), internalDER) {
+		t.Error("Reload() accepted certificate for wrong server name")
+	}
+	writeTestKeyPair(t, ids[1].CertFile, ids[1].KeyFile, ids[1].ServerName, time.Now().Add(-time.Hour))
+	certs.Reload(ctx)
+	if !bytes.Equal(certificate(ids[1].ServerName), internalDER) {
+		t.Error("Reload() accepted expired certificate")
+	}
+}
@@ -0,0 +1,183 @@
+package sync
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"time"
+
+	"example.com/scion-time/base/timebase"
+	"example.com/scion-time/base/timemath"
+
+	"example.com/scion-time/core/client"
+)
+
+var (
+	errTooFewBootstrapClocks = errors.New("too few reference clocks for bootstrapping")
+	errNoAgreement           = errors.New("reference clocks do not agree on time")
+	errOutsideValidity       = errors.New("time outside of certificate validity")
+)
+
+// BootstrapClock is a reference clock whose server certificates have been
+// validated without regard to their validity periods, see
+// ntske.Fetcher.Bootstrap.
+type BootstrapClock interface {
+	client.ReferenceClock
+	CertificateValidity() (notBefore, notAfter time.Time, ok bool)
+	EndBootstrap(ctx context.Context) error
+}
+
+type BootstrapConfig struct {
+	// MinAgreement is the minimum number of reference clocks that must agree
+	// on the time. In any case, a majority of all clocks must agree.
+	MinAgreement int
+	// MaxDisagreement is the maximum spread of the offsets of agreeing
+	// reference clocks.
+	MaxDisagreement time.Duration
+	SyncTimeout     time.Duration
+	SyncInterval    time.Duration
+}
+
+type bootstrapSample struct {
+	offset    time.Duration
+	notBefore time.Time
+	notAfter  time.Time
+}
+
+// crossCheck returns the offset agreed on by at least quorum samples. Samples
+// agree if their offsets differ by at most maxDisagreement and if the
+// resulting time now+offset is within the validity periods of all their
+// certificates.
+func crossCheck(now time.Time, samples []bootstrapSample, quorum int,
+	maxDisagreement time.Duration) (time.Duration, error) {
+	slices.SortFunc(samples, func(a, b bootstrapSample) int {
+		return cmp.Compare(a.offset, b.offset)
+	})
+	var agreeing []bootstrapSample
+	for i, j := 0, 0; j < len(samples); j++ {
+		for samples[j].offset-samples[i].offset > maxDisagreement {
+			i++
+		}
+		if j+1-i > len(agreeing) {
+			agreeing = samples[i : j+1]
+		}
+	}
+	if len(agreeing) < quorum {
+		return 0, fmt.Errorf("%w: %d of %d required clocks agree", errNoAgreement, len(agreeing), quorum)
+	}
+	offsets := make([]time.Duration, len(agreeing))
+	for i, s := range agreeing {
+		offsets[i] = s.offset
+	}
+	offset := timemath.Median(offsets)
+	t := now.Add(offset)
+	for _, s := range agreeing {
+		if t.Before(s.notBefore) || t.After(s.notAfter) {
+			return 0, fmt.Errorf("%w: %v not in [%v, %v]", errOutsideValidity, t, s.notBefore, s.notAfter)
+		}
+	}
+	return offset, nil
+}
+
+func measureBootstrapSamples(ctx context.Context, log *slog.Logger, clk timebase.SystemClock,
+	refClks []BootstrapClock, timeout time.Duration) []bootstrapSample {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	type result struct {
+		offset time.Duration
+		err    error
+	}
+	results := make([]result, len(refClks))
+	done := make(chan struct{})
+	for i, c := range refClks {
+		go func() {
+			_, off, err := c.MeasureClockOffset(ctx)
+			results[i] = result{offset: off, err: err}
+			done <- struct{}{}
+		}()
+	}
+	for range refClks {
+		<-done
+	}
+	now := clk.Now()
+	var samples []bootstrapSample
+	for i, c := range refClks {
+		if results[i].err != nil {
+			log.LogAttrs(ctx, slog.LevelInfo, "failed to measure clock offset for bootstrapping",
+				slog.Any("error", results[i].err))
+			continue
+		}
+		notBefore, notAfter, ok := c.CertificateValidity()
+		if !ok {
+			continue
+		}
+		t := now.Add(results[i].offset)
+		if t.Before(notBefore) || t.After(notAfter) {
+			log.LogAttrs(ctx, slog.LevelWarn, "reference clock time outside of its certificate validity",
+				slog.Time("time", t), slog.Time("not_before", notBefore), slog.Time("not_after", notAfter))
+			continue
+		}
+		samples = append(samples, bootstrapSample{
+			offset:    results[i].offset,
+			notBefore: notBefore,
+			notAfter:  notAfter,
+		})
+	}
+	return samples
+}
+
+// Bootstrap sets the clock to the time agreed on by a majority of the
+// reference clocks and ends their bootstrap mode s.t. their certificates are
+// subsequently validated regularly. It blocks until the reference clocks
+// agree and must be called before Run.
+func Bootstrap(ctx context.Context, log *slog.Logger, cfg BootstrapConfig,
+	clk timebase.SystemClock, refClks []BootstrapClock) error {
+	if cfg.MaxDisagreement < 0 {
+		panic("invalid maximum disagreement")
+	}
+	if cfg.SyncInterval <= 0 {
+		panic("invalid sync interval")
+	}
+	if cfg.SyncTimeout <= 0 {
+		panic("invalid sync timeout")
+	}
+	quorum := max(cfg.MinAgreement, len(refClks)/2+1)
+	if len(refClks) < quorum {
+		return errTooFewBootstrapClocks
+	}
+	for {
+		err := ctx.Err()
+		if err != nil {
+			return err
+		}
+		samples := measureBootstrapSamples(ctx, log, clk, refClks, cfg.SyncTimeout)
+		offset, err := crossCheck(clk.Now(), samples, quorum, cfg.MaxDisagreement)
+		if err == nil {
+			log.LogAttrs(ctx, slog.LevelInfo, "bootstrapped clock",
+				slog.Float64("offset", offset.Seconds()), slog.Int("clocks", len(samples)))
+			clk.Step(offset)
+			break
+		}
+		log.LogAttrs(ctx, slog.LevelInfo, "failed to bootstrap clock", slog.Any("error", err))
+		clk.Sleep(cfg.SyncInterval)
+	}
+	n := 0
+	for _, c := range refClks {
+		rctx, cancel := context.WithTimeout(ctx, cfg.SyncTimeout)
+		err := c.EndBootstrap(rctx)
+		cancel()
+		if err != nil {
+			log.LogAttrs(ctx, slog.LevelWarn, "failed to revalidate reference clock after bootstrapping",
+				slog.Any("error", err))
+			continue
+		}
+		n++
+	}
+	if n < quorum {
+		return fmt.Errorf("%w: %d of %d required clocks revalidated", errTooFewBootstrapClocks, n, quorum)
+	}
+	return nil
+}
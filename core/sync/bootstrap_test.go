@@ -0,0 +1,122 @@
+package sync_test
+
+import (
+	"context"
+	"log/slog"
+	"testing"
+	"time"
+
+	"example.com/scion-time/core/sync"
+)
+
+type testClock struct {
+	now    time.Time
+	steps  []time.Duration
+	cancel context.CancelFunc
+}
+
+func (c *testClock) Epoch() uint64                              { return 0 }
+func (c *testClock) Now() time.Time                             { return c.now }
+func (c *testClock) Drift(duration time.Duration) time.Duration { return 0 }
+func (c *testClock) Step(offset time.Duration) {
+	c.now = c.now.Add(offset)
+	c.steps = append(c.steps, offset)
+}
+func (c *testClock) Adjust(offset, duration time.Duration, frequency float64) {}
+func (c *testClock) Sleep(duration time.Duration) {
+	c.cancel()
+}
+
+type testBootstrapClock struct {
+	offset    time.Duration
+	notBefore time.Time
+	notAfter  time.Time
+	ended     bool
+}
+
+func (c *testBootstrapClock) MeasureClockOffset(context.Context) (time.Time, time.Duration, error) {
+	return time.Time{}, c.offset, nil
+}
+
+func (c *testBootstrapClock) CertificateValidity() (time.Time, time.Time, bool) {
+	return c.notBefore, c.notAfter, true
+}
+
+func (c *testBootstrapClock) EndBootstrap(context.Context) error {
+	c.ended = true
+	return nil
+}
+
+func TestBootstrap(t *testing.T) {
+	log := slog.New(slog.DiscardHandler)
+	cfg := sync.BootstrapConfig{
+		MinAgreement:    2,
+		MaxDisagreement: time.Second,
+		SyncTimeout:     time.Second,
+		SyncInterval:    time.Second,
+	}
+	refTime := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	valid := func(offset time.Duration) *testBootstrapClock {
+		return &testBootstrapClock{
+			offset:    offset,
+			notBefore: refTime.Add(-24 * time.Hour),
+			notAfter:  refTime.Add(24 * time.Hour),
+		}
+	}
+
+	for _, tt := range []struct {
+		name    string
+		clks    []*testBootstrapClock
+		wantOff time.Duration
+		wantErr bool
+	}{{
+		name: "majority agrees",
+		clks: []*testBootstrapClock{
+			valid(10 * time.Hour), valid(10*time.Hour + 100*time.Millisecond), valid(-5 * time.Hour),
+		},
+		wantOff: 10*time.Hour + 50*time.Millisecond,
+	}, {
+		name:    "minority agrees",
+		clks:    []*testBootstrapClock{valid(10 * time.Hour), valid(-5 * time.Hour), valid(3 * time.Hour)},
+		wantErr: true,
+	}, {
+		name:    "single clock",
+		clks:    []*testBootstrapClock{valid(10 * time.Hour)},
+		wantErr: true,
+	}, {
+		name: "time outside of certificate validity",
+		clks: []*testBootstrapClock{
+			valid(10 * 24 * time.Hour), valid(10 * 24 * time.Hour), valid(10 * 24 * time.Hour),
+		},
+		wantErr: true,
+	}} {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			// The local clock is 10 hours behind.
+			clk := &testClock{now: refTime.Add(-10 * time.Hour), cancel: cancel}
+			var clks []sync.BootstrapClock
+			for _, c := range tt.clks {
+				clks = append(clks, c)
+			}
+			err := sync.Bootstrap(ctx, log, cfg, clk, clks)
+			if tt.wantErr {
+				if err == nil || len(clk.steps) != 0 {
+					t.Errorf("Bootstrap() = %v with steps %v; want error without steps", err, clk.steps)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(clk.steps) != 1 || clk.steps[0] != tt.wantOff {
+				t.Errorf("Bootstrap() stepped clock by %v; want %v", clk.steps, tt.wantOff)
+			}
+			for i, c := range tt.clks {
+				if !c.ended {
+					t.Errorf("Bootstrap() did not end bootstrap mode of clock %d", i)
+				}
+			}
+		})
+	}
+}
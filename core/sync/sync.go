@@ -3,6 +3,7 @@ package sync
 import (
 	"context"
 	"log/slog"
+	"sync/atomic"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
@@ -25,6 +26,52 @@ type Config struct {
 	SyncInterval         time.Duration
 }
 
+// State describes the synchronization state of the local clock.
+type State struct {
+	// Synchronized is true if a majority of the reference clocks, or of the
+	// peers if there are no reference clocks, could be measured in the most
+	// recent synchronization round.
+	Synchronized bool
+	// LastSynchronized is the local time of the most recent synchronization
+	// round in which the local clock was synchronized.
+	LastSynchronized time.Time
+	// Offset is the clock offset measured in that round.
+	Offset time.Duration
+	// Variance is an exponentially weighted estimate of the variance of the
+	// measured clock offsets in seconds squared.
+	Variance float64
+}
+
+const stateVarianceWeight = 1.0 / 8.0
+
+var state atomic.Pointer[State]
+
+// CurrentState returns the synchronization state of the local clock as of the
+// most recent synchronization round. The zero State is returned before the
+// first round has completed.
+func CurrentState() State {
+	s := state.Load()
+	if s == nil {
+		return State{}
+	}
+	return *s
+}
+
+func updateState(s State, now time.Time, synced bool, off time.Duration) State {
+	s.Synchronized = synced
+	if synced {
+		x := off.Seconds() * off.Seconds()
+		if s.LastSynchronized.IsZero() {
+			s.Variance = x
+		} else {
+			s.Variance += stateVarianceWeight * (x - s.Variance)
+		}
+		s.LastSynchronized = now
+		s.Offset = off
+	}
+	return s
+}
+
 type localReferenceClock struct{}
 
 func (c *localReferenceClock) MeasureClockOffset(context.Context) (
@@ -34,12 +81,12 @@ func (c *localReferenceClock) MeasureClockOffset(context.Context) (
 
 func measureOffsetToRefClks(refClkClient client.ReferenceClockClient,
 	refClks []client.ReferenceClock, refClkOffsets []measurements.Measurement,
-	timeout time.Duration) (time.Time, time.Duration) {
+	timeout time.Duration) (time.Time, time.Duration, int) {
 	ctx, cancel := context.WithTimeout(context.Background(), timeout)
 	defer cancel()
-	refClkClient.MeasureClockOffsets(ctx, refClks, refClkOffsets)
+	n := refClkClient.MeasureClockOffsets(ctx, refClks, refClkOffsets)
 	m := measurements.FaultTolerantMidpoint(refClkOffsets)
-	return m.Timestamp, m.Offset
+	return m.Timestamp, m.Offset, n
 }
 
 func Run(log *slog.Logger, cfg Config,
@@ -72,12 +119,14 @@ func Run(log *slog.Logger, cfg Config,
 	var refClkClient client.ReferenceClockClient
 	refClkOffsets := make([]measurements.Measurement, len(refClks))
 	refClkOffCh := make(chan time.Duration)
+	refClkNumCh := make(chan int, 1)
 	if len(peerClks) != 0 {
 		peerClks = append(peerClks, &localReferenceClock{})
 	}
 	var peerClkClient client.ReferenceClockClient
 	peerClkOffsets := make([]measurements.Measurement, len(peerClks))
 	peerClkOffCh := make(chan time.Duration)
+	peerClkNumCh := make(chan int, 1)
 	corrGauge := promauto.NewGauge(prometheus.GaugeOpts{
 		Name: metrics.SyncCorrN,
 		Help: metrics.SyncCorrH,
@@ -86,21 +135,26 @@ func Run(log *slog.Logger, cfg Config,
 	for {
 		go func() {
 			var refClkOff time.Duration
+			var refClkNum int
 			if len(refClks) != 0 {
-				_, refClkOff = measureOffsetToRefClks(
+				_, refClkOff, refClkNum = measureOffsetToRefClks(
 					refClkClient, refClks, refClkOffsets, cfg.SyncTimeout)
 			}
+			refClkNumCh <- refClkNum
 			refClkOffCh <- refClkOff
 		}()
 		go func() {
 			var peerClkOff time.Duration
+			var peerClkNum int
 			if len(peerClks) != 0 {
-				_, peerClkOff = measureOffsetToRefClks(
+				_, peerClkOff, peerClkNum = measureOffsetToRefClks(
 					peerClkClient, peerClks, peerClkOffsets, cfg.SyncTimeout)
 			}
+			peerClkNumCh <- peerClkNum
 			peerClkOffCh <- peerClkOff
 		}()
 		refClkOff, peerClkOff := <-refClkOffCh, <-peerClkOffCh
+		refClkNum, peerClkNum := <-refClkNumCh, <-peerClkNumCh
 		refClkCorr, peerClkCorr := refClkOff, peerClkOff
 		var refClkOk bool
 		if float64(refClkCorr.Abs()) > refClkMaxCorr {
@@ -135,6 +189,15 @@ func Run(log *slog.Logger, cfg Config,
 			slog.Float64("peerClkOff", peerClkOff.Seconds()),
 			slog.Float64("peerClkCorr", peerClkCorr.Seconds()),
 			slog.Float64("peerClkMaxCorr", float64(peerClkMaxCorr)/1e9))
+		var synced bool
+		var off time.Duration
+		if len(refClks) != 0 {
+			synced, off = refClkNum > len(refClks)/2, refClkOff
+		} else if len(peerClks) != 0 {
+			synced, off = peerClkNum > len(peerClks)/2, peerClkOff
+		}
+		s := updateState(CurrentState(), clk.Now(), synced, off)
+		state.Store(&s)
 		adj.Do(corr)
 		corrGauge.Set(float64(corr))
 		clk.Sleep(cfg.SyncInterval)
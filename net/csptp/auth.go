@@ -0,0 +1,155 @@
+package csptp
+
+// See IEEE 1588-2019, 16.14, AUTHENTICATION TLV with immediate security
+// processing, using HMAC-SHA256 truncated to 128 bits as integrity check value.
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	TLVTypeAuthentication = 0x8009
+
+	// ICVLength is the length of the HMAC-SHA256-128 integrity check value.
+	ICVLength = 16
+
+	EncodedAuthenticationTLVLength = 10 + ICVLength
+
+	MinKeyLength = 16
+)
+
+var (
+	errUnexpectedAuthenticationTLVSize = errors.New("unexpected authentication TLV size")
+	errUnexpectedAuthenticationTLV     = errors.New("unexpected authentication TLV")
+)
+
+type AuthenticationTLV struct {
+	Type              uint16
+	Length            uint16
+	SPP               uint8
+	SecParamIndicator uint8
+	KeyID             uint32
+	ICV               [ICVLength]byte
+}
+
+func EncodeAuthenticationTLV(b []byte, tlv *AuthenticationTLV) {
+	_ = b[EncodedAuthenticationTLVLength-1]
+	b[0] = byte(tlv.Type >> 8)
+	b[1] = byte(tlv.Type)
+	b[2] = byte(tlv.Length >> 8)
+	b[3] = byte(tlv.Length)
+	b[4] = byte(tlv.SPP)
+	b[5] = byte(tlv.SecParamIndicator)
+	b[6] = byte(tlv.KeyID >> 24)
+	b[7] = byte(tlv.KeyID >> 16)
+	b[8] = byte(tlv.KeyID >> 8)
+	b[9] = byte(tlv.KeyID)
+	copy(b[10:], tlv.ICV[:])
+}
+
+func DecodeAuthenticationTLV(tlv *AuthenticationTLV, b []byte) error {
+	if len(b) != EncodedAuthenticationTLVLength {
+		return errUnexpectedAuthenticationTLVSize
+	}
+	tlv.Type = uint16(b[0])<<8 | uint16(b[1])
+	tlv.Length = uint16(b[2])<<8 | uint16(b[3])
+	tlv.SPP = b[4]
+	tlv.SecParamIndicator = b[5]
+	tlv.KeyID = uint32(b[6])<<24 | uint32(b[7])<<16 | uint32(b[8])<<8 | uint32(b[9])
+	copy(tlv.ICV[:], b[10:])
+	if tlv.Type != TLVTypeAuthentication ||
+		tlv.Length != EncodedAuthenticationTLVLength-4 ||
+		tlv.SecParamIndicator != 0 {
+		return errUnexpectedAuthenticationTLV
+	}
+	return nil
+}
+
+// NewAuthenticationTLV returns an AUTHENTICATION TLV for key keyID with an
+// empty integrity check value.
+func NewAuthenticationTLV(keyID uint32) AuthenticationTLV {
+	return AuthenticationTLV{
+		Type:   TLVTypeAuthentication,
+		Length: EncodedAuthenticationTLVLength - 4,
+		KeyID:  keyID,
+	}
+}
+
+// ComputeICV computes the integrity check value of message b, which must end
+// with an encoded AUTHENTICATION TLV, and stores it in the TLV.
+func ComputeICV(b, key []byte) {
+	mac := hmac.New(sha256.New, key)
+	_, _ = mac.Write(b[:len(b)-ICVLength])
+	copy(b[len(b)-ICVLength:], mac.Sum(nil))
+}
+
+// ValidICV reports whether message b, which must end with an encoded
+// AUTHENTICATION TLV, carries a valid integrity check value for key.
+func ValidICV(b, key []byte) bool {
+	mac := hmac.New(sha256.New, key)
+	_, _ = mac.Write(b[:len(b)-ICVLength])
+	return hmac.Equal(b[len(b)-ICVLength:], mac.Sum(nil)[:ICVLength])
+}
+
+// LoadKeys reads the keys for AUTHENTICATION TLVs from keyFile. Each line
+// consists of a key ID and the hex encoded key, lines starting with '#' are
+// ignored. The key file must be a regular file not accessible by group or
+// others.
+func LoadKeys(keyFile string) (map[uint32][]byte, error) {
+	fi, err := os.Stat(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	if !fi.Mode().IsRegular() {
+		return nil, fmt.Errorf("CSPTP key file %s is not a regular file", keyFile)
+	}
+	if fi.Mode().Perm()&0o077 != 0 {
+		return nil, fmt.Errorf("CSPTP key file %s must not be accessible by group or others (mode %v)",
+			keyFile, fi.Mode().Perm())
+	}
+	f, err := os.Open(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+	keys := make(map[uint32][]byte)
+	s := bufio.NewScanner(f)
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("CSPTP key file %s, line %d: unexpected number of fields", keyFile, n)
+		}
+		id, err := strconv.ParseUint(fields[0], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("CSPTP key file %s, line %d: invalid key ID: %w", keyFile, n, err)
+		}
+		key, err := hex.DecodeString(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("CSPTP key file %s, line %d: invalid key: %w", keyFile, n, err)
+		}
+		if len(key) < MinKeyLength {
+			return nil, fmt.Errorf("CSPTP key file %s, line %d: key too short", keyFile, n)
+		}
+		if _, ok := keys[uint32(id)]; ok {
+			return nil, fmt.Errorf("CSPTP key file %s, line %d: duplicate key ID %d", keyFile, n, id)
+		}
+		keys[uint32(id)] = key
+	}
+	err = s.Err()
+	if err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
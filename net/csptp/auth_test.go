@@ -0,0 +1,106 @@
+package csptp_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"example.com/scion-time/net/csptp"
+)
+
+var testKey = []byte("0123456789abcdef0123456789abcdef")
+
+func TestAuthenticationTLVRoundTrip(t *testing.T) {
+	tlv0 := csptp.NewAuthenticationTLV(0x01020304)
+	tlv0.ICV = [csptp.ICVLength]byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}
+	b := make([]byte, csptp.EncodedAuthenticationTLVLength)
+	csptp.EncodeAuthenticationTLV(b, &tlv0)
+	var tlv1 csptp.AuthenticationTLV
+	err := csptp.DecodeAuthenticationTLV(&tlv1, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tlv1 != tlv0 {
+		t.Errorf("DecodeAuthenticationTLV() = %+v; want %+v", tlv1, tlv0)
+	}
+
+	err = csptp.DecodeAuthenticationTLV(&tlv1, b[:len(b)-1])
+	if err == nil {
+		t.Error("DecodeAuthenticationTLV() accepted truncated TLV")
+	}
+	b[5] = 1 // secParamIndicator
+	err = csptp.DecodeAuthenticationTLV(&tlv1, b)
+	if err == nil {
+		t.Error("DecodeAuthenticationTLV() accepted delayed security processing")
+	}
+}
+
+func TestICV(t *testing.T) {
+	msg := csptp.Message{
+		SdoIDMessageType: csptp.MessageTypeSync,
+		PTPVersion:       csptp.PTPVersion,
+		MessageLength:    csptp.MinMessageLength + csptp.EncodedAuthenticationTLVLength,
+		SequenceID:       42,
+	}
+	tlv := csptp.NewAuthenticationTLV(7)
+	b := make([]byte, msg.MessageLength)
+	csptp.EncodeMessage(b[:csptp.MinMessageLength], &msg)
+	csptp.EncodeAuthenticationTLV(b[csptp.MinMessageLength:], &tlv)
+	csptp.ComputeICV(b, testKey)
+	if !csptp.ValidICV(b, testKey) {
+		t.Fatal("ValidICV() rejected message")
+	}
+	if csptp.ValidICV(b, bytes.Repeat([]byte{1}, len(testKey))) {
+		t.Error("ValidICV() accepted message with wrong key")
+	}
+	for _, i := range []int{0, 30, csptp.MinMessageLength + 9, len(b) - 1} {
+		c := bytes.Clone(b)
+		c[i] ^= 1
+		if csptp.ValidICV(c, testKey) {
+			t.Errorf("ValidICV() accepted message modified at offset %d", i)
+		}
+	}
+}
+
+func writeKeyFile(t *testing.T, data string, perm os.FileMode) string {
+	t.Helper()
+	name := filepath.Join(t.TempDir(), "csptp.keys")
+	err := os.WriteFile(name, []byte(data), perm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return name
+}
+
+func TestLoadKeys(t *testing.T) {
+	name := writeKeyFile(t, "# CSPTP keys\n"+
+		"1 000102030405060708090a0b0c0d0e0f\n"+
+		"\n"+
+		"4294967295 "+"00112233445566778899aabbccddeeff00112233445566778899aabbccddeeff\n", 0o600)
+	keys, err := csptp.LoadKeys(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 2 || len(keys[1]) != 16 || len(keys[4294967295]) != 32 || keys[1][15] != 0x0f {
+		t.Errorf("LoadKeys() = %x", keys)
+	}
+
+	for _, data := range []string{
+		"1\n",
+		"x 000102030405060708090a0b0c0d0e0f\n",
+		"1 000102030405060708090a0b0c0d0e\n",
+		"1 000102030405060708090a0b0c0d0e0g\n",
+		"1 000102030405060708090a0b0c0d0e0f\n1 000102030405060708090a0b0c0d0e0f\n",
+	} {
+		_, err := csptp.LoadKeys(writeKeyFile(t, data, 0o600))
+		if err == nil {
+			t.Errorf("LoadKeys() accepted %q", data)
+		}
+	}
+
+	_, err = csptp.LoadKeys(writeKeyFile(t, "1 000102030405060708090a0b0c0d0e0f\n", 0o644))
+	if err == nil {
+		t.Error("LoadKeys() accepted key file accessible by others")
+	}
+}
@@ -53,6 +53,21 @@ const (
 	TLVFlagServerStateDS = 1 << 0
 
 	ErrorTxTimestampInvalid = 1
+
+	ClockClassLocked              = 6
+	ClockClassHoldover            = 7
+	ClockClassApplicationSpecific = 13
+	ClockClassDefault             = 248
+
+	ClockAccuracyUnknown = 0xfe
+
+	ClockVarianceUnknown = 0xffff
+
+	TimeSourceGNSS               = 0x20
+	TimeSourcePTP                = 0x40
+	TimeSourceNTP                = 0x50
+	TimeSourceHandSet            = 0x60
+	TimeSourceInternalOscillator = 0xa0
 )
 
 type PortID struct {
@@ -141,7 +156,7 @@ func DurationFromTimeInterval(i int64) time.Duration {
 }
 
 const MinMessageLength = 44
-const MaxMessageLength = 98
+const MaxMessageLength = 98 + EncodedAuthenticationTLVLength
 
 func EncodeMessage(b []byte, msg *Message) {
 	_ = b[43]
@@ -0,0 +1,161 @@
+package ntp
+
+// See RFC 9327, Control Messages Protocol for Use with Network Time Protocol
+// Version 4 (mode 6)
+
+import (
+	"errors"
+)
+
+const (
+	ControlHeaderLen = 12
+	// ControlMaxDataLen is the maximum length of the data of a single control
+	// message, longer responses are fragmented.
+	ControlMaxDataLen = 468
+
+	ControlFlagResponse = 0x80
+	ControlFlagError    = 0x40
+	ControlFlagMore     = 0x20
+
+	ControlOpReadStatus     = 1
+	ControlOpReadVariables  = 2
+	ControlOpWriteVariables = 3
+	ControlOpReadClock      = 4
+	ControlOpWriteClock     = 5
+	ControlOpSetTrap        = 6
+	ControlOpAsyncMessage   = 7
+	ControlOpConfigure      = 8
+	ControlOpSaveConfig     = 9
+	ControlOpUnsetTrap      = 31
+
+	ControlErrorUnspecified        = 0
+	ControlErrorAuthentication     = 1
+	ControlErrorFormat             = 2
+	ControlErrorOpcode             = 3
+	ControlErrorUnknownAssociation = 4
+	ControlErrorUnknownVariable    = 5
+	ControlErrorValue              = 6
+	ControlErrorProhibited         = 7
+
+	// System status clock sources
+	ControlClockSourceUnspecified = 0
+	ControlClockSourceNTP         = 6
+
+	// Peer status flags and selection codes
+	ControlPeerConfigured  = 0x80
+	ControlPeerAuthEnabled = 0x40
+	ControlPeerAuthentic   = 0x20
+	ControlPeerReachable   = 0x10
+	ControlPeerBroadcast   = 0x08
+
+	ControlSelectReject    = 0
+	ControlSelectCandidate = 4
+	ControlSelectSystem    = 6
+)
+
+var (
+	errUnexpectedControlMessage = errors.New("unexpected control message")
+)
+
+// ControlPacket is an NTP control message.
+type ControlPacket struct {
+	LVM           uint8
+	REMOp         uint8
+	Sequence      uint16
+	Status        uint16
+	AssociationID uint16
+	Offset        uint16
+	Count         uint16
+	Data          []byte
+}
+
+// EncodeControlPacket encodes pkt into b, padding its data to a multiple of 4
+// bytes. pkt.Count is the length of pkt.Data.
+func EncodeControlPacket(b *[]byte, pkt *ControlPacket) {
+	if len(pkt.Data) > ControlMaxDataLen {
+		panic("unexpected NTP control message data length")
+	}
+	n := ControlHeaderLen + (len(pkt.Data)+3)/4*4
+	if cap(*b) < n {
+		*b = make([]byte, n)
+	} else {
+		*b = (*b)[:n]
+	}
+
+	buf := *b
+	buf[0] = byte(pkt.LVM)
+	buf[1] = byte(pkt.REMOp)
+	buf[2] = byte(pkt.Sequence >> 8)
+	buf[3] = byte(pkt.Sequence)
+	buf[4] = byte(pkt.Status >> 8)
+	buf[5] = byte(pkt.Status)
+	buf[6] = byte(pkt.AssociationID >> 8)
+	buf[7] = byte(pkt.AssociationID)
+	buf[8] = byte(pkt.Offset >> 8)
+	buf[9] = byte(pkt.Offset)
+	buf[10] = byte(len(pkt.Data) >> 8)
+	buf[11] = byte(len(pkt.Data))
+	m := copy(buf[ControlHeaderLen:], pkt.Data)
+	clear(buf[ControlHeaderLen+m:])
+}
+
+// DecodeControlPacket decodes the control message b into pkt. pkt.Data refers
+// to b.
+func DecodeControlPacket(pkt *ControlPacket, b []byte) error {
+	if len(b) < ControlHeaderLen {
+		return errUnexpectedPacketSize
+	}
+
+	pkt.LVM = uint8(b[0])
+	pkt.REMOp = uint8(b[1])
+	pkt.Sequence = uint16(b[2])<<8 | uint16(b[3])
+	pkt.Status = uint16(b[4])<<8 | uint16(b[5])
+	pkt.AssociationID = uint16(b[6])<<8 | uint16(b[7])
+	pkt.Offset = uint16(b[8])<<8 | uint16(b[9])
+	pkt.Count = uint16(b[10])<<8 | uint16(b[11])
+	if int(pkt.Count) > len(b)-ControlHeaderLen || pkt.Count > ControlMaxDataLen {
+		return errUnexpectedPacketSize
+	}
+	pkt.Data = b[ControlHeaderLen : ControlHeaderLen+int(pkt.Count)]
+
+	return nil
+}
+
+// ValidateControlRequest returns an error unless pkt is an unfragmented
+// control request.
+func ValidateControlRequest(pkt *ControlPacket) error {
+	if pkt.Mode() != ModeControl {
+		return errUnexpectedControlMessage
+	}
+	if pkt.Version() < VersionMin || pkt.Version() > VersionMax {
+		return errUnexpectedControlMessage
+	}
+	if pkt.REMOp&(ControlFlagResponse|ControlFlagError|ControlFlagMore) != 0 || pkt.Offset != 0 {
+		return errUnexpectedControlMessage
+	}
+	return nil
+}
+
+func (p *ControlPacket) Version() uint8 {
+	return (p.LVM >> 3) & 0b0000_0111
+}
+
+func (p *ControlPacket) Mode() uint8 {
+	return p.LVM & 0b0000_0111
+}
+
+func (p *ControlPacket) Opcode() uint8 {
+	return p.REMOp & 0b0001_1111
+}
+
+// ControlSystemStatus returns the system status word of a control response.
+func ControlSystemStatus(leap, clockSource, eventCount, eventCode uint8) uint16 {
+	return uint16(leap&0b11)<<14 | uint16(clockSource&0b11_1111)<<8 |
+		uint16(eventCount&0b1111)<<4 | uint16(eventCode&0b1111)
+}
+
+// ControlPeerStatus returns the peer status word of a control response.
+func ControlPeerStatus(flags, selection, eventCount, eventCode uint8) uint16 {
+	return uint16(flags&0b1111_1000|selection&0b111)<<8 |
+		uint16(eventCount&0b1111)<<4 | uint16(eventCode&0b1111)
+}
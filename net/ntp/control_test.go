@@ -0,0 +1,76 @@
+package ntp_test
+
+import (
+	"bytes"
+	"testing"
+
+	"example.com/scion-time/net/ntp"
+)
+
+func TestControlPacketEncodeDecode(t *testing.T) {
+	pkt := ntp.ControlPacket{
+		LVM:           2<<3 | ntp.ModeControl,
+		REMOp:         ntp.ControlFlagResponse | ntp.ControlFlagMore | ntp.ControlOpReadVariables,
+		Sequence:      0x1234,
+		Status:        0x0615,
+		AssociationID: 7,
+		Offset:        468,
+		Data:          []byte("stratum=1"),
+	}
+	var b []byte
+	ntp.EncodeControlPacket(&b, &pkt)
+	if len(b) != ntp.ControlHeaderLen+12 || b[len(b)-1] != 0 {
+		t.Fatalf("EncodeControlPacket() = %x; want data padded to 12 bytes", b)
+	}
+
+	var got ntp.ControlPacket
+	err := ntp.DecodeControlPacket(&got, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.LVM != pkt.LVM || got.REMOp != pkt.REMOp || got.Sequence != pkt.Sequence ||
+		got.Status != pkt.Status || got.AssociationID != pkt.AssociationID ||
+		got.Offset != pkt.Offset || int(got.Count) != len(pkt.Data) || !bytes.Equal(got.Data, pkt.Data) {
+		t.Errorf("DecodeControlPacket() = %+v; want %+v", got, pkt)
+	}
+	if got.Version() != 2 || got.Mode() != ntp.ModeControl || got.Opcode() != ntp.ControlOpReadVariables {
+		t.Errorf("unexpected version %d, mode %d or opcode %d", got.Version(), got.Mode(), got.Opcode())
+	}
+
+	err = ntp.DecodeControlPacket(&got, b[:ntp.ControlHeaderLen+8])
+	if err == nil {
+		t.Error("DecodeControlPacket() accepted truncated data")
+	}
+	err = ntp.DecodeControlPacket(&got, b[:ntp.ControlHeaderLen-1])
+	if err == nil {
+		t.Error("DecodeControlPacket() accepted truncated header")
+	}
+}
+
+func TestValidateControlRequest(t *testing.T) {
+	req := ntp.ControlPacket{LVM: 2<<3 | ntp.ModeControl, REMOp: ntp.ControlOpReadStatus}
+	if err := ntp.ValidateControlRequest(&req); err != nil {
+		t.Errorf("ValidateControlRequest() failed: %v", err)
+	}
+	for _, pkt := range []ntp.ControlPacket{
+		{LVM: 2<<3 | ntp.ModeClient, REMOp: ntp.ControlOpReadStatus},
+		{LVM: 0<<3 | ntp.ModeControl, REMOp: ntp.ControlOpReadStatus},
+		{LVM: 2<<3 | ntp.ModeControl, REMOp: ntp.ControlFlagResponse | ntp.ControlOpReadStatus},
+		{LVM: 2<<3 | ntp.ModeControl, REMOp: ntp.ControlFlagMore | ntp.ControlOpReadVariables},
+		{LVM: 2<<3 | ntp.ModeControl, REMOp: ntp.ControlOpReadVariables, Offset: 468},
+	} {
+		if err := ntp.ValidateControlRequest(&pkt); err == nil {
+			t.Errorf("ValidateControlRequest() accepted %+v", pkt)
+		}
+	}
+}
+
+func TestControlStatus(t *testing.T) {
+	if s := ntp.ControlSystemStatus(ntp.LeapIndicatorUnknown, ntp.ControlClockSourceNTP, 1, 5); s != 0xc615 {
+		t.Errorf("ControlSystemStatus() = %#04x; want 0xc615", s)
+	}
+	s := ntp.ControlPeerStatus(ntp.ControlPeerConfigured|ntp.ControlPeerReachable, ntp.ControlSelectCandidate, 0, 0)
+	if s != 0x9400 {
+		t.Errorf("ControlPeerStatus() = %#04x; want 0x9400", s)
+	}
+}
@@ -45,3 +45,25 @@ func (v PacketLogValuer) LogValue() slog.Value {
 		slog.Any("TransmitTime", Time64LogValuer{T: v.Pkt.TransmitTime}),
 	)
 }
+
+type PacketV5LogValuer struct {
+	Pkt *PacketV5
+}
+
+func (v PacketV5LogValuer) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.Uint64("LVM", uint64(v.Pkt.LVM)),
+		slog.Uint64("Stratum", uint64(v.Pkt.Stratum)),
+		slog.Int64("Poll", int64(v.Pkt.Poll)),
+		slog.Int64("Precision", int64(v.Pkt.Precision)),
+		slog.Uint64("Timescale", uint64(v.Pkt.Timescale)),
+		slog.Uint64("Era", uint64(v.Pkt.Era)),
+		slog.Uint64("Flags", uint64(v.Pkt.Flags)),
+		slog.Uint64("RootDelay", uint64(v.Pkt.RootDelay)),
+		slog.Uint64("RootDispersion", uint64(v.Pkt.RootDispersion)),
+		slog.Uint64("ServerCookie", v.Pkt.ServerCookie),
+		slog.Uint64("ClientCookie", v.Pkt.ClientCookie),
+		slog.Any("ReceiveTime", Time64LogValuer{T: v.Pkt.ReceiveTime}),
+		slog.Any("TransmitTime", Time64LogValuer{T: v.Pkt.TransmitTime}),
+	)
+}
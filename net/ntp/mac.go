@@ -0,0 +1,266 @@
+package ntp
+
+// See RFC 5905, Section 7.3 and RFC 8573, message authentication codes (MACs)
+// based on symmetric keys
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/dchest/cmac"
+)
+
+const (
+	MACKeyIDLen = 4
+
+	// maxDigestLen is the maximum length of a digest in a MAC, see RFC 7822,
+	// Section 7.5. Longer digests are truncated.
+	maxDigestLen = 20
+
+	// MaxMACLen is the maximum length of a MAC consisting of a key ID and a
+	// digest.
+	MaxMACLen = MACKeyIDLen + maxDigestLen
+)
+
+// KeyType is the algorithm with which a MAC is computed.
+type KeyType int
+
+const (
+	// KeyTypeAES128CMAC computes MACs with AES-CMAC, see RFC 8573.
+	KeyTypeAES128CMAC KeyType = iota
+	// KeyTypeSHA1 computes MACs as the SHA-1 digest of the key and the
+	// packet, see RFC 5905, Section 7.3.
+	KeyTypeSHA1
+	// KeyTypeSHA256 computes MACs as the SHA-256 digest of the key and the
+	// packet, truncated to 20 bytes.
+	KeyTypeSHA256
+)
+
+var (
+	errUnexpectedMAC = errors.New("unexpected MAC")
+	errInvalidMAC    = errors.New("invalid MAC")
+)
+
+// A SymmetricKey is a key shared by NTP servers, peers and clients to
+// authenticate packets by a MAC. The zero Type is AES-128-CMAC, for which
+// Value must be an AES-128 key.
+type SymmetricKey struct {
+	ID    uint32
+	Type  KeyType
+	Value []byte
+}
+
+func (k SymmetricKey) digestLen() int {
+	if k.Type == KeyTypeAES128CMAC {
+		return aes.BlockSize
+	}
+	return maxDigestLen
+}
+
+// HasMAC reports whether the NTP packet b ends with a MAC. Packets with a MAC
+// do not carry extension fields.
+func HasMAC(b []byte) bool {
+	return len(b) == PacketLen+MACKeyIDLen+aes.BlockSize || len(b) == PacketLen+MaxMACLen
+}
+
+// MACKeyID returns the key ID of the MAC of the NTP packet b.
+func MACKeyID(b []byte) uint32 {
+	if !HasMAC(b) {
+		panic("unexpected NTP packet structure")
+	}
+	b = b[PacketLen:]
+	return uint32(b[0])<<24 | uint32(b[1])<<16 | uint32(b[2])<<8 | uint32(b[3])
+}
+
+func digest(b []byte, key SymmetricKey) []byte {
+	switch key.Type {
+	case KeyTypeAES128CMAC:
+		block, err := aes.NewCipher(key.Value)
+		if err != nil {
+			panic(err)
+		}
+		h, err := cmac.New(block)
+		if err != nil {
+			panic(err)
+		}
+		_, _ = h.Write(b)
+		return h.Sum(nil)
+	case KeyTypeSHA1:
+		h := sha1.New()
+		_, _ = h.Write(key.Value)
+		_, _ = h.Write(b)
+		return h.Sum(nil)
+	case KeyTypeSHA256:
+		h := sha256.New()
+		_, _ = h.Write(key.Value)
+		_, _ = h.Write(b)
+		return h.Sum(nil)[:maxDigestLen]
+	default:
+		panic("unexpected key type")
+	}
+}
+
+// AppendMAC appends the MAC of the NTP packet b computed with key to b.
+func AppendMAC(b []byte, key SymmetricKey) []byte {
+	if len(b) != PacketLen {
+		panic("unexpected NTP packet structure")
+	}
+	d := digest(b, key)
+	b = append(b, byte(key.ID>>24), byte(key.ID>>16), byte(key.ID>>8), byte(key.ID))
+	return append(b, d...)
+}
+
+// VerifyMAC returns an error unless the NTP packet b ends with a valid MAC
+// computed with key.
+func VerifyMAC(b []byte, key SymmetricKey) error {
+	if len(b) != PacketLen+MACKeyIDLen+key.digestLen() || MACKeyID(b) != key.ID {
+		return errUnexpectedMAC
+	}
+	d := digest(b[:PacketLen], key)
+	if subtle.ConstantTimeCompare(b[PacketLen+MACKeyIDLen:], d) != 1 {
+		return errInvalidMAC
+	}
+	return nil
+}
+
+// KeyFileFormat is the format of a file of symmetric keys.
+type KeyFileFormat int
+
+const (
+	// KeyFileChrony is the key file format of chrony, see chrony.conf(5). Each
+	// line consists of a key ID, optionally the key type, MD5 if omitted, and
+	// the key, which is hex encoded if prefixed by "HEX:" and ASCII otherwise,
+	// optionally prefixed by "ASCII:". Lines starting with '#', '!', ';' or
+	// '%' are comments. Supported key types are "AES128", "SHA1" and
+	// "SHA256".
+	KeyFileChrony KeyFileFormat = iota
+	// KeyFileNTPD is the key file format of ntpd, see ntp.keys(5). Each line
+	// consists of a key ID between 1 and 65535, the key type and the key,
+	// which is ASCII if it is at most 20 characters long and hex encoded
+	// otherwise. Comments start with '#'. Supported key types are
+	// "AES128CMAC", "SHA1" and "SHA256", in any case. Address restrictions
+	// following the key are not supported.
+	KeyFileNTPD
+)
+
+// keyTypes maps the names of the supported key types to key types.
+var keyTypes = [...]map[string]KeyType{
+	KeyFileChrony: {"AES128": KeyTypeAES128CMAC, "SHA1": KeyTypeSHA1, "SHA256": KeyTypeSHA256},
+	KeyFileNTPD:   {"AES128CMAC": KeyTypeAES128CMAC, "SHA1": KeyTypeSHA1, "SHA256": KeyTypeSHA256},
+}
+
+// ntpdMaxASCIIKeyLen is the maximum length of ASCII keys in ntpd key files,
+// longer keys are hex encoded.
+const ntpdMaxASCIIKeyLen = 20
+
+// parseKeyLine parses line of a key file in format and returns its key ID,
+// key type name and key. Comments and empty lines yield key ID 0.
+func parseKeyLine(line string, format KeyFileFormat) (uint64, string, []byte, error) {
+	var fields []string
+	switch format {
+	case KeyFileChrony:
+		fields = strings.Fields(line)
+		if len(fields) == 0 || strings.ContainsAny(fields[0][:1], "#!;%") {
+			return 0, "", nil, nil
+		}
+		if len(fields) == 2 {
+			fields = []string{fields[0], "MD5", fields[1]}
+		}
+	case KeyFileNTPD:
+		line, _, _ = strings.Cut(line, "#")
+		fields = strings.Fields(line)
+		if len(fields) == 0 {
+			return 0, "", nil, nil
+		}
+		if len(fields) > 3 {
+			return 0, "", nil, errors.New("address restrictions not supported")
+		}
+	default:
+		panic("unexpected key file format")
+	}
+	if len(fields) != 3 {
+		return 0, "", nil, errors.New("unexpected number of fields")
+	}
+
+	id, err := strconv.ParseUint(fields[0], 10, 32)
+	if err != nil || id == 0 || format == KeyFileNTPD && id > 65535 {
+		return 0, "", nil, errors.New("invalid key ID")
+	}
+	typ := fields[1]
+	if format == KeyFileNTPD {
+		typ = strings.ToUpper(typ)
+	}
+	var key []byte
+	switch {
+	case format == KeyFileChrony && strings.HasPrefix(fields[2], "HEX:"):
+		key, err = hex.DecodeString(fields[2][len("HEX:"):])
+	case format == KeyFileChrony:
+		key = []byte(strings.TrimPrefix(fields[2], "ASCII:"))
+	case len(fields[2]) <= ntpdMaxASCIIKeyLen:
+		key = []byte(fields[2])
+	default:
+		key, err = hex.DecodeString(fields[2])
+	}
+	if err != nil {
+		return 0, "", nil, fmt.Errorf("invalid key: %w", err)
+	}
+	return id, typ, key, nil
+}
+
+// LoadSymmetricKeys reads symmetric keys from keyFile in format. Keys of
+// unsupported types, e.g., MD5, are ignored like chrony and ntpd do. The key
+// file must be a regular file not accessible by group or others.
+func LoadSymmetricKeys(keyFile string, format KeyFileFormat) (map[uint32]SymmetricKey, error) {
+	fi, err := os.Stat(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	if !fi.Mode().IsRegular() {
+		return nil, fmt.Errorf("NTP key file %s is not a regular file", keyFile)
+	}
+	if fi.Mode().Perm()&0o077 != 0 {
+		return nil, fmt.Errorf("NTP key file %s must not be accessible by group or others (mode %v)",
+			keyFile, fi.Mode().Perm())
+	}
+	f, err := os.Open(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+	keys := make(map[uint32]SymmetricKey)
+	s := bufio.NewScanner(f)
+	for n := 1; s.Scan(); n++ {
+		id, name, key, err := parseKeyLine(s.Text(), format)
+		if err != nil {
+			return nil, fmt.Errorf("NTP key file %s, line %d: %w", keyFile, n, err)
+		}
+		if id == 0 {
+			continue
+		}
+		typ, ok := keyTypes[format][name]
+		if !ok {
+			continue
+		}
+		if typ == KeyTypeAES128CMAC && len(key) != aes.BlockSize || len(key) == 0 {
+			return nil, fmt.Errorf("NTP key file %s, line %d: unexpected key length", keyFile, n)
+		}
+		if _, ok := keys[uint32(id)]; ok {
+			return nil, fmt.Errorf("NTP key file %s, line %d: duplicate key ID %d", keyFile, n, id)
+		}
+		keys[uint32(id)] = SymmetricKey{ID: uint32(id), Type: typ, Value: key}
+	}
+	err = s.Err()
+	if err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
@@ -0,0 +1,173 @@
+package ntp_test
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"example.com/scion-time/net/ntp"
+)
+
+// RFC 4493, Section 4, example key
+var testSymmetricKey = ntp.SymmetricKey{
+	ID: 1,
+	Value: []byte{
+		0x2b, 0x7e, 0x15, 0x16, 0x28, 0xae, 0xd2, 0xa6,
+		0xab, 0xf7, 0x15, 0x88, 0x09, 0xcf, 0x4f, 0x3c,
+	},
+}
+
+func TestMAC(t *testing.T) {
+	p := ntp.Packet{}
+	p.SetVersion(ntp.VersionMax)
+	p.SetMode(ntp.ModeSymmetricActive)
+	p.TransmitTime = ntp.Time64{Seconds: 0xe5f663a8, Fraction: 0x798eae2b}
+	b := make([]byte, ntp.PacketLen)
+	ntp.EncodePacket(&b, &p)
+	if ntp.HasMAC(b) {
+		t.Error("HasMAC() = true for packet without MAC")
+	}
+
+	b = ntp.AppendMAC(b, testSymmetricKey)
+	if !ntp.HasMAC(b) || ntp.MACKeyID(b) != testSymmetricKey.ID {
+		t.Errorf("AppendMAC() = %x", b)
+	}
+	err := ntp.VerifyMAC(b, testSymmetricKey)
+	if err != nil {
+		t.Errorf("VerifyMAC() failed: %v", err)
+	}
+
+	otherKey := ntp.SymmetricKey{ID: 2, Value: testSymmetricKey.Value}
+	err = ntp.VerifyMAC(b, otherKey)
+	if err == nil {
+		t.Error("VerifyMAC() accepted MAC with unexpected key ID")
+	}
+
+	for i := range b {
+		c := append([]byte(nil), b...)
+		c[i] ^= 0x01
+		if ntp.VerifyMAC(c, testSymmetricKey) == nil {
+			t.Errorf("VerifyMAC() accepted packet modified at offset %d", i)
+		}
+	}
+}
+
+func TestMACDigest(t *testing.T) {
+	p := ntp.Packet{}
+	p.SetVersion(ntp.VersionMax)
+	p.SetMode(ntp.ModeClient)
+	p.TransmitTime = ntp.Time64{Seconds: 0xe5f663a8, Fraction: 0x798eae2b}
+	b := make([]byte, ntp.PacketLen)
+	ntp.EncodePacket(&b, &p)
+
+	value := []byte("0123456789abcdefghij")
+	sha1Digest := sha1.Sum(append(append([]byte(nil), value...), b...))
+	sha256Digest := sha256.Sum256(append(append([]byte(nil), value...), b...))
+	for _, tc := range []struct {
+		key    ntp.SymmetricKey
+		digest []byte
+	}{
+		{ntp.SymmetricKey{ID: 1, Type: ntp.KeyTypeSHA1, Value: value}, sha1Digest[:]},
+		{ntp.SymmetricKey{ID: 2, Type: ntp.KeyTypeSHA256, Value: value}, sha256Digest[:20]},
+	} {
+		c := ntp.AppendMAC(append([]byte(nil), b...), tc.key)
+		if !ntp.HasMAC(c) || ntp.MACKeyID(c) != tc.key.ID ||
+			!bytes.Equal(c[ntp.PacketLen+ntp.MACKeyIDLen:], tc.digest) {
+			t.Errorf("AppendMAC() = %x", c)
+		}
+		err := ntp.VerifyMAC(c, tc.key)
+		if err != nil {
+			t.Errorf("VerifyMAC() failed: %v", err)
+		}
+		err = ntp.VerifyMAC(c, ntp.SymmetricKey{ID: tc.key.ID, Value: testSymmetricKey.Value})
+		if err == nil {
+			t.Error("VerifyMAC() accepted MAC with unexpected key type")
+		}
+	}
+}
+
+func writeKeyFile(t *testing.T, data string, perm os.FileMode) string {
+	t.Helper()
+	name := filepath.Join(t.TempDir(), "ntp.keys")
+	err := os.WriteFile(name, []byte(data), perm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return name
+}
+
+func TestLoadSymmetricKeys(t *testing.T) {
+	// The key files follow the examples in chrony.conf(5) and ntp.keys(5).
+	name := writeKeyFile(t, "# chrony keys\n"+
+		"10 tulip\n"+
+		"20 MD5 ASCII:crocus\n"+
+		"25 SHA1 HEX:933F62BE1D604E68A81B557F18CFA200483F5B70\n"+
+		"30 AES128 HEX:7EA62AE64D190114D46D5A082F948EC1\n"+
+		"31 AES256 HEX:37DDCBC67BB902BCB8E995977FAB4D2B5642F5B32EBCEEE421921D97E5CBFE39\n"+
+		"\n"+
+		"! SHA256 keys\n"+
+		"40 SHA256 ASCII:secret\n"+
+		"41 SHA256 0123456789abcdef0123456789abcdef0123456789abcdef\n", 0o600)
+	keys, err := ntp.LoadSymmetricKeys(name, ntp.KeyFileChrony)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 4 ||
+		keys[25].Type != ntp.KeyTypeSHA1 || len(keys[25].Value) != 20 || keys[25].Value[0] != 0x93 ||
+		keys[30].Type != ntp.KeyTypeAES128CMAC || len(keys[30].Value) != 16 || keys[30].Value[15] != 0xc1 ||
+		keys[40].Type != ntp.KeyTypeSHA256 || string(keys[40].Value) != "secret" ||
+		string(keys[41].Value) != "0123456789abcdef0123456789abcdef0123456789abcdef" {
+		t.Errorf("LoadSymmetricKeys(chrony) = %v", keys)
+	}
+
+	name = writeKeyFile(t, "# ntpkey_MD5key_bk.ntp.org.3595864945\n"+
+		"# Thu Dec 12 19:22:25 2013\n"+
+		" 1 MD5  L\";Nw<`.I<f4U0)247\"i  # MD5 key\n"+
+		"11 SHA1 d3e54352e5548080dba07aab4a3a3e0d9e4c3dd1  # SHA1 key\n"+
+		"12 sha256 ASCII:secret\n"+
+		"13 AES128CMAC 2b7e151628aed2a6abf7158809cf4f3c\n"+
+		"14 AES128CMAC 0123456789abcdef\n", 0o600)
+	keys, err = ntp.LoadSymmetricKeys(name, ntp.KeyFileNTPD)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 4 ||
+		keys[11].Type != ntp.KeyTypeSHA1 || len(keys[11].Value) != 20 || keys[11].Value[0] != 0xd3 ||
+		keys[12].Type != ntp.KeyTypeSHA256 || string(keys[12].Value) != "ASCII:secret" ||
+		keys[13].Type != ntp.KeyTypeAES128CMAC || keys[13].Value[0] != 0x2b ||
+		string(keys[14].Value) != "0123456789abcdef" {
+		t.Errorf("LoadSymmetricKeys(ntpd) = %v", keys)
+	}
+
+	for _, tc := range []struct {
+		format ntp.KeyFileFormat
+		data   string
+	}{
+		{ntp.KeyFileChrony, "1 AES128 HEX:2b7e151628aed2a6abf7158809cf4f3c extra\n"},
+		{ntp.KeyFileChrony, "0 AES128 HEX:2b7e151628aed2a6abf7158809cf4f3c\n"},
+		{ntp.KeyFileChrony, "1 AES128 HEX:2b7e151628aed2a6abf7158809cf4f\n"},
+		{ntp.KeyFileChrony, "1 AES128 HEX:2b7e151628aed2a6abf7158809cf4f3g\n"},
+		{ntp.KeyFileChrony, "1 AES128 2b7e151628aed2a6abf7158809cf4f3c\n"},
+		{ntp.KeyFileChrony, "1 SHA1 ASCII:\n"},
+		{ntp.KeyFileChrony, "1 SHA1 a\n1 SHA256 b\n"},
+		{ntp.KeyFileNTPD, "1 AES128CMAC\n"},
+		{ntp.KeyFileNTPD, "65536 SHA1 secret\n"},
+		{ntp.KeyFileNTPD, "1 SHA1 secret 192.0.2.1\n"},
+		{ntp.KeyFileNTPD, "1 AES128CMAC 0123456789abcde\n"},
+		{ntp.KeyFileNTPD, "1 SHA1 d3e54352e5548080dba07aab4a3a3e0d9e4c3dd\n"},
+	} {
+		_, err := ntp.LoadSymmetricKeys(writeKeyFile(t, tc.data, 0o600), tc.format)
+		if err == nil {
+			t.Errorf("LoadSymmetricKeys(%v) accepted %q", tc.format, tc.data)
+		}
+	}
+
+	_, err = ntp.LoadSymmetricKeys(writeKeyFile(t, "1 AES128 HEX:2b7e151628aed2a6abf7158809cf4f3c\n", 0o644),
+		ntp.KeyFileChrony)
+	if err == nil {
+		t.Error("LoadSymmetricKeys() accepted key file accessible by others")
+	}
+}
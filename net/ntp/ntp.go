@@ -33,6 +33,12 @@ const (
 	ModeBroadcast        = 5
 	ModeControl          = 6
 	ModeReserved7        = 7
+
+	// Kiss codes, see RFC 5905, Section 7.4, and RFC 8915, Section 5.7
+	KissCodeDENY = 0x44454e59
+	KissCodeNTSN = 0x4e54534e
+	KissCodeRATE = 0x52415445
+	KissCodeRSTR = 0x52535452
 )
 
 type Time32 struct {
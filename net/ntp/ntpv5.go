@@ -0,0 +1,263 @@
+package ntp
+
+// See draft-ietf-ntp-ntpv5, Network Time Protocol Version 5
+
+import (
+	"bytes"
+	"errors"
+	"time"
+)
+
+const (
+	Version5 = 5
+
+	TimescaleUTC            = 0
+	TimescaleTAI            = 1
+	TimescaleUT1            = 2
+	TimescaleLeapSmearedUTC = 3
+
+	FlagUnknownLeap = 0x0001
+	FlagInterleaved = 0x0002
+	FlagAuthNAK     = 0x0004
+
+	// NTPv5 extension field types
+	ExtPadding              = 0xf501
+	ExtMAC                  = 0xf502
+	ExtRefIDsRequest        = 0xf503
+	ExtRefIDsResponse       = 0xf504
+	ExtServerInfo           = 0xf505
+	ExtCorrection           = 0xf506
+	ExtReferenceTimestamp   = 0xf507
+	ExtMonotonicRxTimestamp = 0xf508
+	ExtSecondaryRxTimestamp = 0xf509
+	ExtDraftIdentification  = 0xf5ff
+
+	// DraftIdentification identifies the version of the NTPv5 draft
+	// implemented, NTPv5 packets are only exchanged between peers implementing
+	// the same draft version.
+	DraftIdentification = "draft-ietf-ntp-ntpv5-06"
+)
+
+const extensionFieldHeaderLength = 4
+
+// NegotiationReferenceTime is the reference timestamp of NTPv4 requests with
+// which a client indicates support for NTPv5. Servers supporting NTPv5 echo it
+// in the reference timestamp of their NTPv4 responses.
+var NegotiationReferenceTime = Time64{Seconds: 0x4e545035, Fraction: 0x4e545035} // "NTP5NTP5"
+
+// PacketV5 is an NTPv5 packet header. The root delay and root dispersion are
+// unsigned fixed-point values in seconds with 28 fractional bits.
+type PacketV5 struct {
+	LVM            uint8
+	Stratum        uint8
+	Poll           int8
+	Precision      int8
+	Timescale      uint8
+	Era            uint8
+	Flags          uint16
+	RootDelay      uint32
+	RootDispersion uint32
+	ServerCookie   uint64
+	ClientCookie   uint64
+	ReceiveTime    Time64
+	TransmitTime   Time64
+}
+
+var (
+	errUnexpectedExtensionField = errors.New("unexpected extension field")
+)
+
+// EraFromTime returns the NTP era of t, i.e., the number of NTP timestamp
+// overflows since the NTP epoch.
+func EraFromTime(t time.Time) uint8 {
+	s := t.Unix() - epoch
+	if s < 0 {
+		return uint8((s - secondsPerEra + 1) / secondsPerEra)
+	}
+	return uint8(s / secondsPerEra)
+}
+
+// TimeFromTime64Era converts an NTP timestamp of NTP era era to a time.Time.
+func TimeFromTime64Era(t Time64, era uint8) time.Time {
+	sec := epoch + int64(era)*secondsPerEra + int64(t.Seconds)
+	nsec := int64(t.Fraction) * nanosecondsPerSecond >> 32
+	return time.Unix(sec, nsec).UTC()
+}
+
+func EncodePacketV5(b *[]byte, pkt *PacketV5) {
+	if cap(*b) < PacketLen {
+		*b = make([]byte, PacketLen)
+	} else {
+		*b = (*b)[:PacketLen]
+	}
+
+	buf := *b
+	_ = buf[47]
+	buf[0] = byte(pkt.LVM)
+	buf[1] = byte(pkt.Stratum)
+	buf[2] = byte(pkt.Poll)
+	buf[3] = byte(pkt.Precision)
+	buf[4] = byte(pkt.Timescale)
+	buf[5] = byte(pkt.Era)
+	buf[6] = byte(pkt.Flags >> 8)
+	buf[7] = byte(pkt.Flags)
+	buf[8] = byte(pkt.RootDelay >> 24)
+	buf[9] = byte(pkt.RootDelay >> 16)
+	buf[10] = byte(pkt.RootDelay >> 8)
+	buf[11] = byte(pkt.RootDelay)
+	buf[12] = byte(pkt.RootDispersion >> 24)
+	buf[13] = byte(pkt.RootDispersion >> 16)
+	buf[14] = byte(pkt.RootDispersion >> 8)
+	buf[15] = byte(pkt.RootDispersion)
+	for i := range 8 {
+		buf[16+i] = byte(pkt.ServerCookie >> (56 - 8*i))
+		buf[24+i] = byte(pkt.ClientCookie >> (56 - 8*i))
+	}
+	buf[32] = byte(pkt.ReceiveTime.Seconds >> 24)
+	buf[33] = byte(pkt.ReceiveTime.Seconds >> 16)
+	buf[34] = byte(pkt.ReceiveTime.Seconds >> 8)
+	buf[35] = byte(pkt.ReceiveTime.Seconds)
+	buf[36] = byte(pkt.ReceiveTime.Fraction >> 24)
+	buf[37] = byte(pkt.ReceiveTime.Fraction >> 16)
+	buf[38] = byte(pkt.ReceiveTime.Fraction >> 8)
+	buf[39] = byte(pkt.ReceiveTime.Fraction)
+	buf[40] = byte(pkt.TransmitTime.Seconds >> 24)
+	buf[41] = byte(pkt.TransmitTime.Seconds >> 16)
+	buf[42] = byte(pkt.TransmitTime.Seconds >> 8)
+	buf[43] = byte(pkt.TransmitTime.Seconds)
+	buf[44] = byte(pkt.TransmitTime.Fraction >> 24)
+	buf[45] = byte(pkt.TransmitTime.Fraction >> 16)
+	buf[46] = byte(pkt.TransmitTime.Fraction >> 8)
+	buf[47] = byte(pkt.TransmitTime.Fraction)
+}
+
+func DecodePacketV5(pkt *PacketV5, b []byte) error {
+	if len(b) < PacketLen {
+		return errUnexpectedPacketSize
+	}
+
+	_ = b[47]
+	pkt.LVM = uint8(b[0])
+	pkt.Stratum = uint8(b[1])
+	pkt.Poll = int8(b[2])
+	pkt.Precision = int8(b[3])
+	pkt.Timescale = uint8(b[4])
+	pkt.Era = uint8(b[5])
+	pkt.Flags = uint16(b[6])<<8 | uint16(b[7])
+	pkt.RootDelay = uint32(b[8])<<24 | uint32(b[9])<<16 | uint32(b[10])<<8 | uint32(b[11])
+	pkt.RootDispersion = uint32(b[12])<<24 | uint32(b[13])<<16 | uint32(b[14])<<8 | uint32(b[15])
+	pkt.ServerCookie, pkt.ClientCookie = 0, 0
+	for i := range 8 {
+		pkt.ServerCookie = pkt.ServerCookie<<8 | uint64(b[16+i])
+		pkt.ClientCookie = pkt.ClientCookie<<8 | uint64(b[24+i])
+	}
+	pkt.ReceiveTime.Seconds = uint32(b[32])<<24 | uint32(b[33])<<16 | uint32(b[34])<<8 | uint32(b[35])
+	pkt.ReceiveTime.Fraction = uint32(b[36])<<24 | uint32(b[37])<<16 | uint32(b[38])<<8 | uint32(b[39])
+	pkt.TransmitTime.Seconds = uint32(b[40])<<24 | uint32(b[41])<<16 | uint32(b[42])<<8 | uint32(b[43])
+	pkt.TransmitTime.Fraction = uint32(b[44])<<24 | uint32(b[45])<<16 | uint32(b[46])<<8 | uint32(b[47])
+
+	return nil
+}
+
+func (p *PacketV5) LeapIndicator() uint8 {
+	return (p.LVM >> 6) & 0b0000_0011
+}
+
+func (p *PacketV5) SetLeapIndicator(l uint8) {
+	if l&0b0000_0011 != l {
+		panic("unexpected NTP leap indicator value")
+	}
+	p.LVM = (p.LVM & 0b0011_1111) | (l << 6)
+}
+
+func (p *PacketV5) Version() uint8 {
+	return (p.LVM >> 3) & 0b0000_0111
+}
+
+func (p *PacketV5) SetVersion(v uint8) {
+	if v&0b0000_0111 != v {
+		panic("unexpected NTP version value")
+	}
+	p.LVM = (p.LVM & 0b_1100_0111) | (v << 3)
+}
+
+func (p *PacketV5) Mode() uint8 {
+	return p.LVM & 0b0000_0111
+}
+
+func (p *PacketV5) SetMode(m uint8) {
+	if m&0b0000_0111 != m {
+		panic("unexpected NTP mode value")
+	}
+	p.LVM = (p.LVM & 0b1111_1000) | m
+}
+
+// AppendExtensionField appends an extension field of type typ with value v,
+// zero-padded to a multiple of 4 bytes, to b.
+func AppendExtensionField(b []byte, typ uint16, v []byte) []byte {
+	n := extensionFieldHeaderLength + (len(v)+3)&^3
+	b = append(b, byte(typ>>8), byte(typ), byte(n>>8), byte(n))
+	b = append(b, v...)
+	for range n - extensionFieldHeaderLength - len(v) {
+		b = append(b, 0)
+	}
+	return b
+}
+
+// DecodeExtensionField returns the type, the value including padding, and the
+// encoded length of the extension field at the beginning of b.
+func DecodeExtensionField(b []byte) (uint16, []byte, int, error) {
+	if len(b) < extensionFieldHeaderLength {
+		return 0, nil, 0, errUnexpectedExtensionField
+	}
+	n := int(b[2])<<8 | int(b[3])
+	if n < extensionFieldHeaderLength || n%4 != 0 || len(b) < n {
+		return 0, nil, 0, errUnexpectedExtensionField
+	}
+	return uint16(b[0])<<8 | uint16(b[1]), b[extensionFieldHeaderLength:n], n, nil
+}
+
+// AppendDraftIdentification appends the Draft Identification extension field
+// for DraftIdentification to b.
+func AppendDraftIdentification(b []byte) []byte {
+	return AppendExtensionField(b, ExtDraftIdentification, []byte(DraftIdentification))
+}
+
+// IsDraftIdentification reports whether v, the value of a Draft
+// Identification extension field, identifies DraftIdentification.
+func IsDraftIdentification(v []byte) bool {
+	return string(bytes.TrimRight(v, "\x00")) == DraftIdentification
+}
+
+// AppendRefIDsRequest appends a Reference IDs Request extension field to b
+// requesting n bytes of the server's reference ID filter starting at offset.
+// The value of the extension field is as long as the requested chunk s.t. the
+// response is not larger than the request.
+func AppendRefIDsRequest(b []byte, offset, n int) []byte {
+	if n < 4 || n%4 != 0 || offset < 0 || offset+n > RefIDFilterLen {
+		panic("invalid argument: unexpected reference IDs chunk")
+	}
+	v := make([]byte, n)
+	v[0] = byte(offset >> 8)
+	v[1] = byte(offset)
+	return AppendExtensionField(b, ExtRefIDsRequest, v)
+}
+
+// DecodeRefIDsRequest returns the offset and the length of the reference ID
+// filter chunk requested by the Reference IDs Request extension field value v.
+func DecodeRefIDsRequest(v []byte) (offset, n int, err error) {
+	if len(v) < 4 {
+		return 0, 0, errUnexpectedExtensionField
+	}
+	offset = int(v[0])<<8 | int(v[1])
+	if offset+len(v) > RefIDFilterLen {
+		return 0, 0, errUnexpectedExtensionField
+	}
+	return offset, len(v), nil
+}
+
+// AppendRefIDsResponse appends a Reference IDs Response extension field to b
+// holding the n bytes of f starting at offset.
+func AppendRefIDsResponse(b []byte, f *RefIDFilter, offset, n int) []byte {
+	return AppendExtensionField(b, ExtRefIDsResponse, f[offset:offset+n])
+}
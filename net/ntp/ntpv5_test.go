@@ -0,0 +1,188 @@
+package ntp_test
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"example.com/scion-time/net/ntp"
+)
+
+func TestPacketV5(t *testing.T) {
+	p0 := ntp.PacketV5{}
+	p0.SetLeapIndicator(ntp.LeapIndicatorNoWarning)
+	p0.SetVersion(ntp.Version5)
+	p0.SetMode(ntp.ModeServer)
+	p0.Stratum = 1
+	p0.Poll = 6
+	p0.Precision = -32
+	p0.Timescale = ntp.TimescaleUTC
+	p0.Era = 0
+	p0.Flags = ntp.FlagInterleaved
+	p0.RootDelay = 0x400
+	p0.RootDispersion = 0xa000
+	p0.ServerCookie = 0x0123456789abcdef
+	p0.ClientCookie = 0xfedcba9876543210
+	p0.ReceiveTime = ntp.Time64{Seconds: 0xe5f663a8, Fraction: 0x798c6581}
+	p0.TransmitTime = ntp.Time64{Seconds: 0xe5f663a8, Fraction: 0x798eae2b}
+	b0 := make([]byte, ntp.PacketLen)
+	ntp.EncodePacketV5(&b0, &p0)
+	b1 := []byte{
+		0x2c, 0x01, 0x06, 0xe0, 0x00, 0x00, 0x00, 0x02,
+		0x00, 0x00, 0x04, 0x00, 0x00, 0x00, 0xa0, 0x00,
+		0x01, 0x23, 0x45, 0x67, 0x89, 0xab, 0xcd, 0xef,
+		0xfe, 0xdc, 0xba, 0x98, 0x76, 0x54, 0x32, 0x10,
+		0xe5, 0xf6, 0x63, 0xa8, 0x79, 0x8c, 0x65, 0x81,
+		0xe5, 0xf6, 0x63, 0xa8, 0x79, 0x8e, 0xae, 0x2b,
+	}
+	p1 := ntp.PacketV5{}
+	err := ntp.DecodePacketV5(&p1, b1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b1, b0) {
+		t.Errorf("EncodePacketV5() = %x; want %x", b0, b1)
+	}
+	if p1 != p0 {
+		t.Errorf("DecodePacketV5() = %+v; want %+v", p1, p0)
+	}
+
+	// NTPv4 and NTPv5 packets share the positions of the rx and tx timestamps.
+	p2 := ntp.Packet{}
+	err = ntp.DecodePacket(&p2, b1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p2.Version() != ntp.Version5 || p2.ReceiveTime != p0.ReceiveTime || p2.TransmitTime != p0.TransmitTime {
+		t.Errorf("DecodePacket() = %+v; want version 5 and timestamps of %+v", p2, p0)
+	}
+}
+
+func TestEra(t *testing.T) {
+	tests := []struct {
+		name string
+		tt   time.Time
+		era  uint8
+	}{
+		{
+			name: "NTP epoch",
+			tt:   time.Date(1900, 1, 1, 0, 0, 0, 0, time.UTC),
+			era:  0,
+		},
+		{
+			name: "Present",
+			tt:   time.Date(2024, 1, 17, 12, 30, 45, 500000000, time.UTC),
+			era:  0,
+		},
+		{
+			name: "Era 1",
+			tt:   time.Date(2036, 2, 7, 6, 28, 16, 0, time.UTC),
+			era:  1,
+		},
+		{
+			name: "Before NTP epoch",
+			tt:   time.Date(1899, 12, 31, 23, 59, 59, 0, time.UTC),
+			era:  255,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			era := ntp.EraFromTime(tt.tt)
+			if era != tt.era {
+				t.Errorf("EraFromTime() = %d; want %d", era, tt.era)
+			}
+			if tt.tt.Year() < 1900 {
+				return
+			}
+			got := ntp.TimeFromTime64Era(ntp.Time64FromTime(tt.tt), era)
+			if !got.Equal(tt.tt) {
+				t.Errorf("TimeFromTime64Era() = %v; want %v", got, tt.tt)
+			}
+		})
+	}
+}
+
+func TestExtensionFields(t *testing.T) {
+	b := make([]byte, ntp.PacketLen)
+	b = ntp.AppendDraftIdentification(b)
+	b = ntp.AppendRefIDsRequest(b, 128, 128)
+	b = ntp.AppendExtensionField(b, ntp.ExtPadding, []byte{1})
+
+	pos := ntp.PacketLen
+	var types []uint16
+	for pos != len(b) {
+		typ, v, n, err := ntp.DecodeExtensionField(b[pos:])
+		if err != nil {
+			t.Fatalf("DecodeExtensionField() failed: %v", err)
+		}
+		if n%4 != 0 {
+			t.Errorf("DecodeExtensionField() = length %d; want multiple of 4", n)
+		}
+		switch typ {
+		case ntp.ExtDraftIdentification:
+			if !ntp.IsDraftIdentification(v) {
+				t.Errorf("IsDraftIdentification(%q) = false; want true", v)
+			}
+		case ntp.ExtRefIDsRequest:
+			offset, n, err := ntp.DecodeRefIDsRequest(v)
+			if err != nil || offset != 128 || n != 128 {
+				t.Errorf("DecodeRefIDsRequest() = %d, %d, %v; want 128, 128, nil", offset, n, err)
+			}
+		case ntp.ExtPadding:
+			if !bytes.Equal(v, []byte{1, 0, 0, 0}) {
+				t.Errorf("DecodeExtensionField() = %x; want 01000000", v)
+			}
+		}
+		types = append(types, typ)
+		pos += n
+	}
+	if len(types) != 3 {
+		t.Errorf("decoded %d extension fields; want 3", len(types))
+	}
+
+	_, _, _, err := ntp.DecodeExtensionField([]byte{0xf5, 0x01, 0x00, 0x02})
+	if err == nil {
+		t.Error("DecodeExtensionField() succeeded for truncated extension field; want error")
+	}
+	_, _, err = ntp.DecodeRefIDsRequest([]byte{0x01, 0xfc, 0, 0, 0, 0, 0, 0})
+	if err == nil {
+		t.Error("DecodeRefIDsRequest() succeeded beyond end of filter; want error")
+	}
+}
+
+func TestRefIDs(t *testing.T) {
+	r := ntp.NewRefIDs()
+	s := ntp.NewRefIDs()
+
+	f := r.Filter()
+	if !f.Contains(r.ID()) {
+		t.Error("Filter() does not contain own reference ID")
+	}
+
+	g := s.Filter()
+	r.Update("s", &g)
+	f = r.Filter()
+	if !f.Contains(r.ID()) || !f.Contains(s.ID()) {
+		t.Error("Filter() does not contain reference IDs of sources")
+	}
+
+	// Reassemble the filter from chunks as requested by NTPv5 clients.
+	var h ntp.RefIDFilter
+	for offset := 0; offset != ntp.RefIDFilterLen; offset += 128 {
+		b := ntp.AppendRefIDsResponse(nil, &f, offset, 128)
+		_, v, _, err := ntp.DecodeExtensionField(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		copy(h[offset:], v)
+	}
+	if h != f {
+		t.Error("reassembled reference ID filter differs")
+	}
+
+	r.Remove("s")
+	f = r.Filter()
+	if f.Contains(s.ID()) {
+		t.Error("Filter() contains reference ID of removed source")
+	}
+}
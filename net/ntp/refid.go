@@ -0,0 +1,113 @@
+package ntp
+
+// See draft-ietf-ntp-ntpv5, Section 7 (reference IDs and loop detection)
+
+import (
+	"crypto/rand"
+	"sync"
+)
+
+const (
+	RefIDLen       = 15
+	RefIDFilterLen = 512
+
+	refIDFilterBits = RefIDFilterLen * 8
+)
+
+// A RefID is the random 120-bit reference ID of an NTPv5 instance.
+type RefID [RefIDLen]byte
+
+// A RefIDFilter is a 4096-bit Bloom filter of the reference IDs of an NTPv5
+// server and its sources, direct or indirect.
+type RefIDFilter [RefIDFilterLen]byte
+
+func NewRefID() RefID {
+	var id RefID
+	_, err := rand.Read(id[:])
+	if err != nil {
+		panic(err)
+	}
+	return id
+}
+
+// indices returns the ten 12-bit filter indices of id.
+func (id *RefID) indices() [10]int {
+	var x [10]int
+	for i := range 5 {
+		b := id[3*i : 3*i+3]
+		x[2*i] = int(b[0])<<4 | int(b[1])>>4
+		x[2*i+1] = int(b[1]&0x0f)<<8 | int(b[2])
+	}
+	return x
+}
+
+func (f *RefIDFilter) Add(id RefID) {
+	for _, i := range id.indices() {
+		f[i/8] |= 0x80 >> (i % 8)
+	}
+}
+
+// Contains reports whether id may have been added to f. False positives are
+// possible, false negatives are not.
+func (f *RefIDFilter) Contains(id RefID) bool {
+	for _, i := range id.indices() {
+		if f[i/8]&(0x80>>(i%8)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Merge adds all reference IDs of g to f.
+func (f *RefIDFilter) Merge(g *RefIDFilter) {
+	for i := range f {
+		f[i] |= g[i]
+	}
+}
+
+// RefIDs tracks the reference ID of a local NTPv5 instance and the reference
+// ID filters of its sources. It is safe for concurrent use.
+type RefIDs struct {
+	id      RefID
+	mu      sync.Mutex
+	sources map[string]RefIDFilter
+}
+
+func NewRefIDs() *RefIDs {
+	return &RefIDs{
+		id:      NewRefID(),
+		sources: make(map[string]RefIDFilter),
+	}
+}
+
+// ID returns the reference ID of the local instance.
+func (r *RefIDs) ID() RefID {
+	return r.id
+}
+
+// Update replaces the reference ID filter of source by f.
+func (r *RefIDs) Update(source string, f *RefIDFilter) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sources[source] = *f
+}
+
+// Remove discards the reference ID filter of source.
+func (r *RefIDs) Remove(source string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.sources, source)
+}
+
+// Filter returns the reference ID filter to be announced by the local
+// instance: its own reference ID and the filters of all of its sources.
+func (r *RefIDs) Filter() RefIDFilter {
+	var f RefIDFilter
+	f.Add(r.id)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, g := range r.sources {
+		f.Merge(&g)
+	}
+	return f
+}
@@ -28,6 +28,18 @@ func ValidateResponseMetadata(resp *Packet) error {
 	return nil
 }
 
+// IsKissOfDeath reports whether resp is a Kiss-o'-Death packet, i.e., a server
+// response with stratum 0 carrying a kiss code in its reference ID.
+func IsKissOfDeath(resp *Packet) bool {
+	return resp.Mode() == ModeServer && resp.Stratum == 0
+}
+
+// KissCodeString returns the four ASCII character representation of a kiss
+// code.
+func KissCodeString(code uint32) string {
+	return string([]byte{byte(code >> 24), byte(code >> 16), byte(code >> 8), byte(code)})
+}
+
 func ValidateResponseTimestamps(t0, t1, t2, t3 time.Time) error {
 	if t3.Sub(t0) < 0 {
 		panic("unexpected system clock behavior")
@@ -53,3 +65,81 @@ func ValidateRequest(req *Packet, srcPort uint16) error {
 	}
 	return nil
 }
+
+func ValidateRequestV5(req *PacketV5) error {
+	if req.Version() != Version5 || req.Mode() != ModeClient {
+		return errUnexpectedRequest
+	}
+	return nil
+}
+
+// ValidateResponseMetadataV5 validates the metadata of a response to a request
+// for UTC. Responses in other timescales are rejected.
+func ValidateResponseMetadataV5(resp *PacketV5) error {
+	if resp.LeapIndicator() == LeapIndicatorUnknown || resp.Flags&FlagUnknownLeap != 0 {
+		return errUnexpectedResponse
+	}
+	if resp.Version() != Version5 {
+		return errUnexpectedResponse
+	}
+	if resp.Mode() != ModeServer {
+		return errUnexpectedResponse
+	}
+	if resp.Stratum == 0 || resp.Stratum > 15 {
+		return errUnexpectedResponse
+	}
+	if resp.Timescale != TimescaleUTC {
+		return errUnexpectedResponse
+	}
+	return nil
+}
+
+// ValidateSymmetricRequest validates a request of a peer in symmetric active
+// mode, see RFC 5905, Section 9. Requests are valid with any leap indicator:
+// peers announce leap seconds by it and unsynchronized peers are answered
+// nonetheless.
+func ValidateSymmetricRequest(req *Packet) error {
+	vn := req.Version()
+	if vn != 3 && vn != 4 {
+		return errUnexpectedRequest
+	}
+	if req.Mode() != ModeSymmetricActive {
+		return errUnexpectedRequest
+	}
+	return nil
+}
+
+// ValidateSymmetricResponseMetadata validates a response of a peer in symmetric
+// passive mode.
+func ValidateSymmetricResponseMetadata(resp *Packet) error {
+	if resp.LeapIndicator() == LeapIndicatorUnknown {
+		return errUnexpectedResponse
+	}
+	if resp.Version() != 3 && resp.Version() != 4 {
+		return errUnexpectedResponse
+	}
+	if resp.Mode() != ModeSymmetricPassive {
+		return errUnexpectedResponse
+	}
+	if resp.Stratum == 0 || resp.Stratum > 15 {
+		return errUnexpectedResponse
+	}
+	return nil
+}
+
+// ValidateBroadcastMetadata validates a packet of a server in broadcast mode.
+func ValidateBroadcastMetadata(pkt *Packet) error {
+	if pkt.LeapIndicator() == LeapIndicatorUnknown {
+		return errUnexpectedResponse
+	}
+	if pkt.Version() != 3 && pkt.Version() != 4 {
+		return errUnexpectedResponse
+	}
+	if pkt.Mode() != ModeBroadcast {
+		return errUnexpectedResponse
+	}
+	if pkt.Stratum == 0 || pkt.Stratum > 15 {
+		return errUnexpectedResponse
+	}
+	return nil
+}
@@ -0,0 +1,30 @@
+package ntp_test
+
+import (
+	"testing"
+
+	"example.com/scion-time/net/ntp"
+)
+
+func TestValidateSymmetricRequest(t *testing.T) {
+	for _, li := range []uint8{
+		ntp.LeapIndicatorNoWarning,
+		ntp.LeapIndicatorInsertSecond,
+		ntp.LeapIndicatorDeleteSecond,
+		ntp.LeapIndicatorUnknown,
+	} {
+		req := ntp.Packet{LVM: li<<6 | 4<<3 | ntp.ModeSymmetricActive}
+		if err := ntp.ValidateSymmetricRequest(&req); err != nil {
+			t.Errorf("ValidateSymmetricRequest() rejected request with LI=%d: %v", li, err)
+		}
+	}
+	for _, req := range []ntp.Packet{
+		{LVM: ntp.LeapIndicatorInsertSecond<<6 | 2<<3 | ntp.ModeSymmetricActive},
+		{LVM: ntp.LeapIndicatorInsertSecond<<6 | 4<<3 | ntp.ModeClient},
+		{LVM: ntp.LeapIndicatorInsertSecond<<6 | 4<<3 | ntp.ModeSymmetricPassive},
+	} {
+		if err := ntp.ValidateSymmetricRequest(&req); err == nil {
+			t.Errorf("ValidateSymmetricRequest() accepted %+v", req)
+		}
+	}
+}
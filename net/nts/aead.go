@@ -0,0 +1,44 @@
+package nts
+
+// AEAD algorithms negotiable via NTS-KE, see RFC 8915, Section 5.1:
+// AEAD_AES_SIV_CMAC_256, _384 and _512 as specified in RFC 5297 and
+// AEAD_AES_128_GCM_SIV as specified in RFC 8452.
+
+import (
+	"crypto/cipher"
+	"errors"
+
+	gcmsiv "github.com/ericlagergren/siv"
+	"github.com/miscreant/miscreant.go"
+
+	"example.com/scion-time/net/ntske"
+)
+
+const (
+	sivNonceLen = 16
+	tagLen      = 16
+)
+
+var (
+	errUnknownAlgo   = errors.New("unsupported AEAD algorithm")
+	errInvalidKeyLen = errors.New("invalid AEAD key length")
+)
+
+// NewAEAD returns the AEAD algorithm algo, identified by its NTS-KE AEAD
+// algorithm ID, keyed with key.
+func NewAEAD(algo uint16, key []byte) (cipher.AEAD, error) {
+	n := ntske.KeyLen(algo)
+	if n == 0 {
+		return nil, errUnknownAlgo
+	}
+	if len(key) != n {
+		return nil, errInvalidKeyLen
+	}
+	switch algo {
+	case ntske.AES_SIV_CMAC_384:
+		return newSIV(key)
+	case ntske.AES_128_GCM_SIV:
+		return gcmsiv.NewGCM(key)
+	}
+	return miscreant.NewAEAD("AES-CMAC-SIV", key, sivNonceLen)
+}
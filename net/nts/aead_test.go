@@ -0,0 +1,260 @@
+package nts_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+
+	"github.com/miscreant/miscreant.go"
+
+	"example.com/scion-time/net/ntp"
+	"example.com/scion-time/net/nts"
+	"example.com/scion-time/net/ntske"
+)
+
+func decodeHex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestAESSIVVectors(t *testing.T) {
+	// RFC 5297, Appendix A, with AES-128 as AEAD_AES_SIV_CMAC_256. The AES-SIV
+	// implementation for AEAD_AES_SIV_CMAC_384 must produce them, too.
+	tests := []struct {
+		name    string
+		key, pt string
+		data    []string
+		want    string
+	}{{
+		name: "A.1 deterministic",
+		key:  "fffefdfcfbfaf9f8f7f6f5f4f3f2f1f0f0f1f2f3f4f5f6f7f8f9fafbfcfdfeff",
+		data: []string{"101112131415161718191a1b1c1d1e1f2021222324252627"},
+		pt:   "112233445566778899aabbccddee",
+		want: "85632d07c6e8f37f950acd320a2ecc9340c02b9690c4dc04daef7f6afe5c",
+	}, {
+		name: "A.2 nonce-based",
+		key:  "7f7e7d7c7b7a79787776757473727170404142434445464748494a4b4c4d4e4f",
+		data: []string{
+			"00112233445566778899aabbccddeeffdeaddadadeaddadaffeeddccbbaa99887766554433221100",
+			"102030405060708090a0",
+			"09f911029d74e35bd84156c5635688c0",
+		},
+		pt: "7468697320697320736f6d6520706c61696e7465787420746f20656e6372797074" +
+			"207573696e67205349562d414553",
+		want: "7bdb6e3b432667eb06f4d14bff2fbd0fcb900f2fddbe404326601965c889bf17" +
+			"dba77ceb094fa663b7a3f748ba8af829ea64ad544a272e9c485b62a3fd5c0d",
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, pt := decodeHex(t, tt.key), decodeHex(t, tt.pt)
+			var data [][]byte
+			for _, s := range tt.data {
+				data = append(data, decodeHex(t, s))
+			}
+			c, err := miscreant.NewAESCMACSIV(key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ct, err := c.Seal(nil, pt, data...)
+			if got := hex.EncodeToString(ct); err != nil || got != tt.want {
+				t.Errorf("miscreant: Seal() = %s, %v; want %s", got, err, tt.want)
+			}
+			ct, err = nts.SealSIV(key, pt, data...)
+			if got := hex.EncodeToString(ct); err != nil || got != tt.want {
+				t.Errorf("SealSIV() = %s, %v; want %s", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestAEADVectors(t *testing.T) {
+	// The AEAD_AES_SIV_CMAC_384 vectors were generated with the AES-192-SIV
+	// cipher of OpenSSL 3.0, with the associated data and the nonce as header
+	// components. The AEAD_AES_128_GCM_SIV vectors are from RFC 8452,
+	// Appendix C.1.
+	const (
+		sivKey384 = "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f" +
+			"202122232425262728292a2b2c2d2e2f"
+		sivAD    = "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f202122232425262728292a2b2c2d2e2f"
+		sivNonce = "a0a1a2a3a4a5a6a7a8a9aaabacadaeaf"
+	)
+	tests := []struct {
+		name               string
+		algo               uint16
+		key, nonce, ad, pt string
+		want               string
+	}{{
+		name:  "AES_SIV_CMAC_384",
+		algo:  ntske.AES_SIV_CMAC_384,
+		key:   sivKey384,
+		nonce: sivNonce,
+		ad:    sivAD,
+		pt:    "02040008000000004e545320636f6f6b6965206461746121",
+		want:  "d09711430c7127d2c0fe3f6dcaa25626a2b502bc97a7639ed1bd69cfb9af49bc3689274e2a620a19",
+	}, {
+		name:  "AES_SIV_CMAC_384 short",
+		algo:  ntske.AES_SIV_CMAC_384,
+		key:   sivKey384,
+		nonce: sivNonce,
+		ad:    sivAD,
+		pt:    "0204000800000000",
+		want:  "8d31ed37cf2646e814b6248a40a3b1ec96ed6747ba92e03f",
+	}, {
+		name:  "AES_128_GCM_SIV empty",
+		algo:  ntske.AES_128_GCM_SIV,
+		key:   "01000000000000000000000000000000",
+		nonce: "030000000000000000000000",
+		want:  "dc20e2d83f25705bb49e439eca56de25",
+	}, {
+		name:  "AES_128_GCM_SIV 8 bytes",
+		algo:  ntske.AES_128_GCM_SIV,
+		key:   "01000000000000000000000000000000",
+		nonce: "030000000000000000000000",
+		pt:    "0100000000000000",
+		want:  "b5d839330ac7b786578782fff6013b815b287c22493a364c",
+	}, {
+		name:  "AES_128_GCM_SIV 12 bytes",
+		algo:  ntske.AES_128_GCM_SIV,
+		key:   "01000000000000000000000000000000",
+		nonce: "030000000000000000000000",
+		pt:    "010000000000000000000000",
+		want:  "7323ea61d05932260047d942a4978db357391a0bc4fdec8b0d106639",
+	}, {
+		name:  "AES_128_GCM_SIV with AD",
+		algo:  ntske.AES_128_GCM_SIV,
+		key:   "01000000000000000000000000000000",
+		nonce: "030000000000000000000000",
+		ad:    "01",
+		pt:    "0200000000000000",
+		want:  "1e6daba35669f4273b0a1a2560969cdf790d99759abd1508",
+	}, {
+		name:  "AES_128_GCM_SIV random key empty",
+		algo:  ntske.AES_128_GCM_SIV,
+		key:   "e66021d5eb8e4f4066d4adb9c33560e4",
+		nonce: "f46e44bb3da0015c94f70887",
+		want:  "a4194b79071b01a87d65f706e3949578",
+	}, {
+		name:  "AES_128_GCM_SIV random key",
+		algo:  ntske.AES_128_GCM_SIV,
+		key:   "36864200e0eaf5284d884a0e77d31646",
+		nonce: "bae8e37fc83441b16034566b",
+		ad:    "46bb91c3c5",
+		pt:    "7a806c",
+		want:  "af60eb711bd85bc1e4d3e0a462e074eea428a8",
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			aead, err := nts.NewAEAD(tt.algo, decodeHex(t, tt.key))
+			if err != nil {
+				t.Fatal(err)
+			}
+			nonce, ad, pt := decodeHex(t, tt.nonce), decodeHex(t, tt.ad), decodeHex(t, tt.pt)
+			ct := aead.Seal(nil, nonce, pt, ad)
+			if got := hex.EncodeToString(ct); got != tt.want {
+				t.Errorf("Seal() = %s; want %s", got, tt.want)
+			}
+			got, err := aead.Open(nil, nonce, ct, ad)
+			if err != nil || !bytes.Equal(got, pt) {
+				t.Errorf("Open() = %x, %v; want %x", got, err, pt)
+			}
+			ct[len(ct)-1] ^= 1
+			if _, err := aead.Open(nil, nonce, ct, ad); err == nil {
+				t.Error("Open() accepted modified ciphertext")
+			}
+		})
+	}
+}
+
+func TestAEADSIVInterop(t *testing.T) {
+	// The AES-SIV implementation for AEAD_AES_SIV_CMAC_384 must agree with
+	// miscreant, used for _256 and _512, on packets with associated data and
+	// a nonce.
+	for _, n := range []int{32, 64} {
+		key := bytes.Repeat([]byte{0x5a}, n)
+		nonce := bytes.Repeat([]byte{0xa5}, 16)
+		m, err := miscreant.NewAEAD("AES-CMAC-SIV", key, len(nonce))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := range 48 {
+			pt := bytes.Repeat([]byte{byte(i)}, i)
+			ad := bytes.Repeat([]byte{0x3c}, 48+i)
+			want := m.Seal(nil, nonce, pt, ad)
+			if got, err := nts.SealSIV(key, pt, ad, nonce); err != nil || !bytes.Equal(got, want) {
+				t.Errorf("%d byte key, %d bytes: SealSIV() = %x, %v; want %x", n, i, got, err, want)
+			}
+		}
+	}
+}
+
+func TestAEADKeyLen(t *testing.T) {
+	if _, err := nts.NewAEAD(ntske.AES_SIV_CMAC_384, make([]byte, 32)); err == nil {
+		t.Error("NewAEAD() accepted key of wrong length")
+	}
+	if _, err := nts.NewAEAD(0xffff, make([]byte, 32)); err == nil {
+		t.Error("NewAEAD() accepted unknown algorithm")
+	}
+}
+
+func TestPacketAuthentication(t *testing.T) {
+	for _, algo := range []uint16{
+		ntske.AES_SIV_CMAC_256,
+		ntske.AES_SIV_CMAC_384,
+		ntske.AES_SIV_CMAC_512,
+		ntske.AES_128_GCM_SIV,
+	} {
+		data := ntske.Data{
+			C2sKey: bytes.Repeat([]byte{1}, ntske.KeyLen(algo)),
+			S2cKey: bytes.Repeat([]byte{2}, ntske.KeyLen(algo)),
+			Cookie: [][]byte{bytes.Repeat([]byte{3}, 100)},
+			Algo:   algo,
+		}
+		req, _ := nts.NewRequestPacket(data)
+		b := make([]byte, ntp.PacketLen)
+		nts.EncodePacket(&b, &req)
+
+		var pkt nts.Packet
+		err := nts.DecodePacket(&pkt, b)
+		if err != nil {
+			t.Fatalf("algorithm %d: DecodePacket() failed: %v", algo, err)
+		}
+		err = nts.ProcessRequest(b, algo, data.C2sKey, &pkt)
+		if err != nil {
+			t.Errorf("algorithm %d: ProcessRequest() failed: %v", algo, err)
+		}
+		err = nts.ProcessRequest(b, algo, data.S2cKey, &pkt)
+		if err == nil {
+			t.Errorf("algorithm %d: ProcessRequest() accepted wrong key", algo)
+		}
+	}
+}
+
+func TestProcessNAK(t *testing.T) {
+	uid := bytes.Repeat([]byte{4}, 32)
+	var h ntp.Packet
+	h.SetVersion(ntp.VersionMax)
+	h.SetMode(ntp.ModeServer)
+	h.ReferenceID = ntp.KissCodeNTSN
+	b := make([]byte, ntp.PacketLen)
+	ntp.EncodePacket(&b, &h)
+	err := nts.EncodeNAK(&b, uid)
+	if err != nil {
+		t.Fatalf("EncodeNAK() failed: %v", err)
+	}
+
+	var pkt nts.Packet
+	err = nts.DecodePacket(&pkt, b)
+	if err != nil {
+		t.Fatalf("DecodePacket() failed: %v", err)
+	}
+	// A NAK is not authenticated, so it must not affect the cached cookies:
+	// there is no fetcher to reset here.
+	err = nts.ProcessResponse(b, ntske.Data{}, nil /* ntskeFetcher */, &pkt, uid)
+	if err != nts.ErrNAK {
+		t.Errorf("ProcessResponse() = %v, want %v", err, nts.ErrNAK)
+	}
+}
@@ -0,0 +1,11 @@
+package nts
+
+// SealSIV encrypts plaintext with AES-SIV keyed with key and authenticates it
+// together with the header components data.
+func SealSIV(key, plaintext []byte, data ...[]byte) ([]byte, error) {
+	c, err := newSIV(key)
+	if err != nil {
+		return nil, err
+	}
+	return c.seal(nil, plaintext, data...), nil
+}
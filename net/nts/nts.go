@@ -33,14 +33,19 @@ import (
 	"encoding/binary"
 	"errors"
 
+	"example.com/scion-time/net/ntp"
 	"example.com/scion-time/net/ntske"
-	"github.com/miscreant/miscreant.go"
 )
 
 const (
 	MaxPacketLen     = 1024
 	numStoredCookies = 8
 	ntpPacketLen     = 48
+
+	// uniqueIDExtLen and authExtLen are the lengths of the Unique Identifier
+	// and of an Authenticator extension field without plaintext in requests.
+	uniqueIDExtLen = 4 + 32
+	authExtLen     = 4 + 4 + sivNonceLen + tagLen
 )
 
 const (
@@ -55,8 +60,14 @@ var (
 	errNoCookies            = errors.New("packet does not contain cookies")
 	errNoUniqueID           = errors.New("packet does not contain a unique identifier")
 	errShortUniqueID        = errors.New("UniqueIdentifier.ID < 32 bytes")
+	errLongUniqueID         = errors.New("UniqueIdentifier.ID exceeds packet size")
 	errUnexpectedExtHdrType = errors.New("unexpected extension header type")
+	errUnexpectedExtHdrLen  = errors.New("unexpected extension header length")
 	errUnexpectedResponseID = errors.New("unexpected response ID")
+	errUnexpectedNonceLen   = errors.New("unexpected nonce length")
+
+	// ErrNAK is returned by ProcessResponse for NTS NAK responses.
+	ErrNAK = errors.New("NTS NAK received")
 )
 
 // A Packet contains the NTP extension fields for a NTS secured NTP request or response.
@@ -65,6 +76,7 @@ type Packet struct {
 	Cookies            []Cookie
 	CookiePlaceholders []CookiePlaceholder
 	Auth               Authenticator
+	nak                bool
 }
 
 // NewRequestPacket returns a new Packet initialized with a new UniqueID and a Cookie from ntskeData.
@@ -82,15 +94,20 @@ func NewRequestPacket(ntskeData ntske.Data) (pkt Packet, uniqueid []byte) {
 	cookie.Cookie = ntskeData.Cookie[0]
 	pkt.Cookies = append(pkt.Cookies, cookie)
 
-	// Add cookie extension fields here s.t. 8 cookies are available after response.
+	// Add cookie extension fields here s.t. 8 cookies are available after response,
+	// as far as they fit into a packet of at most MaxPacketLen bytes.
+	cookieExtLen := 4 + (len(cookie.Cookie)+3)&^3
+	maxPlaceholders := (MaxPacketLen - ntpPacketLen - uniqueIDExtLen - authExtLen - cookieExtLen) / cookieExtLen
+	numPlaceholders := min(numStoredCookies-len(ntskeData.Cookie), maxPlaceholders)
 	cookiePlaceholderData := make([]byte, len(cookie.Cookie))
-	for i := len(ntskeData.Cookie); i < numStoredCookies; i++ {
+	for range numPlaceholders {
 		var cookiePlacholder CookiePlaceholder
 		cookiePlacholder.Cookie = cookiePlaceholderData
 		pkt.CookiePlaceholders = append(pkt.CookiePlaceholders, cookiePlacholder)
 	}
 
 	var auth Authenticator
+	auth.Algo = ntskeData.Algo
 	auth.Key = ntskeData.C2sKey
 	pkt.Auth = auth
 
@@ -98,18 +115,20 @@ func NewRequestPacket(ntskeData ntske.Data) (pkt Packet, uniqueid []byte) {
 }
 
 // EncodePacket encodes pkt to a byte slice. It is expected that
-// the first 48 bytes of the slice already contain a NTP packet.
-// NTS authentication is added here.
+// the slice already contains a NTP packet, possibly followed by
+// further extension fields, e.g., NTPv5 extension fields. These are
+// covered by the NTS authentication added here.
 func EncodePacket(b *[]byte, pkt *Packet) {
-	if len(*b) != ntpPacketLen {
+	if len(*b) < ntpPacketLen {
 		panic("unexpected NTP header")
 	}
-	if cap(*b) < MaxPacketLen {
-		*b = append(make([]byte, 0, MaxPacketLen), (*b)...)
+	pos := len(*b)
+	n := pos + MaxPacketLen - ntpPacketLen
+	if cap(*b) < n {
+		*b = append(make([]byte, 0, n), (*b)...)
 	}
-	*b = (*b)[:MaxPacketLen]
+	*b = (*b)[:n]
 
-	pos := ntpPacketLen
 	pos, err := pkt.UniqueID.pack(*b, pos)
 	if err != nil {
 		panic(err)
@@ -135,7 +154,10 @@ func EncodePacket(b *[]byte, pkt *Packet) {
 
 // DecodePacket decodes a byte slice to a Packet. Authentication is not
 // checked, but an error is returned if b does not contain an
-// Autheticator or UniqueID extension field.
+// Autheticator or UniqueID extension field. The only exception are NTS NAKs
+// (see RFC 8915, Section 5.7), i.e., Kiss-o'-Death packets with kiss code NTSN
+// or NTPv5 responses with the authentication NAK flag set, which do not contain
+// an Authenticator.
 func DecodePacket(pkt *Packet, b []byte) (err error) {
 	pos := ntpPacketLen
 	foundUniqueID := false
@@ -144,6 +166,9 @@ func DecodePacket(pkt *Packet, b []byte) (err error) {
 	for len(b)-pos >= 28 && !foundAuthenticator {
 		var eh extHdr
 		eh.unpack(b, pos)
+		if eh.Length < 4 || int(eh.Length) > len(b)-pos {
+			return errUnexpectedExtHdrLen
+		}
 		pos += 4
 
 		switch eh.Type {
@@ -193,12 +218,43 @@ func DecodePacket(pkt *Packet, b []byte) (err error) {
 		return errNoUniqueID
 	}
 	if !foundAuthenticator {
+		var h ntp.Packet
+		if ntp.DecodePacket(&h, b) == nil &&
+			ntp.IsKissOfDeath(&h) && h.ReferenceID == ntp.KissCodeNTSN {
+			pkt.nak = true
+			return nil
+		}
+		var h5 ntp.PacketV5
+		if ntp.DecodePacketV5(&h5, b) == nil && h5.Version() == ntp.Version5 &&
+			h5.Mode() == ntp.ModeServer && h5.Flags&ntp.FlagAuthNAK != 0 {
+			pkt.nak = true
+			return nil
+		}
 		return errNoAuthenticator
 	}
 
 	return nil
 }
 
+// ContainsUniqueID reports whether the NTP packet b contains a Unique
+// Identifier extension field, i.e., whether it is an NTS packet. Other
+// extension fields, e.g., NTPv5 extension fields, may precede it.
+func ContainsUniqueID(b []byte) bool {
+	pos := ntpPacketLen
+	for len(b)-pos >= 4 {
+		var eh extHdr
+		eh.unpack(b, pos)
+		if eh.Type == extUniqueIdentifier {
+			return true
+		}
+		if eh.Length < 4 {
+			return false
+		}
+		pos += int(eh.Length)
+	}
+	return false
+}
+
 // FirstCookie returns the first cookie byte slice a packet contains.
 func (pkt *Packet) FirstCookie() ([]byte, error) {
 	var cookie []byte
@@ -209,13 +265,16 @@ func (pkt *Packet) FirstCookie() ([]byte, error) {
 	return cookie, nil
 }
 
-func (pkt *Packet) authenticate(b []byte, key []byte) error {
-	aessiv, err := miscreant.NewAEAD("AES-CMAC-SIV", key, 16)
+func (pkt *Packet) authenticate(b []byte, algo uint16, key []byte) error {
+	aead, err := NewAEAD(algo, key)
 	if err != nil {
 		return err
 	}
+	if len(pkt.Auth.Nonce) != aead.NonceSize() {
+		return errUnexpectedNonceLen
+	}
 
-	decrytedBuf, err := aessiv.Open(nil, pkt.Auth.Nonce, pkt.Auth.CipherText, b[:pkt.Auth.pos])
+	decrytedBuf, err := aead.Open(nil, pkt.Auth.Nonce, pkt.Auth.CipherText, b[:pkt.Auth.pos])
 	if err != nil {
 		return err
 	}
@@ -243,25 +302,59 @@ func (pkt *Packet) authenticate(b []byte, key []byte) error {
 
 // ProcessResponse handles the response from a server. It checks that the UniqueID matches
 // the one from the request and checks the authentication. Additionally it stores the cookies.
-func ProcessResponse(b []byte, key []byte, ntskeFetcher *ntske.Fetcher, pkt *Packet, reqID []byte) error {
+// The response is authenticated using the keys and the AEAD algorithm of ntskeData, the data
+// the request was made with. If the response is an NTS NAK, ErrNAK is returned without any
+// further processing; it is up to the caller to check that the NAK responds to its request
+// before it discards its cookies.
+func ProcessResponse(b []byte, ntskeData ntske.Data, ntskeFetcher *ntske.Fetcher, pkt *Packet, reqID []byte) error {
 	if !bytes.Equal(reqID, pkt.UniqueID.ID) {
 		return errUnexpectedResponseID
 	}
 
-	err := pkt.authenticate(b, key)
+	if pkt.nak {
+		return ErrNAK
+	}
+
+	err := pkt.authenticate(b, ntskeData.Algo, ntskeData.S2cKey)
 	if err != nil {
 		return err
 	}
 
 	for _, cookie := range pkt.Cookies {
-		ntskeFetcher.StoreCookie(cookie.Cookie)
+		ntskeFetcher.StoreCookie(ntskeData, cookie.Cookie)
+	}
+	return nil
+}
+
+// EncodeNAK encodes an NTS NAK to b by appending the UniqueID of the request it
+// responds to. It is expected that the first 48 bytes of the slice already
+// contain a Kiss-o'-Death NTP packet with kiss code NTSN.
+func EncodeNAK(b *[]byte, uniqueid []byte) error {
+	if len(*b) != ntpPacketLen {
+		panic("unexpected NTP header")
+	}
+	if ntpPacketLen+4+len(uniqueid) > MaxPacketLen {
+		return errLongUniqueID
 	}
+	if cap(*b) < MaxPacketLen {
+		*b = append(make([]byte, 0, MaxPacketLen), (*b)...)
+	}
+	*b = (*b)[:MaxPacketLen]
+
+	var uid UniqueIdentifier
+	uid.ID = uniqueid
+	pos, err := uid.pack(*b, ntpPacketLen)
+	if err != nil {
+		*b = (*b)[:ntpPacketLen]
+		return err
+	}
+	*b = (*b)[:pos]
 	return nil
 }
 
 // NewResponsePacket creates and returns a new Packet that should be used by
-// a server for a response to a request.
-func NewResponsePacket(cookies [][]byte, key []byte, uniqueid []byte) (pkt Packet) {
+// a server for a response to a request authenticated with AEAD algorithm algo.
+func NewResponsePacket(cookies [][]byte, algo uint16, key []byte, uniqueid []byte) (pkt Packet) {
 	var uid UniqueIdentifier
 	uid.ID = uniqueid
 	pkt.UniqueID = uid
@@ -280,6 +373,7 @@ func NewResponsePacket(cookies [][]byte, key []byte, uniqueid []byte) (pkt Packe
 	}
 
 	var auth Authenticator
+	auth.Algo = algo
 	auth.Key = key
 	auth.PlainText = buf
 	pkt.Auth = auth
@@ -288,9 +382,9 @@ func NewResponsePacket(cookies [][]byte, key []byte, uniqueid []byte) (pkt Packe
 }
 
 // ProcessRequest handles a request from a client.
-// It checks the authentication.
-func ProcessRequest(b []byte, key []byte, pkt *Packet) error {
-	err := pkt.authenticate(b, key)
+// It checks the authentication using AEAD algorithm algo from the request's cookie.
+func ProcessRequest(b []byte, algo uint16, key []byte, pkt *Packet) error {
+	err := pkt.authenticate(b, algo, key)
 	if err != nil {
 		return err
 	}
@@ -430,25 +524,26 @@ func (c *CookiePlaceholder) unpack(buf []byte, pos int) error {
 }
 
 // An Authenticator is the NTS extension field for a NTS authenticator.
-// It contains a nonce and authenticates the Packet using the Key.
-// Additionally it can encrypt the contents of PlainText.
+// It contains a nonce and authenticates the Packet using the Key with
+// AEAD algorithm Algo. Additionally it can encrypt the contents of PlainText.
 // pos is the position of the Authenticator in the NTP packet byte slice.
 type Authenticator struct {
 	extHdr
 	Nonce      []byte
 	CipherText []byte
+	Algo       uint16
 	Key        []byte
 	PlainText  []byte
 	pos        int
 }
 
 func (a Authenticator) pack(buf []byte, pos int) (int, error) {
-	aessiv, err := miscreant.NewAEAD("AES-CMAC-SIV", a.Key, 16)
+	aead, err := NewAEAD(a.Algo, a.Key)
 	if err != nil {
 		return 0, err
 	}
 
-	bits := make([]byte, 16)
+	bits := make([]byte, aead.NonceSize())
 	_, err = rand.Read(bits)
 	if err != nil {
 		return 0, err
@@ -458,7 +553,7 @@ func (a Authenticator) pack(buf []byte, pos int) (int, error) {
 	nonceLen := uint16(len(a.Nonce))
 	noncepadlen := (-nonceLen) % 4
 
-	a.CipherText = aessiv.Seal(nil, a.Nonce, a.PlainText, buf[:pos])
+	a.CipherText = aead.Seal(nil, a.Nonce, a.PlainText, buf[:pos])
 	cipherTextLen := uint16(len(a.CipherText))
 	cipherpadlen := (-cipherTextLen) % 4
 
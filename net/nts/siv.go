@@ -0,0 +1,117 @@
+package nts
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/subtle"
+	"errors"
+
+	"github.com/miscreant/miscreant.go/block"
+	"github.com/miscreant/miscreant.go/cmac"
+)
+
+var errOpen = errors.New("message authentication failed")
+
+// siv implements AES-SIV as specified in RFC 5297 for AEAD_AES_SIV_CMAC_384,
+// which miscreant does not support, from the CMAC of miscreant. Like
+// miscreant, it uses the associated data, unless nil, and the nonce as the
+// header components of S2V.
+type siv struct {
+	mac cipher.Block
+	ctr cipher.Block
+}
+
+func newSIV(key []byte) (*siv, error) {
+	mac, err := aes.NewCipher(key[:len(key)/2])
+	if err != nil {
+		return nil, err
+	}
+	ctr, err := aes.NewCipher(key[len(key)/2:])
+	if err != nil {
+		return nil, err
+	}
+	return &siv{mac: mac, ctr: ctr}, nil
+}
+
+func (c *siv) NonceSize() int { return sivNonceLen }
+
+func (c *siv) Overhead() int { return tagLen }
+
+// s2v computes the synthetic IV v of plaintext and the header components
+// data, see RFC 5297, Section 2.4.
+func (c *siv) s2v(v *block.Block, plaintext []byte, data ...[]byte) {
+	h := cmac.New(c.mac)
+	var d, t block.Block
+	_, _ = h.Write(d[:])
+	h.Sum(d[:0])
+	for _, s := range data {
+		h.Reset()
+		_, _ = h.Write(s)
+		d.Dbl()
+		subtle.XORBytes(d[:], d[:], h.Sum(t[:0]))
+	}
+	h.Reset()
+	if n := len(plaintext); n >= block.Size {
+		_, _ = h.Write(plaintext[:n-block.Size])
+		subtle.XORBytes(t[:], plaintext[n-block.Size:], d[:])
+	} else {
+		d.Dbl()
+		t.Clear()
+		copy(t[:], plaintext)
+		t[n] = 0x80
+		subtle.XORBytes(t[:], t[:], d[:])
+	}
+	_, _ = h.Write(t[:])
+	h.Sum(v[:0])
+}
+
+func (c *siv) xorKeyStream(dst, src []byte, v *block.Block) {
+	q := *v
+	q[8] &= 0x7f
+	q[12] &= 0x7f
+	cipher.NewCTR(c.ctr, q[:]).XORKeyStream(dst, src)
+}
+
+func (c *siv) seal(dst, plaintext []byte, data ...[]byte) []byte {
+	var v block.Block
+	c.s2v(&v, plaintext, data...)
+	ret := append(dst, v[:]...)
+	ret = append(ret, plaintext...)
+	c.xorKeyStream(ret[len(dst)+tagLen:], plaintext, &v)
+	return ret
+}
+
+func (c *siv) open(dst, ciphertext []byte, data ...[]byte) ([]byte, error) {
+	if len(ciphertext) < tagLen {
+		return nil, errOpen
+	}
+	var v, w block.Block
+	copy(v[:], ciphertext)
+	ret := append(dst, ciphertext[tagLen:]...)
+	out := ret[len(dst):]
+	c.xorKeyStream(out, out, &v)
+	c.s2v(&w, out, data...)
+	if subtle.ConstantTimeCompare(v[:], w[:]) != 1 {
+		clear(out)
+		return nil, errOpen
+	}
+	return ret, nil
+}
+
+func (c *siv) header(nonce, additionalData []byte) [][]byte {
+	if len(nonce) != sivNonceLen {
+		panic("nts: incorrect nonce length given to AES-SIV")
+	}
+	if additionalData == nil {
+		return [][]byte{nonce}
+	}
+	return [][]byte{additionalData, nonce}
+}
+
+func (c *siv) Seal(dst, nonce, plaintext, additionalData []byte) []byte {
+	return c.seal(dst, plaintext, c.header(nonce, additionalData)...)
+}
+
+func (c *siv) Open(dst, nonce, ciphertext, additionalData []byte) ([]byte, error) {
+	return c.open(dst, ciphertext, c.header(nonce, additionalData)...)
+}
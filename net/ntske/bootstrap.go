@@ -0,0 +1,91 @@
+package ntske
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"time"
+)
+
+var (
+	errNoServerCertificate = errors.New("no server certificate")
+	errNoCommonValidity    = errors.New("server certificate chain has no common validity period")
+)
+
+// certValidity returns the period during which all certificates of a chain
+// are valid.
+func certValidity(certs []*x509.Certificate) (notBefore, notAfter time.Time, ok bool) {
+	if len(certs) == 0 {
+		return time.Time{}, time.Time{}, false
+	}
+	notBefore, notAfter = certs[0].NotBefore, certs[0].NotAfter
+	for _, c := range certs[1:] {
+		if c.NotBefore.After(notBefore) {
+			notBefore = c.NotBefore
+		}
+		if c.NotAfter.Before(notAfter) {
+			notAfter = c.NotAfter
+		}
+	}
+	return notBefore, notAfter, !notBefore.After(notAfter)
+}
+
+// verifyIgnoringValidity returns a tls.Config.VerifyConnection function that
+// verifies server certificate chains like crypto/tls does, except that the
+// chain is verified at the start of its validity period instead of at the
+// current time. It must be combined with InsecureSkipVerify.
+func verifyIgnoringValidity(config *tls.Config) func(cs tls.ConnectionState) error {
+	return func(cs tls.ConnectionState) error {
+		if len(cs.PeerCertificates) == 0 {
+			return errNoServerCertificate
+		}
+		notBefore, _, ok := certValidity(cs.PeerCertificates)
+		if !ok {
+			return errNoCommonValidity
+		}
+		opts := x509.VerifyOptions{
+			Roots:         config.RootCAs,
+			CurrentTime:   notBefore,
+			DNSName:       config.ServerName,
+			Intermediates: x509.NewCertPool(),
+		}
+		for _, c := range cs.PeerCertificates[1:] {
+			opts.Intermediates.AddCert(c)
+		}
+		_, err := cs.PeerCertificates[0].Verify(opts)
+		return err
+	}
+}
+
+// bootstrapping reports whether server certificates are currently validated
+// ignoring their validity periods.
+func (f *Fetcher) bootstrapping() bool {
+	return f.Bootstrap && !f.bootstrapped.Load()
+}
+
+// CertificateValidity returns the period during which the certificate chain
+// presented by the server in the last key exchange is valid. The result is
+// not available for data resumed from a store.
+func (f *Fetcher) CertificateValidity() (notBefore, notAfter time.Time, ok bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.data.notBefore.IsZero() && f.data.notAfter.IsZero() {
+		return time.Time{}, time.Time{}, false
+	}
+	return f.data.notBefore, f.data.notAfter, true
+}
+
+// EndBootstrap ends bootstrap mode and immediately performs a new key exchange
+// with regular certificate validation. It should be called once the local
+// clock has been set to a time cross-checked with multiple servers.
+func (f *Fetcher) EndBootstrap(ctx context.Context) error {
+	if !f.bootstrapping() {
+		return nil
+	}
+	f.bootstrapped.Store(true)
+	f.mu.Lock()
+	f.retryAt = time.Time{}
+	f.mu.Unlock()
+	return f.Rekey(ctx)
+}
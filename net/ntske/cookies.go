@@ -12,6 +12,7 @@ const (
 	cookieTypeAlgorithm uint16 = 0x101
 	cookieTypeKeyS2C    uint16 = 0x201
 	cookieTypeKeyC2S    uint16 = 0x301
+	cookieTypeIdentity  uint16 = 0x701
 
 	cookieTypeKeyID      uint16 = 0x401
 	cookieTypeNonce      uint16 = 0x501
@@ -20,11 +21,19 @@ const (
 
 var errUnexpectedCookieData = errors.New("unexpected cookie data")
 
+// MaxIdentityLen is the maximum length of client identities embedded in
+// cookies.
+const MaxIdentityLen = 64
+
 // ServerCookie is the representation of a plaintext NTS cookie.
 type ServerCookie struct {
 	Algo uint16
 	S2C  []byte
 	C2S  []byte
+	// Identity is the identity of the client authenticated during the key
+	// exchange, if any. Cookies without client identity are encoded as before
+	// s.t. they remain compatible with servers unaware of client identities.
+	Identity string
 }
 
 // Encode encodes the ServerCookie to a byte slice with following format for each field.
@@ -32,6 +41,9 @@ type ServerCookie struct {
 // type   | length | value
 func (c *ServerCookie) Encode() []byte {
 	cookieLen := 3*4 + 2 + len(c.C2S) + len(c.S2C)
+	if c.Identity != "" {
+		cookieLen += 4 + len(c.Identity)
+	}
 	b := make([]byte, cookieLen)
 	binary.BigEndian.PutUint16(b[0:], cookieTypeAlgorithm)
 	binary.BigEndian.PutUint16(b[2:], 0x2)
@@ -43,6 +55,12 @@ func (c *ServerCookie) Encode() []byte {
 	binary.BigEndian.PutUint16(b[pos:], cookieTypeKeyC2S)
 	binary.BigEndian.PutUint16(b[pos+2:], uint16(len(c.C2S)))
 	copy(b[pos+4:], c.C2S)
+	if c.Identity != "" {
+		pos += len(c.C2S) + 4
+		binary.BigEndian.PutUint16(b[pos:], cookieTypeIdentity)
+		binary.BigEndian.PutUint16(b[pos+2:], uint16(len(c.Identity)))
+		copy(b[pos+4:], c.Identity)
+	}
 	return b
 }
 
@@ -62,6 +80,8 @@ func (c *ServerCookie) Decode(b []byte) error {
 		} else if t == cookieTypeKeyC2S {
 			c.C2S = b[pos+4 : pos+4+int(len)]
 			c2s = true
+		} else if t == cookieTypeIdentity {
+			c.Identity = string(b[pos+4 : pos+4+int(len)])
 		}
 		pos += 4 + int(len)
 	}
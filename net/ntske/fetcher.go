@@ -7,18 +7,73 @@ import (
 	"errors"
 	"log/slog"
 	"net"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
 
 	"github.com/quic-go/quic-go"
 
+	"github.com/scionproto/scion/pkg/daemon"
+
+	"example.com/scion-time/base/metrics"
+
+	"example.com/scion-time/net/scion"
 	"example.com/scion-time/net/udp"
 )
 
+const (
+	maxStoredCookies = 8
+	minStoredCookies = 2
+
+	minExchangeBackoff = 1 * time.Second
+	maxExchangeBackoff = 5 * time.Minute
+	refreshTimeout     = 30 * time.Second
+)
+
 var (
 	errNoCookies   = errors.New("unexpected NTS-KE meta data: no cookies")
 	errUnknownAlgo = errors.New("unexpected NTS-KE meta data: unknown algorithm")
+	errNoCSPTPKey  = errors.New("unexpected NTS-KE meta data: no CSPTP key")
+	errBackoff     = errors.New("NTS key exchange backing off after failure")
 )
 
+type fetcherMetrics struct {
+	cookies           prometheus.Gauge
+	keyExchanges      prometheus.Counter
+	keyExchangeErrors prometheus.Counter
+}
+
+func newFetcherMetrics() *fetcherMetrics {
+	return &fetcherMetrics{
+		cookies: promauto.NewGauge(prometheus.GaugeOpts{
+			Name: metrics.NTSKEFetcherCookiesN,
+			Help: metrics.NTSKEFetcherCookiesH,
+		}),
+		keyExchanges: promauto.NewCounter(prometheus.CounterOpts{
+			Name: metrics.NTSKEFetcherKeyExchangesN,
+			Help: metrics.NTSKEFetcherKeyExchangesH,
+		}),
+		keyExchangeErrors: promauto.NewCounter(prometheus.CounterOpts{
+			Name: metrics.NTSKEFetcherKeyExchangeErrorsN,
+			Help: metrics.NTSKEFetcherKeyExchangeErrorsH,
+		}),
+	}
+}
+
+var fetcherMtrcs atomic.Pointer[fetcherMetrics]
+
+func init() {
+	fetcherMtrcs.Store(newFetcherMetrics())
+}
+
 // Fetcher is a client side NTS Cookie fetcher. It can be used for both TCP/TLS and SCION QUIC connections.
+// A Fetcher is safe for concurrent use. It keeps a stock of cookies, which is replenished by responses to
+// requests with cookie placeholders, and performs a new key exchange in the background when running low on
+// cookies or when the current keys have reached MaxAge.
 type Fetcher struct {
 	Log       *slog.Logger
 	TLSConfig tls.Config
@@ -26,10 +81,65 @@ type Fetcher struct {
 	QUIC      struct {
 		Enabled    bool
 		DaemonAddr string
-		LocalAddr  udp.UDPAddr
-		RemoteAddr udp.UDPAddr
+		// DaemonConnector, if set, is used for path lookups instead of a
+		// connection to the daemon at DaemonAddr.
+		DaemonConnector daemon.Connector
+		LocalAddr       udp.UDPAddr
+		RemoteAddr      udp.UDPAddr
+		// RemoteAddrs lists further NTS-KE servers tried in order if the key
+		// exchange with RemoteAddr fails.
+		RemoteAddrs []udp.UDPAddr
 	}
-	data Data
+	// Servers lists further NTS-KE servers as host:port, tried in order if
+	// the key exchange with TLSConfig.ServerName fails. Failing over is only
+	// useful if the servers share their cookie keys, see NewSeededProvider.
+	Servers []string
+	// Algorithms lists the AEAD algorithms proposed to the server in order of
+	// preference. If empty, all supported algorithms are proposed.
+	Algorithms []uint16
+	// MaxAge is the age after which keys and cookies are replaced by a new key
+	// exchange. Zero disables age based key renewal.
+	MaxAge time.Duration
+	// Store, if set, persists keys and unused cookies s.t. a new Fetcher for
+	// the same server can resume without a key exchange. Data is written in
+	// the background, see Flush.
+	Store *CookieStore
+	// ClientCertFile and ClientKeyFile, if set, are the certificate and key
+	// presented to NTS-KE servers that require client authentication. They
+	// are loaded for each key exchange s.t. renewed certificates are used.
+	ClientCertFile string
+	ClientKeyFile  string
+	// Bootstrap, if set, validates server certificates ignoring their validity
+	// periods s.t. a client whose clock is far off can perform key exchanges.
+	// Time obtained in bootstrap mode must be cross-checked with multiple
+	// servers and their CertificateValidity before it is trusted, bootstrap
+	// mode is left with EndBootstrap.
+	Bootstrap bool
+	// CSPTP, if set, requests a key for CSPTP authentication TLVs in each key
+	// exchange, see FetchCSPTPKey.
+	CSPTP bool
+	// NTPv5, if set, proposes NTPv5 as next protocol in each key exchange,
+	// with NTPv4 as fallback. Data.NTPv5 reports whether the server selected
+	// NTPv5.
+	NTPv5 bool
+
+	bootstrapped atomic.Bool
+
+	mu         sync.Mutex
+	data       Data
+	gen        uint64
+	fetchedAt  time.Time
+	failures   int
+	retryAt    time.Time
+	refreshing bool
+	loaded     bool
+	slot       int
+	slotted    bool
+	dirty      bool
+	saving     bool
+	saveDone   chan struct{}
+	// xmu serializes key exchanges.
+	xmu sync.Mutex
 }
 
 func logData(ctx context.Context, log *slog.Logger, data Data) {
@@ -41,76 +151,419 @@ func logData(ctx context.Context, log *slog.Logger, data Data) {
 		slog.Uint64("port", uint64(data.Port)),
 		slog.Uint64("algo", uint64(data.Algo)),
 		slog.Any("cookies", data.Cookie),
+		slog.Uint64("csptp_key_id", uint64(data.CSPTPKeyID)),
+		slog.Bool("ntpv5", data.NTPv5),
 	)
 }
 
-func (f *Fetcher) exchangeKeys(ctx context.Context) error {
-	if f.QUIC.Enabled {
-		conn, _, err := dialQUIC(f.Log, f.QUIC.LocalAddr, f.QUIC.RemoteAddr, f.QUIC.DaemonAddr, &f.TLSConfig)
+func exchangeKeysQUIC(ctx context.Context, log *slog.Logger, dc daemon.Connector,
+	localAddr, remoteAddr udp.UDPAddr, config *tls.Config, algos []uint16, csptp, ntpv5 bool) (Data, error) {
+	conn, data, err := dialQUIC(log, localAddr, remoteAddr, dc, config)
+	if err != nil {
+		return Data{}, err
+	}
+	data.CSPTP = csptp
+	data.NTPv5 = ntpv5
+	defer func() {
+		err := conn.CloseWithError(quic.ApplicationErrorCode(0), "" /* error string */)
 		if err != nil {
-			return err
+			log.LogAttrs(ctx, slog.LevelInfo, "failed to close connection", slog.Any("error", err))
 		}
-		defer func() {
-			err := conn.CloseWithError(quic.ApplicationErrorCode(0), "" /* error string */)
+	}()
+
+	err = exchangeDataQUIC(ctx, log, conn, algos, &data)
+	if err != nil {
+		return Data{}, err
+	}
+	if len(data.Algos) != 1 || !slices.Contains(algos, data.Algo) {
+		return Data{}, errUnknownAlgo
+	}
+
+	err = ExportKeys(conn.ConnectionState().TLS, &data)
+	if err != nil {
+		return Data{}, err
+	}
+	data.notBefore, data.notAfter, _ = certValidity(conn.ConnectionState().TLS.PeerCertificates)
+	return data, nil
+}
+
+func exchangeKeysTLS(ctx context.Context, log *slog.Logger,
+	serverAddr string, config *tls.Config, algos []uint16, csptp, ntpv5 bool) (Data, error) {
+	conn, data, err := dialTLS(serverAddr, config)
+	if err != nil {
+		return Data{}, err
+	}
+	data.CSPTP = csptp
+	data.NTPv5 = ntpv5
+	defer func() { _ = conn.Close() }()
+
+	err = exchangeDataTLS(ctx, log, conn, algos, &data)
+	if err != nil {
+		return Data{}, err
+	}
+	if len(data.Algos) != 1 || !slices.Contains(algos, data.Algo) {
+		return Data{}, errUnknownAlgo
+	}
+
+	err = ExportKeys(conn.ConnectionState(), &data)
+	if err != nil {
+		return Data{}, err
+	}
+	data.notBefore, data.notAfter, _ = certValidity(conn.ConnectionState().PeerCertificates)
+	return data, nil
+}
+
+func (f *Fetcher) tlsConfig() *tls.Config {
+	config := f.TLSConfig.Clone()
+	if f.ClientCertFile != "" {
+		certFile, keyFile := f.ClientCertFile, f.ClientKeyFile
+		config.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			cert, err := tls.LoadX509KeyPair(certFile, keyFile)
 			if err != nil {
-				f.Log.LogAttrs(ctx, slog.LevelInfo, "failed to close connection", slog.Any("error", err))
+				return nil, err
 			}
-		}()
-
-		err = exchangeDataQUIC(ctx, f.Log, conn, &f.data)
-		if err != nil {
-			return err
+			return &cert, nil
 		}
+	}
+	if f.bootstrapping() && !config.InsecureSkipVerify {
+		config.InsecureSkipVerify = true
+		config.VerifyConnection = verifyIgnoringValidity(config)
+	}
+	return config
+}
 
-		err = ExportKeys(conn.ConnectionState().TLS, &f.data)
-		if err != nil {
-			return err
+// exchangeKeys performs a key exchange with the first server that responds
+// successfully.
+func (f *Fetcher) exchangeKeys(ctx context.Context) (Data, error) {
+	algos := f.Algorithms
+	if len(algos) == 0 {
+		algos = defaultAlgos
+	}
+
+	var data Data
+	var err error
+	if f.QUIC.Enabled {
+		dc := f.QUIC.DaemonConnector
+		if dc == nil {
+			dc = scion.NewDaemonConnector(ctx, f.QUIC.DaemonAddr)
+		}
+		for _, remoteAddr := range slices.Concat([]udp.UDPAddr{f.QUIC.RemoteAddr}, f.QUIC.RemoteAddrs) {
+			data, err = exchangeKeysQUIC(ctx, f.Log, dc, f.QUIC.LocalAddr, remoteAddr, f.tlsConfig(), algos, f.CSPTP, f.NTPv5)
+			if err == nil {
+				break
+			}
+			f.Log.LogAttrs(ctx, slog.LevelInfo, "failed to exchange keys",
+				slog.Any("server", remoteAddr), slog.Any("error", err))
 		}
 	} else {
-		var err error
-		var conn *tls.Conn
-		serverAddr := net.JoinHostPort(f.TLSConfig.ServerName, f.Port)
-		conn, f.data, err = dialTLS(serverAddr, &f.TLSConfig)
-		if err != nil {
-			return err
+		for _, serverAddr := range slices.Concat([]string{net.JoinHostPort(f.TLSConfig.ServerName, f.Port)}, f.Servers) {
+			config := f.tlsConfig()
+			host, _, splitErr := net.SplitHostPort(serverAddr)
+			if splitErr == nil {
+				config.ServerName = host
+			}
+			data, err = exchangeKeysTLS(ctx, f.Log, serverAddr, config, algos, f.CSPTP, f.NTPv5)
+			if err == nil {
+				break
+			}
+			f.Log.LogAttrs(ctx, slog.LevelInfo, "failed to exchange keys",
+				slog.String("server", serverAddr), slog.Any("error", err))
 		}
+	}
+	if err != nil {
+		return Data{}, err
+	}
 
-		err = exchangeDataTLS(ctx, f.Log, conn, &f.data)
-		if err != nil {
-			return err
+	if len(data.Cookie) == 0 {
+		return Data{}, errNoCookies
+	}
+	if len(data.Cookie) > maxStoredCookies {
+		data.Cookie = data.Cookie[:maxStoredCookies]
+	}
+
+	logData(ctx, f.Log, data)
+	return data, nil
+}
+
+// fetch performs a key exchange unless, if refresh is false, another caller
+// has already obtained new cookies in the meantime.
+func (f *Fetcher) fetch(ctx context.Context, refresh bool) error {
+	f.xmu.Lock()
+	defer f.xmu.Unlock()
+
+	f.mu.Lock()
+	if !refresh && len(f.data.Cookie) != 0 {
+		f.mu.Unlock()
+		return nil
+	}
+	// Stored data is not resumed in bootstrap mode as the validity of the
+	// server certificate is only known after a key exchange.
+	if !refresh && f.Store != nil && !f.loaded && !f.bootstrapping() {
+		f.loaded = true
+		if f.resume(ctx) {
+			f.mu.Unlock()
+			return nil
 		}
+	}
+	if time.Now().Before(f.retryAt) {
+		f.mu.Unlock()
+		return errBackoff
+	}
+	f.mu.Unlock()
 
-		err = ExportKeys(conn.ConnectionState(), &f.data)
-		if err != nil {
-			return err
+	data, err := f.exchangeKeys(ctx)
+
+	mtrcs := fetcherMtrcs.Load()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err != nil {
+		mtrcs.keyExchangeErrors.Inc()
+		f.failures++
+		backoff := maxExchangeBackoff
+		if f.failures < 32 {
+			backoff = min(minExchangeBackoff<<(f.failures-1), maxExchangeBackoff)
 		}
+		f.retryAt = time.Now().Add(backoff)
+		return err
+	}
+	mtrcs.keyExchanges.Inc()
+	f.install(data, time.Now())
+	f.failures = 0
+	f.retryAt = time.Time{}
+	f.save()
+	return nil
+}
+
+// install replaces the cached data by data obtained at fetchedAt.
+func (f *Fetcher) install(data Data, fetchedAt time.Time) {
+	fetcherMtrcs.Load().cookies.Add(float64(len(data.Cookie) - len(f.data.Cookie)))
+	f.gen++
+	data.gen = f.gen
+	f.data = data
+	f.fetchedAt = fetchedAt
+}
+
+func (f *Fetcher) storeAddr() string {
+	if f.QUIC.Enabled {
+		return f.QUIC.RemoteAddr.String()
+	}
+	return net.JoinHostPort(f.TLSConfig.ServerName, f.Port)
+}
+
+// resume installs data from f.Store if it is usable.
+func (f *Fetcher) resume(ctx context.Context) bool {
+	data, fetchedAt, ok := f.Store.Load(f.TLSConfig.ServerName, f.storeAddr(), f.storeSlot())
+	if !ok {
+		return false
+	}
+	algos := f.Algorithms
+	if len(algos) == 0 {
+		algos = defaultAlgos
 	}
+	maxAge := f.MaxAge
+	if maxAge == 0 {
+		maxAge = maxStoredDataAge
+	}
+	age := time.Since(fetchedAt)
+	if age < 0 || age >= maxAge ||
+		len(data.Cookie) == 0 || len(data.Cookie) > maxStoredCookies ||
+		!slices.Contains(algos, data.Algo) ||
+		len(data.C2sKey) != KeyLen(data.Algo) || len(data.S2cKey) != KeyLen(data.Algo) {
+		f.save()
+		return false
+	}
+	f.install(data, time.Now().Add(-age))
+	f.Log.LogAttrs(ctx, slog.LevelDebug, "resumed NTS-KE data from store",
+		slog.Duration("age", age), slog.Int("cookies", len(data.Cookie)))
+	return true
+}
+
+// storeSlot returns the slot of f in f.Store and claims one on first use.
+func (f *Fetcher) storeSlot() int {
+	if !f.slotted {
+		f.slot = f.Store.Claim(f.TLSConfig.ServerName, f.storeAddr())
+		f.slotted = true
+	}
+	return f.slot
+}
 
-	if len(f.data.Cookie) == 0 {
-		return errNoCookies
+// save schedules writing the cached data to f.Store if a store is configured.
+// Only the latest data is written if it changes while a write is in progress.
+func (f *Fetcher) save() {
+	if f.Store == nil {
+		return
 	}
-	if f.data.Algo != AES_SIV_CMAC_256 {
-		return errUnknownAlgo
+	f.dirty = true
+	if !f.saving {
+		f.saving = true
+		f.saveDone = make(chan struct{})
+		go f.writeStore(f.saveDone)
 	}
+}
 
-	logData(ctx, f.Log, f.data)
-	return nil
+// writeStore writes the cached data to f.Store until it is up to date. Stored
+// data is removed if there are no cookies left.
+func (f *Fetcher) writeStore(done chan struct{}) {
+	defer close(done)
+	ctx := context.Background()
+	serverName, serverAddr := f.TLSConfig.ServerName, f.storeAddr()
+	f.mu.Lock()
+	for f.dirty {
+		f.dirty = false
+		slot, data, fetchedAt := f.storeSlot(), f.data, f.fetchedAt
+		data.Cookie = slices.Clone(data.Cookie)
+		f.mu.Unlock()
+
+		if len(data.Cookie) == 0 {
+			err := f.Store.Delete(serverName, serverAddr, slot)
+			if err != nil {
+				f.Log.LogAttrs(ctx, slog.LevelInfo, "failed to delete stored NTS-KE data", slog.Any("error", err))
+			}
+		} else {
+			err := f.Store.Save(serverName, serverAddr, slot, data, fetchedAt)
+			if err != nil {
+				f.Log.LogAttrs(ctx, slog.LevelInfo, "failed to store NTS-KE data", slog.Any("error", err))
+			}
+		}
+
+		f.mu.Lock()
+	}
+	f.saving = false
+	f.mu.Unlock()
+}
+
+// Flush waits until data scheduled to be written to Store has been written.
+func (f *Fetcher) Flush() {
+	f.mu.Lock()
+	saving, done := f.saving, f.saveDone
+	f.mu.Unlock()
+	if saving {
+		<-done
+	}
+}
+
+// refresh performs a key exchange in the background.
+func (f *Fetcher) refresh() {
+	defer func() {
+		f.mu.Lock()
+		f.refreshing = false
+		f.mu.Unlock()
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), refreshTimeout)
+	defer cancel()
+	err := f.fetch(ctx, true /* refresh */)
+	if err != nil {
+		f.Log.LogAttrs(ctx, slog.LevelInfo, "failed to renew key exchange data", slog.Any("error", err))
+	}
 }
 
 // FetchData returns either cached data or requests new Data by performing a NTS key exchange.
+// The first cookie of the returned data is removed from the stock of cookies.
 func (f *Fetcher) FetchData(ctx context.Context) (Data, error) {
-	if len(f.data.Cookie) == 0 {
-		err := f.exchangeKeys(ctx)
+	f.mu.Lock()
+	for len(f.data.Cookie) == 0 {
+		// Concurrent callers may use up all cookies obtained by fetch.
+		f.mu.Unlock()
+		err := ctx.Err()
 		if err != nil {
 			return Data{}, err
 		}
+		err = f.fetch(ctx, false /* refresh */)
+		if err != nil {
+			return Data{}, err
+		}
+		f.mu.Lock()
 	}
+	defer f.mu.Unlock()
+
 	data := f.data
+	data.Cookie = slices.Clone(f.data.Cookie)
 	f.data.Cookie = f.data.Cookie[1:]
+	fetcherMtrcs.Load().cookies.Dec()
+	// The stock is persisted in the background. If the process stops before
+	// it is written, a resumed fetcher may reuse the cookie once.
+	f.save()
+
+	now := time.Now()
+	if !f.refreshing && !now.Before(f.retryAt) && (len(f.data.Cookie) < minStoredCookies ||
+		f.MaxAge > 0 && now.Sub(f.fetchedAt) >= f.MaxAge) {
+		f.refreshing = true
+		go f.refresh()
+	}
 	return data, nil
 }
 
-// StoreCookie stores a cookie byte slice and appends it to the cached data.
-func (f *Fetcher) StoreCookie(cookie []byte) {
+// FetchCSPTPKey returns the key ID and key for CSPTP authentication TLVs
+// obtained in the current key exchange or performs a new key exchange if there
+// is none. It requires CSPTP to be set and does not consume cookies. Keys are
+// renewed in the background once they have reached MaxAge or, if zero, the key
+// renewal interval of NTS-KE servers.
+func (f *Fetcher) FetchCSPTPKey(ctx context.Context) (uint32, []byte, error) {
+	f.mu.Lock()
+	if len(f.data.CSPTPKey) == 0 {
+		// Data resumed from a store or obtained before does not include a key.
+		refresh := len(f.data.Cookie) != 0
+		f.mu.Unlock()
+		err := f.fetch(ctx, refresh)
+		if err != nil {
+			return 0, nil, err
+		}
+		f.mu.Lock()
+	}
+	defer f.mu.Unlock()
+
+	if len(f.data.CSPTPKey) == 0 {
+		return 0, nil, errNoCSPTPKey
+	}
+	keyID, key := f.data.CSPTPKeyID, f.data.CSPTPKey
+
+	maxAge := f.MaxAge
+	if maxAge == 0 {
+		maxAge = keyRenewalInterval
+	}
+	now := time.Now()
+	if !f.refreshing && !now.Before(f.retryAt) && now.Sub(f.fetchedAt) >= maxAge {
+		f.refreshing = true
+		go f.refresh()
+	}
+	return keyID, key, nil
+}
+
+// Reset discards all cached and stored data. The next call to FetchData
+// performs a new NTS key exchange.
+func (f *Fetcher) Reset() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	fetcherMtrcs.Load().cookies.Sub(float64(len(f.data.Cookie)))
+	f.gen++
+	f.data = Data{}
+	f.loaded = true
+	f.save()
+}
+
+// Rekey discards all cached data and immediately performs a new NTS key
+// exchange.
+func (f *Fetcher) Rekey(ctx context.Context) error {
+	f.Reset()
+	return f.fetch(ctx, false /* refresh */)
+}
+
+// StoreCookie stores a cookie received in a response to a request made with
+// data. Cookies are discarded if data stems from a previous key exchange or if
+// the stock of cookies is full.
+func (f *Fetcher) StoreCookie(data Data, cookie []byte) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if data.gen != f.gen || len(f.data.Cookie) >= maxStoredCookies {
+		return
+	}
 	f.data.Cookie = append(f.data.Cookie, cookie)
+	fetcherMtrcs.Load().cookies.Inc()
+}
+
+// NumCookies returns the number of cookies currently stored.
+func (f *Fetcher) NumCookies() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.data.Cookie)
 }
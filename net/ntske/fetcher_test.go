@@ -0,0 +1,397 @@
+package ntske_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"log/slog"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"example.com/scion-time/net/ntske"
+)
+
+type testCertificate struct {
+	tls.Certificate
+	leaf *x509.Certificate
+}
+
+func newTestCertificate(t *testing.T, name string, ip net.IP, notBefore, notAfter time.Time) testCertificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	if ip != nil {
+		tmpl.IPAddresses = []net.IP{ip}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return testCertificate{
+		Certificate: tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key},
+		leaf:        leaf,
+	}
+}
+
+func (c testCertificate) pool() *x509.CertPool {
+	p := x509.NewCertPool()
+	p.AddCert(c.leaf)
+	return p
+}
+
+func (c testCertificate) writeFiles(t *testing.T, certFile, keyFile string) {
+	t.Helper()
+	keyDER, err := x509.MarshalECPrivateKey(c.PrivateKey.(*ecdsa.PrivateKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.Certificate.Certificate[0]}), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// testServer is a minimal NTS-KE server that issues cookies for the NTP
+// server on its own address. It records the common names of verified client
+// certificates.
+type testServer struct {
+	ip       net.IP
+	port     string
+	provider *ntske.Provider
+	mu       sync.Mutex
+	clients  []string
+}
+
+func startTestServer(t *testing.T, ip net.IP, config *tls.Config) *testServer {
+	t.Helper()
+	config = config.Clone()
+	config.NextProtos = []string{"ntske/1"}
+	config.MinVersion = tls.VersionTLS13
+	l, err := tls.Listen("tcp", net.JoinHostPort(ip.String(), "0"), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = l.Close() })
+	s := &testServer{
+		ip:       ip,
+		port:     strconv.Itoa(l.Addr().(*net.TCPAddr).Port),
+		provider: ntske.NewProvider(),
+	}
+	go func() {
+		for {
+			conn, err := ntske.AcceptTLSConn(l)
+			if err != nil {
+				return
+			}
+			go s.handle(conn)
+		}
+	}()
+	return s
+}
+
+func (s *testServer) addr() string {
+	return net.JoinHostPort(s.ip.String(), s.port)
+}
+
+func (s *testServer) handle(conn *tls.Conn) {
+	defer func() { _ = conn.Close() }()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err := conn.HandshakeContext(ctx)
+	if err != nil {
+		return
+	}
+	if cs := conn.ConnectionState(); len(cs.VerifiedChains) != 0 {
+		s.mu.Lock()
+		s.clients = append(s.clients, cs.VerifiedChains[0][0].Subject.CommonName)
+		s.mu.Unlock()
+	}
+
+	var data ntske.Data
+	err = ntske.ReadData(ctx, slog.New(slog.DiscardHandler), conn, bufio.NewReader(conn), &data)
+	if err != nil {
+		return
+	}
+	var ok bool
+	data.Algo, ok = ntske.SelectAlgorithm(data.Algos)
+	if !ok {
+		return
+	}
+	err = ntske.ExportKeys(conn.ConnectionState(), &data)
+	if err != nil {
+		return
+	}
+
+	var msg ntske.ExchangeMsg
+	msg.AddRecord(ntske.NextProto{NextProto: ntske.NTPv4})
+	msg.AddRecord(ntske.Algorithm{Algo: []uint16{data.Algo}})
+	msg.AddRecord(ntske.Server{Addr: []byte(s.ip.String())})
+	msg.AddRecord(ntske.Port{Port: 123})
+	c := ntske.ServerCookie{Algo: data.Algo, C2S: data.C2sKey, S2C: data.S2cKey}
+	key := s.provider.Current()
+	for range 8 {
+		encryptedCookie, err := c.EncryptWithNonce(key.Value, key.ID)
+		if err != nil {
+			return
+		}
+		msg.AddRecord(ntske.Cookie{Cookie: encryptedCookie.Encode()})
+	}
+	msg.AddRecord(ntske.End{})
+	buf, err := msg.Pack()
+	if err != nil {
+		return
+	}
+	_, _ = conn.Write(buf.Bytes())
+}
+
+func (s *testServer) verifiedClients() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.clients...)
+}
+
+func newTestFetcher(s *testServer, roots *x509.CertPool) *ntske.Fetcher {
+	f := &ntske.Fetcher{Log: slog.New(slog.DiscardHandler)}
+	f.TLSConfig = tls.Config{
+		ServerName: s.ip.String(),
+		RootCAs:    roots,
+		MinVersion: tls.VersionTLS13,
+	}
+	f.Port = s.port
+	return f
+}
+
+func TestFetcher(t *testing.T) {
+	ctx := context.Background()
+
+	now := time.Now()
+	serverIP := net.IPv4(127, 0, 1, 1).To4()
+	cert := newTestCertificate(t, serverIP.String(), serverIP, now.Add(-time.Hour), now.Add(time.Hour))
+	s := startTestServer(t, serverIP, &tls.Config{Certificates: []tls.Certificate{cert.Certificate}})
+
+	// The primary NTS-KE server is unreachable, the fetcher fails over to the
+	// second one.
+	f := newTestFetcher(s, cert.pool())
+	f.TLSConfig.ServerName = "127.0.1.2"
+	f.Servers = []string{s.addr()}
+
+	var wg sync.WaitGroup
+	for range 32 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			data, err := f.FetchData(ctx)
+			if err != nil {
+				t.Errorf("FetchData() failed: %v", err)
+				return
+			}
+			if data.Server != serverIP.String() || len(data.Cookie) == 0 {
+				t.Errorf("FetchData() = server %q with %d cookies; want server %q with cookies",
+					data.Server, len(data.Cookie), serverIP)
+			}
+		}()
+	}
+	wg.Wait()
+
+	data, err := f.FetchData(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = f.Rekey(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := f.NumCookies()
+	f.StoreCookie(data, data.Cookie[0])
+	if f.NumCookies() != n {
+		t.Error("StoreCookie() accepted cookie from previous key exchange")
+	}
+	data, err = f.FetchData(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.StoreCookie(data, data.Cookie[0])
+	if f.NumCookies() != n {
+		t.Errorf("NumCookies() = %d after StoreCookie(); want %d", f.NumCookies(), n)
+	}
+}
+
+func TestFetcherStore(t *testing.T) {
+	ctx := context.Background()
+
+	now := time.Now()
+	serverIP := net.IPv4(127, 0, 1, 3).To4()
+	cert := newTestCertificate(t, serverIP.String(), serverIP, now.Add(-time.Hour), now.Add(time.Hour))
+	s := startTestServer(t, serverIP, &tls.Config{Certificates: []tls.Certificate{cert.Certificate}})
+
+	// Several fetchers of the same server, e.g., the SCION clients of a
+	// reference clock, each store a stock of cookies of their own.
+	const numFetchers = 3
+	dir := filepath.Join(t.TempDir(), "nts")
+	newFetchers := func() []*ntske.Fetcher {
+		store, err := ntske.OpenCookieStore(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fs := make([]*ntske.Fetcher, numFetchers)
+		for i := range fs {
+			fs[i] = newTestFetcher(s, cert.pool())
+			fs[i].Store = store
+		}
+		return fs
+	}
+
+	var data0 [numFetchers]ntske.Data
+	for i, f := range newFetchers() {
+		var err error
+		data0[i], err = f.FetchData(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Flush()
+	}
+
+	// New fetchers resume with the stored keys and the unused cookies.
+	var data1 ntske.Data
+	var f1 *ntske.Fetcher
+	for _, f := range newFetchers() {
+		var err error
+		data1, err = f.FetchData(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Flush()
+		i := slices.IndexFunc(data0[:], func(d ntske.Data) bool { return bytes.Equal(d.C2sKey, data1.C2sKey) })
+		if i < 0 {
+			t.Fatal("FetchData() did not resume stored keys")
+		}
+		if slices.ContainsFunc(data1.Cookie, func(c []byte) bool { return bytes.Equal(c, data0[i].Cookie[0]) }) {
+			t.Error("FetchData() resumed a cookie that was already used")
+		}
+		data0[i] = ntske.Data{}
+		f1 = f
+	}
+
+	// Stored data is discarded on reset, e.g., after an NTS NAK.
+	f1.Reset()
+	f1.Flush()
+	for _, f := range newFetchers() {
+		data2, err := f.FetchData(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bytes.Equal(data2.C2sKey, data1.C2sKey) {
+			t.Error("FetchData() resumed keys discarded on reset")
+		}
+	}
+}
+
+func TestFetcherClientCertificate(t *testing.T) {
+	ctx := context.Background()
+
+	now := time.Now()
+	dir := t.TempDir()
+	const identity = "node1.fleet.example"
+	clientCert := newTestCertificate(t, identity, nil /* ip */, now.Add(-time.Hour), now.Add(time.Hour))
+	certFile, keyFile := filepath.Join(dir, "client.crt"), filepath.Join(dir, "client.key")
+	clientCert.writeFiles(t, certFile, keyFile)
+
+	serverIP := net.IPv4(127, 0, 1, 4).To4()
+	cert := newTestCertificate(t, serverIP.String(), serverIP, now.Add(-time.Hour), now.Add(time.Hour))
+	s := startTestServer(t, serverIP, &tls.Config{
+		Certificates: []tls.Certificate{cert.Certificate},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    clientCert.pool(),
+	})
+
+	f := newTestFetcher(s, cert.pool())
+	if err := f.Rekey(ctx); err == nil {
+		t.Error("key exchange succeeded without client certificate")
+	}
+
+	f = newTestFetcher(s, cert.pool())
+	f.ClientCertFile, f.ClientKeyFile = certFile, keyFile
+	_, err := f.FetchData(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if clients := s.verifiedClients(); !slices.Equal(clients, []string{identity}) {
+		t.Errorf("verified clients = %q; want %q", clients, identity)
+	}
+}
+
+func TestFetcherBootstrap(t *testing.T) {
+	ctx := context.Background()
+
+	// The server certificate appears expired to the client, e.g., because the
+	// client clock is far ahead.
+	serverIP := net.IPv4(127, 0, 1, 5).To4()
+	notBefore := time.Now().Add(-3 * time.Hour).Truncate(time.Second)
+	notAfter := time.Now().Add(-2 * time.Hour).Truncate(time.Second)
+	cert := newTestCertificate(t, serverIP.String(), serverIP, notBefore, notAfter)
+	s := startTestServer(t, serverIP, &tls.Config{Certificates: []tls.Certificate{cert.Certificate}})
+
+	newFetcher := func(roots *x509.CertPool, bootstrap bool) *ntske.Fetcher {
+		f := newTestFetcher(s, roots)
+		f.Bootstrap = bootstrap
+		return f
+	}
+
+	f := newFetcher(cert.pool(), false /* bootstrap */)
+	if err := f.Rekey(ctx); err == nil {
+		t.Error("key exchange succeeded with expired certificate")
+	}
+
+	// Untrusted certificates are rejected in bootstrap mode as well.
+	f = newFetcher(x509.NewCertPool(), true /* bootstrap */)
+	if err := f.Rekey(ctx); err == nil {
+		t.Error("key exchange succeeded with untrusted certificate")
+	}
+
+	f = newFetcher(cert.pool(), true /* bootstrap */)
+	_, err := f.FetchData(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nb, na, ok := f.CertificateValidity()
+	if !ok || !nb.Equal(notBefore) || !na.Equal(notAfter) {
+		t.Errorf("CertificateValidity() = %v, %v, %v; want %v, %v, true", nb, na, ok, notBefore, notAfter)
+	}
+
+	// Certificates are validated regularly after bootstrapping.
+	if err := f.EndBootstrap(ctx); err == nil {
+		t.Error("key exchange succeeded with expired certificate after bootstrapping")
+	}
+}
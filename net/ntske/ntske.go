@@ -29,7 +29,10 @@ import (
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"io"
 	"log/slog"
+	"slices"
+	"time"
 )
 
 // Data is the negotiated data from the NTS Key Exchange.
@@ -40,6 +43,20 @@ type Data struct {
 	Port   uint16
 	Cookie [][]byte
 	Algo   uint16
+	Algos  []uint16
+	// CSPTP is set if a key for CSPTP message authentication is requested,
+	// CSPTPKeyID and CSPTPKey hold the key provided by the server.
+	CSPTP      bool
+	CSPTPKeyID uint32
+	CSPTPKey   []byte
+	// NTPv5 is set if NTPv5 is proposed as next protocol in a request or if
+	// it was selected by the server in a response.
+	NTPv5 bool
+	gen   uint64
+	// notBefore and notAfter bound the validity of the server certificate
+	// chain presented in the key exchange.
+	notBefore time.Time
+	notAfter  time.Time
 }
 
 // NTS-KE record types
@@ -52,11 +69,21 @@ const (
 	RecCookie    uint16 = 5
 	RecServer    uint16 = 6
 	RecPort      uint16 = 7
+
+	// RecCSPTPKey is a record type from the private use range, see RFC 8915,
+	// Section 7.6, for provisioning keys for CSPTP authentication TLVs.
+	RecCSPTPKey uint16 = 0x4001
 )
 
+// AEAD algorithms, see RFC 8915, Section 5.1
 const (
 	AES_SIV_CMAC_256 = 0x0f
+	AES_SIV_CMAC_384 = 0x10
+	AES_SIV_CMAC_512 = 0x11
+	AES_128_GCM_SIV  = 0x1e
+)
 
+const (
 	ServerPortIP    = 4460
 	ServerPortSCION = 14460
 )
@@ -69,14 +96,36 @@ const (
 
 const alpn = "ntske/1"
 
+const (
+	// maxMsgLen bounds the length of NTS-KE messages accepted by ReadData.
+	maxMsgLen = 1 << 16
+
+	// recordReadTimeout bounds the time to read a single NTS-KE record.
+	recordReadTimeout = 2 * time.Second
+)
+
 var (
 	errServerNoNTSKE            = errors.New("server does not support ntske/1")
 	errReadInternalServer       = errors.New("ntske received internal server error message")
 	errReadBadRequest           = errors.New("ntske received bad request error message")
 	errReadUnrecognisedCritical = errors.New("ntske received unrecognized critical error message")
 	errReadUnknown              = errors.New("ntske received unknown error message")
+	errMsgTooLong               = errors.New("ntske message too long")
+
+	// ErrUnrecognizedCritical is returned by ReadData if a message contains a
+	// record of unknown type with the critical bit set.
+	ErrUnrecognizedCritical = errors.New("ntske received unrecognized critical record")
+	// ErrMalformed is returned by ReadData if a message contains a record
+	// with an invalid body.
+	ErrMalformed = errors.New("ntske received malformed record")
 )
 
+// ReadDeadliner is implemented by connections and streams NTS-KE messages are
+// read from.
+type ReadDeadliner interface {
+	SetReadDeadline(t time.Time) error
+}
+
 // RecordHdr is the header on all records exchanged in NTS-KE.
 type RecordHdr struct {
 	Type    uint16 // First bit is Critical bit
@@ -162,12 +211,20 @@ func (m *ExchangeMsg) AddRecord(rec Record) {
 	m.Record = append(m.Record, rec)
 }
 
-const NTPv4 uint16 = 0
+// Next protocol IDs. NTPv5 has no assigned protocol ID yet, the ID from the
+// private or experimental use range is the one used for NTPv5 interoperability
+// testing.
+const (
+	NTPv4 uint16 = 0
+	NTPv5 uint16 = 0x8001
+)
 
-// NextProto record. Set to 0.
+// NextProto record. NextProto is the preferred protocol, Fallback lists further
+// supported protocols in order of preference.
 type NextProto struct {
 	RecordHdr
 	NextProto uint16
+	Fallback  []uint16
 }
 
 func (n NextProto) pack(buf *bytes.Buffer) error {
@@ -176,6 +233,12 @@ func (n NextProto) pack(buf *bytes.Buffer) error {
 	if err != nil {
 		return err
 	}
+	for _, p := range n.Fallback {
+		err = binary.Write(value, binary.BigEndian, p)
+		if err != nil {
+			return err
+		}
+	}
 
 	n.RecordHdr.Type = RecNextproto
 	n.RecordHdr.Type = setBit(n.RecordHdr.Type, 15)
@@ -237,6 +300,28 @@ func (c Cookie) pack(buf *bytes.Buffer) error {
 	return packsimple(RecCookie, false, c.Cookie, buf)
 }
 
+// CSPTPKey is the record type to request and to provide a key for CSPTP
+// authentication TLVs. Requests carry an empty body, responses the key ID
+// followed by the key.
+type CSPTPKey struct {
+	RecordHdr
+	KeyID uint32
+	Key   []byte
+}
+
+func (k CSPTPKey) pack(buf *bytes.Buffer) error {
+	if len(k.Key) == 0 {
+		return packheader(RecCSPTPKey, false, buf, 0)
+	}
+	value := new(bytes.Buffer)
+	err := binary.Write(value, binary.BigEndian, k.KeyID)
+	if err != nil {
+		return err
+	}
+	_, _ = value.Write(k.Key)
+	return packsimple(RecCSPTPKey, false, value.Bytes(), buf)
+}
+
 // Warning is the record type to send warnings to the other end.
 type Warning struct {
 	RecordHdr
@@ -268,21 +353,64 @@ func (a Algorithm) pack(buf *bytes.Buffer) error {
 	return packsimple(RecAead, true, a.Algo, buf)
 }
 
+// defaultAlgos lists the supported AEAD algorithms in order of preference.
+var defaultAlgos = []uint16{
+	AES_SIV_CMAC_256,
+	AES_SIV_CMAC_512,
+	AES_SIV_CMAC_384,
+	AES_128_GCM_SIV,
+}
+
+// KeyLen returns the key length in bytes of AEAD algorithm algo or 0 if algo is
+// not supported.
+func KeyLen(algo uint16) int {
+	switch algo {
+	case AES_SIV_CMAC_256:
+		return 32
+	case AES_SIV_CMAC_384:
+		return 48
+	case AES_SIV_CMAC_512:
+		return 64
+	case AES_128_GCM_SIV:
+		return 16
+	}
+	return 0
+}
+
+// SelectAlgorithm returns the first supported AEAD algorithm in algos, which
+// are expected to be in the client's order of preference.
+func SelectAlgorithm(algos []uint16) (uint16, bool) {
+	for _, algo := range algos {
+		if KeyLen(algo) != 0 {
+			return algo, true
+		}
+	}
+	return 0, false
+}
+
 // ExportKeys exports two extra session keys from the already
-// established NTS-KE connection for use with NTS.
+// established NTS-KE connection for use with NTS. The length of the keys
+// depends on the negotiated AEAD algorithm data.Algo.
 func ExportKeys(cs tls.ConnectionState, data *Data) error {
+	keyLen := KeyLen(data.Algo)
+	if keyLen == 0 {
+		return errUnknownAlgo
+	}
 	label := "EXPORTER-network-time-security"
-	s2cContext := []byte{0x00, 0x00, 0x00, 0x0f, 0x01}
-	c2sContext := []byte{0x00, 0x00, 0x00, 0x0f, 0x00}
-	len := 32
+	proto := NTPv4
+	if data.NTPv5 {
+		proto = NTPv5
+	}
+	s2cContext := []byte{byte(proto >> 8), byte(proto), byte(data.Algo >> 8), byte(data.Algo), 0x01}
+	c2sContext := []byte{byte(proto >> 8), byte(proto), byte(data.Algo >> 8), byte(data.Algo), 0x00}
 
 	var err error
-	data.S2cKey, err = cs.ExportKeyingMaterial(label, s2cContext, len)
+	data.S2cKey, err = cs.ExportKeyingMaterial(label, s2cContext, keyLen)
 	if err != nil {
 		return err
 	}
 
-	data.C2sKey, err = cs.ExportKeyingMaterial(label, c2sContext, len)
+	data.C2sKey, err = cs.ExportKeyingMaterial(label, c2sContext, keyLen)
 	if err != nil {
 		return err
 	}
@@ -290,16 +418,42 @@ func ExportKeys(cs tls.ConnectionState, data *Data) error {
 	return nil
 }
 
-func ReadData(ctx context.Context, log *slog.Logger, reader *bufio.Reader, data *Data) error {
+// ReadData reads an NTS-KE message from reader, which must be buffering conn.
+// Reading the message is bounded by the deadline of ctx, if any, and reading each
+// of its records by recordReadTimeout.
+func ReadData(ctx context.Context, log *slog.Logger, conn ReadDeadliner, reader *bufio.Reader,
+	data *Data) error {
 	var msg RecordHdr
 	var critical bool
 
+	defer func() { _ = conn.SetReadDeadline(time.Time{}) }()
+	msgDeadline, hasMsgDeadline := ctx.Deadline()
+	msgLen := 0
+
 	for {
-		err := binary.Read(reader, binary.BigEndian, &msg)
+		err := ctx.Err()
+		if err != nil {
+			return err
+		}
+		deadline := time.Now().Add(recordReadTimeout)
+		if hasMsgDeadline && msgDeadline.Before(deadline) {
+			deadline = msgDeadline
+		}
+		err = conn.SetReadDeadline(deadline)
+		if err != nil {
+			return err
+		}
+
+		err = binary.Read(reader, binary.BigEndian, &msg)
 		if err != nil {
 			return err
 		}
 
+		msgLen += binary.Size(msg) + int(msg.BodyLen)
+		if msgLen > maxMsgLen {
+			return errMsgTooLong
+		}
+
 		// C (Critical Bit): Determines the disposition of
 		// unrecognized Record Types. Implementations which
 		// receive a record with an unrecognized Record Type
@@ -316,26 +470,39 @@ func ReadData(ctx context.Context, log *slog.Logger, reader *bufio.Reader, data
 
 		switch msg.Type {
 		case RecEom:
+			if msg.BodyLen != 0 {
+				return fmt.Errorf("%w: end of message record length %v", ErrMalformed, msg.BodyLen)
+			}
 			return nil
 
 		case RecNextproto:
-			var nextProto uint16
+			if msg.BodyLen == 0 || msg.BodyLen%2 != 0 {
+				return fmt.Errorf("%w: next protocol record length %v", ErrMalformed, msg.BodyLen)
+			}
+			nextProto := make([]uint16, msg.BodyLen/2)
 			err := binary.Read(reader, binary.BigEndian, &nextProto)
 			if err != nil {
 				return err
 			}
+			data.NTPv5 = slices.Contains(nextProto, NTPv5)
 
 		case RecAead:
-			var aead uint16
+			if msg.BodyLen%2 != 0 {
+				return fmt.Errorf("%w: AEAD record length %v", ErrMalformed, msg.BodyLen)
+			}
+			aead := make([]uint16, msg.BodyLen/2)
 			err := binary.Read(reader, binary.BigEndian, &aead)
 			if err != nil {
 				return err
 			}
-			data.Algo = aead
+			data.Algos = append(data.Algos, aead...)
+			if len(data.Algos) != 0 {
+				data.Algo = data.Algos[0]
+			}
 
 		case RecCookie:
 			cookie := make([]byte, msg.BodyLen)
-			_, err := reader.Read(cookie)
+			_, err := io.ReadFull(reader, cookie)
 			if err != nil {
 				return err
 			}
@@ -351,12 +518,36 @@ func ReadData(ctx context.Context, log *slog.Logger, reader *bufio.Reader, data
 			data.Server = string(address)
 
 		case RecPort:
+			if msg.BodyLen != 2 {
+				return fmt.Errorf("%w: port record length %v", ErrMalformed, msg.BodyLen)
+			}
 			err := binary.Read(reader, binary.BigEndian, &data.Port)
 			if err != nil {
 				return err
 			}
 
+		case RecCSPTPKey:
+			if msg.BodyLen == 0 {
+				data.CSPTP = true
+				break
+			}
+			if msg.BodyLen < 4+csptpMinKeyLen {
+				return fmt.Errorf("%w: CSPTP key record length %v", ErrMalformed, msg.BodyLen)
+			}
+			err := binary.Read(reader, binary.BigEndian, &data.CSPTPKeyID)
+			if err != nil {
+				return err
+			}
+			data.CSPTPKey = make([]byte, msg.BodyLen-4)
+			_, err = io.ReadFull(reader, data.CSPTPKey)
+			if err != nil {
+				return err
+			}
+
 		case RecError:
+			if msg.BodyLen != 2 {
+				return fmt.Errorf("%w: error record length %v", ErrMalformed, msg.BodyLen)
+			}
 			var code uint16
 			err := binary.Read(reader, binary.BigEndian, &code)
 			if err != nil {
@@ -373,12 +564,11 @@ func ReadData(ctx context.Context, log *slog.Logger, reader *bufio.Reader, data
 
 		default:
 			if critical {
-				return fmt.Errorf("unknown record type %v with critical bit set", msg.Type)
+				return fmt.Errorf("%w: type %v", ErrUnrecognizedCritical, msg.Type)
 			}
 
 			// Swallow unknown record.
-			unknownMsg := make([]byte, msg.BodyLen)
-			err := binary.Read(reader, binary.BigEndian, &unknownMsg)
+			_, err := reader.Discard(int(msg.BodyLen))
 			if err != nil {
 				return err
 			}
@@ -63,17 +63,26 @@ func dialTLS(hostport string, config *tls.Config) (*tls.Conn, Data, error) {
 	return conn, data, nil
 }
 
-func exchangeDataTLS(ctx context.Context, log *slog.Logger, conn *tls.Conn, data *Data) error {
+func exchangeDataTLS(ctx context.Context, log *slog.Logger, conn *tls.Conn, algos []uint16, data *Data) error {
 	var msg ExchangeMsg
 
 	var nextproto NextProto
 	nextproto.NextProto = NTPv4
+	if data.NTPv5 {
+		nextproto.NextProto = NTPv5
+		nextproto.Fallback = []uint16{NTPv4}
+	}
 	msg.AddRecord(nextproto)
 
 	var algo Algorithm
-	algo.Algo = []uint16{AES_SIV_CMAC_256}
+	algo.Algo = algos
 	msg.AddRecord(algo)
 
+	if data.CSPTP {
+		msg.AddRecord(CSPTPKey{})
+	}
+	data.NTPv5 = false
+
 	var end End
 	msg.AddRecord(end)
 
@@ -88,7 +97,7 @@ func exchangeDataTLS(ctx context.Context, log *slog.Logger, conn *tls.Conn, data
 	}
 
 	reader := bufio.NewReader(conn)
-	err = ReadData(ctx, log, reader, data)
+	err = ReadData(ctx, log, conn, reader, data)
 	if err != nil {
 		return err
 	}
@@ -19,13 +19,11 @@ import (
 
 var errNoPath = errors.New("failed to dial QUIC connection: no path")
 
-func dialQUIC(log *slog.Logger, localAddr, remoteAddr udp.UDPAddr, daemonAddr string, config *tls.Config) (*scion.QUICConnection, Data, error) {
+func dialQUIC(log *slog.Logger, localAddr, remoteAddr udp.UDPAddr, dc daemon.Connector, config *tls.Config) (*scion.QUICConnection, Data, error) {
 	config.NextProtos = []string{alpn}
 	var err error
 	ctx := context.Background()
 
-	dc := scion.NewDaemonConnector(ctx, daemonAddr)
-
 	var ps []snet.Path
 	if remoteAddr.IA == localAddr.IA {
 		ps = []snet.Path{path.Path{
@@ -74,7 +72,7 @@ func dialQUIC(log *slog.Logger, localAddr, remoteAddr udp.UDPAddr, daemonAddr st
 	return conn, data, nil
 }
 
-func exchangeDataQUIC(ctx context.Context, log *slog.Logger, conn *scion.QUICConnection, data *Data) error {
+func exchangeDataQUIC(ctx context.Context, log *slog.Logger, conn *scion.QUICConnection, algos []uint16, data *Data) error {
 	stream, err := conn.OpenStream()
 	if err != nil {
 		return err
@@ -85,12 +83,21 @@ func exchangeDataQUIC(ctx context.Context, log *slog.Logger, conn *scion.QUICCon
 
 	var nextproto NextProto
 	nextproto.NextProto = NTPv4
+	if data.NTPv5 {
+		nextproto.NextProto = NTPv5
+		nextproto.Fallback = []uint16{NTPv4}
+	}
 	msg.AddRecord(nextproto)
 
 	var algo Algorithm
-	algo.Algo = []uint16{AES_SIV_CMAC_256}
+	algo.Algo = algos
 	msg.AddRecord(algo)
 
+	if data.CSPTP {
+		msg.AddRecord(CSPTPKey{})
+	}
+	data.NTPv5 = false
+
 	var end End
 	msg.AddRecord(end)
 
@@ -105,7 +112,7 @@ func exchangeDataQUIC(ctx context.Context, log *slog.Logger, conn *scion.QUICCon
 	}
 
 	reader := bufio.NewReader(stream)
-	err = ReadData(ctx, log, reader, data)
+	err = ReadData(ctx, log, stream, reader, data)
 	if err != nil {
 		return err
 	}
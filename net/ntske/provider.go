@@ -1,22 +1,55 @@
 package ntske
 
 import (
+	"crypto/hkdf"
 	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
 	"math"
+	"os"
 	"sync"
 	"time"
 )
 
 /*
 This provider is set up to be used concurrently by the NTP and NTS-KE servers.
-In case they should not run on the same machine one option would be to synchronize
-an initial key once at startup of the servers and then each of them will separately
-create the next key each day using some key derivation function like hkdf.
+By default, keys are generated randomly and only live in memory. In case the
+servers should not run in the same process or on the same machine, or cookies
+should survive restarts, a seeded provider derives the key for each renewal
+epoch from a shared secret seed with HKDF. All instances sharing the seed then
+use identical keys and key IDs without further synchronization.
+
+Keys for CSPTP authentication TLVs are the exception: they are random and only
+kept in memory by the provider that issued them as CSPTP key IDs are too short
+to carry a sealed key. Hence, CSPTP servers must share the provider with the
+NTS-KE servers issuing their keys.
 */
 
 const (
 	keyValidity        time.Duration = time.Hour * 24 * 3
 	keyRenewalInterval time.Duration = time.Hour * 24
+
+	// Tolerated clock offset between instances sharing a seed.
+	keyClockSkew time.Duration = time.Minute
+
+	keyLen       = 32
+	minSeedLen   = 32
+	keyIDSpace   = 1 << 16
+	keyDeriveCtx = "scion-time NTS cookie key"
+
+	// Keys for CSPTP authentication TLVs are kept for keyValidity, at most
+	// csptpKeyCap at a time.
+	csptpKeyLen    = 32
+	csptpMinKeyLen = 16
+	csptpKeyCap    = 1 << 18
+)
+
+var (
+	errShortSeed            = errors.New("NTS key seed too short")
+	errInvalidKeyValidity   = errors.New("NTS key validity must not be shorter than the renewal interval")
+	errExcessiveKeyValidity = errors.New("NTS key validity spans too many renewal intervals")
 )
 
 // Key is the key shared between NTP and NTS-KE servers with a validity time period.
@@ -35,6 +68,19 @@ type Provider struct {
 	keys        map[int]Key
 	currentID   int
 	generatedAt time.Time
+
+	seed            []byte
+	renewalInterval time.Duration
+	validity        time.Duration
+
+	csptpMu    sync.Mutex
+	csptpKeys  map[uint32]csptpKey
+	csptpQueue []uint32
+}
+
+type csptpKey struct {
+	value    []byte
+	notAfter time.Time
 }
 
 // IsValidAt returns whether the key is still valid.
@@ -83,11 +129,112 @@ func NewProvider() *Provider {
 	return p
 }
 
+// NewSeededProvider creates and returns a new provider that derives its keys
+// from seed. A new key is used every renewalInterval, starting at multiples of
+// renewalInterval since the Unix epoch, and each key remains valid for validity.
+// Zero durations select the defaults of providers created with NewProvider.
+func NewSeededProvider(seed []byte, renewalInterval, validity time.Duration) (*Provider, error) {
+	if len(seed) < minSeedLen {
+		return nil, errShortSeed
+	}
+	if renewalInterval == 0 {
+		renewalInterval = keyRenewalInterval
+	}
+	if validity == 0 {
+		validity = keyValidity
+	}
+	if renewalInterval < 0 || validity < renewalInterval {
+		return nil, errInvalidKeyValidity
+	}
+	if validity/renewalInterval >= keyIDSpace-1 {
+		return nil, errExcessiveKeyValidity
+	}
+	p := &Provider{
+		keys:            make(map[int]Key),
+		seed:            append([]byte(nil), seed...),
+		renewalInterval: renewalInterval,
+		validity:        validity,
+	}
+	return p, nil
+}
+
+// LoadSeededProvider creates and returns a new provider that derives its keys
+// from the seed stored in seedFile, see NewSeededProvider. The seed file must
+// be a regular file not accessible by group or others.
+func LoadSeededProvider(seedFile string, renewalInterval, validity time.Duration) (*Provider, error) {
+	fi, err := os.Stat(seedFile)
+	if err != nil {
+		return nil, err
+	}
+	if !fi.Mode().IsRegular() {
+		return nil, fmt.Errorf("NTS key seed file %s is not a regular file", seedFile)
+	}
+	if fi.Mode().Perm()&0o077 != 0 {
+		return nil, fmt.Errorf("NTS key seed file %s must not be accessible by group or others (mode %v)",
+			seedFile, fi.Mode().Perm())
+	}
+	seed, err := os.ReadFile(seedFile)
+	if err != nil {
+		return nil, err
+	}
+	return NewSeededProvider(seed, renewalInterval, validity)
+}
+
+func (p *Provider) epoch(t time.Time) int64 {
+	return t.UnixNano() / int64(p.renewalInterval)
+}
+
+// derivedKey returns the key of renewal epoch e, derived from the seed.
+func (p *Provider) derivedKey(e int64) Key {
+	id := int(uint16(e))
+	if key, ok := p.keys[id]; ok && key.Validity.NotBefore.Equal(time.Unix(0, e*int64(p.renewalInterval))) {
+		return key
+	}
+	var info [8]byte
+	binary.BigEndian.PutUint64(info[:], uint64(e))
+	value, err := hkdf.Key(sha256.New, p.seed, nil /* salt */, keyDeriveCtx+string(info[:]), keyLen)
+	if err != nil {
+		panic(err)
+	}
+	key := Key{
+		ID:    id,
+		Value: value,
+	}
+	key.Validity.NotBefore = time.Unix(0, e*int64(p.renewalInterval))
+	key.Validity.NotAfter = key.Validity.NotBefore.Add(p.validity)
+	p.keys[id] = key
+	return key
+}
+
+// isUsableAt returns whether key may be used to decrypt cookies at time t. Keys
+// derived from a seed are accepted keyClockSkew before they become valid to
+// tolerate clock offsets between instances.
+func (p *Provider) isUsableAt(key *Key, t time.Time) bool {
+	if p.seed != nil {
+		return !t.Add(keyClockSkew).Before(key.Validity.NotBefore) && !t.After(key.Validity.NotAfter)
+	}
+	return key.IsValidAt(t)
+}
+
 // Get returns the Key with ID id and true if it exists and is still valid or false otherwise.
 func (p *Provider) Get(id int) (Key, bool) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
+	if p.seed != nil {
+		if id < 0 || id >= keyIDSpace {
+			return Key{}, false
+		}
+		tNow := time.Now()
+		e := p.epoch(tNow.Add(keyClockSkew))
+		e -= int64(uint16(e) - uint16(id))
+		key := p.derivedKey(e)
+		if !p.isUsableAt(&key, tNow) {
+			return Key{}, false
+		}
+		return key, true
+	}
+
 	key, ok := p.keys[id]
 	if !ok {
 		return Key{}, false
@@ -104,9 +251,73 @@ func (p *Provider) Current() Key {
 	defer p.mu.Unlock()
 
 	tNow := time.Now()
+	if p.seed != nil {
+		for id, key := range p.keys {
+			if !p.isUsableAt(&key, tNow) {
+				delete(p.keys, id)
+			}
+		}
+		return p.derivedKey(p.epoch(tNow))
+	}
 	if key := p.keys[p.currentID]; !key.IsValidAt(tNow) || p.generatedAt.Add(keyRenewalInterval).Before(tNow) {
 		p.generateNext()
 	}
 
 	return p.keys[p.currentID]
 }
+
+// NewCSPTPKey returns a new random key for CSPTP authentication TLVs and its
+// random key ID. The key can be looked up by its key ID with CSPTPKey on the
+// same provider for the validity period of NTS keys. If too many keys have been
+// issued, the oldest key is discarded.
+func (p *Provider) NewCSPTPKey() (uint32, []byte) {
+	p.csptpMu.Lock()
+	defer p.csptpMu.Unlock()
+
+	tNow := time.Now()
+	if p.csptpKeys == nil {
+		p.csptpKeys = make(map[uint32]csptpKey)
+	}
+	for len(p.csptpQueue) != 0 {
+		id := p.csptpQueue[0]
+		if len(p.csptpQueue) < csptpKeyCap && !tNow.After(p.csptpKeys[id].notAfter) {
+			break
+		}
+		delete(p.csptpKeys, id)
+		p.csptpQueue = p.csptpQueue[1:]
+	}
+
+	value := make([]byte, csptpKeyLen)
+	_, err := rand.Read(value)
+	if err != nil {
+		panic("failed to read from rand")
+	}
+	var keyID uint32
+	for {
+		var r [4]byte
+		_, err = rand.Read(r[:])
+		if err != nil {
+			panic("failed to read from rand")
+		}
+		keyID = binary.BigEndian.Uint32(r[:])
+		if _, ok := p.csptpKeys[keyID]; !ok {
+			break
+		}
+	}
+	p.csptpKeys[keyID] = csptpKey{value: value, notAfter: tNow.Add(keyValidity)}
+	p.csptpQueue = append(p.csptpQueue, keyID)
+	return keyID, value
+}
+
+// CSPTPKey returns the key for CSPTP authentication TLVs with ID keyID and
+// true if it was issued by NewCSPTPKey and is still valid or false otherwise.
+func (p *Provider) CSPTPKey(keyID uint32) ([]byte, bool) {
+	p.csptpMu.Lock()
+	defer p.csptpMu.Unlock()
+
+	key, ok := p.csptpKeys[keyID]
+	if !ok || time.Now().After(key.notAfter) {
+		return nil, false
+	}
+	return key.value, true
+}
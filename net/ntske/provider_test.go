@@ -0,0 +1,120 @@
+package ntske_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"example.com/scion-time/net/ntske"
+)
+
+var testSeed = []byte("0123456789abcdef0123456789abcdef")
+
+func TestSeededProviderSharedKeys(t *testing.T) {
+	p0, err := ntske.NewSeededProvider(testSeed, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p1, err := ntske.NewSeededProvider(testSeed, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k0 := p0.Current()
+	k1, ok := p1.Get(k0.ID)
+	if !ok {
+		t.Fatalf("Get(%d) failed on second instance", k0.ID)
+	}
+	if !bytes.Equal(k0.Value, k1.Value) {
+		t.Errorf("instances sharing a seed derived different keys for ID %d", k0.ID)
+	}
+	if k := p1.Current(); k.ID != k0.ID || !bytes.Equal(k.Value, k0.Value) {
+		t.Errorf("Current() differs between instances sharing a seed")
+	}
+
+	p2, err := ntske.NewSeededProvider(bytes.Repeat([]byte{1}, 32), 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k, _ := p2.Get(k0.ID); bytes.Equal(k.Value, k0.Value) {
+		t.Errorf("instances with different seeds derived the same key")
+	}
+}
+
+func TestSeededProviderRollover(t *testing.T) {
+	p, err := ntske.NewSeededProvider(testSeed, time.Millisecond, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k0 := p.Current()
+	time.Sleep(5 * time.Millisecond)
+	k1 := p.Current()
+	if k1.ID == k0.ID {
+		t.Fatalf("Current() did not roll over to a new key")
+	}
+	if k, ok := p.Get(k0.ID); !ok || !bytes.Equal(k.Value, k0.Value) {
+		t.Errorf("Get(%d) failed for previous key within validity window", k0.ID)
+	}
+}
+
+func TestSeededProviderConfig(t *testing.T) {
+	_, err := ntske.NewSeededProvider(testSeed[:16], 0, 0)
+	if err == nil {
+		t.Error("NewSeededProvider() accepted short seed")
+	}
+	_, err = ntske.NewSeededProvider(testSeed, time.Hour, time.Minute)
+	if err == nil {
+		t.Error("NewSeededProvider() accepted validity shorter than renewal interval")
+	}
+
+	dir := t.TempDir()
+	seedFile := filepath.Join(dir, "seed")
+	err = os.WriteFile(seedFile, testSeed, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = ntske.LoadSeededProvider(seedFile, 0, 0)
+	if err == nil {
+		t.Error("LoadSeededProvider() accepted seed file readable by others")
+	}
+	err = os.Chmod(seedFile, 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = ntske.LoadSeededProvider(seedFile, 0, 0)
+	if err != nil {
+		t.Errorf("LoadSeededProvider() failed: %v", err)
+	}
+}
+
+func TestCSPTPKey(t *testing.T) {
+	p0, err := ntske.NewSeededProvider(testSeed, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p1, err := ntske.NewSeededProvider(testSeed, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyID, key := p0.NewCSPTPKey()
+	if len(key) < 16 {
+		t.Fatalf("NewCSPTPKey() returned key of length %d", len(key))
+	}
+	if k, ok := p0.CSPTPKey(keyID); !ok || !bytes.Equal(k, key) {
+		t.Errorf("CSPTPKey(%#x) failed", keyID)
+	}
+	if _, ok := p0.CSPTPKey(keyID ^ 1); ok {
+		t.Errorf("CSPTPKey(%#x) succeeded for unknown key", keyID^1)
+	}
+
+	// Keys are random and cannot be derived from their key IDs, not even by
+	// instances sharing the seed.
+	if _, ok := p1.CSPTPKey(keyID); ok {
+		t.Errorf("CSPTPKey(%#x) succeeded on second instance", keyID)
+	}
+	keyID1, key1 := p1.NewCSPTPKey()
+	if keyID1 == keyID || bytes.Equal(key1, key) {
+		t.Error("NewCSPTPKey() returned the same key on second instance")
+	}
+}
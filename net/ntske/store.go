@@ -0,0 +1,253 @@
+package ntske
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/miscreant/miscreant.go"
+)
+
+/*
+A cookie store persists the keys and unused cookies obtained by NTS-KE clients
+s.t. clients can resume after a restart without a new key exchange. Entries are
+stored in one file per NTS-KE server, named after a hash of the server name and
+address, and encrypted with AES-SIV under a store key kept in the same
+directory. The server name and address are authenticated as associated data.
+
+Cookies must not be reused. Hence, each fetcher claims a slot of its own for a
+server before it loads or saves data, and a slot is handed out only once per
+store. If several fetchers use the same server, e.g., the SCION clients of a
+reference clock, each of them resumes the stock of cookies stored in its slot.
+*/
+
+const (
+	storeKeyFile    = "store.key"
+	storeFileSuffix = ".cookies"
+	storeKeyLen     = 32
+	storeNonceLen   = 16
+
+	// Stored data older than maxStoredDataAge is discarded unless the fetcher
+	// limits the age of its data itself. Keys of the NTS-KE server are valid
+	// for at least another day after they were used to issue cookies.
+	maxStoredDataAge = keyRenewalInterval
+)
+
+var errInvalidStoreKey = errors.New("invalid NTS cookie store key")
+
+// CookieStore is an on-disk store for NTS keys and cookies safe for use by
+// multiple goroutines.
+type CookieStore struct {
+	mu   sync.Mutex
+	dir  string
+	key  []byte
+	used map[string]int
+}
+
+type storedData struct {
+	Server    string   `json:"server"`
+	Port      uint16   `json:"port"`
+	Algo      uint16   `json:"algo"`
+	C2sKey    []byte   `json:"c2s_key"`
+	S2cKey    []byte   `json:"s2c_key"`
+	Cookies   [][]byte `json:"cookies"`
+	FetchedAt int64    `json:"fetched_at"` // Unix time in nanoseconds
+
+	CSPTPKeyID uint32 `json:"csptp_key_id,omitempty"`
+	CSPTPKey   []byte `json:"csptp_key,omitempty"`
+
+	NTPv5 bool `json:"ntpv5,omitempty"`
+}
+
+func checkPrivate(fi fs.FileInfo, name string) error {
+	if fi.Mode().Perm()&0o077 != 0 {
+		return fmt.Errorf("NTS cookie store %s must not be accessible by group or others (mode %v)",
+			name, fi.Mode().Perm())
+	}
+	return nil
+}
+
+// OpenCookieStore opens the cookie store in directory dir. The directory and
+// the store key are created if they do not exist yet.
+func OpenCookieStore(dir string) (*CookieStore, error) {
+	err := os.MkdirAll(dir, 0o700)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := os.Stat(dir)
+	if err != nil {
+		return nil, err
+	}
+	if !fi.IsDir() {
+		return nil, fmt.Errorf("NTS cookie store %s is not a directory", dir)
+	}
+	err = checkPrivate(fi, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	keyFile := filepath.Join(dir, storeKeyFile)
+	key, err := os.ReadFile(keyFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		key = make([]byte, storeKeyLen)
+		_, err = rand.Read(key)
+		if err != nil {
+			return nil, err
+		}
+		err = writeFileAtomic(keyFile, key)
+		if err != nil {
+			return nil, err
+		}
+	} else if err != nil {
+		return nil, err
+	}
+	if len(key) != storeKeyLen {
+		return nil, errInvalidStoreKey
+	}
+	return &CookieStore{dir: dir, key: key, used: map[string]int{}}, nil
+}
+
+func writeFileAtomic(name string, data []byte) error {
+	f, err := os.CreateTemp(filepath.Dir(name), filepath.Base(name)+".*")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), name)
+	}
+	if err != nil {
+		_ = os.Remove(f.Name())
+	}
+	return err
+}
+
+func storeID(serverName, serverAddr string, slot int) string {
+	id := serverName + "\x00" + serverAddr
+	if slot != 0 {
+		id += "\x00" + strconv.Itoa(slot)
+	}
+	return id
+}
+
+func (s *CookieStore) path(id string) string {
+	h := sha256.Sum256([]byte(id))
+	return filepath.Join(s.dir, hex.EncodeToString(h[:])+storeFileSuffix)
+}
+
+// Claim returns a slot for the NTS-KE server with name serverName at serverAddr
+// that has not been claimed before through this store.
+func (s *CookieStore) Claim(serverName, serverAddr string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id := storeID(serverName, serverAddr, 0 /* slot */)
+	slot := s.used[id]
+	s.used[id] = slot + 1
+	return slot
+}
+
+// Load returns the data stored in slot for the NTS-KE server with name
+// serverName at serverAddr together with the time it was obtained.
+func (s *CookieStore) Load(serverName, serverAddr string, slot int) (Data, time.Time, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id := storeID(serverName, serverAddr, slot)
+	b, err := os.ReadFile(s.path(id))
+	if err != nil || len(b) < storeNonceLen {
+		return Data{}, time.Time{}, false
+	}
+	aead, err := miscreant.NewAEAD("AES-CMAC-SIV", s.key, storeNonceLen)
+	if err != nil {
+		return Data{}, time.Time{}, false
+	}
+	b, err = aead.Open(nil /* dst */, b[:storeNonceLen], b[storeNonceLen:], []byte(id))
+	if err != nil {
+		return Data{}, time.Time{}, false
+	}
+	var sd storedData
+	err = json.Unmarshal(b, &sd)
+	if err != nil {
+		return Data{}, time.Time{}, false
+	}
+	data := Data{
+		C2sKey: sd.C2sKey,
+		S2cKey: sd.S2cKey,
+		Server: sd.Server,
+		Port:   sd.Port,
+		Cookie: sd.Cookies,
+		Algo:   sd.Algo,
+		Algos:  []uint16{sd.Algo},
+
+		CSPTPKeyID: sd.CSPTPKeyID,
+		CSPTPKey:   sd.CSPTPKey,
+
+		NTPv5: sd.NTPv5,
+	}
+	return data, time.Unix(0, sd.FetchedAt), true
+}
+
+// Save stores data obtained at fetchedAt from the NTS-KE server with name
+// serverName at serverAddr in slot, replacing previously stored data.
+func (s *CookieStore) Save(serverName, serverAddr string, slot int, data Data, fetchedAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id := storeID(serverName, serverAddr, slot)
+	b, err := json.Marshal(storedData{
+		Server:    data.Server,
+		Port:      data.Port,
+		Algo:      data.Algo,
+		C2sKey:    data.C2sKey,
+		S2cKey:    data.S2cKey,
+		Cookies:   data.Cookie,
+		FetchedAt: fetchedAt.UnixNano(),
+
+		CSPTPKeyID: data.CSPTPKeyID,
+		CSPTPKey:   data.CSPTPKey,
+
+		NTPv5: data.NTPv5,
+	})
+	if err != nil {
+		return err
+	}
+	aead, err := miscreant.NewAEAD("AES-CMAC-SIV", s.key, storeNonceLen)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, storeNonceLen)
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.path(id), aead.Seal(nonce, nonce, b, []byte(id)))
+}
+
+// Delete removes the data stored in slot for the NTS-KE server with name
+// serverName at serverAddr.
+func (s *CookieStore) Delete(serverName, serverAddr string, slot int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := os.Remove(s.path(storeID(serverName, serverAddr, slot)))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,82 @@
+package ntske_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"example.com/scion-time/net/ntske"
+)
+
+func TestCookieStore(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "nts")
+	s, err := ntske.OpenCookieStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := ntske.Data{
+		C2sKey: bytes.Repeat([]byte{1}, 32),
+		S2cKey: bytes.Repeat([]byte{2}, 32),
+		Server: "192.0.2.1",
+		Port:   123,
+		Cookie: [][]byte{{3, 3, 3}, {4, 4, 4}},
+		Algo:   ntske.AES_SIV_CMAC_256,
+	}
+	fetchedAt := time.Now().Add(-time.Minute)
+	slot := s.Claim("ntp.example.com", "192.0.2.1:4460")
+	err = s.Save("ntp.example.com", "192.0.2.1:4460", slot, data, fetchedAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err = ntske.OpenCookieStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, ok := s.Load("ntp.example.org", "192.0.2.1:4460", slot); ok {
+		t.Error("Load() returned data stored for a different server name")
+	}
+	if got := s.Claim("ntp.example.com", "192.0.2.1:4460"); got != slot {
+		t.Errorf("Claim() = %d; want %d", got, slot)
+	}
+	got, gotFetchedAt, ok := s.Load("ntp.example.com", "192.0.2.1:4460", slot)
+	if !ok {
+		t.Fatal("Load() failed")
+	}
+	if !bytes.Equal(got.C2sKey, data.C2sKey) || !bytes.Equal(got.S2cKey, data.S2cKey) ||
+		got.Server != data.Server || got.Port != data.Port || got.Algo != data.Algo ||
+		len(got.Cookie) != len(data.Cookie) || !gotFetchedAt.Equal(fetchedAt) {
+		t.Errorf("Load() = %+v, %v; want %+v, %v", got, gotFetchedAt, data, fetchedAt)
+	}
+	// Other fetchers of the same server claim slots of their own.
+	other := s.Claim("ntp.example.com", "192.0.2.1:4460")
+	if other == slot {
+		t.Errorf("Claim() returned slot %d twice", slot)
+	}
+	if _, _, ok := s.Load("ntp.example.com", "192.0.2.1:4460", other); ok {
+		t.Error("Load() returned data stored in a different slot")
+	}
+
+	err = s.Delete("ntp.example.com", "192.0.2.1:4460", slot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err = ntske.OpenCookieStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, ok := s.Load("ntp.example.com", "192.0.2.1:4460", slot); ok {
+		t.Error("Load() returned deleted data")
+	}
+
+	err = os.Chmod(dir, 0o755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = ntske.OpenCookieStore(dir)
+	if err == nil {
+		t.Error("OpenCookieStore() accepted directory accessible by others")
+	}
+}
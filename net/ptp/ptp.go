@@ -0,0 +1,261 @@
+package ptp
+
+// See IEEE 1588-2019, PTP version 2.1, 13 (message formats), 16.1 (unicast
+// negotiation) and 9.3 (best master clock algorithm). Common message headers
+// are encoded with package csptp.
+
+import (
+	"cmp"
+	"errors"
+
+	"example.com/scion-time/net/csptp"
+)
+
+const (
+	MessageTypeDelayReq  = 1
+	MessageTypeDelayResp = 9
+	MessageTypeAnnounce  = 0xb
+	MessageTypeSignaling = 0xc
+
+	ControlDelayReq  = 1
+	ControlDelayResp = 3
+
+	TLVTypeRequestUnicastTransmission           = 0x0004
+	TLVTypeGrantUnicastTransmission             = 0x0005
+	TLVTypeCancelUnicastTransmission            = 0x0006
+	TLVTypeAcknowledgeCancelUnicastTransmission = 0x0007
+
+	// GrantFlagRenewalInvited is set in GRANT_UNICAST_TRANSMISSION TLVs if the
+	// grantor will accept requests to renew the grant.
+	GrantFlagRenewalInvited = 1 << 0
+
+	AnnounceMessageLength  = 64
+	DelayReqMessageLength  = 44
+	DelayRespMessageLength = 54
+	SignalingMessageLength = 44 // without TLVs
+
+	MaxMessageLength = 256
+)
+
+// AllPorts is the wildcard port identity, see IEEE 1588-2019, 7.5.2.4.
+var AllPorts = csptp.PortID{
+	ClockID: 0xffffffffffffffff,
+	Port:    0xffff,
+}
+
+// Announce holds the body of an Announce message following the originTimestamp.
+type Announce struct {
+	CurrentUTCOffset int16
+	DS               csptp.ServerStateDS
+}
+
+type UnicastTLV struct {
+	Type                  uint16
+	Length                uint16
+	MessageType           uint8
+	LogInterMessagePeriod int8
+	DurationField         uint32
+	Flags                 uint8
+}
+
+var (
+	errUnexpectedMessageSize    = errors.New("unexpected message size")
+	errUnexpectedTLVSize        = errors.New("unexpected TLV size")
+	errUnexpectedUnicastTLV     = errors.New("unexpected unicast TLV")
+	errUnexpectedUnicastTLVType = errors.New("unexpected unicast TLV type")
+)
+
+// EncodePortID encodes id into the first 10 bytes of b, e.g., the
+// targetPortIdentity of a Signaling message at offset 34.
+func EncodePortID(b []byte, id csptp.PortID) {
+	_ = b[9]
+	b[0] = byte(id.ClockID >> 56)
+	b[1] = byte(id.ClockID >> 48)
+	b[2] = byte(id.ClockID >> 40)
+	b[3] = byte(id.ClockID >> 32)
+	b[4] = byte(id.ClockID >> 24)
+	b[5] = byte(id.ClockID >> 16)
+	b[6] = byte(id.ClockID >> 8)
+	b[7] = byte(id.ClockID)
+	b[8] = byte(id.Port >> 8)
+	b[9] = byte(id.Port)
+}
+
+func DecodePortID(b []byte) csptp.PortID {
+	_ = b[9]
+	return csptp.PortID{
+		ClockID: uint64(b[0])<<56 | uint64(b[1])<<48 | uint64(b[2])<<40 | uint64(b[3])<<32 |
+			uint64(b[4])<<24 | uint64(b[5])<<16 | uint64(b[6])<<8 | uint64(b[7]),
+		Port: uint16(b[8])<<8 | uint16(b[9]),
+	}
+}
+
+// EncodeAnnounce encodes a into b, the 20 bytes of an Announce message
+// following its common header and originTimestamp.
+func EncodeAnnounce(b []byte, a *Announce) {
+	_ = b[19]
+	b[0] = byte(a.CurrentUTCOffset >> 8)
+	b[1] = byte(a.CurrentUTCOffset)
+	b[2] = 0
+	b[3] = byte(a.DS.GMPriority1)
+	b[4] = byte(a.DS.GMClockClass)
+	b[5] = byte(a.DS.GMClockAccuracy)
+	b[6] = byte(a.DS.GMClockVariance >> 8)
+	b[7] = byte(a.DS.GMClockVariance)
+	b[8] = byte(a.DS.GMPriority2)
+	b[9] = byte(a.DS.GMClockID >> 56)
+	b[10] = byte(a.DS.GMClockID >> 48)
+	b[11] = byte(a.DS.GMClockID >> 40)
+	b[12] = byte(a.DS.GMClockID >> 32)
+	b[13] = byte(a.DS.GMClockID >> 24)
+	b[14] = byte(a.DS.GMClockID >> 16)
+	b[15] = byte(a.DS.GMClockID >> 8)
+	b[16] = byte(a.DS.GMClockID)
+	b[17] = byte(a.DS.StepsRemoved >> 8)
+	b[18] = byte(a.DS.StepsRemoved)
+	b[19] = byte(a.DS.TimeSource)
+}
+
+func DecodeAnnounce(a *Announce, b []byte) error {
+	if len(b) < AnnounceMessageLength-csptp.MinMessageLength {
+		return errUnexpectedMessageSize
+	}
+	_ = b[19]
+	a.CurrentUTCOffset = int16(uint16(b[0])<<8 | uint16(b[1]))
+	a.DS.GMPriority1 = b[3]
+	a.DS.GMClockClass = b[4]
+	a.DS.GMClockAccuracy = b[5]
+	a.DS.GMClockVariance = uint16(b[6])<<8 | uint16(b[7])
+	a.DS.GMPriority2 = b[8]
+	a.DS.GMClockID = uint64(b[9])<<56 | uint64(b[10])<<48 | uint64(b[11])<<40 | uint64(b[12])<<32 |
+		uint64(b[13])<<24 | uint64(b[14])<<16 | uint64(b[15])<<8 | uint64(b[16])
+	a.DS.StepsRemoved = uint16(b[17])<<8 | uint16(b[18])
+	a.DS.TimeSource = b[19]
+	a.DS.Reserved = 0
+	return nil
+}
+
+// DecodeTLVHeader returns the type and the encoded length, including the
+// header, of the TLV at the beginning of b.
+func DecodeTLVHeader(b []byte) (uint16, int, error) {
+	if len(b) < 4 {
+		return 0, 0, errUnexpectedTLVSize
+	}
+	n := 4 + (int(b[2])<<8 | int(b[3]))
+	if len(b) < n {
+		return 0, 0, errUnexpectedTLVSize
+	}
+	return uint16(b[0])<<8 | uint16(b[1]), n, nil
+}
+
+func unicastTLVBodyLength(typ uint16) int {
+	switch typ {
+	case TLVTypeRequestUnicastTransmission:
+		return 6
+	case TLVTypeGrantUnicastTransmission:
+		return 8
+	case TLVTypeCancelUnicastTransmission, TLVTypeAcknowledgeCancelUnicastTransmission:
+		return 2
+	default:
+		return -1
+	}
+}
+
+// NewUnicastTLV returns a unicast negotiation TLV of type typ for messages of
+// type msgType with the length field set.
+func NewUnicastTLV(typ uint16, msgType uint8) UnicastTLV {
+	n := unicastTLVBodyLength(typ)
+	if n < 0 {
+		panic("invalid argument: typ must be a unicast negotiation TLV type")
+	}
+	return UnicastTLV{
+		Type:        typ,
+		Length:      uint16(n),
+		MessageType: msgType,
+	}
+}
+
+func EncodedUnicastTLVLength(tlv *UnicastTLV) int {
+	return 4 + unicastTLVBodyLength(tlv.Type)
+}
+
+func EncodeUnicastTLV(b []byte, tlv *UnicastTLV) {
+	_ = b[5]
+	b[0] = byte(tlv.Type >> 8)
+	b[1] = byte(tlv.Type)
+	b[2] = byte(tlv.Length >> 8)
+	b[3] = byte(tlv.Length)
+	b[4] = byte(tlv.MessageType << 4)
+	switch tlv.Type {
+	case TLVTypeRequestUnicastTransmission, TLVTypeGrantUnicastTransmission:
+		_ = b[9]
+		b[5] = byte(tlv.LogInterMessagePeriod)
+		b[6] = byte(tlv.DurationField >> 24)
+		b[7] = byte(tlv.DurationField >> 16)
+		b[8] = byte(tlv.DurationField >> 8)
+		b[9] = byte(tlv.DurationField)
+		if tlv.Type == TLVTypeGrantUnicastTransmission {
+			_ = b[11]
+			b[10] = 0
+			b[11] = byte(tlv.Flags)
+		}
+	default:
+		b[5] = 0
+	}
+}
+
+// DecodeUnicastTLV decodes the unicast negotiation TLV b, see DecodeTLVHeader.
+func DecodeUnicastTLV(tlv *UnicastTLV, b []byte) error {
+	if len(b) < 6 {
+		return errUnexpectedTLVSize
+	}
+	tlv.Type = uint16(b[0])<<8 | uint16(b[1])
+	tlv.Length = uint16(b[2])<<8 | uint16(b[3])
+	n := unicastTLVBodyLength(tlv.Type)
+	if n < 0 {
+		return errUnexpectedUnicastTLVType
+	}
+	if int(tlv.Length) != n || len(b) != 4+n {
+		return errUnexpectedUnicastTLV
+	}
+	tlv.MessageType = b[4] >> 4
+	tlv.LogInterMessagePeriod = 0
+	tlv.DurationField = 0
+	tlv.Flags = 0
+	switch tlv.Type {
+	case TLVTypeRequestUnicastTransmission, TLVTypeGrantUnicastTransmission:
+		tlv.LogInterMessagePeriod = int8(b[5])
+		tlv.DurationField = uint32(b[6])<<24 | uint32(b[7])<<16 | uint32(b[8])<<8 | uint32(b[9])
+		if tlv.Type == TLVTypeGrantUnicastTransmission {
+			tlv.Flags = b[11]
+		}
+	}
+	return nil
+}
+
+// CompareDatasets compares the grandmaster datasets a and b announced by two
+// PTP instances as in the dataset comparison algorithm of IEEE 1588-2019,
+// 9.3.4, without the topology comparison of equal grandmasters beyond steps
+// removed. The result is negative if a is better than b, positive if b is
+// better than a, and 0 if they are equivalent.
+func CompareDatasets(a, b *csptp.ServerStateDS) int {
+	if a.GMClockID == b.GMClockID {
+		return cmp.Compare(a.StepsRemoved, b.StepsRemoved)
+	}
+	if c := cmp.Compare(a.GMPriority1, b.GMPriority1); c != 0 {
+		return c
+	}
+	if c := cmp.Compare(a.GMClockClass, b.GMClockClass); c != 0 {
+		return c
+	}
+	if c := cmp.Compare(a.GMClockAccuracy, b.GMClockAccuracy); c != 0 {
+		return c
+	}
+	if c := cmp.Compare(a.GMClockVariance, b.GMClockVariance); c != 0 {
+		return c
+	}
+	if c := cmp.Compare(a.GMPriority2, b.GMPriority2); c != 0 {
+		return c
+	}
+	return cmp.Compare(a.GMClockID, b.GMClockID)
+}
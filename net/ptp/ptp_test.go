@@ -0,0 +1,136 @@
+package ptp_test
+
+import (
+	"testing"
+
+	"example.com/scion-time/net/csptp"
+	"example.com/scion-time/net/ptp"
+)
+
+func TestPortIDRoundTrip(t *testing.T) {
+	for _, id0 := range []csptp.PortID{{}, {ClockID: 0x0102030405060708, Port: 1}, ptp.AllPorts} {
+		b := make([]byte, 10)
+		ptp.EncodePortID(b, id0)
+		id1 := ptp.DecodePortID(b)
+		if id1 != id0 {
+			t.Errorf("DecodePortID() = %+v; want %+v", id1, id0)
+		}
+	}
+}
+
+func TestAnnounceRoundTrip(t *testing.T) {
+	a0 := ptp.Announce{
+		CurrentUTCOffset: 37,
+		DS: csptp.ServerStateDS{
+			GMPriority1:     128,
+			GMClockClass:    csptp.ClockClassLocked,
+			GMClockAccuracy: 0x21,
+			GMClockVariance: 0x4e5d,
+			GMPriority2:     127,
+			GMClockID:       0x0102030405060708,
+			StepsRemoved:    2,
+			TimeSource:      csptp.TimeSourceGNSS,
+		},
+	}
+	b := make([]byte, ptp.AnnounceMessageLength-csptp.MinMessageLength)
+	ptp.EncodeAnnounce(b, &a0)
+	var a1 ptp.Announce
+	err := ptp.DecodeAnnounce(&a1, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a1 != a0 {
+		t.Errorf("DecodeAnnounce() = %+v; want %+v", a1, a0)
+	}
+	err = ptp.DecodeAnnounce(&a1, b[:len(b)-1])
+	if err == nil {
+		t.Error("DecodeAnnounce() accepted truncated message")
+	}
+}
+
+func TestUnicastTLVRoundTrip(t *testing.T) {
+	tlvs := []ptp.UnicastTLV{
+		ptp.NewUnicastTLV(ptp.TLVTypeRequestUnicastTransmission, csptp.MessageTypeSync),
+		ptp.NewUnicastTLV(ptp.TLVTypeGrantUnicastTransmission, ptp.MessageTypeAnnounce),
+		ptp.NewUnicastTLV(ptp.TLVTypeCancelUnicastTransmission, ptp.MessageTypeDelayResp),
+		ptp.NewUnicastTLV(ptp.TLVTypeAcknowledgeCancelUnicastTransmission, ptp.MessageTypeDelayResp),
+	}
+	tlvs[0].LogInterMessagePeriod = -3
+	tlvs[0].DurationField = 60
+	tlvs[1].LogInterMessagePeriod = 1
+	tlvs[1].DurationField = 300
+	tlvs[1].Flags = ptp.GrantFlagRenewalInvited
+	for _, tlv0 := range tlvs {
+		b := make([]byte, ptp.EncodedUnicastTLVLength(&tlv0)+4)
+		ptp.EncodeUnicastTLV(b, &tlv0)
+		typ, n, err := ptp.DecodeTLVHeader(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if typ != tlv0.Type || n != ptp.EncodedUnicastTLVLength(&tlv0) {
+			t.Errorf("DecodeTLVHeader() = %d, %d; want %d, %d", typ, n, tlv0.Type, ptp.EncodedUnicastTLVLength(&tlv0))
+		}
+		var tlv1 ptp.UnicastTLV
+		err = ptp.DecodeUnicastTLV(&tlv1, b[:n])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tlv1 != tlv0 {
+			t.Errorf("DecodeUnicastTLV() = %+v; want %+v", tlv1, tlv0)
+		}
+		err = ptp.DecodeUnicastTLV(&tlv1, b[:n-1])
+		if err == nil {
+			t.Errorf("DecodeUnicastTLV() accepted truncated TLV %+v", tlv0)
+		}
+	}
+
+	b := []byte{0x80, 0x09, 0, 2, 0, 0}
+	var tlv ptp.UnicastTLV
+	err := ptp.DecodeUnicastTLV(&tlv, b)
+	if err == nil {
+		t.Error("DecodeUnicastTLV() accepted unexpected TLV type")
+	}
+	_, _, err = ptp.DecodeTLVHeader(b[:5])
+	if err == nil {
+		t.Error("DecodeTLVHeader() accepted truncated TLV")
+	}
+}
+
+func TestCompareDatasets(t *testing.T) {
+	ds := csptp.ServerStateDS{
+		GMPriority1:     128,
+		GMClockClass:    csptp.ClockClassDefault,
+		GMClockAccuracy: csptp.ClockAccuracyUnknown,
+		GMClockVariance: csptp.ClockVarianceUnknown,
+		GMPriority2:     128,
+		GMClockID:       2,
+	}
+	better := []func(ds *csptp.ServerStateDS){
+		func(ds *csptp.ServerStateDS) { ds.GMPriority1-- },
+		func(ds *csptp.ServerStateDS) { ds.GMClockClass = csptp.ClockClassLocked },
+		func(ds *csptp.ServerStateDS) { ds.GMClockAccuracy = 0x21 },
+		func(ds *csptp.ServerStateDS) { ds.GMClockVariance-- },
+		func(ds *csptp.ServerStateDS) { ds.GMPriority2-- },
+		func(ds *csptp.ServerStateDS) { ds.GMClockID = 1; ds.StepsRemoved = 5 },
+	}
+	for i, f := range better {
+		a := ds
+		a.GMClockID = 3
+		f(&a)
+		if c := ptp.CompareDatasets(&a, &ds); c >= 0 {
+			t.Errorf("CompareDatasets() = %d for modification %d; want < 0", c, i)
+		}
+		if c := ptp.CompareDatasets(&ds, &a); c <= 0 {
+			t.Errorf("CompareDatasets() = %d for modification %d; want > 0", c, i)
+		}
+	}
+	a := ds
+	a.GMPriority1 = 0
+	a.StepsRemoved = 1
+	if c := ptp.CompareDatasets(&ds, &a); c >= 0 {
+		t.Errorf("CompareDatasets() = %d for same grandmaster; want < 0", c)
+	}
+	if c := ptp.CompareDatasets(&ds, &ds); c != 0 {
+		t.Errorf("CompareDatasets() = %d for equal datasets; want 0", c)
+	}
+}
@@ -6,14 +6,18 @@ import (
 	"github.com/scionproto/scion/pkg/daemon"
 )
 
+func connectDaemon(ctx context.Context, daemonAddr string) (daemon.Connector, error) {
+	s := &daemon.Service{
+		Address: daemonAddr,
+	}
+	return s.Connect(ctx)
+}
+
 func NewDaemonConnector(ctx context.Context, daemonAddr string) daemon.Connector {
 	if daemonAddr == "" {
 		return nil
 	}
-	s := &daemon.Service{
-		Address: daemonAddr,
-	}
-	c, err := s.Connect(ctx)
+	c, err := connectDaemon(ctx, daemonAddr)
 	if err != nil {
 		return nil
 	}
@@ -11,13 +11,96 @@ import (
 	"github.com/scionproto/scion/pkg/snet"
 )
 
-const pathRefreshPeriod = 15 * time.Second
+const (
+	// Paths are refreshed pathRefreshMargin before the earliest path of a
+	// destination expires, but at least every pathRefreshMaxPeriod and at most
+	// every pathRefreshMinPeriod.
+	pathRefreshMargin    = 5 * time.Minute
+	pathRefreshMinPeriod = 15 * time.Second
+	pathRefreshMaxPeriod = 5 * time.Minute
 
+	// Failed daemon requests are retried with exponential backoff.
+	pathRetryMinPeriod = 1 * time.Second
+	pathRetryMaxPeriod = 1 * time.Minute
+
+	pathRequestTimeout = 5 * time.Second
+)
+
+type patherDst struct {
+	paths     []snet.Path
+	refreshAt time.Time
+	failures  int
+}
+
+// Pather maintains the set of paths to a dynamic set of destination ISD-ASes.
+// Path lookups are scheduled based on path expiration times and retried with
+// backoff on daemon errors. Unexpired paths from earlier lookups continue to be
+// served while the daemon is unavailable.
 type Pather struct {
-	log     *slog.Logger
-	mu      sync.Mutex
-	localIA addr.IA
-	paths   map[addr.IA][]snet.Path
+	log *slog.Logger
+	// dc is only accessed by the goroutine refreshing paths. If it is nil,
+	// connect, if not nil, is retried with backoff to connect to the daemon.
+	dc       daemon.Connector
+	connect  func(ctx context.Context) (daemon.Connector, error)
+	wake     chan struct{}
+	mu       sync.Mutex
+	localIA  addr.IA
+	dsts     map[addr.IA]*patherDst
+	retryAt  time.Time
+	failures int
+}
+
+func newPather(log *slog.Logger, dc daemon.Connector) *Pather {
+	return &Pather{
+		log:  log,
+		dc:   dc,
+		wake: make(chan struct{}, 1),
+		dsts: make(map[addr.IA]*patherDst),
+	}
+}
+
+func retryPeriod(failures int) time.Duration {
+	d := pathRetryMinPeriod
+	for i := 1; i < failures && d < pathRetryMaxPeriod; i++ {
+		d *= 2
+	}
+	return min(d, pathRetryMaxPeriod)
+}
+
+func unexpiredPaths(paths []snet.Path, now time.Time) []snet.Path {
+	ps := make([]snet.Path, 0, len(paths))
+	for _, p := range paths {
+		md := p.Metadata()
+		if md == nil || md.Expiry.IsZero() || md.Expiry.After(now) {
+			ps = append(ps, p)
+		}
+	}
+	return ps
+}
+
+func earliestExpiry(paths []snet.Path) time.Time {
+	var t time.Time
+	for _, p := range paths {
+		md := p.Metadata()
+		if md == nil || md.Expiry.IsZero() {
+			continue
+		}
+		if t.IsZero() || md.Expiry.Before(t) {
+			t = md.Expiry
+		}
+	}
+	return t
+}
+
+func nextRefresh(paths []snet.Path, now time.Time) time.Time {
+	t := now.Add(pathRefreshMaxPeriod)
+	if exp := earliestExpiry(paths); !exp.IsZero() && exp.Add(-pathRefreshMargin).Before(t) {
+		t = exp.Add(-pathRefreshMargin)
+	}
+	if t.Before(now.Add(pathRefreshMinPeriod)) {
+		t = now.Add(pathRefreshMinPeriod)
+	}
+	return t
 }
 
 func (p *Pather) LocalIA() addr.IA {
@@ -27,51 +110,205 @@ func (p *Pather) LocalIA() addr.IA {
 }
 
 func (p *Pather) Paths(dst addr.IA) []snet.Path {
+	return p.paths(dst, time.Now())
+}
+
+func (p *Pather) paths(dst addr.IA, now time.Time) []snet.Path {
 	p.mu.Lock()
 	defer p.mu.Unlock()
-	paths, ok := p.paths[dst]
+	d, ok := p.dsts[dst]
 	if !ok {
 		return nil
 	}
-	return append(make([]snet.Path, 0, len(paths)), paths...)
+	return unexpiredPaths(d.paths, now)
+}
+
+// AddDestination adds dstIA to the set of destinations paths are maintained for
+// and schedules an immediate path lookup.
+func (p *Pather) AddDestination(dstIA addr.IA) {
+	if dstIA.IsWildcard() {
+		panic("unexpected destination IA: wildcard.")
+	}
+	p.mu.Lock()
+	_, ok := p.dsts[dstIA]
+	if !ok {
+		p.dsts[dstIA] = &patherDst{}
+	}
+	p.mu.Unlock()
+	if !ok {
+		p.notify()
+	}
 }
 
-func update(ctx context.Context, p *Pather, dc daemon.Connector, dstIAs []addr.IA) {
-	localIA, err := dc.LocalIA(ctx)
+// RemoveDestination removes dstIA from the set of destinations paths are
+// maintained for.
+func (p *Pather) RemoveDestination(dstIA addr.IA) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.dsts, dstIA)
+}
+
+func (p *Pather) notify() {
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (p *Pather) lookUpLocalIA(ctx context.Context, now time.Time) (addr.IA, bool) {
+	p.mu.Lock()
+	localIA, retryAt := p.localIA, p.retryAt
+	p.mu.Unlock()
+	if !localIA.IsZero() {
+		return localIA, true
+	}
+	if now.Before(retryAt) {
+		return 0, false
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, pathRequestTimeout)
+	defer cancel()
+	if p.dc == nil {
+		dc, err := p.connect(ctx)
+		if err != nil {
+			p.mu.Lock()
+			defer p.mu.Unlock()
+			p.failures++
+			p.retryAt = now.Add(retryPeriod(p.failures))
+			p.log.LogAttrs(ctx, slog.LevelInfo,
+				"failed to connect to daemon", slog.Any("error", err))
+			return 0, false
+		}
+		p.dc = dc
+	}
+	localIA, err := p.dc.LocalIA(ctx)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
 	if err != nil {
+		p.failures++
+		p.retryAt = now.Add(retryPeriod(p.failures))
 		p.log.LogAttrs(ctx, slog.LevelInfo,
 			"failed to look up local IA", slog.Any("error", err))
-		return
+		return 0, false
 	}
+	p.localIA = localIA
+	p.failures = 0
+	return localIA, true
+}
 
-	paths := map[addr.IA][]snet.Path{}
-	for _, dstIA := range dstIAs {
-		if dstIA.IsWildcard() {
-			panic("unexpected destination IA: wildcard.")
+// refresh looks up paths for all destinations due for a refresh at time now
+// and returns the time of the next scheduled refresh.
+func (p *Pather) refresh(ctx context.Context, now time.Time) time.Time {
+	localIA, ok := p.lookUpLocalIA(ctx, now)
+	if !ok {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		return p.retryAt
+	}
+
+	type dueDst struct {
+		ia    addr.IA
+		force bool
+	}
+	var due []dueDst
+	p.mu.Lock()
+	for ia, d := range p.dsts {
+		if !now.Before(d.refreshAt) {
+			ps := unexpiredPaths(d.paths, now)
+			exp := earliestExpiry(ps)
+			force := len(ps) == 0 || !exp.IsZero() && !now.Before(exp.Add(-pathRefreshMargin))
+			due = append(due, dueDst{ia: ia, force: force})
 		}
-		ps, err := dc.Paths(ctx, dstIA, localIA, daemon.PathReqFlags{Refresh: true})
+	}
+	p.mu.Unlock()
+
+	for _, dst := range due {
+		reqCtx, cancel := context.WithTimeout(ctx, pathRequestTimeout)
+		ps, err := p.dc.Paths(reqCtx, dst.ia, localIA, daemon.PathReqFlags{Refresh: dst.force})
+		cancel()
 		if err != nil {
 			p.log.LogAttrs(ctx, slog.LevelInfo,
-				"failed to look up paths", slog.Any("to", dstIA), slog.Any("error", err))
+				"failed to look up paths", slog.Any("to", dst.ia), slog.Any("error", err))
+		} else if len(ps) == 0 {
+			p.log.LogAttrs(ctx, slog.LevelInfo,
+				"no paths available", slog.Any("to", dst.ia))
+		}
+
+		p.mu.Lock()
+		d, ok := p.dsts[dst.ia]
+		if ok {
+			if err != nil || len(ps) == 0 {
+				d.paths = unexpiredPaths(d.paths, now)
+				d.failures++
+				d.refreshAt = now.Add(retryPeriod(d.failures))
+			} else {
+				d.paths = ps
+				d.failures = 0
+				d.refreshAt = nextRefresh(ps, now)
+			}
 		}
-		paths[dstIA] = append(paths[dstIA], ps...)
+		p.mu.Unlock()
 	}
 
 	p.mu.Lock()
-	p.localIA = localIA
-	p.paths = paths
-	p.mu.Unlock()
+	defer p.mu.Unlock()
+	next := now.Add(pathRefreshMaxPeriod)
+	for _, d := range p.dsts {
+		if d.refreshAt.Before(next) {
+			next = d.refreshAt
+		}
+	}
+	return next
 }
 
-func StartPather(ctx context.Context, log *slog.Logger, daemonAddr string, dstIAs []addr.IA) *Pather {
-	p := &Pather{log: log}
-	dc := NewDaemonConnector(ctx, daemonAddr)
-	update(ctx, p, dc, dstIAs)
-	go func(ctx context.Context, p *Pather, dc daemon.Connector, dstIAs []addr.IA) {
-		ticker := time.NewTicker(pathRefreshPeriod)
-		for range ticker.C {
-			update(ctx, p, dc, dstIAs)
+func (p *Pather) run(ctx context.Context, next time.Time) {
+	timer := time.NewTimer(time.Until(next))
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		case <-p.wake:
 		}
-	}(ctx, p, dc, dstIAs)
+		now := time.Now()
+		timer.Reset(p.refresh(ctx, now).Sub(now))
+	}
+}
+
+func startPather(ctx context.Context, p *Pather, dstIAs []addr.IA) *Pather {
+	for _, dstIA := range dstIAs {
+		if dstIA.IsWildcard() {
+			panic("unexpected destination IA: wildcard.")
+		}
+		p.dsts[dstIA] = &patherDst{}
+	}
+	if p.dc == nil && p.connect == nil {
+		p.log.LogAttrs(ctx, slog.LevelError, "failed to connect to daemon")
+		return p
+	}
+	// initial lookup before returning, subsequent lookups in the background
+	next := p.refresh(ctx, time.Now())
+	go p.run(ctx, next)
 	return p
 }
+
+// StartPatherWithConnector starts a Pather using the daemon connector dc. The
+// Pather stops refreshing paths once ctx is done.
+func StartPatherWithConnector(ctx context.Context, log *slog.Logger,
+	dc daemon.Connector, dstIAs []addr.IA) *Pather {
+	return startPather(ctx, newPather(log, dc), dstIAs)
+}
+
+// StartPather starts a Pather using the daemon at daemonAddr. If the daemon is
+// not reachable, connecting to it is retried with backoff.
+func StartPather(ctx context.Context, log *slog.Logger, daemonAddr string, dstIAs []addr.IA) *Pather {
+	p := newPather(log, nil /* dc */)
+	if daemonAddr != "" {
+		p.connect = func(ctx context.Context) (daemon.Connector, error) {
+			return connectDaemon(ctx, daemonAddr)
+		}
+	}
+	return startPather(ctx, p, dstIAs)
+}
@@ -0,0 +1,254 @@
+package scion
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/scionproto/scion/pkg/addr"
+	"github.com/scionproto/scion/pkg/daemon"
+	"github.com/scionproto/scion/pkg/snet"
+	"github.com/scionproto/scion/pkg/snet/path"
+)
+
+var errDaemonUnavailable = errors.New("daemon unavailable")
+
+type patherTestConnector struct {
+	daemon.Connector
+	mu      sync.Mutex
+	localIA addr.IA
+	paths   map[addr.IA][]snet.Path
+	err     error
+	reqs    []daemon.PathReqFlags
+}
+
+func (c *patherTestConnector) LocalIA(ctx context.Context) (addr.IA, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.localIA, nil
+}
+
+func (c *patherTestConnector) Paths(ctx context.Context, dst, src addr.IA,
+	f daemon.PathReqFlags) ([]snet.Path, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.reqs = append(c.reqs, f)
+	if c.err != nil {
+		return nil, c.err
+	}
+	return c.paths[dst], nil
+}
+
+func newPatherTestPath(src, dst addr.IA, expiry time.Time) snet.Path {
+	return path.Path{
+		Src:           src,
+		Dst:           dst,
+		DataplanePath: path.Empty{},
+		Meta:          snet.PathMetadata{Expiry: expiry},
+	}
+}
+
+func TestPatherRefresh(t *testing.T) {
+	localIA := addr.MustParseIA("1-ff00:0:110")
+	dstIA := addr.MustParseIA("1-ff00:0:111")
+	now := time.Now()
+
+	dc := &patherTestConnector{
+		localIA: localIA,
+		paths: map[addr.IA][]snet.Path{
+			dstIA: {
+				newPatherTestPath(localIA, dstIA, now.Add(1*time.Hour)),
+				newPatherTestPath(localIA, dstIA, now.Add(2*time.Hour)),
+			},
+		},
+	}
+	p := newPather(slog.New(slog.DiscardHandler), dc)
+	p.AddDestination(dstIA)
+
+	next := p.refresh(context.Background(), now)
+	if p.LocalIA() != localIA {
+		t.Errorf("LocalIA() = %v; want %v", p.LocalIA(), localIA)
+	}
+	if n := len(p.paths(dstIA, now)); n != 2 {
+		t.Errorf("len(paths) = %d; want 2", n)
+	}
+	if !next.Equal(now.Add(pathRefreshMaxPeriod)) {
+		t.Errorf("next refresh = %v; want %v", next.Sub(now), pathRefreshMaxPeriod)
+	}
+	if len(dc.reqs) != 1 || !dc.reqs[0].Refresh {
+		t.Errorf("initial path request = %+v; want forced refresh", dc.reqs)
+	}
+
+	// not yet due
+	_ = p.refresh(context.Background(), now.Add(pathRefreshMinPeriod))
+	if len(dc.reqs) != 1 {
+		t.Errorf("number of path requests = %d; want 1", len(dc.reqs))
+	}
+
+	// due, but paths are far from expiring
+	_ = p.refresh(context.Background(), next)
+	if len(dc.reqs) != 2 || dc.reqs[1].Refresh {
+		t.Errorf("periodic path request = %+v; want cached lookup", dc.reqs)
+	}
+}
+
+func TestPatherExpiry(t *testing.T) {
+	localIA := addr.MustParseIA("1-ff00:0:110")
+	dstIA := addr.MustParseIA("1-ff00:0:111")
+	now := time.Now()
+
+	dc := &patherTestConnector{
+		localIA: localIA,
+		paths: map[addr.IA][]snet.Path{
+			dstIA: {
+				newPatherTestPath(localIA, dstIA, now.Add(10*time.Minute)),
+				newPatherTestPath(localIA, dstIA, now.Add(2*time.Hour)),
+			},
+		},
+	}
+	p := newPather(slog.New(slog.DiscardHandler), dc)
+	p.AddDestination(dstIA)
+
+	next := p.refresh(context.Background(), now)
+	want := now.Add(10*time.Minute - pathRefreshMargin)
+	if !next.Equal(want) {
+		t.Errorf("next refresh = %v; want %v", next.Sub(now), want.Sub(now))
+	}
+
+	later := now.Add(15 * time.Minute)
+	if n := len(p.paths(dstIA, later)); n != 1 {
+		t.Errorf("len(paths) after expiry = %d; want 1", n)
+	}
+}
+
+func TestPatherDaemonOutage(t *testing.T) {
+	localIA := addr.MustParseIA("1-ff00:0:110")
+	dstIA := addr.MustParseIA("1-ff00:0:111")
+	now := time.Now()
+
+	dc := &patherTestConnector{
+		localIA: localIA,
+		paths: map[addr.IA][]snet.Path{
+			dstIA: {newPatherTestPath(localIA, dstIA, now.Add(1*time.Hour))},
+		},
+	}
+	p := newPather(slog.New(slog.DiscardHandler), dc)
+	p.AddDestination(dstIA)
+	next := p.refresh(context.Background(), now)
+
+	dc.err = errDaemonUnavailable
+	for i := range 3 {
+		t0 := next
+		next = p.refresh(context.Background(), t0)
+		if d := next.Sub(t0); d != retryPeriod(i+1) {
+			t.Errorf("retry period after %d failures = %v; want %v", i+1, d, retryPeriod(i+1))
+		}
+		if n := len(p.paths(dstIA, t0)); n != 1 {
+			t.Errorf("len(paths) during outage = %d; want 1", n)
+		}
+	}
+
+	dc.err = nil
+	t0 := next
+	next = p.refresh(context.Background(), t0)
+	if d := next.Sub(t0); d < pathRefreshMinPeriod {
+		t.Errorf("refresh period after recovery = %v; want at least %v", d, pathRefreshMinPeriod)
+	}
+}
+
+func TestPatherDestinations(t *testing.T) {
+	localIA := addr.MustParseIA("1-ff00:0:110")
+	dstIA0 := addr.MustParseIA("1-ff00:0:111")
+	dstIA1 := addr.MustParseIA("1-ff00:0:112")
+	now := time.Now()
+
+	dc := &patherTestConnector{
+		localIA: localIA,
+		paths: map[addr.IA][]snet.Path{
+			dstIA0: {newPatherTestPath(localIA, dstIA0, now.Add(1*time.Hour))},
+			dstIA1: {newPatherTestPath(localIA, dstIA1, now.Add(1*time.Hour))},
+		},
+	}
+	p := newPather(slog.New(slog.DiscardHandler), dc)
+	p.AddDestination(dstIA0)
+	_ = p.refresh(context.Background(), now)
+	if p.Paths(dstIA1) != nil {
+		t.Errorf("Paths(%v) != nil before adding destination", dstIA1)
+	}
+
+	p.AddDestination(dstIA1)
+	_ = p.refresh(context.Background(), now.Add(time.Second))
+	if n := len(p.paths(dstIA1, now)); n != 1 {
+		t.Errorf("len(paths) of added destination = %d; want 1", n)
+	}
+	if len(dc.reqs) != 2 {
+		t.Errorf("number of path requests = %d; want 2", len(dc.reqs))
+	}
+
+	p.RemoveDestination(dstIA0)
+	if p.Paths(dstIA0) != nil {
+		t.Errorf("Paths(%v) != nil after removing destination", dstIA0)
+	}
+}
+
+func TestPatherReconnect(t *testing.T) {
+	localIA := addr.MustParseIA("1-ff00:0:110")
+	dstIA := addr.MustParseIA("1-ff00:0:111")
+	now := time.Now()
+
+	dc := &patherTestConnector{
+		localIA: localIA,
+		paths: map[addr.IA][]snet.Path{
+			dstIA: {newPatherTestPath(localIA, dstIA, now.Add(1*time.Hour))},
+		},
+	}
+	connectErr := errDaemonUnavailable
+	connects := 0
+	p := newPather(slog.New(slog.DiscardHandler), nil /* dc */)
+	p.connect = func(ctx context.Context) (daemon.Connector, error) {
+		connects++
+		if connectErr != nil {
+			return nil, connectErr
+		}
+		return dc, nil
+	}
+	p.AddDestination(dstIA)
+
+	next := now
+	for i := range 3 {
+		t0 := next
+		next = p.refresh(context.Background(), t0)
+		if d := next.Sub(t0); d != retryPeriod(i+1) {
+			t.Errorf("retry period after %d failures = %v; want %v", i+1, d, retryPeriod(i+1))
+		}
+	}
+	if connects != 3 {
+		t.Errorf("number of connection attempts = %d; want 3", connects)
+	}
+
+	// not yet due
+	_ = p.refresh(context.Background(), next.Add(-time.Millisecond))
+	if connects != 3 {
+		t.Errorf("number of connection attempts = %d; want 3", connects)
+	}
+
+	connectErr = nil
+	_ = p.refresh(context.Background(), next)
+	if p.LocalIA() != localIA {
+		t.Errorf("LocalIA() = %v; want %v", p.LocalIA(), localIA)
+	}
+	if n := len(p.paths(dstIA, next)); n != 1 {
+		t.Errorf("len(paths) after reconnecting = %d; want 1", n)
+	}
+
+	_ = p.refresh(context.Background(), next.Add(pathRefreshMaxPeriod))
+	if connects != 4 {
+		t.Errorf("number of connection attempts = %d; want 4", connects)
+	}
+}
@@ -211,6 +211,17 @@ func (ap udpAddrPath) String() string {
 	return ap.addr.String()
 }
 
+// UDPAddrOf returns the SCION address a of a QUIC connection endpoint.
+func UDPAddrOf(a net.Addr) (udp.UDPAddr, bool) {
+	switch a := a.(type) {
+	case udp.UDPAddr:
+		return a, true
+	case udpAddrPath:
+		return a.addr, true
+	}
+	return udp.UDPAddr{}, false
+}
+
 type serverConn struct {
 	baseConn
 }
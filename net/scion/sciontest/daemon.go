@@ -0,0 +1,319 @@
+// Package sciontest provides an in-memory SCION daemon connector and a
+// loopback border router for running SCION endpoints in tests.
+package sciontest
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/scionproto/scion/pkg/addr"
+	"github.com/scionproto/scion/pkg/daemon"
+	"github.com/scionproto/scion/pkg/drkey"
+	"github.com/scionproto/scion/pkg/drkey/generic"
+	"github.com/scionproto/scion/pkg/drkey/specific"
+	"github.com/scionproto/scion/pkg/private/ctrl/path_mgmt"
+	"github.com/scionproto/scion/pkg/scrypto/cppki"
+	"github.com/scionproto/scion/pkg/segment/iface"
+	"github.com/scionproto/scion/pkg/slayers/path"
+	"github.com/scionproto/scion/pkg/slayers/path/scion"
+	"github.com/scionproto/scion/pkg/snet"
+
+	snetpath "github.com/scionproto/scion/pkg/snet/path"
+)
+
+const (
+	defaultNumPaths     = 2
+	defaultPathLifetime = 6 * time.Hour
+	defaultMTU          = 1472
+
+	drkeyEpochDuration = 24 * time.Hour
+)
+
+var (
+	errUnsupported = errors.New("operation not supported by test daemon")
+
+	defaultSecret = []byte("scion-time test topology secret")
+)
+
+var _ daemon.Connector = (*DaemonConnector)(nil)
+
+// DaemonConnector is an in-memory daemon.Connector for the AS IA. It serves
+// synthetic paths to any other AS via the border router at NextHop and DRKey
+// material derived from Secret. Connectors sharing the same Secret derive
+// matching keys, i.e., they form a consistent test topology.
+type DaemonConnector struct {
+	IA           addr.IA
+	NextHop      *net.UDPAddr
+	NumPaths     int
+	PathLifetime time.Duration
+	Secret       []byte
+
+	mu  sync.Mutex
+	err error
+}
+
+// NewDaemonConnector returns a connector for the AS ia whose paths to other
+// ASes lead via the border router at nextHop.
+func NewDaemonConnector(ia addr.IA, nextHop *net.UDPAddr) *DaemonConnector {
+	return &DaemonConnector{
+		IA:      ia,
+		NextHop: nextHop,
+	}
+}
+
+// SetError makes all subsequent requests fail with err until it is reset to
+// nil. This can be used to simulate daemon outages.
+func (c *DaemonConnector) SetError(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.err = err
+}
+
+func (c *DaemonConnector) check(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+func (c *DaemonConnector) secret() []byte {
+	if len(c.Secret) != 0 {
+		return c.Secret
+	}
+	return defaultSecret
+}
+
+func (c *DaemonConnector) LocalIA(ctx context.Context) (addr.IA, error) {
+	if err := c.check(ctx); err != nil {
+		return 0, err
+	}
+	return c.IA, nil
+}
+
+func (c *DaemonConnector) PortRange(ctx context.Context) (uint16, uint16, error) {
+	if err := c.check(ctx); err != nil {
+		return 0, 0, err
+	}
+	return 1024, 65535, nil
+}
+
+func (c *DaemonConnector) Interfaces(ctx context.Context) (map[uint16]netip.AddrPort, error) {
+	if err := c.check(ctx); err != nil {
+		return nil, err
+	}
+	ifs := make(map[uint16]netip.AddrPort)
+	if c.NextHop != nil {
+		for i := range c.numPaths() {
+			ifs[uint16(i+1)] = c.NextHop.AddrPort()
+		}
+	}
+	return ifs, nil
+}
+
+func (c *DaemonConnector) numPaths() int {
+	if c.NumPaths > 0 {
+		return c.NumPaths
+	}
+	return defaultNumPaths
+}
+
+func (c *DaemonConnector) Paths(ctx context.Context, dst, src addr.IA,
+	f daemon.PathReqFlags) ([]snet.Path, error) {
+	if err := c.check(ctx); err != nil {
+		return nil, err
+	}
+	if src.IsZero() {
+		src = c.IA
+	}
+	if src != c.IA {
+		return nil, nil
+	}
+	if dst == src {
+		return []snet.Path{snetpath.Path{
+			Src:           src,
+			Dst:           dst,
+			DataplanePath: snetpath.Empty{},
+			Meta: snet.PathMetadata{
+				MTU: defaultMTU,
+			},
+		}}, nil
+	}
+	lifetime := c.PathLifetime
+	if lifetime == 0 {
+		lifetime = defaultPathLifetime
+	}
+	now := time.Now()
+	n := c.numPaths()
+	ps := make([]snet.Path, n)
+	for i := range n {
+		ifID := uint16(i + 1)
+		dp, err := newDataplanePath(now, ifID)
+		if err != nil {
+			return nil, err
+		}
+		ps[i] = snetpath.Path{
+			Src:           src,
+			Dst:           dst,
+			DataplanePath: dp,
+			NextHop:       c.NextHop,
+			Meta: snet.PathMetadata{
+				Interfaces: []snet.PathInterface{
+					{IA: src, ID: iface.ID(ifID)},
+					{IA: dst, ID: iface.ID(ifID)},
+				},
+				MTU:    defaultMTU,
+				Expiry: now.Add(lifetime),
+			},
+		}
+	}
+	return ps, nil
+}
+
+// newDataplanePath returns a single-segment path over the interface pair
+// (ifID, ifID). Hop fields are not authenticated; the loopback router does not
+// verify them.
+func newDataplanePath(now time.Time, ifID uint16) (snetpath.SCION, error) {
+	d := scion.Decoded{
+		Base: scion.Base{
+			PathMeta: scion.MetaHdr{
+				SegLen: [3]uint8{2, 0, 0},
+			},
+			NumINF:  1,
+			NumHops: 2,
+		},
+		InfoFields: []path.InfoField{{
+			ConsDir:   true,
+			SegID:     ifID,
+			Timestamp: uint32(now.Unix()),
+		}},
+		HopFields: []path.HopField{
+			{ExpTime: 63, ConsEgress: ifID},
+			{ExpTime: 63, ConsIngress: ifID},
+		},
+	}
+	return snetpath.NewSCIONFromDecoded(d)
+}
+
+func (c *DaemonConnector) ASInfo(ctx context.Context, ia addr.IA) (daemon.ASInfo, error) {
+	if err := c.check(ctx); err != nil {
+		return daemon.ASInfo{}, err
+	}
+	if ia.IsZero() {
+		ia = c.IA
+	}
+	return daemon.ASInfo{IA: ia, MTU: defaultMTU}, nil
+}
+
+func (c *DaemonConnector) SVCInfo(ctx context.Context, svcTypes []addr.SVC) (
+	map[addr.SVC][]string, error) {
+	return nil, errUnsupported
+}
+
+func (c *DaemonConnector) RevNotification(ctx context.Context, revInfo *path_mgmt.RevInfo) error {
+	return errUnsupported
+}
+
+func drkeyEpoch(t time.Time) drkey.Epoch {
+	notBefore := t.Truncate(drkeyEpochDuration)
+	return drkey.Epoch{
+		Validity: cppki.Validity{
+			NotBefore: notBefore,
+			NotAfter:  notBefore.Add(drkeyEpochDuration),
+		},
+	}
+}
+
+func (c *DaemonConnector) level1Key(proto drkey.Protocol, epoch drkey.Epoch,
+	srcIA, dstIA addr.IA) (drkey.Key, error) {
+	sv, err := drkey.DeriveSV(proto, epoch, append(slices.Clip(c.secret()), srcIA.String()...))
+	if err != nil {
+		return drkey.Key{}, err
+	}
+	return specific.Deriver{}.DeriveLevel1(dstIA, sv.Key)
+}
+
+func (c *DaemonConnector) DRKeyGetASHostKey(ctx context.Context, meta drkey.ASHostMeta) (
+	drkey.ASHostKey, error) {
+	if err := c.check(ctx); err != nil {
+		return drkey.ASHostKey{}, err
+	}
+	epoch := drkeyEpoch(meta.Validity)
+	k, err := c.level1Key(meta.ProtoId, epoch, meta.SrcIA, meta.DstIA)
+	if err != nil {
+		return drkey.ASHostKey{}, err
+	}
+	k, err = generic.Deriver{Proto: meta.ProtoId}.DeriveASHost(meta.DstHost, k)
+	if err != nil {
+		return drkey.ASHostKey{}, err
+	}
+	return drkey.ASHostKey{
+		ProtoId: meta.ProtoId,
+		Epoch:   epoch,
+		SrcIA:   meta.SrcIA,
+		DstIA:   meta.DstIA,
+		DstHost: meta.DstHost,
+		Key:     k,
+	}, nil
+}
+
+func (c *DaemonConnector) DRKeyGetHostASKey(ctx context.Context, meta drkey.HostASMeta) (
+	drkey.HostASKey, error) {
+	if err := c.check(ctx); err != nil {
+		return drkey.HostASKey{}, err
+	}
+	epoch := drkeyEpoch(meta.Validity)
+	k, err := c.level1Key(meta.ProtoId, epoch, meta.SrcIA, meta.DstIA)
+	if err != nil {
+		return drkey.HostASKey{}, err
+	}
+	k, err = generic.Deriver{Proto: meta.ProtoId}.DeriveHostAS(meta.SrcHost, k)
+	if err != nil {
+		return drkey.HostASKey{}, err
+	}
+	return drkey.HostASKey{
+		ProtoId: meta.ProtoId,
+		Epoch:   epoch,
+		SrcIA:   meta.SrcIA,
+		DstIA:   meta.DstIA,
+		SrcHost: meta.SrcHost,
+		Key:     k,
+	}, nil
+}
+
+func (c *DaemonConnector) DRKeyGetHostHostKey(ctx context.Context, meta drkey.HostHostMeta) (
+	drkey.HostHostKey, error) {
+	hostASKey, err := c.DRKeyGetHostASKey(ctx, drkey.HostASMeta{
+		ProtoId:  meta.ProtoId,
+		Validity: meta.Validity,
+		SrcIA:    meta.SrcIA,
+		DstIA:    meta.DstIA,
+		SrcHost:  meta.SrcHost,
+	})
+	if err != nil {
+		return drkey.HostHostKey{}, err
+	}
+	k, err := generic.Deriver{Proto: meta.ProtoId}.DeriveHostHost(meta.DstHost, hostASKey.Key)
+	if err != nil {
+		return drkey.HostHostKey{}, err
+	}
+	return drkey.HostHostKey{
+		ProtoId: meta.ProtoId,
+		Epoch:   hostASKey.Epoch,
+		SrcIA:   meta.SrcIA,
+		DstIA:   meta.DstIA,
+		SrcHost: meta.SrcHost,
+		DstHost: meta.DstHost,
+		Key:     k,
+	}, nil
+}
+
+func (c *DaemonConnector) Close() error {
+	return nil
+}
@@ -0,0 +1,98 @@
+package sciontest
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"net/netip"
+
+	"github.com/google/gopacket"
+
+	"github.com/scionproto/scion/pkg/slayers"
+
+	scionnet "example.com/scion-time/net/scion"
+)
+
+// Router is a loopback border router. It forwards SCION packets unchanged to
+// the destination host address, UDP datagrams to their L4 destination port and
+// all other packets to the end host port. Hop fields are neither verified nor
+// updated.
+type Router struct {
+	log  *slog.Logger
+	conn *net.UDPConn
+}
+
+// StartRouter starts a router listening on localHost. The router stops
+// forwarding packets once ctx is done or Close is called.
+func StartRouter(ctx context.Context, log *slog.Logger, localHost *net.UDPAddr) (*Router, error) {
+	conn, err := net.ListenUDP("udp", localHost)
+	if err != nil {
+		return nil, err
+	}
+	r := &Router{log: log, conn: conn}
+	go func() {
+		<-ctx.Done()
+		_ = r.Close()
+	}()
+	go r.run(ctx)
+	return r, nil
+}
+
+// Addr returns the underlay address of the router.
+func (r *Router) Addr() *net.UDPAddr {
+	return r.conn.LocalAddr().(*net.UDPAddr)
+}
+
+func (r *Router) Close() error {
+	return r.conn.Close()
+}
+
+func (r *Router) run(ctx context.Context) {
+	var (
+		scionLayer slayers.SCION
+		hbhLayer   slayers.HopByHopExtnSkipper
+		e2eLayer   slayers.EndToEndExtnSkipper
+		udpLayer   slayers.UDP
+	)
+	scionLayer.RecyclePaths()
+	parser := gopacket.NewDecodingLayerParser(
+		slayers.LayerTypeSCION, &scionLayer, &hbhLayer, &e2eLayer, &udpLayer,
+	)
+	parser.IgnoreUnsupported = true
+	decoded := make([]gopacket.LayerType, 4)
+
+	buf := make([]byte, scionnet.MTU)
+	for {
+		n, err := r.conn.Read(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			r.log.LogAttrs(ctx, slog.LevelInfo, "failed to read packet", slog.Any("error", err))
+			continue
+		}
+		pkt := buf[:n]
+
+		err = parser.DecodeLayers(pkt, &decoded)
+		if err != nil {
+			r.log.LogAttrs(ctx, slog.LevelInfo, "failed to decode packet", slog.Any("error", err))
+			continue
+		}
+		dstAddr, ok := netip.AddrFromSlice(scionLayer.RawDstAddr)
+		if !ok {
+			r.log.LogAttrs(ctx, slog.LevelInfo, "failed to decode packet",
+				slog.String("cause", "unexpected destination address type"))
+			continue
+		}
+		dstPort := uint16(scionnet.EndhostPort)
+		if len(decoded) >= 2 && decoded[len(decoded)-1] == slayers.LayerTypeSCIONUDP {
+			dstPort = udpLayer.DstPort
+		}
+
+		_, err = r.conn.WriteToUDPAddrPort(pkt, netip.AddrPortFrom(dstAddr.Unmap(), dstPort))
+		if err != nil {
+			r.log.LogAttrs(ctx, slog.LevelInfo, "failed to write packet", slog.Any("error", err))
+		}
+	}
+}
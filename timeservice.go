@@ -6,12 +6,16 @@ import (
 	"bytes"
 	"context"
 	"crypto/tls"
+	"crypto/x509"
 	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"log/slog"
 	"net"
 	"net/http"
+	"net/netip"
 	"os"
 	"path/filepath"
 	"runtime"
@@ -30,6 +34,7 @@ import (
 	"github.com/scionproto/scion/pkg/snet"
 	"github.com/scionproto/scion/pkg/snet/path"
 
+	"example.com/scion-time/base/leapsecond"
 	"example.com/scion-time/base/logbase"
 	"example.com/scion-time/base/timemath"
 
@@ -46,6 +51,7 @@ import (
 	"example.com/scion-time/driver/phc"
 	"example.com/scion-time/driver/shm"
 
+	"example.com/scion-time/net/csptp"
 	"example.com/scion-time/net/ntp"
 	"example.com/scion-time/net/ntske"
 	"example.com/scion-time/net/scion"
@@ -57,38 +63,127 @@ const (
 	dispatcherModeInternal = "internal"
 	authModeNTS            = "nts"
 	authModeSPAO           = "spao"
+	authModeSymmetric      = "symmetric"
+	protocolCSPTP          = "csptp"
+	protocolNTP            = "ntp"
+	protocolNTPv5          = "ntpv5"
+	protocolPTP            = "ptp"
 	clockAlgoNtimed        = "ntimed"
 	clockAlgoPI            = "pi"
 
-	tlsCertReloadInterval = time.Minute * 10
-
 	scionRefClockNumClient = 7
+
+	defaultNTPBroadcastInterval = 64 * time.Second
 )
 
 type svcConfig struct {
-	LocalAddr               string   `toml:"local_address,omitempty"`
-	LocalMetricsAddr        string   `toml:"local_metrics_address,omitempty"`
-	SCIONDaemonAddr         string   `toml:"scion_daemon_address,omitempty"`
-	SCIONConfigDir          string   `toml:"scion_config_dir,omitempty"`
-	SCIONDataDir            string   `toml:"scion_data_dir,omitempty"`
-	RemoteAddr              string   `toml:"remote_address,omitempty"`
-	MBGReferenceClocks      []string `toml:"mbg_reference_clocks,omitempty"`
-	PHCReferenceClocks      []string `toml:"phc_reference_clocks,omitempty"`
-	SHMReferenceClocks      []string `toml:"shm_reference_clocks,omitempty"`
-	NTPReferenceClocks      []string `toml:"ntp_reference_clocks,omitempty"`
-	SCIONPeers              []string `toml:"scion_peer_clocks,omitempty"`
-	NTSKECertFile           string   `toml:"ntske_cert_file,omitempty"`
-	NTSKEKeyFile            string   `toml:"ntske_key_file,omitempty"`
-	NTSKEServerName         string   `toml:"ntske_server_name,omitempty"`
-	AuthModes               []string `toml:"auth_modes,omitempty"`
-	NTSKEInsecureSkipVerify bool     `toml:"ntske_insecure_skip_verify,omitempty"`
-	DSCP                    uint8    `toml:"dscp,omitempty"` // must be in range [0, 63]
-	ClockDrift              float64  `toml:"clock_drift,omitempty"`
-	ReferenceClockImpact    float64  `toml:"reference_clock_impact,omitempty"`
-	PeerClockImpact         float64  `toml:"peer_clock_impact,omitempty"`
-	PeerClockCutoff         float64  `toml:"peer_clock_cutoff,omitempty"`
-	SyncTimeout             float64  `toml:"sync_timeout,omitempty"`
-	SyncInterval            float64  `toml:"sync_interval,omitempty"`
+	LocalAddr                     string                    `toml:"local_address,omitempty"`
+	LocalMetricsAddr              string                    `toml:"local_metrics_address,omitempty"`
+	SCIONDaemonAddr               string                    `toml:"scion_daemon_address,omitempty"`
+	SCIONConfigDir                string                    `toml:"scion_config_dir,omitempty"`
+	SCIONDataDir                  string                    `toml:"scion_data_dir,omitempty"`
+	RemoteAddr                    string                    `toml:"remote_address,omitempty"`
+	MBGReferenceClocks            []string                  `toml:"mbg_reference_clocks,omitempty"`
+	PHCReferenceClocks            []string                  `toml:"phc_reference_clocks,omitempty"`
+	SHMReferenceClocks            []string                  `toml:"shm_reference_clocks,omitempty"`
+	NTPReferenceClocks            []string                  `toml:"ntp_reference_clocks,omitempty"`
+	NTPv5                         bool                      `toml:"ntpv5,omitempty"`
+	CSPTPReferenceClocks          []string                  `toml:"csptp_reference_clocks,omitempty"` // "<ISD-AS>,<IP address>"
+	CSPTPServer                   bool                      `toml:"csptp_server,omitempty"`
+	CSPTPKeyFile                  string                    `toml:"csptp_key_file,omitempty"`
+	CSPTPKeyID                    uint32                    `toml:"csptp_key_id,omitempty"`         // static key used by CSPTP clients via IP
+	PTPReferenceClocks            []string                  `toml:"ptp_reference_clocks,omitempty"` // IP addresses of PTP unicast servers
+	PTPServer                     bool                      `toml:"ptp_server,omitempty"`
+	LeapSecondsFile               string                    `toml:"leap_seconds_file,omitempty"`
+	SCIONPeers                    []string                  `toml:"scion_peer_clocks,omitempty"`
+	NTPPeers                      []ntpPeerConfig           `toml:"ntp_peers,omitempty"`
+	NTPKeyFile                    string                    `toml:"ntp_key_file,omitempty"`
+	NTPKeyFileFormat              string                    `toml:"ntp_key_file_format,omitempty"`
+	NTPKeyID                      uint32                    `toml:"ntp_key_id,omitempty"` // key used by NTP clients in auth mode "symmetric"
+	NTPBroadcast                  []ntpBroadcastConfig      `toml:"ntp_broadcast,omitempty"`
+	NTPBroadcastClocks            []ntpBroadcastClockConfig `toml:"ntp_broadcast_clocks,omitempty"`
+	NTPControl                    bool                      `toml:"ntp_control,omitempty"` // answer read-only NTP control queries via IP
+	NTSKECertFile                 string                    `toml:"ntske_cert_file,omitempty"`
+	NTSKEKeyFile                  string                    `toml:"ntske_key_file,omitempty"`
+	NTSKEServerName               string                    `toml:"ntske_server_name,omitempty"`
+	NTSKEIdentities               []ntskeIdentityConfig     `toml:"ntske_identities,omitempty"`
+	NTSKEClientCAFile             string                    `toml:"ntske_client_ca_file,omitempty"`
+	NTSKEClientAuth               string                    `toml:"ntske_client_auth,omitempty"` // "require" or "optional"
+	NTSKEClientCertFile           string                    `toml:"ntske_client_cert_file,omitempty"`
+	NTSKEClientKeyFile            string                    `toml:"ntske_client_key_file,omitempty"`
+	AuthModes                     []string                  `toml:"auth_modes,omitempty"`
+	NTSKEInsecureSkipVerify       bool                      `toml:"ntske_insecure_skip_verify,omitempty"`
+	DSCP                          uint8                     `toml:"dscp,omitempty"` // must be in range [0, 63]
+	ClockDrift                    float64                   `toml:"clock_drift,omitempty"`
+	ReferenceClockImpact          float64                   `toml:"reference_clock_impact,omitempty"`
+	PeerClockImpact               float64                   `toml:"peer_clock_impact,omitempty"`
+	PeerClockCutoff               float64                   `toml:"peer_clock_cutoff,omitempty"`
+	SyncTimeout                   float64                   `toml:"sync_timeout,omitempty"`
+	SyncInterval                  float64                   `toml:"sync_interval,omitempty"`
+	RateLimit                     float64                   `toml:"rate_limit,omitempty"` // requests per second per client
+	RateLimitBurst                int                       `toml:"rate_limit_burst,omitempty"`
+	RateLimitIPv4PrefixLen        int                       `toml:"rate_limit_ipv4_prefix_length,omitempty"`
+	RateLimitIPv6PrefixLen        int                       `toml:"rate_limit_ipv6_prefix_length,omitempty"`
+	ACL                           []aclRuleConfig           `toml:"acl,omitempty"`
+	NTSKeySeedFile                string                    `toml:"nts_key_seed_file,omitempty"`
+	NTSKeyRenewalInterval         float64                   `toml:"nts_key_renewal_interval,omitempty"` // seconds
+	NTSKeyValidity                float64                   `toml:"nts_key_validity,omitempty"`         // seconds
+	NTSKEServers                  map[string][]string       `toml:"ntske_servers,omitempty"`            // failover NTS-KE servers per clock address
+	NTSKEMaxAge                   float64                   `toml:"ntske_max_age,omitempty"`            // seconds
+	NTSCookieStoreDir             string                    `toml:"nts_cookie_store_dir,omitempty"`
+	NTSKEMaxHandshakes            int                       `toml:"ntske_max_handshakes,omitempty"`
+	NTSKEHandshakeTimeout         float64                   `toml:"ntske_handshake_timeout,omitempty"` // seconds
+	NTSKEReadTimeout              float64                   `toml:"ntske_read_timeout,omitempty"`      // seconds
+	NTSKERateLimit                float64                   `toml:"ntske_rate_limit,omitempty"`        // connections per second per client
+	NTSKERateLimitBurst           int                       `toml:"ntske_rate_limit_burst,omitempty"`
+	NTSKENTPServers               []string                  `toml:"ntske_ntp_servers,omitempty"`           // IP NTP servers advertised by NTS-KE
+	NTSKESCIONNTPServers          []string                  `toml:"ntske_scion_ntp_servers,omitempty"`     // SCION NTP servers in the local AS
+	NTSKEHealthCheckInterval      float64                   `toml:"ntske_health_check_interval,omitempty"` // seconds
+	NTSKEBootstrap                bool                      `toml:"ntske_bootstrap,omitempty"`
+	NTSKEBootstrapMinServers      int                       `toml:"ntske_bootstrap_min_servers,omitempty"`
+	NTSKEBootstrapMaxDisagreement float64                   `toml:"ntske_bootstrap_max_disagreement,omitempty"` // seconds
+}
+
+// ntskeIdentityConfig is an additional TLS identity of the NTS-KE server
+// selected by clients via SNI.
+type ntskeIdentityConfig struct {
+	ServerName string `toml:"server_name"`
+	CertFile   string `toml:"cert_file"`
+	KeyFile    string `toml:"key_file"`
+}
+
+// ntpPeerConfig is a peer synchronized with in symmetric mode.
+type ntpPeerConfig struct {
+	Address string `toml:"address"`          // "<IP address>:<port>" or "<ISD-AS>,<IP address>:<port>"
+	KeyID   uint32 `toml:"key_id,omitempty"` // key in ntp_key_file, 0 if none
+}
+
+// ntpBroadcastConfig is a destination of packets sent in broadcast mode.
+type ntpBroadcastConfig struct {
+	Address     string  `toml:"address"`            // broadcast or multicast "<IP address>:<port>"
+	Interval    float64 `toml:"interval,omitempty"` // seconds
+	Interleaved bool    `toml:"interleaved,omitempty"`
+	KeyID       uint32  `toml:"key_id,omitempty"` // key in ntp_key_file, 0 if none
+}
+
+// ntpBroadcastClockConfig is a broadcast server listened to passively after
+// the delay from it has been calibrated in a unicast exchange.
+type ntpBroadcastClockConfig struct {
+	Address       string `toml:"address"`        // "<IP address>:<port>" of the server
+	ListenAddress string `toml:"listen_address"` // broadcast or multicast "<IP address>:<port>"
+	KeyID         uint32 `toml:"key_id,omitempty"`
+	// Unauthenticated accepts broadcast packets without a MAC if KeyID is 0.
+	Unauthenticated bool `toml:"unauthenticated,omitempty"`
+}
+
+type aclRuleConfig struct {
+	Action     string   `toml:"action"` // "allow" or "deny"
+	Prefixes   []string `toml:"prefixes,omitempty"`
+	ISDASes    []string `toml:"isd_as,omitempty"`
+	Identities []string `toml:"identities,omitempty"` // NTS client identities
+	KeyIDs     []uint32 `toml:"key_ids,omitempty"`    // keys of requests authenticated by a MAC
+	Auth       string   `toml:"auth,omitempty"`       // "", "nts", "spao", "symmetric" or "any"
+	Response   string   `toml:"response,omitempty"`   // "drop", "deny" or "rstr"
 }
 
 type ntpReferenceClockIP struct {
@@ -98,6 +193,29 @@ type ntpReferenceClockIP struct {
 	remoteAddr *net.UDPAddr
 }
 
+type ntpBroadcastReferenceClockIP struct {
+	log        *slog.Logger
+	ntpc       *client.BroadcastClientIP
+	localAddr  *net.UDPAddr
+	remoteAddr *net.UDPAddr
+}
+
+type csptpReferenceClockIP struct {
+	log        *slog.Logger
+	csptpc     *client.CSPTPClientIP
+	localAddr  netip.Addr
+	remoteAddr netip.Addr
+}
+
+// ptpReferenceClockIP measures the clock offset to the best of its PTP
+// unicast servers.
+type ptpReferenceClockIP struct {
+	log         *slog.Logger
+	ptpc        *client.PTPClientIP
+	localAddr   netip.Addr
+	remoteAddrs []netip.Addr
+}
+
 type ntpReferenceClockSCION struct {
 	log        *slog.Logger
 	ntpcs      [scionRefClockNumClient]*client.SCIONClient
@@ -106,11 +224,20 @@ type ntpReferenceClockSCION struct {
 	pather     *scion.Pather
 }
 
-type tlsCertCache struct {
-	cert       *tls.Certificate
-	reloadedAt time.Time
-	certFile   string
-	keyFile    string
+type csptpReferenceClockSCION struct {
+	log        *slog.Logger
+	csptpcs    [scionRefClockNumClient]*client.CSPTPClientSCION
+	localAddr  udp.UDPAddr
+	remoteAddr udp.UDPAddr
+	pather     *scion.Pather
+}
+
+// sourceStatus is the state of a reference clock reported by the status API.
+type sourceStatus struct {
+	Source       string     `json:"source"`
+	State        string     `json:"state"`
+	KissCode     string     `json:"kiss_code,omitempty"`
+	HoldoffUntil *time.Time `json:"holdoff_until,omitempty"`
 }
 
 func initLogger(verbose bool) {
@@ -159,9 +286,87 @@ func showInfo() {
 	}
 }
 
-func runMonitor(cfg svcConfig) {
+func newSourceStatus(source string, s client.Status) sourceStatus {
+	ss := sourceStatus{
+		Source: source,
+		State:  s.State.String(),
+	}
+	if s.KissCode != 0 {
+		ss.KissCode = ntp.KissCodeString(s.KissCode)
+	}
+	if !s.HoldoffUntil.IsZero() {
+		ss.HoldoffUntil = &s.HoldoffUntil
+	}
+	return ss
+}
+
+func serveStatus(w http.ResponseWriter, clks []client.ReferenceClock) {
+	var ss []sourceStatus
+	for _, c := range clks {
+		switch c := c.(type) {
+		case *ntpReferenceClockIP:
+			ss = append(ss, c.Status())
+		case *ntpReferenceClockSCION:
+			ss = append(ss, c.Status())
+		case *ntpBroadcastReferenceClockIP:
+			ss = append(ss, c.Status())
+		case *csptpReferenceClockIP:
+			ss = append(ss, c.Status())
+		case *csptpReferenceClockSCION:
+			ss = append(ss, c.Status())
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(ss)
+	if err != nil {
+		slog.Default().LogAttrs(context.Background(), slog.LevelInfo,
+			"failed to write status", slog.Any("error", err))
+	}
+}
+
+func newControlAssociation(ia addr.IA, remoteAddr netip.AddrPort, symmetric bool,
+	s client.Stats) server.ControlAssociation {
+	return server.ControlAssociation{
+		IA:        ia,
+		Addr:      netip.AddrPortFrom(remoteAddr.Addr().Unmap(), remoteAddr.Port()),
+		Symmetric: symmetric,
+		Reach:     s.Reach,
+		Leap:      s.Leap,
+		Stratum:   s.Stratum,
+		RefID:     s.RefID,
+		LastRx:    s.LastRx,
+		Offset:    s.Offset,
+		Delay:     s.Delay,
+		Jitter:    s.Jitter,
+	}
+}
+
+// ntpControl returns the control of the NTP server reporting the NTP
+// reference clocks and peers among clks, if configured.
+func ntpControl(cfg svcConfig, clks []client.ReferenceClock) *server.Control {
+	if !cfg.NTPControl {
+		return nil
+	}
+	return server.NewControl(func() []server.ControlAssociation {
+		var assocs []server.ControlAssociation
+		for _, c := range clks {
+			switch c := c.(type) {
+			case *ntpReferenceClockIP:
+				assocs = append(assocs, c.controlAssociation())
+			case *ntpReferenceClockSCION:
+				assocs = append(assocs, c.controlAssociation())
+			}
+		}
+		return assocs
+	})
+}
+
+func runMonitor(cfg svcConfig, clks []client.ReferenceClock) {
 	if cfg.LocalMetricsAddr != "" {
 		http.Handle("/metrics", promhttp.Handler())
+		http.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+			serveStatus(w, clks)
+		})
 		err := http.ListenAndServe(cfg.LocalMetricsAddr, nil)
 		logbase.Fatal(slog.Default(), "failed to serve metrics", slog.Any("error", err))
 	} else {
@@ -177,20 +382,97 @@ func ntskeServerFromRemoteAddr(remoteAddr string) string {
 	return split[1]
 }
 
-func (c *tlsCertCache) loadCert(chi *tls.ClientHelloInfo) (*tls.Certificate, error) {
-	now := time.Now()
-	if now.Before(c.reloadedAt) || !now.Before(c.reloadedAt.Add(tlsCertReloadInterval)) {
-		cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+// ntskeClientOptions configures key renewal and NTS-KE server failover of NTS
+// clients.
+type ntskeClientOptions struct {
+	ipServers    []string
+	scionServers []udp.UDPAddr
+	failover     map[string]ntskeServers
+	maxAge       time.Duration
+	store        *ntske.CookieStore
+	certFile     string
+	keyFile      string
+	bootstrap    bool
+}
+
+// ntskeServers lists the NTS-KE servers a clock fails over to.
+type ntskeServers struct {
+	ip    []string
+	scion []udp.UDPAddr
+}
+
+// clockAddrs returns the configured addresses of all clocks that may use NTS.
+func clockAddrs(cfg svcConfig) []string {
+	addrs := slices.Concat(cfg.NTPReferenceClocks, cfg.CSPTPReferenceClocks, cfg.SCIONPeers)
+	for _, pc := range cfg.NTPPeers {
+		addrs = append(addrs, pc.Address)
+	}
+	for _, bc := range cfg.NTPBroadcastClocks {
+		addrs = append(addrs, bc.Address)
+	}
+	return addrs
+}
+
+func newNTSKEClientOptions(cfg svcConfig) ntskeClientOptions {
+	var opts ntskeClientOptions
+	clocks := clockAddrs(cfg)
+	for clockAddr, servers := range cfg.NTSKEServers {
+		if !slices.Contains(clocks, clockAddr) {
+			logbase.Fatal(slog.Default(), "unknown clock address for NTS-KE servers specified in config",
+				slog.String("address", clockAddr))
+		}
+		var ss ntskeServers
+		for _, s := range servers {
+			a, err := snet.ParseUDPAddr(s)
+			if err == nil && !a.IA.IsZero() {
+				ss.scion = append(ss.scion, udp.UDPAddrFromSnet(a))
+				continue
+			}
+			_, _, err = net.SplitHostPort(s)
+			if err != nil {
+				logbase.Fatal(slog.Default(), "failed to parse NTS-KE server address",
+					slog.String("address", s), slog.Any("error", err))
+			}
+			ss.ip = append(ss.ip, s)
+		}
+		if opts.failover == nil {
+			opts.failover = make(map[string]ntskeServers)
+		}
+		opts.failover[clockAddr] = ss
+	}
+	if cfg.NTSKEMaxAge < 0 {
+		logbase.Fatal(slog.Default(), "invalid NTS-KE max age", slog.Float64("ntske_max_age", cfg.NTSKEMaxAge))
+	}
+	opts.maxAge = timemath.Duration(cfg.NTSKEMaxAge)
+	if (cfg.NTSKEClientCertFile == "") != (cfg.NTSKEClientKeyFile == "") {
+		logbase.Fatal(slog.Default(), "NTS-KE client certificate requires both cert and key file")
+	}
+	opts.certFile = cfg.NTSKEClientCertFile
+	opts.keyFile = cfg.NTSKEClientKeyFile
+	if cfg.NTSKEBootstrap && !slices.Contains(cfg.AuthModes, authModeNTS) {
+		logbase.Fatal(slog.Default(), "NTS-KE bootstrap requires NTS authentication")
+	}
+	opts.bootstrap = cfg.NTSKEBootstrap
+	if cfg.NTSCookieStoreDir != "" {
+		var err error
+		opts.store, err = ntske.OpenCookieStore(cfg.NTSCookieStoreDir)
 		if err != nil {
-			return &tls.Certificate{}, err
+			logbase.Fatal(slog.Default(), "failed to open NTS cookie store", slog.Any("error", err))
 		}
-		c.cert = &cert
-		c.reloadedAt = now
 	}
-	return c.cert, nil
+	return opts
+}
+
+// forClock returns the options of the clock configured with address clockAddr,
+// which fails over only to the NTS-KE servers listed for it.
+func (opts ntskeClientOptions) forClock(clockAddr string) ntskeClientOptions {
+	ss := opts.failover[clockAddr]
+	opts.ipServers, opts.scionServers = ss.ip, ss.scion
+	return opts
 }
 
-func configureIPClientNTS(c *client.IPClient, ntskeServer string, ntskeInsecureSkipVerify bool, log *slog.Logger) {
+func configureIPClientNTS(c *client.IPClient, ntskeServer string, ntskeInsecureSkipVerify bool,
+	opts ntskeClientOptions, log *slog.Logger) {
 	ntskeHost, ntskePort, err := net.SplitHostPort(ntskeServer)
 	if err != nil {
 		logbase.Fatal(slog.Default(), "failed to split NTS-KE host and port", slog.Any("error", err))
@@ -204,10 +486,17 @@ func configureIPClientNTS(c *client.IPClient, ntskeServer string, ntskeInsecureS
 	}
 	c.Auth.NTSKEFetcher.Port = ntskePort
 	c.Auth.NTSKEFetcher.Log = log
+	c.Auth.NTSKEFetcher.Servers = opts.ipServers
+	c.Auth.NTSKEFetcher.MaxAge = opts.maxAge
+	c.Auth.NTSKEFetcher.Store = opts.store
+	c.Auth.NTSKEFetcher.ClientCertFile = opts.certFile
+	c.Auth.NTSKEFetcher.ClientKeyFile = opts.keyFile
+	c.Auth.NTSKEFetcher.Bootstrap = opts.bootstrap
+	c.Auth.NTSKEFetcher.NTPv5 = c.NTPv5
 }
 
-func newNTPReferenceClockIP(log *slog.Logger, localAddr, remoteAddr *net.UDPAddr, dscp uint8,
-	authModes []string, ntskeServer string, ntskeInsecureSkipVerify bool) *ntpReferenceClockIP {
+func newNTPReferenceClockIP(log *slog.Logger, localAddr, remoteAddr *net.UDPAddr, dscp uint8, ntpv5 bool,
+	authModes []string, ntskeServer string, ntskeInsecureSkipVerify bool, ntskeOpts ntskeClientOptions) *ntpReferenceClockIP {
 	c := &ntpReferenceClockIP{
 		log:        log,
 		localAddr:  localAddr,
@@ -217,10 +506,12 @@ func newNTPReferenceClockIP(log *slog.Logger, localAddr, remoteAddr *net.UDPAddr
 		Log:             log,
 		DSCP:            dscp,
 		InterleavedMode: true,
+		NTPv5:           ntpv5,
+		RefIDs:          server.RefIDs(),
 	}
 	c.ntpc.Filter = client.NewNtimedFilter(log)
 	if slices.Contains(authModes, authModeNTS) {
-		configureIPClientNTS(c.ntpc, ntskeServer, ntskeInsecureSkipVerify, log)
+		configureIPClientNTS(c.ntpc, ntskeServer, ntskeInsecureSkipVerify, ntskeOpts, log)
 	}
 	return c
 }
@@ -230,8 +521,130 @@ func (c *ntpReferenceClockIP) MeasureClockOffset(ctx context.Context) (
 	return client.MeasureClockOffsetIP(ctx, c.log, c.ntpc, c.localAddr, c.remoteAddr)
 }
 
+func (c *ntpReferenceClockIP) Status() sourceStatus {
+	return newSourceStatus(c.remoteAddr.String(), c.ntpc.Status())
+}
+
+func (c *ntpReferenceClockIP) controlAssociation() server.ControlAssociation {
+	return newControlAssociation(0, c.remoteAddr.AddrPort(), c.ntpc.Symmetric, c.ntpc.Stats())
+}
+
+func (c *ntpReferenceClockIP) CertificateValidity() (notBefore, notAfter time.Time, ok bool) {
+	return c.ntpc.Auth.NTSKEFetcher.CertificateValidity()
+}
+
+func (c *ntpReferenceClockIP) EndBootstrap(ctx context.Context) error {
+	return c.ntpc.Auth.NTSKEFetcher.EndBootstrap(ctx)
+}
+
+func newNTPBroadcastReferenceClockIP(log *slog.Logger, localAddr, remoteAddr *net.UDPAddr,
+	listenAddr netip.AddrPort, dscp uint8, key ntp.SymmetricKey, authModes []string, ntskeServer string,
+	ntskeInsecureSkipVerify bool, ntskeOpts ntskeClientOptions) *ntpBroadcastReferenceClockIP {
+	c := &ntpBroadcastReferenceClockIP{
+		log:        log,
+		localAddr:  localAddr,
+		remoteAddr: remoteAddr,
+	}
+	c.ntpc = &client.BroadcastClientIP{
+		Log:        log,
+		ListenAddr: listenAddr,
+		Unicast: newNTPReferenceClockIP(log, localAddr, remoteAddr, dscp, false, /* NTPv5 */
+			authModes, ntskeServer, ntskeInsecureSkipVerify, ntskeOpts).ntpc,
+	}
+	c.ntpc.Auth.SymmetricKey = key
+	c.ntpc.Auth.Unauthenticated = key.ID == 0
+	return c
+}
+
+func (c *ntpBroadcastReferenceClockIP) MeasureClockOffset(ctx context.Context) (
+	time.Time, time.Duration, error) {
+	return c.ntpc.MeasureClockOffset(ctx, c.localAddr, c.remoteAddr)
+}
+
+func (c *ntpBroadcastReferenceClockIP) Status() sourceStatus {
+	return newSourceStatus(c.remoteAddr.String(), c.ntpc.Status())
+}
+
+func configureCSPTPClientNTS(c *client.CSPTPClientIP, ntskeServer string, ntskeInsecureSkipVerify bool,
+	opts ntskeClientOptions, log *slog.Logger) {
+	ntskeHost, ntskePort, err := net.SplitHostPort(ntskeServer)
+	if err != nil {
+		logbase.Fatal(slog.Default(), "failed to split NTS-KE host and port", slog.Any("error", err))
+	}
+	c.Auth.Enabled = true
+	c.Auth.NTSKEFetcher.TLSConfig = tls.Config{
+		NextProtos:         []string{"ntske/1"},
+		InsecureSkipVerify: ntskeInsecureSkipVerify,
+		ServerName:         ntskeHost,
+		MinVersion:         tls.VersionTLS13,
+	}
+	c.Auth.NTSKEFetcher.Port = ntskePort
+	c.Auth.NTSKEFetcher.Log = log
+	c.Auth.NTSKEFetcher.Servers = opts.ipServers
+	c.Auth.NTSKEFetcher.MaxAge = opts.maxAge
+	c.Auth.NTSKEFetcher.Store = opts.store
+	c.Auth.NTSKEFetcher.ClientCertFile = opts.certFile
+	c.Auth.NTSKEFetcher.ClientKeyFile = opts.keyFile
+	c.Auth.NTSKEFetcher.CSPTP = true
+}
+
+func newCSPTPReferenceClockIP(log *slog.Logger, localAddr, remoteAddr netip.Addr, dscp uint8,
+	authModes []string, authKeyID uint32, authKey []byte,
+	ntskeInsecureSkipVerify bool, ntskeOpts ntskeClientOptions) *csptpReferenceClockIP {
+	c := &csptpReferenceClockIP{
+		log:        log,
+		localAddr:  localAddr,
+		remoteAddr: remoteAddr,
+	}
+	c.csptpc = &client.CSPTPClientIP{
+		Log:  log,
+		DSCP: dscp,
+	}
+	c.csptpc.Filter = client.NewNtimedFilter(log)
+	if authKey != nil {
+		c.csptpc.Auth.Enabled = true
+		c.csptpc.Auth.KeyID = authKeyID
+		c.csptpc.Auth.Key = authKey
+	} else if slices.Contains(authModes, authModeNTS) {
+		ntskeServer := net.JoinHostPort(remoteAddr.String(), strconv.Itoa(ntske.ServerPortIP))
+		configureCSPTPClientNTS(c.csptpc, ntskeServer, ntskeInsecureSkipVerify, ntskeOpts, log)
+	}
+	return c
+}
+
+func (c *csptpReferenceClockIP) MeasureClockOffset(ctx context.Context) (
+	time.Time, time.Duration, error) {
+	return c.csptpc.MeasureClockOffset(ctx, c.localAddr, c.remoteAddr)
+}
+
+// Status reports c as active as CSPTP servers cannot deny or rate limit
+// clients.
+func (c *csptpReferenceClockIP) Status() sourceStatus {
+	return newSourceStatus(c.remoteAddr.String(), client.Status{State: client.SourceActive})
+}
+
+func newPTPReferenceClockIP(log *slog.Logger, localAddr netip.Addr, remoteAddrs []netip.Addr,
+	dscp uint8) *ptpReferenceClockIP {
+	c := &ptpReferenceClockIP{
+		log:         log,
+		localAddr:   localAddr,
+		remoteAddrs: remoteAddrs,
+	}
+	c.ptpc = &client.PTPClientIP{
+		Log:  log,
+		DSCP: dscp,
+	}
+	c.ptpc.Filter = client.NewNtimedFilter(log)
+	return c
+}
+
+func (c *ptpReferenceClockIP) MeasureClockOffset(ctx context.Context) (
+	time.Time, time.Duration, error) {
+	return c.ptpc.MeasureClockOffset(ctx, c.localAddr, c.remoteAddrs)
+}
+
 func configureSCIONClientNTS(c *client.SCIONClient, ntskeServer string, ntskeInsecureSkipVerify bool,
-	daemonAddr string, localAddr, remoteAddr udp.UDPAddr, log *slog.Logger) {
+	opts ntskeClientOptions, daemonAddr string, localAddr, remoteAddr udp.UDPAddr, log *slog.Logger) {
 	ntskeHost, ntskePort, err := net.SplitHostPort(ntskeServer)
 	if err != nil {
 		logbase.Fatal(slog.Default(), "failed to split NTS-KE host and port", slog.Any("error", err))
@@ -249,10 +662,17 @@ func configureSCIONClientNTS(c *client.SCIONClient, ntskeServer string, ntskeIns
 	c.Auth.NTSKEFetcher.QUIC.DaemonAddr = daemonAddr
 	c.Auth.NTSKEFetcher.QUIC.LocalAddr = localAddr
 	c.Auth.NTSKEFetcher.QUIC.RemoteAddr = remoteAddr
+	c.Auth.NTSKEFetcher.QUIC.RemoteAddrs = opts.scionServers
+	c.Auth.NTSKEFetcher.MaxAge = opts.maxAge
+	c.Auth.NTSKEFetcher.Store = opts.store
+	c.Auth.NTSKEFetcher.ClientCertFile = opts.certFile
+	c.Auth.NTSKEFetcher.ClientKeyFile = opts.keyFile
+	c.Auth.NTSKEFetcher.Bootstrap = opts.bootstrap
+	c.Auth.NTSKEFetcher.NTPv5 = c.NTPv5
 }
 
-func newNTPReferenceClockSCION(log *slog.Logger, daemonAddr string, localAddr, remoteAddr udp.UDPAddr, dscp uint8,
-	authModes []string, ntskeServer string, ntskeInsecureSkipVerify bool) *ntpReferenceClockSCION {
+func newNTPReferenceClockSCION(log *slog.Logger, daemonAddr string, localAddr, remoteAddr udp.UDPAddr, dscp uint8, ntpv5 bool,
+	authModes []string, ntskeServer string, ntskeInsecureSkipVerify bool, ntskeOpts ntskeClientOptions) *ntpReferenceClockSCION {
 	c := &ntpReferenceClockSCION{
 		log:        log,
 		localAddr:  localAddr,
@@ -263,10 +683,13 @@ func newNTPReferenceClockSCION(log *slog.Logger, daemonAddr string, localAddr, r
 			Log:             log,
 			DSCP:            dscp,
 			InterleavedMode: true,
+			NTPv5:           ntpv5,
+			RefIDs:          server.RefIDs(),
 		}
 		c.ntpcs[i].Filter = client.NewNtimedFilter(log)
 		if slices.Contains(authModes, authModeNTS) {
-			configureSCIONClientNTS(c.ntpcs[i], ntskeServer, ntskeInsecureSkipVerify, daemonAddr, localAddr, remoteAddr, log)
+			configureSCIONClientNTS(c.ntpcs[i], ntskeServer, ntskeInsecureSkipVerify, ntskeOpts,
+				daemonAddr, localAddr, remoteAddr, log)
 		}
 	}
 	return c
@@ -288,6 +711,101 @@ func (c *ntpReferenceClockSCION) MeasureClockOffset(ctx context.Context) (
 	return client.MeasureClockOffsetSCION(ctx, c.log, c.ntpcs[:], c.localAddr, c.remoteAddr, ps)
 }
 
+// Status reports the most severe source state among all clients of c.
+func (c *ntpReferenceClockSCION) Status() sourceStatus {
+	s := c.ntpcs[0].Status()
+	for _, ntpc := range c.ntpcs[1:] {
+		t := ntpc.Status()
+		if t.State > s.State || t.State == s.State && t.HoldoffUntil.After(s.HoldoffUntil) {
+			s = t
+		}
+	}
+	return newSourceStatus(c.remoteAddr.String(), s)
+}
+
+// controlAssociation reports the reachability of the server via any of the
+// clients of c and the statistics of the client that received the most recent
+// response.
+func (c *ntpReferenceClockSCION) controlAssociation() server.ControlAssociation {
+	s := c.ntpcs[0].Stats()
+	reach := s.Reach
+	for _, ntpc := range c.ntpcs[1:] {
+		t := ntpc.Stats()
+		reach |= t.Reach
+		if t.LastRx.After(s.LastRx) {
+			s = t
+		}
+	}
+	s.Reach = reach
+	return newControlAssociation(c.remoteAddr.IA, c.remoteAddr.Host.AddrPort(), c.ntpcs[0].Symmetric, s)
+}
+
+// CertificateValidity returns the period during which the certificates
+// presented to all clients of c that have performed a key exchange are valid.
+func (c *ntpReferenceClockSCION) CertificateValidity() (notBefore, notAfter time.Time, ok bool) {
+	for _, ntpc := range c.ntpcs {
+		nb, na, cok := ntpc.Auth.NTSKEFetcher.CertificateValidity()
+		if !cok {
+			continue
+		}
+		if !ok || nb.After(notBefore) {
+			notBefore = nb
+		}
+		if !ok || na.Before(notAfter) {
+			notAfter = na
+		}
+		ok = true
+	}
+	return notBefore, notAfter, ok && !notBefore.After(notAfter)
+}
+
+func (c *ntpReferenceClockSCION) EndBootstrap(ctx context.Context) error {
+	var errs []error
+	for _, ntpc := range c.ntpcs {
+		errs = append(errs, ntpc.Auth.NTSKEFetcher.EndBootstrap(ctx))
+	}
+	return errors.Join(errs...)
+}
+
+func newCSPTPReferenceClockSCION(log *slog.Logger, localAddr, remoteAddr udp.UDPAddr,
+	dscp uint8) *csptpReferenceClockSCION {
+	c := &csptpReferenceClockSCION{
+		log:        log,
+		localAddr:  localAddr,
+		remoteAddr: remoteAddr,
+	}
+	for i := range len(c.csptpcs) {
+		c.csptpcs[i] = &client.CSPTPClientSCION{
+			Log:  log,
+			DSCP: dscp,
+		}
+		c.csptpcs[i].Filter = client.NewNtimedFilter(log)
+	}
+	return c
+}
+
+func (c *csptpReferenceClockSCION) MeasureClockOffset(ctx context.Context) (
+	time.Time, time.Duration, error) {
+	var ps []snet.Path
+	if c.remoteAddr.IA == c.localAddr.IA {
+		ps = []snet.Path{path.Path{
+			Src:           c.localAddr.IA,
+			Dst:           c.remoteAddr.IA,
+			DataplanePath: path.Empty{},
+			NextHop:       c.remoteAddr.Host,
+		}}
+	} else {
+		ps = c.pather.Paths(c.remoteAddr.IA)
+	}
+	return client.MeasureClockOffsetCSPTPSCION(ctx, c.log, c.csptpcs[:], c.localAddr, c.remoteAddr, ps)
+}
+
+// Status reports c as active as CSPTP servers cannot deny or rate limit
+// clients.
+func (c *csptpReferenceClockSCION) Status() sourceStatus {
+	return newSourceStatus(c.remoteAddr.String(), client.Status{State: client.SourceActive})
+}
+
 func loadConfig(configFile string) svcConfig {
 	raw, err := os.ReadFile(configFile)
 	if err != nil {
@@ -332,6 +850,355 @@ func dscp(cfg svcConfig) uint8 {
 	return cfg.DSCP
 }
 
+// csptpServerConfig derives the time source announced by CSPTP servers from
+// the configured reference clocks, preferring locally attached ones.
+func csptpServerConfig(cfg svcConfig) server.CSPTPServerConfig {
+	switch {
+	case len(cfg.MBGReferenceClocks) != 0 || len(cfg.SHMReferenceClocks) != 0:
+		return server.CSPTPServerConfig{TimeSource: csptp.TimeSourceGNSS, Primary: true}
+	case len(cfg.PHCReferenceClocks) != 0:
+		return server.CSPTPServerConfig{TimeSource: csptp.TimeSourcePTP, StepsRemoved: 1, Primary: true}
+	case len(cfg.CSPTPReferenceClocks) != 0 || len(cfg.PTPReferenceClocks) != 0:
+		return server.CSPTPServerConfig{TimeSource: csptp.TimeSourcePTP, StepsRemoved: 1}
+	case len(cfg.NTPReferenceClocks) != 0:
+		return server.CSPTPServerConfig{TimeSource: csptp.TimeSourceNTP, StepsRemoved: 1}
+	default:
+		return server.CSPTPServerConfig{TimeSource: csptp.TimeSourceInternalOscillator}
+	}
+}
+
+// leapSeconds loads the leap second table announced by CSPTP and PTP servers,
+// if any.
+func leapSeconds(cfg svcConfig) *leapsecond.Table {
+	if cfg.LeapSecondsFile == "" {
+		return nil
+	}
+	t, err := leapsecond.Load(cfg.LeapSecondsFile)
+	if err != nil {
+		logbase.Fatal(slog.Default(), "failed to load leap second file", slog.Any("error", err))
+	}
+	if !time.Now().Before(t.Expires()) {
+		slog.Default().LogAttrs(context.Background(), slog.LevelError, "leap second file expired",
+			slog.String("file", cfg.LeapSecondsFile), slog.Time("expires", t.Expires()))
+	}
+	return t
+}
+
+// csptpKeys loads the static keys for CSPTP authentication TLVs, if any.
+func csptpKeys(cfg svcConfig) map[uint32][]byte {
+	if cfg.CSPTPKeyFile == "" {
+		if cfg.CSPTPKeyID != 0 {
+			logbase.Fatal(slog.Default(), "CSPTP key ID specified without key file")
+		}
+		return nil
+	}
+	keys, err := csptp.LoadKeys(cfg.CSPTPKeyFile)
+	if err != nil {
+		logbase.Fatal(slog.Default(), "failed to load CSPTP keys", slog.Any("error", err))
+	}
+	if _, ok := keys[cfg.CSPTPKeyID]; cfg.CSPTPKeyID != 0 && !ok {
+		logbase.Fatal(slog.Default(), "unknown CSPTP key ID specified in config",
+			slog.Uint64("key_id", uint64(cfg.CSPTPKeyID)))
+	}
+	return keys
+}
+
+func ntpKeys(cfg svcConfig) map[uint32]ntp.SymmetricKey {
+	if cfg.NTPKeyFile == "" {
+		return nil
+	}
+	var format ntp.KeyFileFormat
+	switch cfg.NTPKeyFileFormat {
+	case "", "chrony":
+		format = ntp.KeyFileChrony
+	case "ntpd":
+		format = ntp.KeyFileNTPD
+	default:
+		logbase.Fatal(slog.Default(), "unexpected NTP key file format",
+			slog.String("format", cfg.NTPKeyFileFormat))
+	}
+	keys, err := ntp.LoadSymmetricKeys(cfg.NTPKeyFile, format)
+	if err != nil {
+		logbase.Fatal(slog.Default(), "failed to load NTP keys", slog.Any("error", err))
+	}
+	return keys
+}
+
+// ntpClientKey returns the key with which NTP clients authenticate requests by a
+// MAC in auth mode "symmetric", if any.
+func ntpClientKey(cfg svcConfig) ntp.SymmetricKey {
+	if !slices.Contains(cfg.AuthModes, authModeSymmetric) || len(cfg.NTPReferenceClocks) == 0 {
+		return ntp.SymmetricKey{}
+	}
+	key, ok := ntpKeys(cfg)[cfg.NTPKeyID]
+	if !ok {
+		logbase.Fatal(slog.Default(), "unknown NTP key ID specified in config",
+			slog.Uint64("key_id", uint64(cfg.NTPKeyID)))
+	}
+	return key
+}
+
+// ntpServerKeys returns the keys with which clients may authenticate requests
+// by a MAC in auth mode "symmetric", if any.
+func ntpServerKeys(cfg svcConfig) map[uint32]ntp.SymmetricKey {
+	if !slices.Contains(cfg.AuthModes, authModeSymmetric) {
+		return nil
+	}
+	return ntpKeys(cfg)
+}
+
+func ntpPeerAddress(pc ntpPeerConfig, keys map[uint32]ntp.SymmetricKey) *snet.UDPAddr {
+	remoteAddr, err := snet.ParseUDPAddr(pc.Address)
+	if err != nil {
+		logbase.Fatal(slog.Default(), "failed to parse NTP peer address",
+			slog.String("address", pc.Address), slog.Any("error", err))
+	}
+	if _, ok := keys[pc.KeyID]; pc.KeyID != 0 && !ok {
+		logbase.Fatal(slog.Default(), "unknown NTP key ID specified in config",
+			slog.String("address", pc.Address), slog.Uint64("key_id", uint64(pc.KeyID)))
+	}
+	return remoteAddr
+}
+
+func ntpPeers(cfg svcConfig) *server.Peers {
+	keys := ntpKeys(cfg)
+	var peers []server.Peer
+	for _, pc := range cfg.NTPPeers {
+		remoteAddr := ntpPeerAddress(pc, keys)
+		peers = append(peers, server.Peer{
+			IA:    remoteAddr.IA,
+			Addr:  remoteAddr.Host.AddrPort().Addr().Unmap(),
+			KeyID: pc.KeyID,
+		})
+	}
+	p, err := server.NewPeers(peers, keys)
+	if err != nil {
+		logbase.Fatal(slog.Default(), "failed to configure NTP peers", slog.Any("error", err))
+	}
+	return p
+}
+
+func ntpBroadcastServerConfigs(cfg svcConfig) []server.BroadcastServerConfig {
+	keys := ntpKeys(cfg)
+	var bcfgs []server.BroadcastServerConfig
+	for _, bc := range cfg.NTPBroadcast {
+		addr, err := netip.ParseAddrPort(bc.Address)
+		if err != nil {
+			logbase.Fatal(slog.Default(), "failed to parse NTP broadcast address",
+				slog.String("address", bc.Address), slog.Any("error", err))
+		}
+		if bc.Interval < 0 {
+			logbase.Fatal(slog.Default(), "invalid NTP broadcast interval specified in config")
+		}
+		interval := defaultNTPBroadcastInterval
+		if bc.Interval != 0 {
+			interval = time.Duration(bc.Interval * float64(time.Second))
+		}
+		if _, ok := keys[bc.KeyID]; bc.KeyID != 0 && !ok {
+			logbase.Fatal(slog.Default(), "unknown NTP key ID specified in config",
+				slog.String("address", bc.Address), slog.Uint64("key_id", uint64(bc.KeyID)))
+		}
+		bcfgs = append(bcfgs, server.BroadcastServerConfig{
+			Address:     addr,
+			Interval:    interval,
+			Interleaved: bc.Interleaved,
+			Key:         keys[bc.KeyID],
+		})
+	}
+	return bcfgs
+}
+
+func rateLimiter(cfg svcConfig) *server.RateLimiter {
+	if cfg.RateLimit < 0 || cfg.RateLimitBurst < 0 ||
+		cfg.RateLimitIPv4PrefixLen < 0 || cfg.RateLimitIPv4PrefixLen > 32 ||
+		cfg.RateLimitIPv6PrefixLen < 0 || cfg.RateLimitIPv6PrefixLen > 128 {
+		logbase.Fatal(slog.Default(), "invalid rate limit value specified in config")
+	}
+	return server.NewRateLimiter(server.RateLimitConfig{
+		Rate:          cfg.RateLimit,
+		Burst:         cfg.RateLimitBurst,
+		IPv4PrefixLen: cfg.RateLimitIPv4PrefixLen,
+		IPv6PrefixLen: cfg.RateLimitIPv6PrefixLen,
+	})
+}
+
+func ntskeServerConfig(cfg svcConfig) server.NTSKEServerConfig {
+	if cfg.NTSKEMaxHandshakes < 0 ||
+		cfg.NTSKEHandshakeTimeout < 0 || cfg.NTSKEReadTimeout < 0 ||
+		cfg.NTSKERateLimit < 0 || cfg.NTSKERateLimitBurst < 0 {
+		logbase.Fatal(slog.Default(), "invalid NTS-KE server limit specified in config")
+	}
+	return server.NTSKEServerConfig{
+		MaxHandshakes:    cfg.NTSKEMaxHandshakes,
+		HandshakeTimeout: timemath.Duration(cfg.NTSKEHandshakeTimeout),
+		ReadTimeout:      timemath.Duration(cfg.NTSKEReadTimeout),
+		RateLimit: server.RateLimitConfig{
+			Rate:          cfg.NTSKERateLimit,
+			Burst:         cfg.NTSKERateLimitBurst,
+			IPv4PrefixLen: cfg.RateLimitIPv4PrefixLen,
+			IPv6PrefixLen: cfg.RateLimitIPv6PrefixLen,
+		},
+	}
+}
+
+func ntpBackends(ctx context.Context, cfg svcConfig, localAddr *snet.UDPAddr, log *slog.Logger) (
+	ipBackends, scionBackends *server.NTPBackendPool) {
+	if cfg.NTSKEHealthCheckInterval < 0 {
+		logbase.Fatal(slog.Default(), "invalid NTS-KE health check interval specified in config")
+	}
+	interval := timemath.Duration(cfg.NTSKEHealthCheckInterval)
+
+	var ipbs []server.NTPBackend
+	for _, s := range cfg.NTSKENTPServers {
+		ap, err := netip.ParseAddrPort(s)
+		if err != nil {
+			logbase.Fatal(slog.Default(), "failed to parse NTP server address",
+				slog.String("address", s), slog.Any("error", err))
+		}
+		laddr := &net.UDPAddr{IP: localAddr.Host.IP}
+		raddr := net.UDPAddrFromAddrPort(ap)
+		c := &client.IPClient{Log: log}
+		ipbs = append(ipbs, server.NTPBackend{
+			IP:   raddr.IP,
+			Port: raddr.Port,
+			Probe: func(ctx context.Context) error {
+				_, _, err := client.MeasureClockOffsetIP(ctx, log, c, laddr, raddr)
+				return err
+			},
+		})
+	}
+	ipBackends = server.NewNTPBackendPool(ipbs)
+	ipBackends.StartHealthChecks(ctx, log, interval)
+
+	var scionbs []server.NTPBackend
+	for _, s := range cfg.NTSKESCIONNTPServers {
+		var a snet.UDPAddr
+		err := a.Set(s)
+		if err != nil {
+			logbase.Fatal(slog.Default(), "failed to parse NTP server address",
+				slog.String("address", s), slog.Any("error", err))
+		}
+		// NTS-KE only advertises host addresses, SCION clients stay in the AS
+		// of the NTS-KE server.
+		if a.IA != localAddr.IA {
+			logbase.Fatal(slog.Default(), "SCION NTP server must be in the local AS",
+				slog.String("address", s))
+		}
+		laddr := udp.UDPAddrFromSnet(localAddr)
+		laddr.Host.Port = 0
+		raddr := udp.UDPAddrFromSnet(&a)
+		p := path.Path{
+			Src:           localAddr.IA,
+			Dst:           a.IA,
+			DataplanePath: path.Empty{},
+			NextHop:       a.Host,
+		}
+		c := &client.SCIONClient{Log: log}
+		scionbs = append(scionbs, server.NTPBackend{
+			IP:   a.Host.IP,
+			Port: a.Host.Port,
+			Probe: func(ctx context.Context) error {
+				_, _, err := client.MeasureClockOffsetSCION(ctx, log, []*client.SCIONClient{c},
+					laddr, raddr, []snet.Path{p})
+				return err
+			},
+		})
+	}
+	scionBackends = server.NewNTPBackendPool(scionbs)
+	scionBackends.StartHealthChecks(ctx, log, interval)
+
+	return ipBackends, scionBackends
+}
+
+func ntskeProvider(cfg svcConfig) *ntske.Provider {
+	if cfg.NTSKeySeedFile == "" {
+		if cfg.NTSKeyRenewalInterval != 0 || cfg.NTSKeyValidity != 0 {
+			logbase.Fatal(slog.Default(), "NTS key renewal interval and validity require a key seed file")
+		}
+		return ntske.NewProvider()
+	}
+	if cfg.NTSKeyRenewalInterval < 0 || cfg.NTSKeyValidity < 0 {
+		logbase.Fatal(slog.Default(), "invalid NTS key renewal interval or validity specified in config")
+	}
+	p, err := ntske.LoadSeededProvider(cfg.NTSKeySeedFile,
+		timemath.Duration(cfg.NTSKeyRenewalInterval), timemath.Duration(cfg.NTSKeyValidity))
+	if err != nil {
+		logbase.Fatal(slog.Default(), "failed to load NTS key seed", slog.Any("error", err))
+	}
+	return p
+}
+
+func accessControlList(cfg svcConfig) *server.ACL {
+	rules := make([]server.ACLRule, len(cfg.ACL))
+	for i, rc := range cfg.ACL {
+		r := &rules[i]
+		switch rc.Action {
+		case "allow":
+			r.Action = server.ACLAllow
+		case "deny":
+			r.Action = server.ACLDeny
+		default:
+			logbase.Fatal(slog.Default(), "invalid ACL action specified in config",
+				slog.String("action", rc.Action))
+		}
+		for _, s := range rc.Prefixes {
+			p, err := netip.ParsePrefix(s)
+			if err != nil {
+				logbase.Fatal(slog.Default(), "invalid ACL prefix specified in config",
+					slog.String("prefix", s), slog.Any("error", err))
+			}
+			r.Prefixes = append(r.Prefixes, p.Masked())
+		}
+		for _, s := range rc.ISDASes {
+			ia, err := addr.ParseIA(s)
+			if err != nil || ia.ISD() == 0 {
+				logbase.Fatal(slog.Default(), "invalid ACL ISD-AS specified in config",
+					slog.String("isd_as", s))
+			}
+			r.IAs = append(r.IAs, ia)
+		}
+		for _, s := range rc.Identities {
+			if s == "" {
+				logbase.Fatal(slog.Default(), "invalid ACL identity specified in config")
+			}
+			r.Identities = append(r.Identities, s)
+		}
+		for _, id := range rc.KeyIDs {
+			if id == 0 {
+				logbase.Fatal(slog.Default(), "invalid ACL key ID specified in config")
+			}
+			r.KeyIDs = append(r.KeyIDs, id)
+		}
+		switch rc.Auth {
+		case "":
+			r.Auth = server.ACLAuthNone
+		case authModeNTS:
+			r.Auth = server.ACLAuthNTS
+		case authModeSPAO:
+			r.Auth = server.ACLAuthSPAO
+		case authModeSymmetric:
+			r.Auth = server.ACLAuthMAC
+		case "any":
+			r.Auth = server.ACLAuthAny
+		default:
+			logbase.Fatal(slog.Default(), "invalid ACL authentication requirement specified in config",
+				slog.String("auth", rc.Auth))
+		}
+		switch rc.Response {
+		case "", "drop":
+			r.Response = server.ACLResponseDrop
+		case "deny":
+			r.Response = server.ACLResponseDENY
+		case "rstr":
+			r.Response = server.ACLResponseRSTR
+		default:
+			logbase.Fatal(slog.Default(), "invalid ACL response specified in config",
+				slog.String("response", rc.Response))
+		}
+	}
+	return server.NewACL(rules)
+}
+
 func clockDrift(cfg svcConfig) time.Duration {
 	if cfg.ClockDrift < 0 {
 		logbase.Fatal(slog.Default(), "invalid clock drift value specified in config")
@@ -375,25 +1242,119 @@ func syncConfig(cfg svcConfig) sync.Config {
 	return syncCfg
 }
 
-func tlsConfig(cfg svcConfig) *tls.Config {
+func bootstrapConfig(cfg svcConfig) sync.BootstrapConfig {
+	const (
+		defaultBootstrapMinAgreement    = 2
+		defaultBootstrapMaxDisagreement = 1 * time.Second
+		defaultBootstrapTimeout         = 5 * time.Second
+		defaultBootstrapInterval        = 10 * time.Second
+	)
+
+	if cfg.NTSKEBootstrapMinServers < 0 {
+		logbase.Fatal(slog.Default(), "invalid NTS-KE bootstrap minimum number of servers",
+			slog.Int("ntske_bootstrap_min_servers", cfg.NTSKEBootstrapMinServers))
+	}
+	if cfg.NTSKEBootstrapMaxDisagreement < 0 {
+		logbase.Fatal(slog.Default(), "invalid NTS-KE bootstrap maximum disagreement",
+			slog.Float64("ntske_bootstrap_max_disagreement", cfg.NTSKEBootstrapMaxDisagreement))
+	}
+	bootstrapCfg := sync.BootstrapConfig{
+		MinAgreement:    cfg.NTSKEBootstrapMinServers,
+		MaxDisagreement: timemath.Duration(cfg.NTSKEBootstrapMaxDisagreement),
+		SyncTimeout:     defaultBootstrapTimeout,
+		SyncInterval:    defaultBootstrapInterval,
+	}
+	if bootstrapCfg.MinAgreement == 0 {
+		bootstrapCfg.MinAgreement = defaultBootstrapMinAgreement
+	}
+	if bootstrapCfg.MaxDisagreement == 0 {
+		bootstrapCfg.MaxDisagreement = defaultBootstrapMaxDisagreement
+	}
+	return bootstrapCfg
+}
+
+// bootstrapClock sets the local clock to the time agreed on by the NTS
+// reference clocks if NTS-KE bootstrapping is enabled.
+func bootstrapClock(ctx context.Context, cfg svcConfig, log *slog.Logger,
+	lclk *clocks.SystemClock, refClocks []client.ReferenceClock) {
+	if !cfg.NTSKEBootstrap {
+		return
+	}
+	var clks []sync.BootstrapClock
+	for _, c := range refClocks {
+		bc, ok := c.(sync.BootstrapClock)
+		if ok {
+			clks = append(clks, bc)
+		}
+	}
+	err := sync.Bootstrap(ctx, log, bootstrapConfig(cfg), lclk, clks)
+	if err != nil {
+		logbase.Fatal(slog.Default(), "failed to bootstrap clock", slog.Any("error", err))
+	}
+}
+
+func tlsConfig(ctx context.Context, cfg svcConfig, log *slog.Logger) *tls.Config {
 	if cfg.NTSKEServerName == "" || cfg.NTSKECertFile == "" || cfg.NTSKEKeyFile == "" {
 		logbase.Fatal(slog.Default(), "missing parameters in configuration for NTSKE server")
 	}
-	certCache := tlsCertCache{
-		certFile: cfg.NTSKECertFile,
-		keyFile:  cfg.NTSKEKeyFile,
+	ids := []server.TLSIdentity{{
+		ServerName: cfg.NTSKEServerName,
+		CertFile:   cfg.NTSKECertFile,
+		KeyFile:    cfg.NTSKEKeyFile,
+	}}
+	for _, id := range cfg.NTSKEIdentities {
+		if id.ServerName == "" || id.CertFile == "" || id.KeyFile == "" {
+			logbase.Fatal(slog.Default(), "missing parameters in configuration for NTSKE server identity")
+		}
+		ids = append(ids, server.TLSIdentity{
+			ServerName: id.ServerName,
+			CertFile:   id.CertFile,
+			KeyFile:    id.KeyFile,
+		})
+	}
+	certs, err := server.NewTLSCertificates(log, ids)
+	if err != nil {
+		logbase.Fatal(slog.Default(), "failed to load TLS certificates", slog.Any("error", err))
 	}
-	return &tls.Config{
+	certs.StartReloading(ctx)
+	config := &tls.Config{
 		ServerName:     cfg.NTSKEServerName,
 		NextProtos:     []string{"ntske/1"},
-		GetCertificate: certCache.loadCert,
+		GetCertificate: certs.GetCertificate,
 		MinVersion:     tls.VersionTLS13,
 	}
+	if cfg.NTSKEClientCAFile != "" {
+		pem, err := os.ReadFile(cfg.NTSKEClientCAFile)
+		if err != nil {
+			logbase.Fatal(slog.Default(), "failed to read NTS-KE client CA file", slog.Any("error", err))
+		}
+		config.ClientCAs = x509.NewCertPool()
+		if !config.ClientCAs.AppendCertsFromPEM(pem) {
+			logbase.Fatal(slog.Default(), "no certificates found in NTS-KE client CA file")
+		}
+		switch cfg.NTSKEClientAuth {
+		case "", "require":
+			config.ClientAuth = tls.RequireAndVerifyClientCert
+		case "optional":
+			config.ClientAuth = tls.VerifyClientCertIfGiven
+		default:
+			logbase.Fatal(slog.Default(), "invalid NTS-KE client authentication mode specified in config",
+				slog.String("ntske_client_auth", cfg.NTSKEClientAuth))
+		}
+	} else if cfg.NTSKEClientAuth != "" {
+		logbase.Fatal(slog.Default(), "NTS-KE client authentication requires a client CA file")
+	}
+	return config
 }
 
 func createClocks(cfg svcConfig, localAddr *snet.UDPAddr, log *slog.Logger) (
 	refClocks, peerClocks []client.ReferenceClock) {
 	dscp := dscp(cfg)
+	ntskeOpts := newNTSKEClientOptions(cfg)
+	// Only reference clocks are bootstrapped, peers are synchronized once the
+	// local clock has been bootstrapped.
+	peerNTSKEOpts := ntskeOpts
+	peerNTSKEOpts.bootstrap = false
 
 	for _, s := range cfg.MBGReferenceClocks {
 		refClocks = append(refClocks, mbg.NewReferenceClock(log, s))
@@ -421,6 +1382,7 @@ func createClocks(cfg svcConfig, localAddr *snet.UDPAddr, log *slog.Logger) (
 	}
 
 	var dstIAs []addr.IA
+	ntpClientKey := ntpClientKey(cfg)
 	for _, s := range cfg.NTPReferenceClocks {
 		remoteAddr, err := snet.ParseUDPAddr(s)
 		if err != nil {
@@ -429,30 +1391,97 @@ func createClocks(cfg svcConfig, localAddr *snet.UDPAddr, log *slog.Logger) (
 		}
 		ntskeServer := ntskeServerFromRemoteAddr(s)
 		if !remoteAddr.IA.IsZero() {
-			refClocks = append(refClocks, newNTPReferenceClockSCION(
+			c := newNTPReferenceClockSCION(
 				log,
 				cfg.SCIONDaemonAddr,
 				udp.UDPAddrFromSnet(localAddr),
 				udp.UDPAddrFromSnet(remoteAddr),
 				dscp,
+				cfg.NTPv5,
 				cfg.AuthModes,
 				ntskeServer,
 				cfg.NTSKEInsecureSkipVerify,
-			))
+				ntskeOpts.forClock(s),
+			)
+			for _, ntpc := range c.ntpcs {
+				ntpc.Auth.SymmetricKey = ntpClientKey
+			}
+			refClocks = append(refClocks, c)
 			dstIAs = append(dstIAs, remoteAddr.IA)
 		} else {
-			refClocks = append(refClocks, newNTPReferenceClockIP(
+			c := newNTPReferenceClockIP(
 				log,
 				localAddr.Host,
 				remoteAddr.Host,
 				dscp,
+				cfg.NTPv5,
 				cfg.AuthModes,
 				ntskeServer,
 				cfg.NTSKEInsecureSkipVerify,
+				ntskeOpts.forClock(s),
+			)
+			c.ntpc.Auth.SymmetricKey = ntpClientKey
+			refClocks = append(refClocks, c)
+		}
+	}
+
+	var csptpClientKey []byte
+	if keys := csptpKeys(cfg); cfg.CSPTPKeyID != 0 {
+		csptpClientKey = keys[cfg.CSPTPKeyID]
+	}
+	for _, s := range cfg.CSPTPReferenceClocks {
+		remoteAddr, err := addr.ParseAddr(s)
+		if err != nil || remoteAddr.Host.Type() != addr.HostTypeIP {
+			logbase.Fatal(slog.Default(), "failed to parse CSPTP reference clock address",
+				slog.String("address", s), slog.Any("error", err))
+		}
+		if !remoteAddr.IA.IsZero() {
+			refClocks = append(refClocks, newCSPTPReferenceClockSCION(
+				log,
+				udp.UDPAddrFromSnet(localAddr),
+				udp.UDPAddr{
+					IA: remoteAddr.IA,
+					Host: &net.UDPAddr{
+						IP:   remoteAddr.Host.IP().AsSlice(),
+						Port: csptp.EventPortSCION,
+					},
+				},
+				dscp,
+			))
+			dstIAs = append(dstIAs, remoteAddr.IA)
+		} else {
+			refClocks = append(refClocks, newCSPTPReferenceClockIP(
+				log,
+				localAddr.Host.AddrPort().Addr().Unmap(),
+				remoteAddr.Host.IP().Unmap(),
+				dscp,
+				cfg.AuthModes,
+				cfg.CSPTPKeyID,
+				csptpClientKey,
+				cfg.NTSKEInsecureSkipVerify,
+				ntskeOpts.forClock(s),
 			))
 		}
 	}
 
+	if len(cfg.PTPReferenceClocks) != 0 {
+		var remoteAddrs []netip.Addr
+		for _, s := range cfg.PTPReferenceClocks {
+			remoteAddr, err := netip.ParseAddr(s)
+			if err != nil {
+				logbase.Fatal(slog.Default(), "failed to parse PTP reference clock address",
+					slog.String("address", s), slog.Any("error", err))
+			}
+			remoteAddrs = append(remoteAddrs, remoteAddr.Unmap())
+		}
+		refClocks = append(refClocks, newPTPReferenceClockIP(
+			log,
+			localAddr.Host.AddrPort().Addr().Unmap(),
+			remoteAddrs,
+			dscp,
+		))
+	}
+
 	for _, s := range cfg.SCIONPeers {
 		remoteAddr, err := snet.ParseUDPAddr(s)
 		if err != nil {
@@ -468,13 +1497,94 @@ func createClocks(cfg svcConfig, localAddr *snet.UDPAddr, log *slog.Logger) (
 			udp.UDPAddrFromSnet(localAddr),
 			udp.UDPAddrFromSnet(remoteAddr),
 			dscp,
+			cfg.NTPv5,
 			cfg.AuthModes,
 			ntskeServer,
 			cfg.NTSKEInsecureSkipVerify,
+			peerNTSKEOpts.forClock(s),
 		))
 		dstIAs = append(dstIAs, remoteAddr.IA)
 	}
 
+	ntpKeys := ntpKeys(cfg)
+	for _, pc := range cfg.NTPPeers {
+		remoteAddr := ntpPeerAddress(pc, ntpKeys)
+		ntskeServer := ntskeServerFromRemoteAddr(pc.Address)
+		if !remoteAddr.IA.IsZero() {
+			c := newNTPReferenceClockSCION(
+				log,
+				cfg.SCIONDaemonAddr,
+				udp.UDPAddrFromSnet(localAddr),
+				udp.UDPAddrFromSnet(remoteAddr),
+				dscp,
+				false, /* NTPv5 */
+				cfg.AuthModes,
+				ntskeServer,
+				cfg.NTSKEInsecureSkipVerify,
+				peerNTSKEOpts.forClock(pc.Address),
+			)
+			for _, ntpc := range c.ntpcs {
+				ntpc.Symmetric = true
+				ntpc.Auth.SymmetricKey = ntpKeys[pc.KeyID]
+			}
+			peerClocks = append(peerClocks, c)
+			dstIAs = append(dstIAs, remoteAddr.IA)
+		} else {
+			c := newNTPReferenceClockIP(
+				log,
+				localAddr.Host,
+				remoteAddr.Host,
+				dscp,
+				false, /* NTPv5 */
+				cfg.AuthModes,
+				ntskeServer,
+				cfg.NTSKEInsecureSkipVerify,
+				peerNTSKEOpts.forClock(pc.Address),
+			)
+			c.ntpc.Symmetric = true
+			c.ntpc.Auth.SymmetricKey = ntpKeys[pc.KeyID]
+			peerClocks = append(peerClocks, c)
+		}
+	}
+
+	for _, bc := range cfg.NTPBroadcastClocks {
+		remoteAddr, err := snet.ParseUDPAddr(bc.Address)
+		if err != nil || !remoteAddr.IA.IsZero() {
+			logbase.Fatal(slog.Default(), "failed to parse NTP broadcast clock address",
+				slog.String("address", bc.Address), slog.Any("error", err))
+		}
+		listenAddr, err := netip.ParseAddrPort(bc.ListenAddress)
+		if err != nil {
+			logbase.Fatal(slog.Default(), "failed to parse NTP broadcast clock listen address",
+				slog.String("listen_address", bc.ListenAddress), slog.Any("error", err))
+		}
+		if _, ok := ntpKeys[bc.KeyID]; bc.KeyID != 0 && !ok {
+			logbase.Fatal(slog.Default(), "unknown NTP key ID specified in config",
+				slog.String("address", bc.Address), slog.Uint64("key_id", uint64(bc.KeyID)))
+		}
+		if bc.KeyID == 0 && !bc.Unauthenticated {
+			logbase.Fatal(slog.Default(), "NTP broadcast clock requires a key ID unless unauthenticated",
+				slog.String("address", bc.Address))
+		}
+		if bc.KeyID == 0 {
+			log.LogAttrs(context.Background(), slog.LevelInfo, "accepting unauthenticated NTP broadcast packets",
+				slog.String("address", bc.Address))
+		}
+		// Broadcast clocks are not bootstrapped.
+		refClocks = append(refClocks, newNTPBroadcastReferenceClockIP(
+			log,
+			localAddr.Host,
+			remoteAddr.Host,
+			listenAddr,
+			dscp,
+			ntpKeys[bc.KeyID],
+			cfg.AuthModes,
+			ntskeServerFromRemoteAddr(bc.Address),
+			cfg.NTSKEInsecureSkipVerify,
+			peerNTSKEOpts.forClock(bc.Address),
+		))
+	}
+
 	daemonAddr := cfg.SCIONDaemonAddr
 	if daemonAddr != "" {
 		ctx := context.Background()
@@ -484,8 +1594,8 @@ func createClocks(cfg svcConfig, localAddr *snet.UDPAddr, log *slog.Logger) (
 			drkeyFetcher = scion.NewFetcher(scion.NewDaemonConnector(ctx, daemonAddr))
 		}
 		for _, c := range refClocks {
-			scionclk, ok := c.(*ntpReferenceClockSCION)
-			if ok {
+			switch scionclk := c.(type) {
+			case *ntpReferenceClockSCION:
 				scionclk.pather = pather
 				if drkeyFetcher != nil {
 					for i := range len(scionclk.ntpcs) {
@@ -493,6 +1603,14 @@ func createClocks(cfg svcConfig, localAddr *snet.UDPAddr, log *slog.Logger) (
 						scionclk.ntpcs[i].Auth.DRKeyFetcher = drkeyFetcher
 					}
 				}
+			case *csptpReferenceClockSCION:
+				scionclk.pather = pather
+				if drkeyFetcher != nil {
+					for i := range len(scionclk.csptpcs) {
+						scionclk.csptpcs[i].Auth.Enabled = true
+						scionclk.csptpcs[i].Auth.DRKeyFetcher = drkeyFetcher
+					}
+				}
 			}
 		}
 		for _, c := range peerClocks {
@@ -527,16 +1645,56 @@ func runServer(configFile string) {
 	timebase.RegisterClock(lclk)
 
 	dscp := dscp(cfg)
-	tlsConfig := tlsConfig(cfg)
-	provider := ntske.NewProvider()
+	tlsConfig := tlsConfig(ctx, cfg, log)
+	provider := ntskeProvider(cfg)
+	acl := accessControlList(cfg)
+	limiter := rateLimiter(cfg)
+	peers := ntpPeers(cfg)
+	keys := ntpServerKeys(cfg)
+	control := ntpControl(cfg, slices.Concat(refClocks, peerClocks))
+	ntskeCfgIP := ntskeServerConfig(cfg)
+	ntskeCfgSCION := ntskeCfgIP
+	ntskeCfgIP.Backends, ntskeCfgSCION.Backends = ntpBackends(ctx, cfg, localAddr, log)
+	csptpCfg := csptpServerConfig(cfg)
+	csptpCfg.Keys = csptpKeys(cfg)
+	csptpCfg.Provider = provider
+	csptpCfg.LeapSeconds = leapSeconds(cfg)
+	server.SetLeapSeconds(csptpCfg.LeapSeconds)
+	if cfg.PTPServer && cfg.CSPTPServer {
+		logbase.Fatal(slog.Default(), "PTP and CSPTP servers cannot share the PTP ports")
+	}
+	if (cfg.PTPServer || cfg.CSPTPServer) && len(cfg.PTPReferenceClocks) != 0 {
+		logbase.Fatal(slog.Default(), "PTP reference clocks cannot share the PTP ports with a PTP or CSPTP server")
+	}
 
 	localAddr.Host.Port = ntp.ServerPortIP
-	server.StartNTSKEServerIP(ctx, log, slices.Clone(localAddr.Host.IP), localAddr.Host.Port, tlsConfig, provider)
-	server.StartIPServer(ctx, log, snet.CopyUDPAddr(localAddr.Host), dscp, provider)
+	server.StartNTSKEServerIP(ctx, log, slices.Clone(localAddr.Host.IP), localAddr.Host.Port, tlsConfig, provider, acl, ntskeCfgIP)
+	server.StartIPServer(ctx, log, snet.CopyUDPAddr(localAddr.Host), dscp, provider, acl, limiter, peers, keys,
+		control)
+	if cfg.CSPTPServer {
+		localHost := snet.CopyUDPAddr(localAddr.Host)
+		localHost.Port = 0
+		server.StartCSPTPServerIP(ctx, log, localHost, dscp, acl, limiter, csptpCfg)
+	}
+	if cfg.PTPServer {
+		localHost := snet.CopyUDPAddr(localAddr.Host)
+		localHost.Port = 0
+		server.StartPTPServerIP(ctx, log, localHost, dscp, acl, limiter, csptpCfg)
+	}
+	for _, bcfg := range ntpBroadcastServerConfigs(cfg) {
+		localHost := snet.CopyUDPAddr(localAddr.Host)
+		localHost.Port = 0
+		server.StartBroadcastServerIP(ctx, log, localHost, dscp, bcfg)
+	}
 
 	localAddr.Host.Port = ntp.ServerPortSCION
-	server.StartNTSKEServerSCION(ctx, log, udp.UDPAddrFromSnet(localAddr), tlsConfig, provider)
-	server.StartSCIONServer(ctx, log, daemonAddr, snet.CopyUDPAddr(localAddr.Host), dscp, provider)
+	server.StartNTSKEServerSCION(ctx, log, udp.UDPAddrFromSnet(localAddr), tlsConfig, provider, acl, ntskeCfgSCION)
+	server.StartSCIONServer(ctx, log, daemonAddr, snet.CopyUDPAddr(localAddr.Host), dscp, provider, acl, limiter, peers, keys)
+	if cfg.CSPTPServer {
+		localHost := snet.CopyUDPAddr(localAddr.Host)
+		localHost.Port = 0
+		server.StartCSPTPServerSCION(ctx, log, daemonAddr, localHost, dscp, acl, limiter, csptpCfg)
+	}
 
 	syncCfg := syncConfig(cfg)
 
@@ -546,9 +1704,10 @@ func runServer(configFile string) {
 		StepThreshold: adjustments.PIControllerDefaultStepThreshold,
 	}
 
+	bootstrapClock(ctx, cfg, log, lclk, refClocks)
 	go sync.Run(log, syncCfg, lclk, adj, refClocks, peerClocks)
 
-	runMonitor(cfg)
+	runMonitor(cfg, slices.Concat(refClocks, peerClocks))
 }
 
 func runClient(configFile string) {
@@ -570,10 +1729,9 @@ func runClient(configFile string) {
 
 	scionClocksAvailable := false
 	for _, c := range refClocks {
-		_, ok := c.(*ntpReferenceClockSCION)
-		if ok {
+		switch c.(type) {
+		case *ntpReferenceClockSCION, *csptpReferenceClockSCION:
 			scionClocksAvailable = true
-			break
 		}
 	}
 	if scionClocksAvailable {
@@ -588,12 +1746,13 @@ func runClient(configFile string) {
 		StepThreshold: adjustments.PIControllerDefaultStepThreshold,
 	}
 
+	bootstrapClock(ctx, cfg, log, lclk, refClocks)
 	go sync.Run(log, syncCfg, lclk, adj, refClocks, peerClocks)
 
-	runMonitor(cfg)
+	runMonitor(cfg, slices.Concat(refClocks, peerClocks))
 }
 
-func runToolIP(localAddr, remoteAddr *snet.UDPAddr, dscp uint8,
+func runToolIP(localAddr, remoteAddr *snet.UDPAddr, dscp uint8, ntpv5 bool,
 	authModes []string, ntskeServer string, ntskeInsecureSkipVerify, periodic bool) {
 	log := slog.Default()
 
@@ -603,12 +1762,13 @@ func runToolIP(localAddr, remoteAddr *snet.UDPAddr, dscp uint8,
 	laddr := localAddr.Host
 	raddr := remoteAddr.Host
 	c := &client.IPClient{
-		Log:  log,
-		DSCP: dscp,
+		Log:   log,
+		DSCP:  dscp,
+		NTPv5: ntpv5,
 		// InterleavedMode: true,
 	}
 	if slices.Contains(authModes, authModeNTS) {
-		configureIPClientNTS(c, ntskeServer, ntskeInsecureSkipVerify, log)
+		configureIPClientNTS(c, ntskeServer, ntskeInsecureSkipVerify, ntskeClientOptions{}, log)
 	}
 
 	for {
@@ -629,8 +1789,145 @@ func runToolIP(localAddr, remoteAddr *snet.UDPAddr, dscp uint8,
 	}
 }
 
+func runToolCSPTPIP(localAddr, remoteAddr *snet.UDPAddr, dscp uint8,
+	authModes []string, ntskeServer string, ntskeInsecureSkipVerify, periodic bool) {
+	log := slog.Default()
+
+	lclk := clocks.NewSystemClock(log, clocks.UnknownDrift)
+	timebase.RegisterClock(lclk)
+
+	laddr := localAddr.Host.AddrPort().Addr().Unmap()
+	raddr := remoteAddr.Host.AddrPort().Addr().Unmap()
+	c := &client.CSPTPClientIP{
+		Log:  log,
+		DSCP: dscp,
+	}
+	if slices.Contains(authModes, authModeNTS) {
+		configureCSPTPClientNTS(c, ntskeServer, ntskeInsecureSkipVerify, ntskeClientOptions{}, log)
+	}
+
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+		ts, off, err := c.MeasureClockOffset(ctx, laddr, raddr)
+		if err != nil {
+			log.LogAttrs(ctx, slog.LevelInfo, "failed to measure clock offset",
+				slog.Any("remote", raddr), slog.Any("error", err))
+		}
+		cancel()
+		if !periodic {
+			break
+		}
+		if err == nil {
+			fmt.Printf("%s,%+.9f\n", ts.UTC().Format(time.RFC3339), off.Seconds())
+		}
+		lclk.Sleep(1 * time.Second)
+	}
+}
+
+func runToolPTPIP(localAddr, remoteAddr *snet.UDPAddr, dscp uint8, periodic bool) {
+	log := slog.Default()
+
+	lclk := clocks.NewSystemClock(log, clocks.UnknownDrift)
+	timebase.RegisterClock(lclk)
+
+	laddr := localAddr.Host.AddrPort().Addr().Unmap()
+	raddr := remoteAddr.Host.AddrPort().Addr().Unmap()
+	c := &client.PTPClientIP{
+		Log:  log,
+		DSCP: dscp,
+	}
+	defer func() { _ = c.Close() }()
+
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		ts, off, err := c.MeasureClockOffset(ctx, laddr, []netip.Addr{raddr})
+		if err != nil {
+			log.LogAttrs(ctx, slog.LevelInfo, "failed to measure clock offset",
+				slog.Any("remote", raddr), slog.Any("error", err))
+		}
+		cancel()
+		if !periodic {
+			break
+		}
+		if err == nil {
+			fmt.Printf("%s,%+.9f\n", ts.UTC().Format(time.RFC3339), off.Seconds())
+		}
+		lclk.Sleep(1 * time.Second)
+	}
+}
+
+func runToolCSPTPSCION(daemonAddr, dispatcherMode string, localAddr, remoteAddr *snet.UDPAddr,
+	dscp uint8, authModes []string, periodic bool) {
+	var err error
+	ctx := context.Background()
+	log := slog.Default()
+
+	lclk := clocks.NewSystemClock(log, clocks.UnknownDrift)
+	timebase.RegisterClock(lclk)
+
+	if dispatcherMode == dispatcherModeInternal {
+		server.StartSCIONDispatcher(ctx, log, snet.CopyUDPAddr(localAddr.Host))
+	}
+
+	dc := scion.NewDaemonConnector(ctx, daemonAddr)
+
+	laddr := udp.UDPAddrFromSnet(localAddr)
+	raddr := udp.UDPAddrFromSnet(remoteAddr)
+	raddr.Host.Port = csptp.EventPortSCION
+
+	var ps []snet.Path
+	if remoteAddr.IA == localAddr.IA {
+		ps = []snet.Path{path.Path{
+			Src:           localAddr.IA,
+			Dst:           remoteAddr.IA,
+			DataplanePath: path.Empty{},
+			NextHop:       raddr.Host,
+		}}
+	} else {
+		ps, err = dc.Paths(ctx, remoteAddr.IA, localAddr.IA, daemon.PathReqFlags{Refresh: true})
+		if err != nil {
+			logbase.Fatal(slog.Default(), "failed to lookup paths", slog.Any("remote", remoteAddr), slog.Any("error", err))
+		}
+		if len(ps) == 0 {
+			logbase.Fatal(slog.Default(), "no paths available", slog.Any("remote", remoteAddr))
+		}
+	}
+
+	log.LogAttrs(ctx, slog.LevelDebug,
+		"available paths",
+		slog.Any("remote", remoteAddr),
+		slog.Any("via", ps),
+	)
+
+	c := &client.CSPTPClientSCION{
+		Log:  log,
+		DSCP: dscp,
+	}
+	if slices.Contains(authModes, authModeSPAO) {
+		c.Auth.Enabled = true
+		c.Auth.DRKeyFetcher = scion.NewFetcher(dc)
+	}
+
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+		ts, off, err := client.MeasureClockOffsetCSPTPSCION(ctx, log, []*client.CSPTPClientSCION{c}, laddr, raddr, ps)
+		if err != nil {
+			log.LogAttrs(ctx, slog.LevelInfo, "failed to measure clock offset",
+				slog.Any("remote", remoteAddr), slog.Any("error", err))
+		}
+		cancel()
+		if !periodic {
+			break
+		}
+		if err == nil {
+			fmt.Printf("%s,%+.9f\n", ts.UTC().Format(time.RFC3339), off.Seconds())
+		}
+		lclk.Sleep(1 * time.Second)
+	}
+}
+
 func runToolSCION(daemonAddr, dispatcherMode string, localAddr, remoteAddr *snet.UDPAddr,
-	dscp uint8, authModes []string, ntskeServer string, ntskeInsecureSkipVerify bool) {
+	dscp uint8, ntpv5 bool, authModes []string, ntskeServer string, ntskeInsecureSkipVerify bool) {
 	var err error
 	ctx := context.Background()
 	log := slog.Default()
@@ -673,13 +1970,15 @@ func runToolSCION(daemonAddr, dispatcherMode string, localAddr, remoteAddr *snet
 		Log:             log,
 		DSCP:            dscp,
 		InterleavedMode: true,
+		NTPv5:           ntpv5,
 	}
 	if slices.Contains(authModes, authModeSPAO) {
 		c.Auth.Enabled = true
 		c.Auth.DRKeyFetcher = scion.NewFetcher(dc)
 	}
 	if slices.Contains(authModes, authModeNTS) {
-		configureSCIONClientNTS(c, ntskeServer, ntskeInsecureSkipVerify, daemonAddr, laddr, raddr, log)
+		configureSCIONClientNTS(c, ntskeServer, ntskeInsecureSkipVerify, ntskeClientOptions{},
+			daemonAddr, laddr, raddr, log)
 	}
 
 	_, _, err = client.MeasureClockOffsetSCION(ctx, log, []*client.SCIONClient{c}, laddr, raddr, ps)
@@ -849,6 +2148,7 @@ func main() {
 		drkeyClientAddr         snet.UDPAddr
 		dscp                    uint
 		authModesStr            string
+		protocol                string
 		ntskeInsecureSkipVerify bool
 		periodic                bool
 	)
@@ -876,6 +2176,7 @@ func main() {
 	toolFlags.StringVar(&authModesStr, "auth", "", "Authentication modes")
 	toolFlags.BoolVar(&ntskeInsecureSkipVerify, "ntske-insecure-skip-verify", false, "Skip NTSKE verification")
 	toolFlags.BoolVar(&periodic, "periodic", false, "Perform periodic offset measurements")
+	toolFlags.StringVar(&protocol, "protocol", protocolNTP, "Protocol, \"ntp\", \"ntpv5\", \"csptp\" or \"ptp\"")
 
 	pingFlags.BoolVar(&verbose, "verbose", false, "Verbose logging")
 	pingFlags.StringVar(&daemonAddr, "daemon", "", "Daemon address")
@@ -940,7 +2241,41 @@ func main() {
 		for i := range authModes {
 			authModes[i] = strings.TrimSpace(authModes[i])
 		}
-		if !remoteAddr.IA.IsZero() {
+		if protocol != protocolNTP && protocol != protocolNTPv5 &&
+			protocol != protocolCSPTP && protocol != protocolPTP {
+			exitWithUsage()
+		}
+		if protocol == protocolPTP {
+			if !remoteAddr.IA.IsZero() || daemonAddr != "" || dispatcherMode != "" || authModesStr != "" {
+				exitWithUsage()
+			}
+			initLogger(verbose)
+			runToolPTPIP(&localAddr, &remoteAddr, uint8(dscp), periodic)
+		} else if protocol == protocolCSPTP && !remoteAddr.IA.IsZero() {
+			if dispatcherMode == "" {
+				dispatcherMode = dispatcherModeExternal
+			} else if dispatcherMode != dispatcherModeExternal &&
+				dispatcherMode != dispatcherModeInternal {
+				exitWithUsage()
+			}
+			if authModesStr != "" && !slices.Equal(authModes, []string{authModeSPAO}) {
+				exitWithUsage()
+			}
+			initLogger(verbose)
+			runToolCSPTPSCION(daemonAddr, dispatcherMode, &localAddr, &remoteAddr, uint8(dscp),
+				authModes, periodic)
+		} else if protocol == protocolCSPTP {
+			if daemonAddr != "" || dispatcherMode != "" {
+				exitWithUsage()
+			}
+			if authModesStr != "" && !slices.Equal(authModes, []string{authModeNTS}) {
+				exitWithUsage()
+			}
+			ntskeServer := ntskeServerFromRemoteAddr(remoteAddrStr)
+			initLogger(verbose)
+			runToolCSPTPIP(&localAddr, &remoteAddr, uint8(dscp),
+				authModes, ntskeServer, ntskeInsecureSkipVerify, periodic)
+		} else if !remoteAddr.IA.IsZero() {
 			if dispatcherMode == "" {
 				dispatcherMode = dispatcherModeExternal
 			} else if dispatcherMode != dispatcherModeExternal &&
@@ -950,7 +2285,7 @@ func main() {
 			ntskeServer := ntskeServerFromRemoteAddr(remoteAddrStr)
 			initLogger(verbose)
 			runToolSCION(daemonAddr, dispatcherMode, &localAddr, &remoteAddr, uint8(dscp),
-				authModes, ntskeServer, ntskeInsecureSkipVerify)
+				protocol == protocolNTPv5, authModes, ntskeServer, ntskeInsecureSkipVerify)
 		} else {
 			if daemonAddr != "" {
 				exitWithUsage()
@@ -960,7 +2295,7 @@ func main() {
 			}
 			ntskeServer := ntskeServerFromRemoteAddr(remoteAddrStr)
 			initLogger(verbose)
-			runToolIP(&localAddr, &remoteAddr, uint8(dscp),
+			runToolIP(&localAddr, &remoteAddr, uint8(dscp), protocol == protocolNTPv5,
 				authModes, ntskeServer, ntskeInsecureSkipVerify, periodic)
 		}
 	case pingFlags.Name():
@@ -1004,8 +2339,6 @@ func main() {
 		serverMode := drkeyMode == "server"
 		initLogger(verbose)
 		runDRKeyDemo(daemonAddr, serverMode, &drkeyServerAddr, &drkeyClientAddr)
-	case "t":
-		runT()
 	default:
 		exitWithUsage()
 	}